@@ -0,0 +1,178 @@
+// Command keyctl manages the JWT signing keyring used by auth-service.
+//
+//	keyctl list
+//	keyctl generate [-alg RS256|EdDSA] [-promote] [-at RFC3339]
+//	keyctl promote [-at RFC3339] <kid>
+//	keyctl retire [-at RFC3339] <kid>
+//
+// The keyring directory is taken from JWT_KEYRING_DIR unless -dir is given.
+// Running auth-service instances pick up changes on their next reload.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+)
+
+func main() {
+	// Load environment variables
+	_ = godotenv.Load()
+	cfg := configs.LoadConfig()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dir := flags.String("dir", cfg.JWT.KeyringDir, "keyring directory")
+	at := flags.String("at", "", "when the change takes effect (RFC3339, default now)")
+	// Retired keys must verify every token they signed, so keep them for at least the longest token lifetime
+	grace := flags.Duration("grace", utils.RetiredKeyGrace(cfg.JWT.ExpiresIn), "how long retired keys keep verifying tokens")
+	alg := flags.String("alg", cfg.JWT.Algorithm, "algorithm for generated keys (RS256 or EdDSA)")
+	promote := flags.Bool("promote", false, "promote the generated key")
+	flags.Parse(args)
+
+	if *dir == "" {
+		log.Fatal("keyring directory is required, set JWT_KEYRING_DIR or pass -dir")
+	}
+
+	effectiveAt := time.Now()
+	if *at != "" {
+		parsed, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatal("Invalid -at value:", err)
+		}
+		effectiveAt = parsed
+	}
+
+	manifest, err := utils.ReadKeyringManifest(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch command {
+	case "list":
+		listKeys(manifest)
+		return
+	case "generate":
+		key, err := generateKey(*dir, *alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// New keys are published right away but only sign once promoted,
+		// unless this is the first key of the keyring
+		first := len(manifest.Keys) == 0
+		activateAt := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+		if first {
+			activateAt = effectiveAt
+		}
+		manifest.Keys = append(manifest.Keys, utils.KeyringEntry{
+			KeyID:      key.KeyID,
+			Algorithm:  key.Algorithm,
+			CreatedAt:  time.Now(),
+			ActivateAt: activateAt,
+		})
+
+		if *promote && !first {
+			err = promoteKey(manifest, key.KeyID, effectiveAt, *grace)
+		}
+		fmt.Println("Generated key", key.KeyID)
+	case "promote":
+		err = promoteKey(manifest, flags.Arg(0), effectiveAt, *grace)
+	case "retire":
+		err = manifest.Retire(flags.Arg(0), effectiveAt, *grace)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.WriteKeyringManifest(*dir, manifest); err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := utils.LoadKeyring(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := keyring.SigningKey(effectiveAt); err != nil {
+		log.Printf("Warning: keyring has no active signing key at %s", effectiveAt.Format(time.RFC3339))
+	}
+
+	listKeys(manifest)
+}
+
+// promoteKey promotes the key, postponed until every verifier has seen it if
+// it was generated too recently
+func promoteKey(manifest *utils.KeyringManifest, keyID string, at time.Time, grace time.Duration) error {
+	if entry := manifest.Find(keyID); entry != nil {
+		if published := entry.CreatedAt.Add(utils.PublishDelay); at.Before(published) {
+			log.Printf("Key %s is still being published, promoting it at %s", keyID, published.Format(time.RFC3339))
+			at = published
+		}
+	}
+	return manifest.Promote(keyID, at, grace)
+}
+
+func generateKey(dir, algorithm string) (*utils.SigningKey, error) {
+	key, err := utils.GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := key.EncodePEM()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key.KeyID+".pem"), data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+
+	return key, nil
+}
+
+func listKeys(manifest *utils.KeyringManifest) {
+	now := time.Now()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "KID\tALG\tSTATUS\tACTIVATE AT\tRETIRE AT\tEXPIRES AT")
+	for _, entry := range manifest.Keys {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.KeyID,
+			entry.Algorithm,
+			entry.Status(now),
+			entry.ActivateAt.Format(time.RFC3339),
+			formatTime(entry.RetireAt),
+			formatTime(entry.ExpiresAt),
+		)
+	}
+	writer.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keyctl <list|generate|promote|retire> [flags] [kid]")
+	os.Exit(2)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -14,10 +16,17 @@ import (
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/events"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/handlers"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/oauthstate"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/passwords"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/revocation"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
 )
 
@@ -30,6 +39,12 @@ func main() {
 	// Load configuration
 	cfg := configs.LoadConfig()
 
+	// Without trusted proxies every request seems to come from the gateway,
+	// so login lockouts by IP would lock everyone out at once
+	if cfg.Server.Env == "production" && len(cfg.Server.TrustedProxies) == 0 {
+		log.Fatal("TRUSTED_PROXIES is required in production")
+	}
+
 	// Initialize database connection
 	db, err := database.NewConnection(cfg)
 	if err != nil {
@@ -39,18 +54,170 @@ func main() {
 
 	// Initialize repositories
 	userRepo := database.NewUserRepository(db)
+	refreshTokenRepo := database.NewRefreshTokenRepository(db)
+	sessionRepo := database.NewSessionRepository(db)
+	oneTimeTokenRepo := database.NewOneTimeTokenRepository(db)
+	mfaRepo := database.NewMFARepository(db)
+	webAuthnRepo := database.NewWebAuthnRepository(db)
+	patRepo := database.NewPersonalAccessTokenRepository(db)
+	roleRepo := database.NewRoleRepository(db)
+	auditLogRepo := database.NewAuditLogRepository(db)
+	securityEventRepo := database.NewSecurityEventRepository(db)
+	oauthClientRepo := database.NewOAuthClientRepository(db)
+	eventOutboxRepo := database.NewEventOutboxRepository(db)
+	dataExportRepo := database.NewDataExportRepository(db)
+
+	// Initialize token revocation store
+	var revocationStore revocation.Store
+	switch cfg.JWT.RevocationStore {
+	case "memory":
+		revocationStore = revocation.NewMemoryStore()
+	default:
+		revocationStore = revocation.NewPostgresStore(db)
+	}
+	go purgeExpiredRevocations(revocationStore)
+
+	// Initialize failed attempt tracking
+	var lockoutStore lockout.Store
+	switch cfg.Lockout.Store {
+	case "redis":
+		lockoutStore, err = lockout.NewRedisStore(cfg.Redis.URL)
+		if err != nil {
+			log.Fatal("Failed to initialize lockout store:", err)
+		}
+	default:
+		lockoutStore = lockout.NewMemoryStore()
+	}
+	// Initialize pending OAuth login storage
+	var oauthStateStore oauthstate.Store
+	switch cfg.OAuth.StateStore {
+	case "redis":
+		oauthStateStore, err = oauthstate.NewRedisStore(cfg.Redis.URL)
+		if err != nil {
+			log.Fatal("Failed to initialize OAuth state store:", err)
+		}
+	default:
+		oauthStateStore = oauthstate.NewPostgresStore(db)
+	}
+	go purgeExpiredOAuthStates(oauthStateStore)
+
+	accountPolicy := lockout.Policy{
+		Threshold: cfg.Lockout.AccountThreshold,
+		BaseDelay: cfg.Lockout.BaseDelay,
+		MaxDelay:  cfg.Lockout.MaxDelay,
+		Window:    cfg.Lockout.Window,
+	}
+	ipPolicy := accountPolicy
+	ipPolicy.Threshold = cfg.Lockout.IPThreshold
+	loginGuard := lockout.NewGuard(lockoutStore, map[string]lockout.Policy{
+		lockout.ScopeAccount: accountPolicy,
+		lockout.ScopeIP:      ipPolicy,
+		lockout.ScopeMFA:     accountPolicy,
+	})
 
 	// Initialize utilities
-	jwtManager := utils.NewJWTManager(cfg)
-	oauthManager := utils.NewOAuthManager(cfg)
+	jwtManager, err := utils.NewJWTManager(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize JWT manager:", err)
+	}
+	go jwtManager.WatchKeyring(utils.KeyringReloadInterval)
+	oauthManager, err := utils.NewOAuthManager(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize OAuth providers:", err)
+	}
+	mailSender, err := mailer.NewMailer(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+	eventPublisher, err := events.NewPublisher(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize event publisher:", err)
+	}
+	go relayEvents(eventOutboxRepo, eventPublisher)
+	mfaEncryptionKey, err := utils.LoadSecret(cfg.Server.Env, "MFA_ENCRYPTION_KEY", cfg.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatal("Failed to initialize MFA encryption:", err)
+	}
+	mfaSecretBox, err := utils.NewSecretBox(mfaEncryptionKey)
+	if err != nil {
+		log.Fatal("Failed to initialize MFA encryption:", err)
+	}
+
+	usernamePolicy := usernames.NewPolicy(cfg.Account.ReservedUsernames)
+	breachCorpus, err := passwords.NewBreachCorpus(cfg.Password.BreachCorpusDir)
+	if err != nil {
+		log.Fatal("Failed to initialize breached password corpus:", err)
+	}
+	if breachCorpus == nil {
+		log.Println("PASSWORD_BREACH_CORPUS_DIR is not set, passwords are not screened against breaches")
+	}
+	passwordPolicy := passwords.NewPolicy(cfg.Password.MinLength, float64(cfg.Password.MinEntropy), breachCorpus)
 
 	// Initialize services
-	authService := services.NewAuthService(userRepo, jwtManager, oauthManager)
-	userService := services.NewUserService(userRepo)
+	verificationService := services.NewVerificationService(userRepo, oneTimeTokenRepo, jwtManager, mailSender, cfg.Server.FrontendURL)
+	authService := services.NewAuthService(
+		userRepo,
+		refreshTokenRepo,
+		sessionRepo,
+		oneTimeTokenRepo,
+		mfaRepo,
+		webAuthnRepo,
+		patRepo,
+		roleRepo,
+		securityEventRepo,
+		revocationStore,
+		loginGuard,
+		verificationService,
+		jwtManager,
+		oauthManager,
+		oauthStateStore,
+		usernamePolicy,
+		passwordPolicy,
+	)
+	passwordService := services.NewPasswordService(userRepo, oneTimeTokenRepo, authService, passwordPolicy, mailSender, cfg.Server.FrontendURL)
+	mfaService := services.NewMFAService(mfaRepo, userRepo, authService, jwtManager, mfaSecretBox, loginGuard, cfg.MFA.Issuer)
+	webAuthnService, err := services.NewWebAuthnService(cfg, webAuthnRepo, userRepo, authService, jwtManager)
+	if err != nil {
+		log.Fatal("Failed to initialize WebAuthn:", err)
+	}
+	go purgeExpiredWebAuthnSessions(webAuthnRepo)
+	userService := services.NewUserService(userRepo, usernamePolicy, cfg.Account)
+	accountService := services.NewAccountService(userRepo, authService, mailSender, cfg.Account.DeletionGracePeriod)
+	go purgeDeletedAccounts(accountService)
+	if cfg.Export.SigningKey, err = utils.LoadSecret(cfg.Server.Env, "EXPORT_SIGNING_KEY", cfg.Export.SigningKey); err != nil {
+		log.Fatal("Failed to initialize data exports:", err)
+	}
+	dataExportService := services.NewDataExportService(
+		dataExportRepo,
+		userRepo,
+		sessionRepo,
+		mfaRepo,
+		webAuthnRepo,
+		patRepo,
+		roleRepo,
+		auditLogRepo,
+		securityEventRepo,
+		oauthClientRepo,
+		mailSender,
+		cfg.Export,
+	)
+	go processDataExports(dataExportService)
+	patService := services.NewPersonalAccessTokenService(patRepo)
+	adminService := services.NewAdminService(userRepo, roleRepo, auditLogRepo, authService, passwordService)
+	oauthServerService := services.NewOAuthServerService(oauthClientRepo, userRepo, oneTimeTokenRepo, authService, jwtManager, cfg.OAuthServer.AuthorizeURL)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	userHandler := handlers.NewUserHandler(userService)
+	authHandler := handlers.NewAuthHandler(authService, verificationService, passwordService)
+	userHandler := handlers.NewUserHandler(userService, passwordService, accountService)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+	webAuthnHandler := handlers.NewWebAuthnHandler(webAuthnService)
+	sessionHandler := handlers.NewSessionHandler(authService)
+	identityHandler := handlers.NewIdentityHandler(authService)
+	patHandler := handlers.NewPersonalAccessTokenHandler(patService)
+	dataExportHandler := handlers.NewDataExportHandler(dataExportService)
+	adminHandler := handlers.NewAdminHandler(adminService)
+	oauthServerHandler := handlers.NewOAuthServerHandler(oauthServerService)
+	wellKnownHandler := handlers.NewWellKnownHandler(jwtManager)
 
 	// Initialize middleware
 	authMiddleware := middleware.NewAuthMiddleware(authService)
@@ -70,6 +237,12 @@ func main() {
 			))
 		},
 		DisableStartupMessage: cfg.Server.Env == "production",
+		// Behind the gateway every request comes from its address, the client
+		// IP that sessions and login lockouts use is taken from its header
+		ProxyHeader:             cfg.Server.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.Server.TrustedProxies,
+		EnableIPValidation:      true,
 	})
 
 	// Middleware
@@ -91,8 +264,12 @@ func main() {
 		})
 	})
 
+	// Public keys for local token verification by other services
+	app.Get("/.well-known/jwks.json", wellKnownHandler.JWKS)
+	app.Get("/.well-known/openid-configuration", oauthServerHandler.OpenIDConfiguration)
+
 	// Setup routes
-	setupRoutes(app, authHandler, userHandler, authMiddleware)
+	setupRoutes(app, authHandler, userHandler, mfaHandler, webAuthnHandler, sessionHandler, identityHandler, patHandler, dataExportHandler, oauthServerHandler, adminHandler, authMiddleware)
 
 	// Start server
 	port := ":" + cfg.Server.Port
@@ -120,6 +297,14 @@ func setupRoutes(
 	app *fiber.App,
 	authHandler *handlers.AuthHandler,
 	userHandler *handlers.UserHandler,
+	mfaHandler *handlers.MFAHandler,
+	webAuthnHandler *handlers.WebAuthnHandler,
+	sessionHandler *handlers.SessionHandler,
+	identityHandler *handlers.IdentityHandler,
+	patHandler *handlers.PersonalAccessTokenHandler,
+	dataExportHandler *handlers.DataExportHandler,
+	oauthServerHandler *handlers.OAuthServerHandler,
+	adminHandler *handlers.AdminHandler,
 	authMiddleware *middleware.AuthMiddleware,
 ) {
 	// API v1 group
@@ -131,23 +316,118 @@ func setupRoutes(
 		// Traditional auth
 		auth.Post("/register", authHandler.Register)
 		auth.Post("/login", authHandler.Login)
+		auth.Post("/refresh", authHandler.RefreshToken)
+		auth.Post("/logout", authMiddleware.JWTMiddleware(), authHandler.Logout)
+		auth.Post("/logout-all", authMiddleware.JWTMiddleware(), authHandler.LogoutAll)
+		auth.Post("/verify-email", authHandler.VerifyEmail)
+		auth.Post("/verify-email/resend", authMiddleware.JWTMiddleware(), authHandler.ResendVerificationEmail)
+		auth.Post("/password/forgot", authHandler.ForgotPassword)
+		auth.Post("/password/reset", authHandler.ResetPassword)
 
-		// OAuth routes
-		auth.Get("/google", authHandler.GoogleLogin)
-		auth.Get("/google/callback", authHandler.GoogleCallback)
-		auth.Get("/facebook", authHandler.FacebookLogin)
-		auth.Get("/facebook/callback", authHandler.FacebookCallback)
+		// Second step of a login with two-factor authentication
+		auth.Post("/mfa/verify", mfaHandler.VerifyChallenge)
+		auth.Post("/mfa/webauthn/begin", webAuthnHandler.BeginMFA)
+		auth.Post("/mfa/webauthn/finish", webAuthnHandler.FinishMFA)
+
+		// Passwordless login with a passkey
+		auth.Post("/webauthn/login/begin", webAuthnHandler.BeginLogin)
+		auth.Post("/webauthn/login/finish", webAuthnHandler.FinishLogin)
 
 		// Token validation (for other services)
 		auth.Post("/validate", authHandler.ValidateToken)
+
+		// Redirect mode OAuth callbacks hand the frontend a code to trade here
+		auth.Post("/exchange", authHandler.ExchangeOAuthCode)
+
+		// OAuth routes, registered last so the provider parameter doesn't
+		// shadow the routes above
+		auth.Get("/providers", authHandler.ListOAuthProviders)
+		auth.Get("/:provider", authHandler.OAuthLogin)
+		auth.Get("/:provider/callback", authHandler.OAuthCallback)
+		auth.Post("/:provider/callback", authHandler.OAuthCallback)
 	}
 
 	// User routes (protected)
+	// Middleware is set per route so personal access tokens reach only the
+	// routes that name a scope
 	users := api.Group("/users")
-	users.Use(authMiddleware.JWTMiddleware())
+	requireUser := authMiddleware.JWTMiddleware()
 	{
-		users.Get("/profile", userHandler.GetProfile)
-		users.Put("/profile", userHandler.UpdateProfile)
+		users.Get("/profile", authMiddleware.JWTMiddleware(models.ScopeProfileRead), userHandler.GetProfile)
+		users.Put("/profile", authMiddleware.JWTMiddleware(models.ScopeProfileWrite), userHandler.UpdateProfile)
+		users.Put("/username", requireUser, userHandler.ChangeUsername)
+		users.Put("/password", requireUser, userHandler.ChangePassword)
+		users.Delete("/me", requireUser, userHandler.DeleteAccount)
+
+		// Personal access tokens for scripts and bots
+		users.Get("/tokens", requireUser, patHandler.ListTokens)
+		users.Post("/tokens", requireUser, patHandler.CreateToken)
+		users.Delete("/tokens/:id", requireUser, patHandler.DeleteToken)
+
+		// Copies of the user's data, downloaded through the signed link
+		users.Get("/exports", requireUser, dataExportHandler.ListExports)
+		users.Post("/exports", requireUser, dataExportHandler.RequestExport)
+		users.Get("/exports/:id/download", dataExportHandler.DownloadExport)
+
+		// Signed in devices
+		users.Get("/sessions", requireUser, sessionHandler.ListSessions)
+		users.Delete("/sessions/:id", requireUser, sessionHandler.RevokeSession)
+
+		// Linked OAuth providers
+		users.Post("/identities/:provider", requireUser, identityHandler.LinkIdentity)
+		users.Delete("/identities/:provider", requireUser, identityHandler.UnlinkIdentity)
+
+		// Two-factor authentication
+		users.Get("/mfa", requireUser, mfaHandler.GetStatus)
+		users.Post("/mfa/totp/setup", requireUser, mfaHandler.SetupTOTP)
+		users.Post("/mfa/totp/enable", requireUser, mfaHandler.EnableTOTP)
+		users.Post("/mfa/totp/disable", requireUser, mfaHandler.DisableTOTP)
+		users.Post("/mfa/recovery-codes", requireUser, mfaHandler.RegenerateRecoveryCodes)
+
+		// Passkeys
+		users.Get("/webauthn/credentials", requireUser, webAuthnHandler.ListCredentials)
+		users.Delete("/webauthn/credentials/:id", requireUser, webAuthnHandler.DeleteCredential)
+		users.Post("/webauthn/register/begin", requireUser, webAuthnHandler.BeginRegistration)
+		users.Post("/webauthn/register/finish", requireUser, webAuthnHandler.FinishRegistration)
+	}
+
+	// Authorization server for third-party apps
+	oauth := api.Group("/oauth")
+	{
+		// Apps registered by the current user
+		oauth.Post("/clients", authMiddleware.JWTMiddleware(), oauthServerHandler.RegisterClient)
+		oauth.Get("/clients", authMiddleware.JWTMiddleware(), oauthServerHandler.ListClients)
+		oauth.Delete("/clients/:id", authMiddleware.JWTMiddleware(), oauthServerHandler.DeleteClient)
+
+		// Consent page of the frontend
+		oauth.Get("/authorize", authMiddleware.JWTMiddleware(), oauthServerHandler.GetConsent)
+		oauth.Post("/authorize", authMiddleware.JWTMiddleware(), oauthServerHandler.Authorize)
+
+		// Called by the third-party apps
+		oauth.Post("/token", oauthServerHandler.Token)
+		oauth.Get("/userinfo", authMiddleware.ScopeMiddleware(models.ScopeOpenID), oauthServerHandler.UserInfo)
+		oauth.Post("/userinfo", authMiddleware.ScopeMiddleware(models.ScopeOpenID), oauthServerHandler.UserInfo)
+	}
+
+	// Admin routes, each guarded by the permission it needs
+	admin := api.Group("/admin")
+	admin.Use(authMiddleware.JWTMiddleware())
+	{
+		canManageRoles := authMiddleware.RequirePermission(models.PermissionRolesManage)
+		admin.Get("/roles", canManageRoles, adminHandler.ListRoles)
+		admin.Get("/users/:id/roles", canManageRoles, adminHandler.ListUserRoles)
+		admin.Post("/users/:id/roles", canManageRoles, adminHandler.GrantRole)
+		admin.Delete("/users/:id/roles/:role", canManageRoles, adminHandler.RevokeRole)
+		admin.Get("/audit-log", canManageRoles, adminHandler.AuditLog)
+
+		canReadUsers := authMiddleware.RequirePermission(models.PermissionUsersRead)
+		canManageUsers := authMiddleware.RequirePermission(models.PermissionUsersManage)
+		admin.Get("/users", canReadUsers, adminHandler.SearchUsers)
+		admin.Get("/users/:id", canReadUsers, adminHandler.GetUser)
+		admin.Post("/users/:id/suspend", canManageUsers, adminHandler.SuspendUser)
+		admin.Post("/users/:id/unsuspend", canManageUsers, adminHandler.UnsuspendUser)
+		admin.Post("/users/:id/logout", canManageUsers, adminHandler.ForceLogout)
+		admin.Post("/users/:id/password-reset", canManageUsers, adminHandler.ForcePasswordReset)
 	}
 
 	// Public user routes
@@ -161,11 +441,11 @@ func setupRoutes(
 	{
 		legacyAuth.Post("/register", authHandler.Register)
 		legacyAuth.Post("/login", authHandler.Login)
-		legacyAuth.Get("/google", authHandler.GoogleLogin)
-		legacyAuth.Get("/google/callback", authHandler.GoogleCallback)
-		legacyAuth.Get("/facebook", authHandler.FacebookLogin)
-		legacyAuth.Get("/facebook/callback", authHandler.FacebookCallback)
 		legacyAuth.Post("/validate", authHandler.ValidateToken)
+		legacyAuth.Get("/providers", authHandler.ListOAuthProviders)
+		legacyAuth.Get("/:provider", authHandler.OAuthLogin)
+		legacyAuth.Get("/:provider/callback", authHandler.OAuthCallback)
+		legacyAuth.Post("/:provider/callback", authHandler.OAuthCallback)
 	}
 
 	legacyUsers := app.Group("/users")
@@ -180,3 +460,106 @@ func setupRoutes(
 		legacyPublicUsers.Get("/:username", userHandler.GetUserByUsername)
 	}
 }
+
+// purgeExpiredRevocations periodically drops revocations of tokens that have expired anyway
+func purgeExpiredRevocations(store revocation.Store) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := store.PurgeExpired(); err != nil {
+			log.Println("Failed to purge expired token revocations:", err)
+		}
+	}
+}
+
+// purgeExpiredWebAuthnSessions periodically drops passkey ceremonies that were never finished
+func purgeExpiredWebAuthnSessions(repo *database.WebAuthnRepository) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := repo.PurgeExpiredSessions(); err != nil {
+			log.Println("Failed to purge expired WebAuthn sessions:", err)
+		}
+	}
+}
+
+// purgeExpiredOAuthStates periodically drops OAuth logins that were never completed
+func purgeExpiredOAuthStates(store oauthstate.Store) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := store.PurgeExpired(); err != nil {
+			log.Println("Failed to purge expired OAuth states:", err)
+		}
+	}
+}
+
+// purgeDeletedAccounts periodically removes accounts whose deletion grace period is over
+func purgeDeletedAccounts(accountService *services.AccountService) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := accountService.PurgeDeletedAccounts()
+		if err != nil {
+			log.Println("Failed to purge deleted accounts:", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted accounts", purged)
+		}
+	}
+}
+
+// processDataExports builds queued data exports and periodically removes
+// archives whose download window is over
+func processDataExports(exportService *services.DataExportService) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+	for range ticker.C {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			processed, err := exportService.ProcessNext(ctx)
+			cancel()
+			if err != nil {
+				log.Println("Failed to process data export:", err)
+			}
+			if !processed {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) >= time.Hour {
+			if err := exportService.PurgeExpired(); err != nil {
+				log.Println("Failed to purge expired data exports:", err)
+			}
+			lastPurge = time.Now()
+		}
+	}
+}
+
+// relayEvents periodically publishes the events waiting in the outbox
+func relayEvents(outbox *database.EventOutboxRepository, publisher events.Publisher) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := events.Relay(ctx, outbox, publisher); err != nil {
+			log.Println("Failed to relay events:", err)
+		}
+		cancel()
+
+		if time.Since(lastPurge) >= time.Hour {
+			if err := outbox.PurgePublished(); err != nil {
+				log.Println("Failed to purge published events:", err)
+			}
+			lastPurge = time.Now()
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OAuth provider types
+const (
+	OAuthProviderTypeOIDC   = "oidc"
+	OAuthProviderTypeOAuth2 = "oauth2"
+	// OAuthProviderTypeApple is OIDC without discovery, with a signed client
+	// secret and form POST callbacks
+	OAuthProviderTypeApple = "apple"
+)
+
+// OAuthProviderConfig describes one external identity provider. OIDC
+// providers only need an issuer, their endpoints are discovered. Plain OAuth2
+// providers (GitHub, Facebook) need explicit endpoints and a user info URL.
+// Apple needs the team ID, key ID and key file instead of a client secret.
+type OAuthProviderConfig struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Type         string   `json:"type"`
+	Issuer       string   `json:"issuer,omitempty"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	AuthURL      string   `json:"auth_url,omitempty"`
+	TokenURL     string   `json:"token_url,omitempty"`
+	UserInfoURL  string   `json:"userinfo_url,omitempty"`
+	// EmailsURL lists the user's addresses as [{"email", "primary",
+	// "verified"}] like GitHub's /user/emails, for providers that leave
+	// private addresses out of the user info or don't say which are verified
+	EmailsURL string `json:"emails_url,omitempty"`
+	// JWKSURL skips OIDC discovery when set together with auth_url and token_url
+	JWKSURL    string            `json:"jwks_url,omitempty"`
+	AuthParams map[string]string `json:"auth_params,omitempty"`
+	Claims     OAuthClaimMapping `json:"claims"`
+	// TrustEmail marks providers that only ever return verified addresses
+	// but have no claim saying so
+	TrustEmail bool `json:"trust_email,omitempty"`
+	// DisablePKCE is for the rare provider that rejects PKCE parameters
+	DisablePKCE bool `json:"disable_pkce,omitempty"`
+
+	TeamID         string `json:"team_id,omitempty"`
+	KeyID          string `json:"key_id,omitempty"`
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+}
+
+// OAuthClaimMapping names the claims, or dot separated paths into the user
+// info document, that hold each user attribute
+type OAuthClaimMapping struct {
+	Subject       string `json:"subject,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified string `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	// PrivateEmail flags relay addresses such as Apple's "Hide My Email"
+	PrivateEmail string `json:"private_email,omitempty"`
+}
+
+// LoadOAuthProviders reads the providers file, if any, and adds Google and
+// Facebook when they are configured through their own environment variables.
+// ${VAR} references in the file are replaced from the environment so secrets
+// can stay out of it.
+//
+//	{"providers": [{"name": "gitlab", "issuer": "https://gitlab.com",
+//	  "client_id": "...", "client_secret": "${GITLAB_CLIENT_SECRET}"}]}
+func LoadOAuthProviders(cfg OAuthConfig) ([]OAuthProviderConfig, error) {
+	var file struct {
+		Providers []OAuthProviderConfig `json:"providers"`
+	}
+
+	if cfg.ProvidersFile != "" {
+		data, err := os.ReadFile(cfg.ProvidersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OAuth providers file: %w", err)
+		}
+		if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+			return nil, fmt.Errorf("failed to parse OAuth providers file: %w", err)
+		}
+	}
+
+	providers := file.Providers
+	seen := make(map[string]bool)
+	for i := range providers {
+		provider := &providers[i]
+		if provider.Name == "" || strings.ContainsAny(provider.Name, "/?#") {
+			return nil, fmt.Errorf("invalid OAuth provider name %q", provider.Name)
+		}
+		if seen[provider.Name] {
+			return nil, fmt.Errorf("duplicate OAuth provider %q", provider.Name)
+		}
+		seen[provider.Name] = true
+	}
+
+	if cfg.Google.ClientID != "" && !seen["google"] {
+		providers = append(providers, OAuthProviderConfig{
+			Name:         "google",
+			DisplayName:  "Google",
+			Type:         OAuthProviderTypeOIDC,
+			Issuer:       "https://accounts.google.com",
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.ClientSecret,
+			RedirectURL:  cfg.Google.RedirectURL,
+			Scopes:       []string{"openid", "profile", "email"},
+		})
+	}
+	if cfg.Facebook.ClientID != "" && !seen["facebook"] {
+		providers = append(providers, OAuthProviderConfig{
+			Name:         "facebook",
+			DisplayName:  "Facebook",
+			Type:         OAuthProviderTypeOAuth2,
+			ClientID:     cfg.Facebook.ClientID,
+			ClientSecret: cfg.Facebook.ClientSecret,
+			RedirectURL:  cfg.Facebook.RedirectURL,
+			Scopes:       []string{"email", "public_profile"},
+			AuthURL:      "https://www.facebook.com/v3.2/dialog/oauth",
+			TokenURL:     "https://graph.facebook.com/v3.2/oauth/access_token",
+			UserInfoURL:  "https://graph.facebook.com/me?fields=id,name,email,picture",
+			Claims: OAuthClaimMapping{
+				Subject: "id",
+				Picture: "picture.data.url",
+			},
+			// Facebook says nothing about whether the email is verified, so
+			// a matching account is only linked once its owner confirms
+		})
+	}
+
+	if cfg.Apple.ClientID != "" && !seen["apple"] {
+		providers = append(providers, OAuthProviderConfig{
+			Name:           "apple",
+			DisplayName:    "Apple",
+			Type:           OAuthProviderTypeApple,
+			ClientID:       cfg.Apple.ClientID,
+			RedirectURL:    cfg.Apple.RedirectURL,
+			TeamID:         cfg.Apple.TeamID,
+			KeyID:          cfg.Apple.KeyID,
+			PrivateKeyPath: cfg.Apple.PrivateKeyPath,
+		})
+	}
+
+	for i := range providers {
+		providers[i].applyDefaults(cfg.CallbackBaseURL)
+		if err := providers[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return providers, nil
+}
+
+func (p *OAuthProviderConfig) applyDefaults(callbackBaseURL string) {
+	if p.Type == "" {
+		p.Type = OAuthProviderTypeOIDC
+	}
+	if p.Type == OAuthProviderTypeApple {
+		p.applyAppleDefaults()
+	}
+	if p.DisplayName == "" {
+		p.DisplayName = p.Name
+	}
+	if p.RedirectURL == "" {
+		p.RedirectURL = strings.TrimRight(callbackBaseURL, "/") + "/auth/" + p.Name + "/callback"
+	}
+	if len(p.Scopes) == 0 && p.Type == OAuthProviderTypeOIDC {
+		p.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	if p.Claims.Subject == "" {
+		p.Claims.Subject = "sub"
+		if p.Type == OAuthProviderTypeOAuth2 {
+			p.Claims.Subject = "id"
+		}
+	}
+	if p.Claims.Email == "" {
+		p.Claims.Email = "email"
+	}
+	if p.Claims.EmailVerified == "" && p.Type != OAuthProviderTypeOAuth2 {
+		p.Claims.EmailVerified = "email_verified"
+	}
+	if p.Claims.Name == "" {
+		p.Claims.Name = "name"
+	}
+	if p.Claims.Picture == "" {
+		p.Claims.Picture = "picture"
+	}
+}
+
+func (p *OAuthProviderConfig) applyAppleDefaults() {
+	if p.Issuer == "" {
+		p.Issuer = "https://appleid.apple.com"
+	}
+	if p.AuthURL == "" {
+		p.AuthURL = "https://appleid.apple.com/auth/authorize"
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = "https://appleid.apple.com/auth/token"
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = "https://appleid.apple.com/auth/keys"
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{"name", "email"}
+	}
+	// Apple only returns the user's name and email to a form POST callback
+	if p.AuthParams == nil {
+		p.AuthParams = map[string]string{}
+	}
+	if _, exists := p.AuthParams["response_mode"]; !exists {
+		p.AuthParams["response_mode"] = "form_post"
+	}
+	// Apple's token endpoint doesn't support PKCE, the nonce binds the ID token instead
+	p.DisablePKCE = true
+	if p.Claims.PrivateEmail == "" {
+		p.Claims.PrivateEmail = "is_private_email"
+	}
+}
+
+func (p *OAuthProviderConfig) validate() error {
+	if p.ClientID == "" {
+		return fmt.Errorf("OAuth provider %q has no client_id", p.Name)
+	}
+
+	switch p.Type {
+	case OAuthProviderTypeOIDC:
+		if p.Issuer == "" {
+			return fmt.Errorf("OIDC provider %q has no issuer", p.Name)
+		}
+		if p.JWKSURL != "" && (p.AuthURL == "" || p.TokenURL == "") {
+			return fmt.Errorf("OIDC provider %q with jwks_url needs auth_url and token_url", p.Name)
+		}
+	case OAuthProviderTypeOAuth2:
+		if p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" {
+			return fmt.Errorf("OAuth2 provider %q needs auth_url, token_url and userinfo_url", p.Name)
+		}
+	case OAuthProviderTypeApple:
+		if p.TeamID == "" || p.KeyID == "" || p.PrivateKeyPath == "" {
+			return fmt.Errorf("Apple provider %q needs team_id, key_id and private_key_path", p.Name)
+		}
+	default:
+		return fmt.Errorf("OAuth provider %q has unknown type %q", p.Name, p.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditLogRepository struct {
+	db *sqlx.DB
+}
+
+func NewAuditLogRepository(db *sqlx.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+const (
+	createAuditLogEntryQuery = `
+		INSERT INTO audit_log (actor_id, target_user_id, action, details, ip_address)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	listAuditLogQuery = `
+		SELECT id, actor_id, target_user_id, action, details, ip_address, created_at
+		FROM audit_log
+		WHERE ($1::uuid IS NULL OR target_user_id = $1)
+			AND ($2 = '' OR action = $2)
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4`
+
+	listUserAuditLogQuery = `
+		SELECT id, actor_id, target_user_id, action, details, ip_address, created_at
+		FROM audit_log WHERE target_user_id = $1
+		ORDER BY created_at DESC`
+)
+
+func (r *AuditLogRepository) Create(entry *models.AuditLogEntry) error {
+	_, err := r.db.Exec(
+		createAuditLogEntryQuery,
+		entry.ActorID,
+		entry.TargetUserID,
+		entry.Action,
+		entry.Details,
+		entry.IPAddress,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// List returns the newest entries first, optionally only those about one
+// user or of one action
+func (r *AuditLogRepository) List(query *models.AuditLogQuery) ([]models.AuditLogEntry, error) {
+	entries := []models.AuditLogEntry{}
+
+	err := r.db.Select(&entries, listAuditLogQuery, query.UserID, query.Action, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// ListForUser returns every entry about the user, newest first
+func (r *AuditLogRepository) ListForUser(userID uuid.UUID) ([]models.AuditLogEntry, error) {
+	entries := []models.AuditLogEntry{}
+
+	if err := r.db.Select(&entries, listUserAuditLogQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type DataExportRepository struct {
+	db *sqlx.DB
+}
+
+func NewDataExportRepository(db *sqlx.DB) *DataExportRepository {
+	return &DataExportRepository{db: db}
+}
+
+const dataExportColumns = `id, user_id, status, file_path, error, created_at, started_at, completed_at, expires_at`
+
+const (
+	// Only one export per user is pending or running at a time
+	createDataExportQuery = `
+		INSERT INTO data_exports (user_id)
+		SELECT $1
+		WHERE NOT EXISTS(SELECT 1 FROM data_exports WHERE user_id = $1 AND status IN ('pending', 'running'))
+		RETURNING ` + dataExportColumns
+
+	getDataExportQuery = `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports WHERE id = $1`
+
+	listDataExportsQuery = `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	// Jobs left running by a crashed worker are picked up again once stale.
+	// SKIP LOCKED lets several instances work through the queue.
+	claimDataExportQuery = `
+		UPDATE data_exports SET status = 'running', started_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1)
+		RETURNING ` + dataExportColumns
+
+	completeDataExportQuery = `
+		UPDATE data_exports SET status = 'completed', file_path = $2, completed_at = CURRENT_TIMESTAMP, expires_at = $3
+		WHERE id = $1`
+
+	failDataExportQuery = `
+		UPDATE data_exports SET status = 'failed', error = $2, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+
+	listExpiredDataExportsQuery = `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE status = 'completed' AND expires_at <= CURRENT_TIMESTAMP`
+
+	expireDataExportQuery = `
+		UPDATE data_exports SET status = 'expired', file_path = NULL
+		WHERE id = $1`
+)
+
+// Create queues an export for the user. It returns nil if one is already
+// pending or running.
+func (r *DataExportRepository) Create(userID uuid.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := r.db.QueryRowx(createDataExportQuery, userID).StructScan(&export)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to create data export: %w", err)
+	}
+
+	return &export, nil
+}
+
+func (r *DataExportRepository) GetByID(id uuid.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := r.db.QueryRowx(getDataExportQuery, id).StructScan(&export)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+
+	return &export, nil
+}
+
+func (r *DataExportRepository) ListByUserID(userID uuid.UUID) ([]models.DataExport, error) {
+	exports := []models.DataExport{}
+
+	if err := r.db.Select(&exports, listDataExportsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list data exports: %w", err)
+	}
+
+	return exports, nil
+}
+
+// ClaimNext marks the oldest pending export as running and returns it, or
+// nil when there is nothing to do. Exports running since before staleBefore
+// are claimed again.
+func (r *DataExportRepository) ClaimNext(staleBefore time.Time) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := r.db.QueryRowx(claimDataExportQuery, staleBefore).StructScan(&export)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim data export: %w", err)
+	}
+
+	return &export, nil
+}
+
+func (r *DataExportRepository) Complete(id uuid.UUID, filePath string, expiresAt time.Time) error {
+	if _, err := r.db.Exec(completeDataExportQuery, id, filePath, expiresAt); err != nil {
+		return fmt.Errorf("failed to complete data export: %w", err)
+	}
+	return nil
+}
+
+func (r *DataExportRepository) Fail(id uuid.UUID, reason string) error {
+	if _, err := r.db.Exec(failDataExportQuery, id, reason); err != nil {
+		return fmt.Errorf("failed to record data export failure: %w", err)
+	}
+	return nil
+}
+
+// ListExpired returns completed exports whose download window is over
+func (r *DataExportRepository) ListExpired() ([]models.DataExport, error) {
+	exports := []models.DataExport{}
+
+	if err := r.db.Select(&exports, listExpiredDataExportsQuery); err != nil {
+		return nil, fmt.Errorf("failed to list expired data exports: %w", err)
+	}
+
+	return exports, nil
+}
+
+// MarkExpired records that the archive of an export was removed
+func (r *DataExportRepository) MarkExpired(id uuid.UUID) error {
+	if _, err := r.db.Exec(expireDataExportQuery, id); err != nil {
+		return fmt.Errorf("failed to expire data export: %w", err)
+	}
+	return nil
+}
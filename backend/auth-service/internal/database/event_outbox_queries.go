@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// EventOutboxRepository reads the events waiting to be published. Events are
+// written by the repository making the change they describe, in its
+// transaction, so neither can happen without the other.
+type EventOutboxRepository struct {
+	db *sqlx.DB
+}
+
+func NewEventOutboxRepository(db *sqlx.DB) *EventOutboxRepository {
+	return &EventOutboxRepository{db: db}
+}
+
+const (
+	createEventQuery = `
+		INSERT INTO event_outbox (id, event_type, payload, created_at)
+		VALUES ($1, $2, $3, $4)`
+
+	listPendingEventsQuery = `
+		SELECT id, event_type, payload, attempts, created_at
+		FROM event_outbox
+		WHERE published_at IS NULL
+		ORDER BY created_at
+		LIMIT $1`
+
+	markEventPublishedQuery = `
+		UPDATE event_outbox SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1
+		WHERE id = $1`
+
+	recordEventFailureQuery = `UPDATE event_outbox SET attempts = attempts + 1 WHERE id = $1`
+
+	purgePublishedEventsQuery = `
+		DELETE FROM event_outbox
+		WHERE published_at < CURRENT_TIMESTAMP - INTERVAL '7 days'`
+)
+
+// ListPending returns the oldest unpublished events
+func (r *EventOutboxRepository) ListPending(limit int) ([]models.Event, error) {
+	events := []models.Event{}
+
+	if err := r.db.Select(&events, listPendingEventsQuery, limit); err != nil {
+		return nil, fmt.Errorf("failed to list pending events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (r *EventOutboxRepository) MarkPublished(id uuid.UUID) error {
+	if _, err := r.db.Exec(markEventPublishedQuery, id); err != nil {
+		return fmt.Errorf("failed to mark event published: %w", err)
+	}
+	return nil
+}
+
+func (r *EventOutboxRepository) RecordFailure(id uuid.UUID) error {
+	if _, err := r.db.Exec(recordEventFailureQuery, id); err != nil {
+		return fmt.Errorf("failed to record event failure: %w", err)
+	}
+	return nil
+}
+
+// PurgePublished drops events published more than a week ago
+func (r *EventOutboxRepository) PurgePublished() error {
+	if _, err := r.db.Exec(purgePublishedEventsQuery); err != nil {
+		return fmt.Errorf("failed to purge published events: %w", err)
+	}
+	return nil
+}
+
+// createEvent adds an event to the outbox within the caller's transaction
+func createEvent(tx *sqlx.Tx, event *models.Event) error {
+	if _, err := tx.Exec(createEventQuery, event.ID, event.Type, string(event.Payload), event.CreatedAt); err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type MFARepository struct {
+	db *sqlx.DB
+}
+
+func NewMFARepository(db *sqlx.DB) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+const (
+	getUserMFAQuery = `
+		SELECT user_id, totp_secret, totp_enabled_at, totp_last_used_step, created_at
+		FROM user_mfa WHERE user_id = $1`
+
+	savePendingTOTPQuery = `
+		INSERT INTO user_mfa (user_id, totp_secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			totp_secret = EXCLUDED.totp_secret,
+			totp_enabled_at = NULL,
+			totp_last_used_step = NULL,
+			created_at = CURRENT_TIMESTAMP
+		WHERE user_mfa.totp_enabled_at IS NULL`
+
+	enableTOTPQuery = `
+		UPDATE user_mfa SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_used_step = $2
+		WHERE user_id = $1 AND totp_enabled_at IS NULL`
+
+	useTOTPStepQuery = `
+		UPDATE user_mfa SET totp_last_used_step = $2
+		WHERE user_id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2)`
+
+	deleteUserMFAQuery = `DELETE FROM user_mfa WHERE user_id = $1`
+
+	deleteRecoveryCodesQuery = `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+
+	createRecoveryCodeQuery = `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+
+	useRecoveryCodeQuery = `
+		UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	countRecoveryCodesQuery = `
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+)
+
+func (r *MFARepository) GetByUserID(userID uuid.UUID) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+
+	err := r.db.QueryRowx(getUserMFAQuery, userID).StructScan(&mfa)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user MFA: %w", err)
+	}
+
+	return &mfa, nil
+}
+
+// SavePendingTOTP stores a new secret awaiting confirmation. It never
+// replaces the secret of an enabled enrollment.
+func (r *MFARepository) SavePendingTOTP(userID uuid.UUID, encryptedSecret string) error {
+	if _, err := r.db.Exec(savePendingTOTPQuery, userID, encryptedSecret); err != nil {
+		return fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+	return nil
+}
+
+func (r *MFARepository) EnableTOTP(userID uuid.UUID, step int64) (bool, error) {
+	return r.execAffected(enableTOTPQuery, "failed to enable TOTP", userID, step)
+}
+
+// UseTOTPStep records an accepted time step. It returns false if that step or
+// a later one was already used, so each code works only once.
+func (r *MFARepository) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	return r.execAffected(useTOTPStepQuery, "failed to record TOTP step", userID, step)
+}
+
+// Delete removes the TOTP enrollment together with the recovery codes
+func (r *MFARepository) Delete(userID uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteRecoveryCodesQuery, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(deleteUserMFAQuery, userID); err != nil {
+		return fmt.Errorf("failed to delete user MFA: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates all recovery codes and stores new ones
+func (r *MFARepository) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteRecoveryCodesQuery, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec(createRecoveryCodeQuery, userID, codeHash); err != nil {
+			return fmt.Errorf("failed to create recovery code: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used
+func (r *MFARepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	return r.execAffected(useRecoveryCodeQuery, "failed to use recovery code", userID, codeHash)
+}
+
+func (r *MFARepository) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	if err := r.db.QueryRow(countRecoveryCodesQuery, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+func (r *MFARepository) execAffected(query, errMsg string, args ...interface{}) (bool, error) {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	return rows > 0, nil
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type OAuthClientRepository struct {
+	db *sqlx.DB
+}
+
+func NewOAuthClientRepository(db *sqlx.DB) *OAuthClientRepository {
+	return &OAuthClientRepository{db: db}
+}
+
+const oauthClientColumns = `id, client_id, client_secret_hash, name, redirect_uris, scopes, public, owner_id, created_at`
+
+const (
+	createOAuthClientQuery = `
+		INSERT INTO oauth_clients (client_id, client_secret_hash, name, redirect_uris, scopes, public, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + oauthClientColumns
+
+	getOAuthClientQuery = `
+		SELECT ` + oauthClientColumns + `
+		FROM oauth_clients WHERE client_id = $1`
+
+	listOAuthClientsQuery = `
+		SELECT ` + oauthClientColumns + `
+		FROM oauth_clients WHERE owner_id = $1
+		ORDER BY created_at`
+
+	deleteOAuthClientQuery = `
+		DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2
+		RETURNING ` + oauthClientColumns
+
+	getOAuthConsentQuery = `
+		SELECT user_id, client_id, scopes, granted_at
+		FROM oauth_consents WHERE user_id = $1 AND client_id = $2`
+
+	listOAuthConsentsQuery = `
+		SELECT user_id, client_id, scopes, granted_at
+		FROM oauth_consents WHERE user_id = $1
+		ORDER BY granted_at DESC`
+
+	// Scopes granted earlier are kept so asking for fewer doesn't shrink the consent
+	saveOAuthConsentQuery = `
+		INSERT INTO oauth_consents (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET
+			scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes)),
+			granted_at = CURRENT_TIMESTAMP`
+)
+
+func (r *OAuthClientRepository) Create(client *models.OAuthClient) (*models.OAuthClient, error) {
+	var createdClient models.OAuthClient
+
+	err := r.db.QueryRowx(
+		createOAuthClientQuery,
+		client.ClientID,
+		client.ClientSecretHash,
+		client.Name,
+		client.RedirectURIs,
+		client.Scopes,
+		client.Public,
+		client.OwnerID,
+	).StructScan(&createdClient)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oauth client: %w", err)
+	}
+
+	return &createdClient, nil
+}
+
+func (r *OAuthClientRepository) GetByClientID(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+
+	err := r.db.QueryRowx(getOAuthClientQuery, clientID).StructScan(&client)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get oauth client: %w", err)
+	}
+
+	return &client, nil
+}
+
+func (r *OAuthClientRepository) ListByOwner(ownerID uuid.UUID) ([]models.OAuthClient, error) {
+	clients := []models.OAuthClient{}
+
+	if err := r.db.Select(&clients, listOAuthClientsQuery, ownerID); err != nil {
+		return nil, fmt.Errorf("failed to list oauth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// Delete removes a client of the owner and returns it, or nil if the owner
+// has no such client. Its consents and sessions go with it, which ends every
+// token issued to it.
+func (r *OAuthClientRepository) Delete(ownerID, id uuid.UUID) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+
+	err := r.db.QueryRowx(deleteOAuthClientQuery, id, ownerID).StructScan(&client)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to delete oauth client: %w", err)
+	}
+
+	return &client, nil
+}
+
+func (r *OAuthClientRepository) GetConsent(userID uuid.UUID, clientID string) (*models.OAuthConsent, error) {
+	var consent models.OAuthConsent
+
+	err := r.db.QueryRowx(getOAuthConsentQuery, userID, clientID).StructScan(&consent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get oauth consent: %w", err)
+	}
+
+	return &consent, nil
+}
+
+// ListConsents returns the third-party apps the user authorized
+func (r *OAuthClientRepository) ListConsents(userID uuid.UUID) ([]models.OAuthConsent, error) {
+	consents := []models.OAuthConsent{}
+
+	if err := r.db.Select(&consents, listOAuthConsentsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list oauth consents: %w", err)
+	}
+
+	return consents, nil
+}
+
+func (r *OAuthClientRepository) SaveConsent(userID uuid.UUID, clientID string, scopes []string) error {
+	if _, err := r.db.Exec(saveOAuthConsentQuery, userID, clientID, pq.StringArray(scopes)); err != nil {
+		return fmt.Errorf("failed to save oauth consent: %w", err)
+	}
+	return nil
+}
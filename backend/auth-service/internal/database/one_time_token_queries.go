@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type OneTimeTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewOneTimeTokenRepository(db *sqlx.DB) *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{db: db}
+}
+
+const (
+	createOneTimeTokenQuery = `
+		INSERT INTO one_time_tokens (user_id, purpose, token_hash, payload, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, purpose, token_hash, payload, expires_at, used_at, created_at`
+
+	getOneTimeTokenQuery = `
+		SELECT id, user_id, purpose, token_hash, payload, expires_at, used_at, created_at
+		FROM one_time_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	consumeOneTimeTokenQuery = `
+		UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, user_id, purpose, token_hash, payload, expires_at, used_at, created_at`
+
+	invalidateOneTimeTokensQuery = `
+		UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+
+	getLatestOneTimeTokenTimeQuery = `
+		SELECT created_at FROM one_time_tokens
+		WHERE user_id = $1 AND purpose = $2
+		ORDER BY created_at DESC LIMIT 1`
+)
+
+func (r *OneTimeTokenRepository) Create(token *models.OneTimeToken) (*models.OneTimeToken, error) {
+	var createdToken models.OneTimeToken
+
+	err := r.db.QueryRowx(
+		createOneTimeTokenQuery,
+		token.UserID,
+		token.Purpose,
+		token.TokenHash,
+		token.Payload,
+		token.ExpiresAt,
+	).StructScan(&createdToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create one-time token: %w", err)
+	}
+
+	return &createdToken, nil
+}
+
+// Get returns an unused, unexpired token without redeeming it
+func (r *OneTimeTokenRepository) Get(purpose, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+
+	err := r.db.QueryRowx(getOneTimeTokenQuery, tokenHash, purpose).StructScan(&token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get one-time token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// Consume marks an unused, unexpired token as used and returns it. It returns
+// nil when no such token exists, so each token can be redeemed only once.
+func (r *OneTimeTokenRepository) Consume(purpose, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+
+	err := r.db.QueryRowx(consumeOneTimeTokenQuery, tokenHash, purpose).StructScan(&token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume one-time token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// InvalidateForUser marks every outstanding token of the purpose as used
+func (r *OneTimeTokenRepository) InvalidateForUser(userID uuid.UUID, purpose string) error {
+	if _, err := r.db.Exec(invalidateOneTimeTokensQuery, userID, purpose); err != nil {
+		return fmt.Errorf("failed to invalidate one-time tokens: %w", err)
+	}
+	return nil
+}
+
+// GetLatestCreatedAt returns when the user was last issued a token of the purpose
+func (r *OneTimeTokenRepository) GetLatestCreatedAt(userID uuid.UUID, purpose string) (*time.Time, error) {
+	var createdAt time.Time
+
+	err := r.db.QueryRow(getLatestOneTimeTokenTimeQuery, userID, purpose).Scan(&createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get latest one-time token: %w", err)
+	}
+
+	return &createdAt, nil
+}
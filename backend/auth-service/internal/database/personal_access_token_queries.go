@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type PersonalAccessTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewPersonalAccessTokenRepository(db *sqlx.DB) *PersonalAccessTokenRepository {
+	return &PersonalAccessTokenRepository{db: db}
+}
+
+const personalAccessTokenColumns = `id, user_id, name, token_prefix, token_hash, scopes, expires_at, last_used_at, created_at`
+
+const (
+	createPersonalAccessTokenQuery = `
+		INSERT INTO personal_access_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + personalAccessTokenColumns
+
+	getPersonalAccessTokenByHashQuery = `
+		SELECT ` + personalAccessTokenColumns + `
+		FROM personal_access_tokens WHERE token_hash = $1`
+
+	listPersonalAccessTokensQuery = `
+		SELECT ` + personalAccessTokenColumns + `
+		FROM personal_access_tokens WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	// Written at most once a minute per token so busy bots don't turn every
+	// request into a write
+	touchPersonalAccessTokenQuery = `
+		UPDATE personal_access_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')`
+
+	deletePersonalAccessTokenQuery = `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`
+
+	deleteUserPersonalAccessTokensQuery = `DELETE FROM personal_access_tokens WHERE user_id = $1`
+)
+
+func (r *PersonalAccessTokenRepository) Create(token *models.PersonalAccessToken) (*models.PersonalAccessToken, error) {
+	var createdToken models.PersonalAccessToken
+
+	err := r.db.QueryRowx(
+		createPersonalAccessTokenQuery,
+		token.UserID,
+		token.Name,
+		token.TokenPrefix,
+		token.TokenHash,
+		token.Scopes,
+		token.ExpiresAt,
+	).StructScan(&createdToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create personal access token: %w", err)
+	}
+
+	return &createdToken, nil
+}
+
+func (r *PersonalAccessTokenRepository) GetByHash(tokenHash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+
+	err := r.db.QueryRowx(getPersonalAccessTokenByHashQuery, tokenHash).StructScan(&token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get personal access token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *PersonalAccessTokenRepository) ListByUserID(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	tokens := []models.PersonalAccessToken{}
+
+	if err := r.db.Select(&tokens, listPersonalAccessTokensQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list personal access tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// Touch records that the token was used
+func (r *PersonalAccessTokenRepository) Touch(id uuid.UUID) error {
+	if _, err := r.db.Exec(touchPersonalAccessTokenQuery, id); err != nil {
+		return fmt.Errorf("failed to update personal access token: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a token of the user. It returns false if the user has no
+// such token.
+func (r *PersonalAccessTokenRepository) Delete(userID, id uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(deletePersonalAccessTokenQuery, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete personal access token: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete personal access token: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// RevokeAllForUser removes every token of the user
+func (r *PersonalAccessTokenRepository) RevokeAllForUser(userID uuid.UUID) error {
+	if _, err := r.db.Exec(deleteUserPersonalAccessTokensQuery, userID); err != nil {
+		return fmt.Errorf("failed to revoke personal access tokens: %w", err)
+	}
+	return nil
+}
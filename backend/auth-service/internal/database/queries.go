@@ -2,13 +2,37 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// usernameConstraints are the unique indexes a username can violate: the
+// exact username, its lowercase form and its look-alike skeleton
+var usernameConstraints = map[string]bool{
+	"users_username_key":          true,
+	"idx_users_username_lower":    true,
+	"idx_users_username_skeleton": true,
+}
+
+// usernameTaken reports whether err is the violation of a unique username
+// index, e.g. when a look-alike was registered concurrently
+func usernameTaken(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && usernameConstraints[pqErr.Constraint]
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 type UserRepository struct {
 	db *sqlx.DB
 }
@@ -17,27 +41,32 @@ func NewUserRepository(db *sqlx.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// userColumns are the columns of a user except the password hash, which only
+// the lookups select
+const userColumns = `id, username, display_name, email, bio, profile_image_url, oauth_providers, email_verified_at,
+	credential_version, suspended_at, suspended_until, suspension_reason, suspended_by, deleted_at, created_at`
+
 const (
 	createUserQuery = `
-		INSERT INTO users (username, display_name, email, password_hash, oauth_providers)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, username, display_name, email, bio, profile_image_url, oauth_providers, created_at`
+		INSERT INTO users (username, display_name, email, password_hash, oauth_providers, username_skeleton)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + userColumns
 
 	getUserByIDQuery = `
-		SELECT id, username, display_name, email, bio, profile_image_url, oauth_providers, created_at
+		SELECT ` + userColumns + `, password_hash
 		FROM users WHERE id = $1`
 
 	getUserByEmailQuery = `
-		SELECT id, username, display_name, email, password_hash, bio, profile_image_url, oauth_providers, created_at
+		SELECT ` + userColumns + `, password_hash
 		FROM users WHERE email = $1`
 
 	getUserByUsernameQuery = `
-		SELECT id, username, display_name, email, password_hash, bio, profile_image_url, oauth_providers, created_at
-		FROM users WHERE username = $1`
+		SELECT ` + userColumns + `, password_hash
+		FROM users WHERE LOWER(username) = LOWER($1)`
 
 	getUserByOAuthQuery = `
-		SELECT id, username, display_name, email, password_hash, bio, profile_image_url, oauth_providers, created_at
-		FROM users WHERE oauth_providers->$1->>'id' = $2`
+		SELECT ` + userColumns + `, password_hash
+		FROM users WHERE oauth_providers @> jsonb_build_object($1::text, jsonb_build_object('id', $2::text))`
 
 	updateUserQuery = `
 		UPDATE users SET 
@@ -45,17 +74,133 @@ const (
 			bio = COALESCE($3, bio),
 			profile_image_url = COALESCE($4, profile_image_url)
 		WHERE id = $1
-		RETURNING id, username, display_name, email, bio, profile_image_url, oauth_providers, created_at`
+		RETURNING ` + userColumns
 
-	updateUserOAuthQuery = `
-		UPDATE users SET oauth_providers = $2
+	linkUserOAuthQuery = `
+		UPDATE users SET oauth_providers = COALESCE(oauth_providers, '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb)
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	// The user must keep a password, another provider or a usable passkey
+	unlinkUserOAuthQuery = `
+		UPDATE users SET oauth_providers = oauth_providers - $2::text
+		WHERE id = $1 AND oauth_providers ? $2::text AND (
+			COALESCE(password_hash, '') <> ''
+			OR (SELECT COUNT(*) FROM jsonb_object_keys(oauth_providers)) > 1
+			OR EXISTS(SELECT 1 FROM webauthn_credentials WHERE user_id = users.id AND clone_warning = FALSE))
+		RETURNING ` + userColumns
+
+	updatePasswordQuery = `
+		UPDATE users SET password_hash = $2, credential_version = credential_version + 1
 		WHERE id = $1
-		RETURNING id, username, display_name, email, bio, profile_image_url, oauth_providers, created_at`
+		RETURNING credential_version`
+
+	markEmailVerifiedQuery = `
+		UPDATE users SET email_verified_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND email_verified_at IS NULL`
+
+	// $1 is the raw query, matched exactly against the ID, and $2 its LIKE
+	// pattern for email and username
+	searchUsersQuery = `
+		SELECT ` + userColumns + `, password_hash
+		FROM users
+		WHERE $1 = '' OR id::text = $1 OR email ILIKE $2 OR username ILIKE $2
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4`
+
+	countUsersQuery = `
+		SELECT COUNT(*) FROM users
+		WHERE $1 = '' OR id::text = $1 OR email ILIKE $2 OR username ILIKE $2`
+
+	// A suspension also bumps the credential version so access tokens issued
+	// before it stop working at once
+	suspendUserQuery = `
+		UPDATE users SET
+			suspended_at = CURRENT_TIMESTAMP,
+			suspended_until = $2,
+			suspension_reason = $3,
+			suspended_by = $4,
+			credential_version = credential_version + 1
+		WHERE id = $1
+		RETURNING ` + userColumns + `, password_hash`
+
+	unsuspendUserQuery = `
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL
+		WHERE id = $1 AND suspended_at IS NOT NULL
+		RETURNING ` + userColumns + `, password_hash`
 
-	checkEmailExistsQuery    = `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
-	checkUsernameExistsQuery = `SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)`
+	userIsAdminQuery = `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id = $1 AND role = 'admin')`
+
+	softDeleteUserQuery = `
+		UPDATE users SET deleted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING ` + userColumns + `, password_hash`
+
+	restoreUserQuery = `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	listDeletedUsersQuery = `
+		SELECT ` + userColumns + `, password_hash
+		FROM users WHERE deleted_at <= $1
+		ORDER BY deleted_at
+		LIMIT $2`
+
+	// Restoring the account in the meantime makes the purge a no-op
+	purgeUserQuery = `DELETE FROM users WHERE id = $1 AND deleted_at <= $2`
+
+	// $4 is the end of the cooldown: the update is skipped if the user changed
+	// their username after it, or changed it concurrently
+	changeUsernameQuery = `
+		UPDATE users SET username = $3, username_skeleton = $5
+		WHERE id = $1 AND username = $2 AND deleted_at IS NULL
+			AND NOT EXISTS(SELECT 1 FROM username_history WHERE user_id = $1 AND changed_at > $4)
+		RETURNING ` + userColumns + `, password_hash`
+
+	createUsernameChangeQuery = `
+		INSERT INTO username_history (user_id, old_username, new_username, reserved_until, old_username_skeleton)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, old_username, new_username, changed_at, reserved_until`
+
+	// Taking back an old username ends its reservation
+	releaseUsernameQuery = `
+		UPDATE username_history SET reserved_until = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND old_username_skeleton = $2 AND reserved_until > CURRENT_TIMESTAMP`
+
+	getLastUsernameChangeQuery = `
+		SELECT id, user_id, old_username, new_username, changed_at, reserved_until
+		FROM username_history WHERE user_id = $1
+		ORDER BY changed_at DESC
+		LIMIT 1`
+
+	listUsernameHistoryQuery = `
+		SELECT id, user_id, old_username, new_username, changed_at, reserved_until
+		FROM username_history WHERE user_id = $1
+		ORDER BY changed_at DESC`
+
+	getUsernameRedirectQuery = `
+		SELECT u.username
+		FROM username_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE LOWER(h.old_username) = LOWER($1) AND h.reserved_until > CURRENT_TIMESTAMP AND u.deleted_at IS NULL
+		ORDER BY h.changed_at DESC
+		LIMIT 1`
+
+	checkEmailExistsQuery = `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
+
+	// Usernames are compared by skeleton, so look-alikes and usernames
+	// reserved for their previous owner count as taken
+	checkUsernameExistsQuery = `
+		SELECT EXISTS(SELECT 1 FROM users WHERE username_skeleton = $1)
+			OR EXISTS(SELECT 1 FROM username_history WHERE old_username_skeleton = $1 AND reserved_until > CURRENT_TIMESTAMP)`
+
+	// The user's own username and reservations don't count
+	checkUsernameAvailableQuery = `
+		SELECT NOT EXISTS(SELECT 1 FROM users WHERE username_skeleton = $1 AND id <> $2)
+			AND NOT EXISTS(SELECT 1 FROM username_history
+				WHERE old_username_skeleton = $1 AND reserved_until > CURRENT_TIMESTAMP AND user_id <> $2)`
 )
 
+// Create inserts the user. It returns nil if the username or a look-alike of
+// it was taken in the meantime.
 func (r *UserRepository) Create(user *models.User) (*models.User, error) {
 	var createdUser models.User
 
@@ -66,9 +211,13 @@ func (r *UserRepository) Create(user *models.User) (*models.User, error) {
 		user.Email,
 		user.PasswordHash,
 		user.OAuthProviders,
+		usernames.Skeleton(user.Username),
 	).StructScan(&createdUser)
 
 	if err != nil {
+		if usernameTaken(err) {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -152,10 +301,33 @@ func (r *UserRepository) Update(id uuid.UUID, req *models.UpdateProfileRequest)
 	return &user, nil
 }
 
-func (r *UserRepository) UpdateOAuth(id uuid.UUID, oauthData *models.OAuthData) (*models.User, error) {
+// LinkOAuth adds or replaces the identity of one provider without touching
+// the others
+func (r *UserRepository) LinkOAuth(id uuid.UUID, provider string, data *models.OAuthUserData) (*models.User, error) {
+	var user models.User
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OAuth data: %w", err)
+	}
+
+	err = r.db.QueryRowx(linkUserOAuthQuery, id, provider, string(encoded)).StructScan(&user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to update user OAuth: %w", err)
+	}
+
+	return &user, nil
+}
+
+// UnlinkOAuth removes the identity of one provider. It returns nil if the
+// provider isn't linked or it is the user's last way to sign in.
+func (r *UserRepository) UnlinkOAuth(id uuid.UUID, provider string) (*models.User, error) {
 	var user models.User
 
-	err := r.db.QueryRowx(updateUserOAuthQuery, id, oauthData).StructScan(&user)
+	err := r.db.QueryRowx(unlinkUserOAuthQuery, id, provider).StructScan(&user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -166,6 +338,254 @@ func (r *UserRepository) UpdateOAuth(id uuid.UUID, oauthData *models.OAuthData)
 	return &user, nil
 }
 
+// UpdatePassword replaces the password hash and returns the bumped credential
+// version
+func (r *UserRepository) UpdatePassword(id uuid.UUID, passwordHash string) (int, error) {
+	var credentialVersion int
+	if err := r.db.QueryRow(updatePasswordQuery, id, passwordHash).Scan(&credentialVersion); err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+	return credentialVersion, nil
+}
+
+func (r *UserRepository) MarkEmailVerified(id uuid.UUID) error {
+	if _, err := r.db.Exec(markEmailVerifiedQuery, id); err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	return nil
+}
+
+// Search returns a page of users whose ID equals the query or whose email or
+// username contains it, newest first, along with the number of matches
+func (r *UserRepository) Search(query string, limit, offset int) ([]models.User, int, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	var total int
+	if err := r.db.QueryRow(countUsersQuery, query, pattern).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	users := []models.User{}
+	if err := r.db.Select(&users, searchUsersQuery, query, pattern, limit, offset); err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// Suspend suspends the user until the given time, or until lifted when it is
+// nil. It returns nil if the user doesn't exist.
+func (r *UserRepository) Suspend(id uuid.UUID, until *time.Time, reason string, suspendedBy uuid.UUID) (*models.User, error) {
+	var user models.User
+
+	err := r.db.QueryRowx(suspendUserQuery, id, until, reason, suspendedBy).StructScan(&user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to suspend user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// Unsuspend lifts the suspension of the user. It returns nil if the user
+// doesn't exist or isn't suspended.
+func (r *UserRepository) Unsuspend(id uuid.UUID) (*models.User, error) {
+	var user models.User
+
+	err := r.db.QueryRowx(unsuspendUserQuery, id).StructScan(&user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to unsuspend user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// SoftDelete marks the user deleted. It returns nil if the user is already
+// deleted or is the last admin, as with revoking the admin role.
+func (r *UserRepository) SoftDelete(id uuid.UUID) (*models.User, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var isAdmin bool
+	if err := tx.Get(&isAdmin, userIsAdminQuery, id); err != nil {
+		return nil, fmt.Errorf("failed to check admin role: %w", err)
+	}
+	if isAdmin {
+		remains, err := otherAdminRemains(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !remains {
+			return nil, nil
+		}
+	}
+
+	var user models.User
+	err = tx.QueryRowx(softDeleteUserQuery, id).StructScan(&user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return &user, nil
+}
+
+// Restore cancels the pending deletion of the user
+func (r *UserRepository) Restore(id uuid.UUID) error {
+	if _, err := r.db.Exec(restoreUserQuery, id); err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	return nil
+}
+
+// ListDeletedBefore returns users deleted at or before the cutoff, oldest first
+func (r *UserRepository) ListDeletedBefore(cutoff time.Time, limit int) ([]models.User, error) {
+	users := []models.User{}
+
+	if err := r.db.Select(&users, listDeletedUsersQuery, cutoff, limit); err != nil {
+		return nil, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+
+	return users, nil
+}
+
+// Purge permanently deletes a user deleted at or before the cutoff, together
+// with everything that references them, and stores the event announcing it.
+// It returns false if the user was restored or purged already.
+func (r *UserRepository) Purge(id uuid.UUID, cutoff time.Time, event *models.Event) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(purgeUserQuery, id, cutoff)
+	if err != nil {
+		return false, fmt.Errorf("failed to purge user: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to purge user: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if err := createEvent(tx, event); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	return true, nil
+}
+
+// ChangeUsername renames the user, records the change and stores the event
+// announcing it. The old username stays reserved until reservedUntil. It
+// returns nil if the user changed their username after cooldownEnd or in the
+// meantime, is deleted, or the new username was taken concurrently.
+func (r *UserRepository) ChangeUsername(id uuid.UUID, oldUsername, newUsername string, cooldownEnd, reservedUntil time.Time) (*models.User, *models.UsernameChange, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var user models.User
+	err = tx.QueryRowx(changeUsernameQuery, id, oldUsername, newUsername, cooldownEnd, usernames.Skeleton(newUsername)).StructScan(&user)
+	if err != nil {
+		if err == sql.ErrNoRows || usernameTaken(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to change username: %w", err)
+	}
+
+	if _, err := tx.Exec(releaseUsernameQuery, id, usernames.Skeleton(newUsername)); err != nil {
+		return nil, nil, fmt.Errorf("failed to release username: %w", err)
+	}
+
+	var change models.UsernameChange
+	err = tx.QueryRowx(createUsernameChangeQuery, id, oldUsername, newUsername, reservedUntil, usernames.Skeleton(oldUsername)).StructScan(&change)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record username change: %w", err)
+	}
+
+	event, err := models.NewEvent(models.EventUserUsernameChanged, &models.UsernameChangedEvent{
+		UserID:      id,
+		OldUsername: oldUsername,
+		NewUsername: newUsername,
+		ChangedAt:   change.ChangedAt,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode username change event: %w", err)
+	}
+	if err := createEvent(tx, event); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit username change: %w", err)
+	}
+	return &user, &change, nil
+}
+
+// GetLastUsernameChange returns the user's most recent username change
+func (r *UserRepository) GetLastUsernameChange(userID uuid.UUID) (*models.UsernameChange, error) {
+	var change models.UsernameChange
+
+	err := r.db.QueryRowx(getLastUsernameChangeQuery, userID).StructScan(&change)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get last username change: %w", err)
+	}
+
+	return &change, nil
+}
+
+// ListUsernameHistory returns the user's username changes, newest first
+func (r *UserRepository) ListUsernameHistory(userID uuid.UUID) ([]models.UsernameChange, error) {
+	changes := []models.UsernameChange{}
+
+	if err := r.db.Select(&changes, listUsernameHistoryQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list username history: %w", err)
+	}
+
+	return changes, nil
+}
+
+// GetUsernameRedirect returns the current username of the user who gave up
+// the given username, while it is still reserved for them. It returns an
+// empty string if there is none.
+func (r *UserRepository) GetUsernameRedirect(oldUsername string) (string, error) {
+	var username string
+
+	err := r.db.QueryRow(getUsernameRedirectQuery, oldUsername).Scan(&username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get username redirect: %w", err)
+	}
+
+	return username, nil
+}
+
 func (r *UserRepository) EmailExists(email string) (bool, error) {
 	var exists bool
 	err := r.db.QueryRow(checkEmailExistsQuery, email).Scan(&exists)
@@ -177,9 +597,20 @@ func (r *UserRepository) EmailExists(email string) (bool, error) {
 
 func (r *UserRepository) UsernameExists(username string) (bool, error) {
 	var exists bool
-	err := r.db.QueryRow(checkUsernameExistsQuery, username).Scan(&exists)
+	err := r.db.QueryRow(checkUsernameExistsQuery, usernames.Skeleton(username)).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check username existence: %w", err)
 	}
 	return exists, nil
 }
+
+// UsernameAvailable reports whether the user can take the username: nobody
+// else uses it or a look-alike, and it is not reserved for someone else
+func (r *UserRepository) UsernameAvailable(username string, userID uuid.UUID) (bool, error) {
+	var available bool
+	err := r.db.QueryRow(checkUsernameAvailableQuery, usernames.Skeleton(username), userID).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check username availability: %w", err)
+	}
+	return available, nil
+}
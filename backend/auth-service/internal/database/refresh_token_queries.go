@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type RefreshTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewRefreshTokenRepository(db *sqlx.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+const (
+	createRefreshTokenQuery = `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, auth_time, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, user_id, family_id, token_hash, auth_time, expires_at, revoked_at, replaced_by, created_at`
+
+	getRefreshTokenByHashQuery = `
+		SELECT id, user_id, family_id, token_hash, auth_time, expires_at, revoked_at, replaced_by, created_at
+		FROM refresh_tokens WHERE token_hash = $1`
+
+	rotateRefreshTokenQuery = `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = $2
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	revokeRefreshTokenFamilyQuery = `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL`
+
+	revokeUserRefreshTokensQuery = `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+)
+
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) (*models.RefreshToken, error) {
+	var createdToken models.RefreshToken
+
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+
+	err := r.db.QueryRowx(
+		createRefreshTokenQuery,
+		token.ID,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.AuthTime,
+		token.ExpiresAt,
+	).StructScan(&createdToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return &createdToken, nil
+}
+
+func (r *RefreshTokenRepository) GetByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+
+	err := r.db.QueryRowx(getRefreshTokenByHashQuery, tokenHash).StructScan(&token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// Rotate marks a token as replaced by its successor. It returns false when the
+// token had already been revoked, which means a concurrent or replayed use.
+func (r *RefreshTokenRepository) Rotate(id, replacedBy uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(rotateRefreshTokenQuery, id, replacedBy)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return rows == 1, nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(familyID uuid.UUID) error {
+	if _, err := r.db.Exec(revokeRefreshTokenFamilyQuery, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(userID uuid.UUID) error {
+	if _, err := r.db.Exec(revokeUserRefreshTokensQuery, userID); err != nil {
+		return fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
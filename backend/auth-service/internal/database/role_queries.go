@@ -0,0 +1,195 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type RoleRepository struct {
+	db *sqlx.DB
+}
+
+func NewRoleRepository(db *sqlx.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+const (
+	listRolesQuery = `
+		SELECT r.name, r.description,
+			COALESCE(ARRAY_AGG(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}') AS permissions
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		GROUP BY r.name, r.description
+		ORDER BY r.name`
+
+	roleExistsQuery = `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`
+
+	listUserRolesQuery = `
+		SELECT user_id, role, granted_by, granted_at
+		FROM user_roles WHERE user_id = $1
+		ORDER BY role`
+
+	listUserPermissionsQuery = `
+		SELECT DISTINCT rp.permission
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = $1
+		ORDER BY rp.permission`
+
+	// Role changes bump the credential version along with the role, so access
+	// tokens carrying the old roles are rejected and clients refresh
+	grantRoleQuery = `
+		WITH granted AS (
+			INSERT INTO user_roles (user_id, role, granted_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, role) DO NOTHING
+			RETURNING user_id
+		)
+		UPDATE users SET credential_version = credential_version + 1
+		WHERE id IN (SELECT user_id FROM granted)`
+
+	revokeRoleQuery = `
+		WITH revoked AS (
+			DELETE FROM user_roles
+			WHERE user_id = $1 AND role = $2
+			RETURNING user_id
+		)
+		UPDATE users SET credential_version = credential_version + 1
+		WHERE id IN (SELECT user_id FROM revoked)`
+
+	// Locks the admins whose accounts aren't deleted. Revoking the admin role
+	// and deleting an account both take these locks before checking another
+	// admin remains, so two of them can't each leave the other as the last.
+	lockAdminsQuery = `
+		SELECT ur.user_id
+		FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id
+		WHERE ur.role = 'admin' AND u.deleted_at IS NULL
+		ORDER BY ur.user_id
+		FOR UPDATE`
+)
+
+func (r *RoleRepository) ListRoles() ([]models.Role, error) {
+	roles := []models.Role{}
+
+	if err := r.db.Select(&roles, listRolesQuery); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func (r *RoleRepository) RoleExists(name string) (bool, error) {
+	var exists bool
+	if err := r.db.Get(&exists, roleExistsQuery, name); err != nil {
+		return false, fmt.Errorf("failed to check role existence: %w", err)
+	}
+	return exists, nil
+}
+
+func (r *RoleRepository) ListUserRoles(userID uuid.UUID) ([]models.UserRole, error) {
+	roles := []models.UserRole{}
+
+	if err := r.db.Select(&roles, listUserRolesQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// GetUserAccess returns the roles of the user and the permissions they grant
+func (r *RoleRepository) GetUserAccess(userID uuid.UUID) (*models.UserAccess, error) {
+	roles, err := r.ListUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	access := &models.UserAccess{}
+	for _, role := range roles {
+		access.Roles = append(access.Roles, role.Role)
+	}
+	if len(access.Roles) == 0 {
+		return access, nil
+	}
+
+	if err := r.db.Select(&access.Permissions, listUserPermissionsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list user permissions: %w", err)
+	}
+
+	return access, nil
+}
+
+// Grant gives the user a role. It returns false if the user already had it.
+func (r *RoleRepository) Grant(userID uuid.UUID, role string, grantedBy uuid.UUID) (bool, error) {
+	return r.execAffected(grantRoleQuery, "failed to grant role", userID, role, grantedBy)
+}
+
+// Revoke takes a role from the user. It returns false if the user doesn't
+// have the role or it is the last admin, so the service can't lock itself out
+// of role management.
+func (r *RoleRepository) Revoke(userID uuid.UUID, role string) (bool, error) {
+	if role != "admin" {
+		return r.execAffected(revokeRoleQuery, "failed to revoke role", userID, role)
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	remains, err := otherAdminRemains(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !remains {
+		return false, nil
+	}
+
+	result, err := tx.Exec(revokeRoleQuery, userID, role)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit role revocation: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// otherAdminRemains locks the admins and reports whether one other than the
+// user remains
+func otherAdminRemains(tx *sqlx.Tx, userID uuid.UUID) (bool, error) {
+	var admins []uuid.UUID
+	if err := tx.Select(&admins, lockAdminsQuery); err != nil {
+		return false, fmt.Errorf("failed to lock admins: %w", err)
+	}
+
+	for _, admin := range admins {
+		if admin != userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *RoleRepository) execAffected(query, errMsg string, args ...interface{}) (bool, error) {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	return rows > 0, nil
+}
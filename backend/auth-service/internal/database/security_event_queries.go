@@ -0,0 +1,47 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type SecurityEventRepository struct {
+	db *sqlx.DB
+}
+
+func NewSecurityEventRepository(db *sqlx.DB) *SecurityEventRepository {
+	return &SecurityEventRepository{db: db}
+}
+
+const (
+	createSecurityEventQuery = `
+		INSERT INTO security_events (user_id, event_type, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4)`
+
+	listUserSecurityEventsQuery = `
+		SELECT id, user_id, event_type, ip_address, user_agent, created_at
+		FROM security_events WHERE user_id = $1
+		ORDER BY created_at DESC`
+)
+
+func (r *SecurityEventRepository) Create(event *models.SecurityEvent) error {
+	_, err := r.db.Exec(createSecurityEventQuery, event.UserID, event.EventType, event.IPAddress, event.UserAgent)
+	if err != nil {
+		return fmt.Errorf("failed to record security event: %w", err)
+	}
+	return nil
+}
+
+// ListByUserID returns every security event of the user, newest first
+func (r *SecurityEventRepository) ListByUserID(userID uuid.UUID) ([]models.SecurityEvent, error) {
+	events := []models.SecurityEvent{}
+
+	if err := r.db.Select(&events, listUserSecurityEventsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list security events: %w", err)
+	}
+
+	return events, nil
+}
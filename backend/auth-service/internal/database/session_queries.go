@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type SessionRepository struct {
+	db *sqlx.DB
+}
+
+func NewSessionRepository(db *sqlx.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+const (
+	createSessionQuery = `
+		INSERT INTO sessions (user_id, user_agent, ip_address, client_id, scope, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, user_id, user_agent, ip_address, client_id, scope, expires_at, revoked_at, last_seen_at, created_at`
+
+	getSessionByIDQuery = `
+		SELECT id, user_id, user_agent, ip_address, client_id, scope, expires_at, revoked_at, last_seen_at, created_at
+		FROM sessions WHERE id = $1`
+
+	listActiveSessionsQuery = `
+		SELECT id, user_id, user_agent, ip_address, client_id, scope, expires_at, revoked_at, last_seen_at, created_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_seen_at DESC`
+
+	listUserSessionsQuery = `
+		SELECT id, user_id, user_agent, ip_address, client_id, scope, expires_at, revoked_at, last_seen_at, created_at
+		FROM sessions WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	touchSessionQuery = `
+		UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	extendSessionQuery = `
+		UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, expires_at = $2
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	revokeSessionQuery = `
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	revokeUserSessionsQuery = `
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+)
+
+func (r *SessionRepository) Create(session *models.Session) (*models.Session, error) {
+	var createdSession models.Session
+
+	err := r.db.QueryRowx(
+		createSessionQuery,
+		session.UserID,
+		session.UserAgent,
+		session.IPAddress,
+		session.ClientID,
+		session.Scope,
+		session.ExpiresAt,
+	).StructScan(&createdSession)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return &createdSession, nil
+}
+
+func (r *SessionRepository) GetByID(id uuid.UUID) (*models.Session, error) {
+	var session models.Session
+
+	err := r.db.QueryRowx(getSessionByIDQuery, id).StructScan(&session)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// ListActive returns the sessions of the user that are neither revoked nor expired
+func (r *SessionRepository) ListActive(userID uuid.UUID) ([]models.Session, error) {
+	sessions := []models.Session{}
+
+	if err := r.db.Select(&sessions, listActiveSessionsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// ListByUserID returns every session of the user, including ended ones
+func (r *SessionRepository) ListByUserID(userID uuid.UUID) ([]models.Session, error) {
+	sessions := []models.Session{}
+
+	if err := r.db.Select(&sessions, listUserSessionsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Touch records activity on the session
+func (r *SessionRepository) Touch(id uuid.UUID) error {
+	if _, err := r.db.Exec(touchSessionQuery, id); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// Extend records activity and moves the expiry along with a rotated refresh token
+func (r *SessionRepository) Extend(id uuid.UUID, expiresAt time.Time) error {
+	if _, err := r.db.Exec(extendSessionQuery, id, expiresAt); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// Revoke ends one of the user's sessions. It returns false if the user has no
+// such active session.
+func (r *SessionRepository) Revoke(userID, id uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(revokeSessionQuery, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+func (r *SessionRepository) RevokeAllForUser(userID uuid.UUID) error {
+	if _, err := r.db.Exec(revokeUserSessionsQuery, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type WebAuthnRepository struct {
+	db *sqlx.DB
+}
+
+func NewWebAuthnRepository(db *sqlx.DB) *WebAuthnRepository {
+	return &WebAuthnRepository{db: db}
+}
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count,
+		transports, backup_eligible, backup_state, clone_warning, name, last_used_at, created_at`
+
+const (
+	createWebAuthnCredentialQuery = `
+		INSERT INTO webauthn_credentials (user_id, credential_id, public_key, attestation_type, aaguid,
+			sign_count, transports, backup_eligible, backup_state, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + webAuthnCredentialColumns
+
+	listWebAuthnCredentialsQuery = `
+		SELECT ` + webAuthnCredentialColumns + `
+		FROM webauthn_credentials WHERE user_id = $1
+		ORDER BY created_at`
+
+	getWebAuthnCredentialQuery = `
+		SELECT ` + webAuthnCredentialColumns + `
+		FROM webauthn_credentials WHERE credential_id = $1`
+
+	// The counter only moves forward, so two assertions racing with the same
+	// count cannot both succeed. Authenticators that don't count report 0.
+	updateWebAuthnSignCountQuery = `
+		UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND clone_warning = FALSE AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))`
+
+	markWebAuthnCloneWarningQuery = `UPDATE webauthn_credentials SET clone_warning = TRUE WHERE id = $1`
+
+	deleteWebAuthnCredentialQuery = `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`
+
+	createWebAuthnSessionQuery = `
+		INSERT INTO webauthn_sessions (user_id, ceremony, session_hash, data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	consumeWebAuthnSessionQuery = `
+		DELETE FROM webauthn_sessions
+		WHERE session_hash = $1 AND ceremony = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, user_id, ceremony, session_hash, data, expires_at, created_at`
+
+	deleteExpiredWebAuthnSessionsQuery = `DELETE FROM webauthn_sessions WHERE expires_at <= CURRENT_TIMESTAMP`
+)
+
+func (r *WebAuthnRepository) Create(credential *models.WebAuthnCredential) (*models.WebAuthnCredential, error) {
+	var createdCredential models.WebAuthnCredential
+
+	err := r.db.QueryRowx(
+		createWebAuthnCredentialQuery,
+		credential.UserID,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.AAGUID,
+		credential.SignCount,
+		credential.Transports,
+		credential.BackupEligible,
+		credential.BackupState,
+		credential.Name,
+	).StructScan(&createdCredential)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webauthn credential: %w", err)
+	}
+
+	return &createdCredential, nil
+}
+
+func (r *WebAuthnRepository) ListByUserID(userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+
+	if err := r.db.Select(&credentials, listWebAuthnCredentialsQuery, userID); err != nil {
+		return nil, fmt.Errorf("failed to list webauthn credentials: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func (r *WebAuthnRepository) GetByCredentialID(credentialID []byte) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+
+	err := r.db.QueryRowx(getWebAuthnCredentialQuery, credentialID).StructScan(&credential)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get webauthn credential: %w", err)
+	}
+
+	return &credential, nil
+}
+
+// UpdateSignCount stores the counter of a successful assertion. It returns
+// false if the stored counter already reached that value or the credential
+// was flagged as cloned in the meantime.
+func (r *WebAuthnRepository) UpdateSignCount(id uuid.UUID, signCount int64, backupState bool) (bool, error) {
+	return r.execAffected(updateWebAuthnSignCountQuery, "failed to update webauthn sign count", id, signCount, backupState)
+}
+
+// MarkCloneWarning flags a credential whose counter went backwards. Flagged
+// credentials can no longer be used to sign in.
+func (r *WebAuthnRepository) MarkCloneWarning(id uuid.UUID) error {
+	if _, err := r.db.Exec(markWebAuthnCloneWarningQuery, id); err != nil {
+		return fmt.Errorf("failed to flag webauthn credential: %w", err)
+	}
+	return nil
+}
+
+func (r *WebAuthnRepository) Delete(userID, id uuid.UUID) (bool, error) {
+	return r.execAffected(deleteWebAuthnCredentialQuery, "failed to delete webauthn credential", id, userID)
+}
+
+func (r *WebAuthnRepository) CreateSession(session *models.WebAuthnSession) error {
+	_, err := r.db.Exec(
+		createWebAuthnSessionQuery,
+		session.UserID,
+		session.Ceremony,
+		session.SessionHash,
+		session.Data,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create webauthn session: %w", err)
+	}
+	return nil
+}
+
+// ConsumeSession deletes an unexpired ceremony and returns it, so each
+// challenge can be answered only once. It returns nil when none matches.
+func (r *WebAuthnRepository) ConsumeSession(ceremony, sessionHash string) (*models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+
+	err := r.db.QueryRowx(consumeWebAuthnSessionQuery, sessionHash, ceremony).StructScan(&session)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume webauthn session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (r *WebAuthnRepository) PurgeExpiredSessions() error {
+	if _, err := r.db.Exec(deleteExpiredWebAuthnSessionsQuery); err != nil {
+		return fmt.Errorf("failed to purge webauthn sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *WebAuthnRepository) execAffected(query, errMsg string, args ...interface{}) (bool, error) {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	return rows > 0, nil
+}
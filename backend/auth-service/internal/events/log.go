@@ -0,0 +1,26 @@
+package events
+
+import (
+	"context"
+	"log"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+)
+
+// LogPublisher prints events instead of publishing them, so the service runs
+// without a message broker
+type LogPublisher struct{}
+
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event *models.Event) error {
+	message, err := event.Message()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Event %s: %s", event.Type, message)
+	return nil
+}
@@ -0,0 +1,56 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+)
+
+// relayBatchSize bounds the events published per Relay call
+const relayBatchSize = 100
+
+// Publisher delivers events to other services
+type Publisher interface {
+	Publish(ctx context.Context, event *models.Event) error
+}
+
+// NewPublisher builds the publisher selected by EVENTS_DRIVER
+func NewPublisher(cfg *configs.Config) (Publisher, error) {
+	switch cfg.Events.Driver {
+	case "rabbitmq":
+		return NewRabbitMQPublisher(cfg.Events.RabbitMQURL, cfg.Events.Exchange), nil
+	case "log", "":
+		return NewLogPublisher(), nil
+	default:
+		return nil, fmt.Errorf("unknown events driver: %s", cfg.Events.Driver)
+	}
+}
+
+// Relay publishes the events waiting in the outbox, oldest first. It stops at
+// the first failure so events keep their order, and the failed event is
+// retried by the next call. Consumers may see an event twice and must handle
+// it idempotently by its ID.
+func Relay(ctx context.Context, outbox *database.EventOutboxRepository, publisher Publisher) (int, error) {
+	pending, err := outbox.ListPending(relayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range pending {
+		event := &pending[i]
+		if err := publisher.Publish(ctx, event); err != nil {
+			if recordErr := outbox.RecordFailure(event.ID); recordErr != nil {
+				err = fmt.Errorf("%w (%v)", err, recordErr)
+			}
+			return i, fmt.Errorf("failed to publish event %s: %w", event.ID, err)
+		}
+		if err := outbox.MarkPublished(event.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(pending), nil
+}
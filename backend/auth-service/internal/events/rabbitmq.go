@@ -0,0 +1,115 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQPublisher publishes events as persistent messages to a topic
+// exchange, routed by event type. The connection is opened on first use and
+// reopened after a failure.
+type RabbitMQPublisher struct {
+	mu       sync.Mutex
+	url      string
+	exchange string
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func NewRabbitMQPublisher(url, exchange string) *RabbitMQPublisher {
+	return &RabbitMQPublisher{
+		url:      url,
+		exchange: exchange,
+	}
+}
+
+// Publish returns once the broker confirmed it has taken the message
+func (p *RabbitMQPublisher) Publish(ctx context.Context, event *models.Event) error {
+	body, err := event.Message()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	err = p.channel.PublishWithContext(ctx, p.exchange, event.Type, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID.String(),
+		Type:         event.Type,
+		Timestamp:    event.CreatedAt,
+		Body:         body,
+	})
+	if err != nil {
+		p.close()
+		return fmt.Errorf("failed to publish to rabbitmq: %w", err)
+	}
+
+	select {
+	case confirmation, ok := <-p.confirms:
+		if !ok {
+			p.close()
+			return fmt.Errorf("rabbitmq channel closed before confirming")
+		}
+		if !confirmation.Ack {
+			return fmt.Errorf("rabbitmq rejected the message")
+		}
+		return nil
+	case <-ctx.Done():
+		// A late confirmation would be taken for the next message's
+		p.close()
+		return ctx.Err()
+	}
+}
+
+func (p *RabbitMQPublisher) connect() error {
+	if p.channel != nil && !p.channel.IsClosed() {
+		return nil
+	}
+	p.close()
+
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open rabbitmq channel: %w", err)
+	}
+
+	if err := channel.ExchangeDeclare(p.exchange, "topic", true, false, false, false, nil); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to declare rabbitmq exchange: %w", err)
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to enable rabbitmq publisher confirms: %w", err)
+	}
+
+	p.conn = conn
+	p.channel = channel
+	p.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+func (p *RabbitMQPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = nil
+	p.channel = nil
+	p.confirms = nil
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type AdminHandler struct {
+	adminService *services.AdminService
+}
+
+func NewAdminHandler(adminService *services.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+// ListRoles returns every role with its permissions
+func (h *AdminHandler) ListRoles(c *fiber.Ctx) error {
+	roles, err := h.adminService.ListRoles()
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Roles retrieved successfully",
+		roles,
+	))
+}
+
+// ListUserRoles returns the roles of the user in the path
+func (h *AdminHandler) ListUserRoles(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	roles, err := h.adminService.ListUserRoles(userID)
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"User roles retrieved successfully",
+		roles,
+	))
+}
+
+// GrantRole gives the user in the path a role
+func (h *AdminHandler) GrantRole(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	var req models.GrantRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	roles, err := h.adminService.GrantRole(actorID, userID, &req, clientInfo(c))
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Role granted successfully",
+		roles,
+	))
+}
+
+// RevokeRole takes a role from the user in the path. The reason is passed in
+// the query string.
+func (h *AdminHandler) RevokeRole(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	roles, err := h.adminService.RevokeRole(actorID, userID, c.Params("role"), c.Query("reason"), clientInfo(c))
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Role revoked successfully",
+		roles,
+	))
+}
+
+// AuditLog returns admin actions, newest first, optionally filtered by user or action
+func (h *AdminHandler) AuditLog(c *fiber.Ctx) error {
+	query := models.AuditLogQuery{
+		Action: c.Query("action"),
+		Limit:  c.QueryInt("limit"),
+		Offset: c.QueryInt("offset"),
+	}
+	if rawUserID := c.Query("user_id"); rawUserID != "" {
+		userID, err := uuid.Parse(rawUserID)
+		if err != nil {
+			return invalidUserID(c)
+		}
+		query.UserID = &userID
+	}
+
+	entries, err := h.adminService.AuditLog(&query)
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Audit log retrieved successfully",
+		entries,
+	))
+}
+
+// SearchUsers finds users by ID, or by part of their email or username given
+// in ?q=
+func (h *AdminHandler) SearchUsers(c *fiber.Ctx) error {
+	response, err := h.adminService.SearchUsers(&models.UserSearchQuery{
+		Query:  strings.TrimSpace(c.Query("q")),
+		Limit:  c.QueryInt("limit"),
+		Offset: c.QueryInt("offset"),
+	})
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Users retrieved successfully",
+		response,
+	))
+}
+
+// GetUser returns the user in the path with their sign-in methods, roles and suspension
+func (h *AdminHandler) GetUser(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	user, err := h.adminService.GetUser(userID)
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"User retrieved successfully",
+		user,
+	))
+}
+
+// SuspendUser suspends the user in the path and signs them out everywhere
+func (h *AdminHandler) SuspendUser(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	var req models.SuspendUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	user, err := h.adminService.SuspendUser(actorID, userID, &req, clientInfo(c))
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"User suspended successfully",
+		user,
+	))
+}
+
+// UnsuspendUser lifts the suspension of the user in the path
+func (h *AdminHandler) UnsuspendUser(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	// The body with the reason is optional
+	var req models.AdminActionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return invalidRequestBody(c, err)
+		}
+	}
+
+	user, err := h.adminService.UnsuspendUser(actorID, userID, &req, clientInfo(c))
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"User unsuspended successfully",
+		user,
+	))
+}
+
+// ForceLogout signs the user in the path out of every session
+func (h *AdminHandler) ForceLogout(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	// The body with the reason is optional
+	var req models.AdminActionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return invalidRequestBody(c, err)
+		}
+	}
+
+	if err := h.adminService.ForceLogout(actorID, userID, &req, clientInfo(c)); err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"User logged out of all sessions",
+		nil,
+	))
+}
+
+// ForcePasswordReset clears the password of the user in the path and emails
+// them a reset link
+func (h *AdminHandler) ForcePasswordReset(c *fiber.Ctx) error {
+	actorID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidUserID(c)
+	}
+
+	// The body with the reason is optional
+	var req models.AdminActionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return invalidRequestBody(c, err)
+		}
+	}
+
+	if err := h.adminService.ForcePasswordReset(c.Context(), actorID, userID, &req, clientInfo(c)); err != nil {
+		return adminError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Password reset, the user was emailed a link to choose a new one",
+		nil,
+	))
+}
+
+func invalidUserID(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+		"INVALID_USER_ID",
+		"Invalid user ID",
+		nil,
+	))
+}
+
+// adminError maps admin service errors to responses
+func adminError(c *fiber.Ctx, err error) error {
+	// Check for validation errors
+	if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"VALIDATION_ERROR",
+			"Validation failed",
+			validationErrors,
+		))
+	}
+
+	switch err.Error() {
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"USER_NOT_FOUND",
+			"User not found",
+			nil,
+		))
+	case "role not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"ROLE_NOT_FOUND",
+			"Role not found",
+			nil,
+		))
+	case "role already granted":
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+			"ROLE_ALREADY_GRANTED",
+			"User already has this role",
+			nil,
+		))
+	case "role not granted":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"ROLE_NOT_GRANTED",
+			"User doesn't have this role",
+			nil,
+		))
+	case "user not suspended":
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+			"USER_NOT_SUSPENDED",
+			"User is not suspended",
+			nil,
+		))
+	case "cannot suspend yourself":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"CANNOT_SUSPEND_SELF",
+			"You can't suspend your own account",
+			nil,
+		))
+	case "suspension end in the past":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_SUSPENSION_END",
+			"Suspension end must be in the future",
+			nil,
+		))
+	case "cannot revoke last admin":
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+			"LAST_ADMIN",
+			"Grant the admin role to someone else before revoking it from the last admin",
+			nil,
+		))
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+		"ADMIN_ACTION_FAILED",
+		"Failed to perform admin action",
+		err.Error(),
+	))
+}
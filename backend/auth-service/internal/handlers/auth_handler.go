@@ -1,10 +1,12 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"time"
+	"errors"
+	"net/url"
+	"strings"
 
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
@@ -12,14 +14,20 @@ import (
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
-	oauthStates map[string]time.Time // In production, use Redis or similar
+	authService         *services.AuthService
+	verificationService *services.VerificationService
+	passwordService     *services.PasswordService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(
+	authService *services.AuthService,
+	verificationService *services.VerificationService,
+	passwordService *services.PasswordService,
+) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		oauthStates: make(map[string]time.Time),
+		authService:         authService,
+		verificationService: verificationService,
+		passwordService:     passwordService,
 	}
 }
 
@@ -35,7 +43,7 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		))
 	}
 
-	response, err := h.authService.Register(&req)
+	response, err := h.authService.Register(&req, clientInfo(c))
 	if err != nil {
 		// Check for validation errors
 		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
@@ -58,10 +66,13 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		if errMsg == "username already taken" {
 			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
 				"USERNAME_EXISTS",
-				"Username already taken",
+				"Username already taken or too similar to an existing one",
 				nil,
 			))
 		}
+		if errMsg == "username reserved" {
+			return usernameReserved(c)
+		}
 
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
 			"REGISTRATION_FAILED",
@@ -88,8 +99,13 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		))
 	}
 
-	response, err := h.authService.Login(&req)
+	response, challenge, err := h.authService.Login(&req, clientInfo(c))
 	if err != nil {
+		var lockedErr *lockout.LockedError
+		if errors.As(err, &lockedErr) {
+			return accountLocked(c, lockedErr)
+		}
+
 		// Check for validation errors
 		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
 			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
@@ -100,12 +116,15 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		}
 
 		// Check for invalid credentials
-		if err.Error() == "invalid email or password" {
+		switch err.Error() {
+		case "invalid email or password":
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
 				"INVALID_CREDENTIALS",
 				"Invalid email or password",
 				nil,
 			))
+		case "account suspended":
+			return accountSuspended(c)
 		}
 
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
@@ -115,76 +134,315 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		))
 	}
 
+	if challenge != nil {
+		return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+			"Two-factor authentication required",
+			challenge,
+		))
+	}
+
 	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
 		"Login successful",
 		response,
 	))
 }
 
-// GoogleLogin initiates Google OAuth flow
-func (h *AuthHandler) GoogleLogin(c *fiber.Ctx) error {
-	state := h.generateState()
-	authURL := h.authService.GetGoogleAuthURL(state)
+// RefreshToken exchanges a refresh token for a new token pair
+func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	var req models.RefreshTokenRequest
 
-	// Store state with expiration (5 minutes)
-	h.oauthStates[state] = time.Now().Add(5 * time.Minute)
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_REQUEST",
+			"Invalid request body",
+			err.Error(),
+		))
+	}
 
-	return c.JSON(models.SuccessResponse(
-		"Google OAuth URL generated",
-		map[string]string{
-			"auth_url": authURL,
-			"state":    state,
-		},
+	response, err := h.authService.RefreshToken(&req)
+	if err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		switch err.Error() {
+		case "invalid refresh token", "refresh token expired":
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"INVALID_REFRESH_TOKEN",
+				"Invalid or expired refresh token",
+				nil,
+			))
+		case "refresh token reuse detected":
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"REFRESH_TOKEN_REUSED",
+				"Refresh token has already been used, all sessions in this family were revoked",
+				nil,
+			))
+		case "account suspended":
+			return accountSuspended(c)
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"REFRESH_FAILED",
+			"Failed to refresh token",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Token refreshed successfully",
+		response,
 	))
 }
 
-// GoogleCallback handles Google OAuth callback
-func (h *AuthHandler) GoogleCallback(c *fiber.Ctx) error {
-	code := c.Query("code")
-	state := c.Query("state")
+// Logout revokes the current access token and optionally its refresh token
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"UNAUTHORIZED",
+			"User not authenticated",
+			err.Error(),
+		))
+	}
+
+	var req models.LogoutRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"INVALID_REQUEST",
+				"Invalid request body",
+				err.Error(),
+			))
+		}
+	}
 
-	if code == "" || state == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
-			"INVALID_CALLBACK",
-			"Missing code or state parameter",
-			nil,
+	if err := h.authService.Logout(claims, &req); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"LOGOUT_FAILED",
+			"Failed to logout",
+			err.Error(),
 		))
 	}
 
-	// Validate state
-	if !h.validateState(state) {
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Logout successful",
+		nil,
+	))
+}
+
+// LogoutAll revokes every token issued to the current user
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"UNAUTHORIZED",
+			"User not authenticated",
+			err.Error(),
+		))
+	}
+
+	if err := h.authService.LogoutAll(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"LOGOUT_FAILED",
+			"Failed to logout from all devices",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Logged out from all devices",
+		nil,
+	))
+}
+
+// VerifyEmail confirms the user's email address with a token from the verification email
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req models.VerifyEmailRequest
+
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
-			"INVALID_STATE",
-			"Invalid or expired state parameter",
-			nil,
+			"INVALID_REQUEST",
+			"Invalid request body",
+			err.Error(),
 		))
 	}
 
-	response, err := h.authService.HandleGoogleCallback(c.Context(), code)
+	user, err := h.verificationService.VerifyEmail(&req)
 	if err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		if err.Error() == "invalid verification token" {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"INVALID_VERIFICATION_TOKEN",
+				"Invalid, expired or already used verification token",
+				nil,
+			))
+		}
+
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
-			"OAUTH_FAILED",
-			"Google OAuth authentication failed",
+			"VERIFICATION_FAILED",
+			"Failed to verify email",
 			err.Error(),
 		))
 	}
 
 	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
-		"Google OAuth login successful",
-		response,
+		"Email verified successfully",
+		user,
+	))
+}
+
+// ResendVerificationEmail sends a new verification email to the current user
+func (h *AuthHandler) ResendVerificationEmail(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"UNAUTHORIZED",
+			"User not authenticated",
+			err.Error(),
+		))
+	}
+
+	if err := h.verificationService.ResendVerificationEmail(c.Context(), userID); err != nil {
+		switch err.Error() {
+		case "email already verified":
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+				"EMAIL_ALREADY_VERIFIED",
+				"Email already verified",
+				nil,
+			))
+		case "verification email recently sent":
+			return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse(
+				"TOO_MANY_REQUESTS",
+				"Verification email was sent recently, please wait before requesting another one",
+				nil,
+			))
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+				"USER_NOT_FOUND",
+				"User not found",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"RESEND_VERIFICATION_FAILED",
+			"Failed to send verification email",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Verification email sent",
+		nil,
 	))
 }
 
-// FacebookLogin initiates Facebook OAuth flow
-func (h *AuthHandler) FacebookLogin(c *fiber.Ctx) error {
-	state := h.generateState()
-	authURL := h.authService.GetFacebookAuthURL(state)
+// ForgotPassword sends a password reset email. It responds the same way
+// whether or not the email is registered.
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req models.ForgotPasswordRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_REQUEST",
+			"Invalid request body",
+			err.Error(),
+		))
+	}
 
-	// Store state with expiration (5 minutes)
-	h.oauthStates[state] = time.Now().Add(5 * time.Minute)
+	if err := h.passwordService.ForgotPassword(&req); err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"If an account exists for this email, a password reset link has been sent",
+		nil,
+	))
+}
+
+// ResetPassword sets a new password using a token from the reset email
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req models.ResetPasswordRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_REQUEST",
+			"Invalid request body",
+			err.Error(),
+		))
+	}
+
+	if err := h.passwordService.ResetPassword(&req); err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		if err.Error() == "invalid reset token" {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"INVALID_RESET_TOKEN",
+				"Invalid, expired or already used reset token",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"RESET_PASSWORD_FAILED",
+			"Failed to reset password",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Password has been reset, please login with your new password",
+		nil,
+	))
+}
+
+// ListOAuthProviders returns the configured OAuth sign-in providers
+func (h *AuthHandler) ListOAuthProviders(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth providers retrieved successfully",
+		h.authService.OAuthProviders(),
+	))
+}
+
+// OAuthLogin initiates the OAuth flow of the provider in the path. The
+// optional redirect query parameter is returned after a successful login.
+func (h *AuthHandler) OAuthLogin(c *fiber.Ctx) error {
+	authURL, state, binding, err := h.authService.BeginOAuth(c.Context(), c.Params("provider"), c.Query("redirect"))
+	if err != nil {
+		return oauthError(c, err)
+	}
+	setOAuthBinding(c, binding)
 
 	return c.JSON(models.SuccessResponse(
-		"Facebook OAuth URL generated",
+		"OAuth URL generated",
 		map[string]string{
 			"auth_url": authURL,
 			"state":    state,
@@ -192,12 +450,22 @@ func (h *AuthHandler) FacebookLogin(c *fiber.Ctx) error {
 	))
 }
 
-// FacebookCallback handles Facebook OAuth callback
-func (h *AuthHandler) FacebookCallback(c *fiber.Ctx) error {
-	code := c.Query("code")
-	state := c.Query("state")
+// OAuthCallback handles the OAuth callback of the provider in the path. The
+// parameters come in the query string, or in a form POST for Apple.
+func (h *AuthHandler) OAuthCallback(c *fiber.Ctx) error {
+	callback := models.OAuthCallbackRequest{
+		Code:    c.FormValue("code"),
+		State:   c.FormValue("state"),
+		User:    c.FormValue("user"),
+		Binding: c.Cookies(oauthBindingCookie),
+	}
+	// The binding is good for one callback, whatever its outcome
+	clearOAuthBinding(c)
 
-	if code == "" || state == "" {
+	if callback.Code == "" || callback.State == "" {
+		if h.authService.OAuthRedirectMode() {
+			return h.redirectToFrontend(c, url.Values{"error": {"INVALID_CALLBACK"}})
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
 			"INVALID_CALLBACK",
 			"Missing code or state parameter",
@@ -205,31 +473,161 @@ func (h *AuthHandler) FacebookCallback(c *fiber.Ctx) error {
 		))
 	}
 
-	// Validate state
-	if !h.validateState(state) {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
-			"INVALID_STATE",
-			"Invalid or expired state parameter",
-			nil,
+	result, err := h.authService.HandleOAuthCallback(c.Context(), c.Params("provider"), &callback, clientInfo(c))
+	if h.authService.OAuthRedirectMode() {
+		return h.oauthRedirect(c, result, err)
+	}
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	switch {
+	case result.LinkRequired != nil:
+		return linkRequired(c, result.LinkRequired)
+	case result.Linked != nil:
+		return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+			"OAuth provider linked successfully",
+			result.Linked,
+		))
+	case result.Challenge != nil:
+		return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+			"Two-factor authentication required",
+			result.Challenge,
 		))
 	}
 
-	response, err := h.authService.HandleFacebookCallback(c.Context(), code)
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth login successful",
+		result.Auth,
+	))
+}
+
+// ExchangeOAuthCode trades the code a redirect mode OAuth callback handed to
+// the frontend for the login result
+func (h *AuthHandler) ExchangeOAuthCode(c *fiber.Ctx) error {
+	var req models.OAuthExchangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	result, err := h.authService.ExchangeOAuthCode(&req, clientInfo(c))
 	if err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		switch err.Error() {
+		case "invalid authorization code":
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"INVALID_CODE",
+				"Invalid or expired authorization code, please login again",
+				nil,
+			))
+		case "account suspended":
+			return accountSuspended(c)
+		}
+
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
 			"OAUTH_FAILED",
-			"Facebook OAuth authentication failed",
+			"OAuth authentication failed",
 			err.Error(),
 		))
 	}
 
+	switch {
+	case result.LinkRequired != nil:
+		return linkRequired(c, result.LinkRequired)
+	case result.Challenge != nil:
+		return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+			"Two-factor authentication required",
+			result.Challenge,
+		))
+	}
+
 	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
-		"Facebook OAuth login successful",
-		response,
+		"OAuth login successful",
+		result.Auth,
 	))
 }
 
-// ValidateToken validates JWT token (for other services)
+// oauthRedirect sends the browser back to the frontend with the outcome of
+// the callback. Tokens never appear in the URL, only a one-time code, which
+// also stands in for the link token when the user has to confirm a link.
+func (h *AuthHandler) oauthRedirect(c *fiber.Ctx, result *models.OAuthCallbackResult, err error) error {
+	params := url.Values{}
+
+	switch {
+	case err != nil:
+		_, code, _ := oauthErrorStatus(err)
+		params.Set("error", code)
+	case result.LinkPending:
+		params.Set("error", "ACCOUNT_LINK_REQUIRED")
+		params.Set("provider", c.Params("provider"))
+		params.Set("code", result.HandOffCode)
+	case result.Linked != nil:
+		params.Set("linked", c.Params("provider"))
+	default:
+		params.Set("code", result.HandOffCode)
+	}
+
+	return h.redirectToFrontend(c, params)
+}
+
+// linkRequired answers an OAuth login whose email matched an account the
+// owner has to confirm linking
+func linkRequired(c *fiber.Ctx, link *models.OAuthLinkRequiredResponse) error {
+	return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+		"ACCOUNT_LINK_REQUIRED",
+		"An account with this email already exists, sign in to it and confirm linking this provider",
+		link,
+	))
+}
+
+// oauthBindingCookie ties an OAuth state to the browser that started the flow
+const oauthBindingCookie = "oauth_binding"
+
+// oauthBindingMaxAge matches the lifetime of the OAuth state, in seconds
+const oauthBindingMaxAge = 10 * 60
+
+// setOAuthBinding hands the browser the cookie its OAuth callback must bring
+// back. Provider callbacks may be configured under /auth as well as
+// /api/v1/auth, so the cookie goes to every path, and SameSite=None lets it
+// ride along cross-site callbacks such as Apple's form POST.
+func setOAuthBinding(c *fiber.Ctx, binding string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthBindingCookie,
+		Value:    binding,
+		Path:     "/",
+		MaxAge:   oauthBindingMaxAge,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteNoneMode,
+	})
+}
+
+func clearOAuthBinding(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthBindingCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteNoneMode,
+	})
+}
+
+func (h *AuthHandler) redirectToFrontend(c *fiber.Ctx, params url.Values) error {
+	// Keep the code out of the Referer of whatever the frontend loads
+	c.Set("Referrer-Policy", "no-referrer")
+	return c.Redirect(h.authService.OAuthFrontendCallbackURL(params), fiber.StatusFound)
+}
+
+// ValidateToken validates JWT token or personal access token (for other services)
 func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 	token := c.Get("Authorization")
 	if token == "" {
@@ -245,8 +643,21 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 		token = token[7:]
 	}
 
-	user, err := h.authService.ValidateToken(token)
+	// Services name the scopes a personal access token needs for the request,
+	// e.g. ?scope=threads:write
+	user, err := h.authService.ValidateToken(token, strings.Fields(c.Query("scope"))...)
 	if err != nil {
+		switch err.Error() {
+		case "insufficient scope":
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"INSUFFICIENT_SCOPE",
+				"Token was not granted the required scope",
+				nil,
+			))
+		case "account suspended":
+			return accountSuspended(c)
+		}
+
 		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
 			"INVALID_TOKEN",
 			"Invalid or expired token",
@@ -260,34 +671,39 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 	))
 }
 
-// generateState generates a random state for OAuth
-func (h *AuthHandler) generateState() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
-}
-
-// validateState validates OAuth state and cleans up expired states
-func (h *AuthHandler) validateState(state string) bool {
-	expiry, exists := h.oauthStates[state]
-	if !exists {
-		return false
-	}
-
-	// Clean up expired states
-	now := time.Now()
-	for s, exp := range h.oauthStates {
-		if now.After(exp) {
-			delete(h.oauthStates, s)
-		}
+// oauthError maps OAuth service errors to responses
+func oauthError(c *fiber.Ctx, err error) error {
+	status, code, message := oauthErrorStatus(err)
+	if status == fiber.StatusInternalServerError {
+		return c.Status(status).JSON(models.ErrorResponse(code, message, err.Error()))
 	}
+	return c.Status(status).JSON(models.ErrorResponse(code, message, nil))
+}
 
-	if now.After(expiry) {
-		delete(h.oauthStates, state)
-		return false
+// oauthErrorStatus returns the status, error code and message of an OAuth service error
+func oauthErrorStatus(err error) (int, string, string) {
+	switch err.Error() {
+	case "unknown oauth provider":
+		return fiber.StatusNotFound, "UNKNOWN_PROVIDER", "OAuth provider is not configured"
+	case "invalid oauth state":
+		return fiber.StatusBadRequest, "INVALID_STATE", "Invalid or expired state parameter"
+	case "invalid redirect url":
+		return fiber.StatusBadRequest, "INVALID_REDIRECT", "Redirect URL is not allowed"
+	case "invalid link token":
+		return fiber.StatusBadRequest, "INVALID_LINK_TOKEN", "Invalid or expired link token, please sign in with the provider again"
+	case "identity already linked":
+		return fiber.StatusConflict, "IDENTITY_ALREADY_LINKED", "This provider is already linked to your account"
+	case "identity linked to another account":
+		return fiber.StatusConflict, "IDENTITY_IN_USE", "This provider account is already linked to another user"
+	case "identity not found":
+		return fiber.StatusNotFound, "IDENTITY_NOT_FOUND", "This provider is not linked to your account"
+	case "cannot remove last login method":
+		return fiber.StatusConflict, "LAST_LOGIN_METHOD", "Set a password or link another provider before removing this one"
+	case "user not found":
+		return fiber.StatusNotFound, "USER_NOT_FOUND", "User not found"
+	case "account suspended":
+		return fiber.StatusForbidden, "ACCOUNT_SUSPENDED", "This account has been suspended"
 	}
 
-	// Remove used state
-	delete(h.oauthStates, state)
-	return true
+	return fiber.StatusInternalServerError, "OAUTH_FAILED", "OAuth authentication failed"
 }
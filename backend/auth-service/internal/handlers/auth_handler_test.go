@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/oauthstate"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryStateStore keeps OAuth states in a map for the length of a test
+type memoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]*oauthstate.State
+}
+
+func (s *memoryStateStore) Save(state string, data *oauthstate.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state] = data
+	return nil
+}
+
+func (s *memoryStateStore) Consume(state string) (*oauthstate.State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.states[state]
+	delete(s.states, state)
+	return data, nil
+}
+
+func (s *memoryStateStore) PurgeExpired() error {
+	return nil
+}
+
+// newOAuthTestApp serves the OAuth routes the way main does, with a plain
+// OAuth2 provider whose callback URL is left to the default. It returns the
+// app, the provider's default callback URL and whether a code exchange
+// reached the provider.
+func newOAuthTestApp(t *testing.T) (*fiber.App, *url.URL, *bool) {
+	t.Helper()
+
+	var exchanged bool
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanged = true
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	t.Cleanup(provider.Close)
+
+	providersFile := filepath.Join(t.TempDir(), "providers.json")
+	providers := map[string]interface{}{
+		"providers": []map[string]interface{}{{
+			"name":          "example",
+			"type":          configs.OAuthProviderTypeOAuth2,
+			"client_id":     "client",
+			"client_secret": "secret",
+			"auth_url":      provider.URL + "/authorize",
+			"token_url":     provider.URL + "/token",
+			"userinfo_url":  provider.URL + "/userinfo",
+		}},
+	}
+	data, err := json.Marshal(providers)
+	if err != nil {
+		t.Fatalf("encode providers: %v", err)
+	}
+	if err := os.WriteFile(providersFile, data, 0o600); err != nil {
+		t.Fatalf("write providers: %v", err)
+	}
+
+	oauthConfig := configs.OAuthConfig{
+		ProvidersFile:   providersFile,
+		CallbackBaseURL: "https://auth.example.com",
+		CallbackMode:    "json",
+	}
+	oauthManager, err := utils.NewOAuthManager(&configs.Config{OAuth: oauthConfig})
+	if err != nil {
+		t.Fatalf("NewOAuthManager: %v", err)
+	}
+	providerConfigs, err := configs.LoadOAuthProviders(oauthConfig)
+	if err != nil {
+		t.Fatalf("LoadOAuthProviders: %v", err)
+	}
+	callbackURL, err := url.Parse(providerConfigs[0].RedirectURL)
+	if err != nil {
+		t.Fatalf("parse callback URL: %v", err)
+	}
+
+	stateStore := &memoryStateStore{states: make(map[string]*oauthstate.State)}
+	authService := services.NewAuthService(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, oauthManager, stateStore, nil, nil)
+	authHandler := NewAuthHandler(authService, nil, nil)
+
+	app := fiber.New()
+	auth := app.Group("/api/v1/auth")
+	auth.Get("/:provider", authHandler.OAuthLogin)
+	auth.Get("/:provider/callback", authHandler.OAuthCallback)
+	legacyAuth := app.Group("/auth")
+	legacyAuth.Get("/:provider", authHandler.OAuthLogin)
+	legacyAuth.Get("/:provider/callback", authHandler.OAuthCallback)
+
+	return app, callbackURL, &exchanged
+}
+
+func TestOAuthCallbackGetsBindingCookie(t *testing.T) {
+	app, callbackURL, exchanged := newOAuthTestApp(t)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar: %v", err)
+	}
+
+	// The frontend starts the flow through the versioned API
+	loginURL, _ := url.Parse("https://auth.example.com/api/v1/auth/example")
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, loginURL.String(), nil))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("login status = %d", resp.StatusCode)
+	}
+	jar.SetCookies(loginURL, resp.Cookies())
+
+	var login struct {
+		Data struct {
+			State string `json:"state"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login: %v", err)
+	}
+
+	// The provider sends the browser back to the default callback URL, with
+	// whatever cookies the browser holds for it
+	callback := *callbackURL
+	callback.RawQuery = url.Values{"code": {"provider-code"}, "state": {login.Data.State}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	for _, cookie := range jar.Cookies(&callback) {
+		req.AddCookie(cookie)
+	}
+
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode callback: %v", err)
+	}
+	if body.Error.Code == "INVALID_STATE" {
+		t.Fatalf("callback at %s rejected the state, the binding cookie did not reach it", callbackURL.Path)
+	}
+	if !*exchanged {
+		t.Error("the code was not exchanged with the provider")
+	}
+}
+
+func TestOAuthCallbackRejectsMissingBinding(t *testing.T) {
+	app, callbackURL, exchanged := newOAuthTestApp(t)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "https://auth.example.com/api/v1/auth/example", nil))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	var login struct {
+		Data struct {
+			State string `json:"state"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login: %v", err)
+	}
+
+	// Someone else's browser opening the callback URL has no binding cookie
+	callback := *callbackURL
+	callback.RawQuery = url.Values{"code": {"provider-code"}, "state": {login.Data.State}}.Encode()
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, callback.String(), nil))
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("callback status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+	if *exchanged {
+		t.Error("the code was exchanged without the binding")
+	}
+}
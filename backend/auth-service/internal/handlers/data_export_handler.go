@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type DataExportHandler struct {
+	exportService *services.DataExportService
+}
+
+func NewDataExportHandler(exportService *services.DataExportService) *DataExportHandler {
+	return &DataExportHandler{
+		exportService: exportService,
+	}
+}
+
+// RequestExport queues an archive of the current user's data
+func (h *DataExportHandler) RequestExport(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	export, err := h.exportService.RequestExport(userID)
+	if err != nil {
+		if err.Error() == "export already in progress" {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+				"EXPORT_IN_PROGRESS",
+				"An export of your data is already being prepared",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"EXPORT_FAILED",
+			"Failed to request data export",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(models.SuccessResponse(
+		"Data export requested, you will receive an email when it is ready",
+		export,
+	))
+}
+
+// ListExports returns the current user's exports with download links for
+// those that are ready
+func (h *DataExportHandler) ListExports(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	exports, err := h.exportService.ListExports(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"GET_EXPORTS_FAILED",
+			"Failed to get data exports",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Data exports retrieved successfully",
+		exports,
+	))
+}
+
+// DownloadExport sends an export archive. The signed link is the credential,
+// so the route needs no token and works straight from the email.
+func (h *DataExportHandler) DownloadExport(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return invalidDownloadLink(c)
+	}
+
+	export, err := h.exportService.OpenDownload(id, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid download link":
+			return invalidDownloadLink(c)
+		case "export expired":
+			return c.Status(fiber.StatusGone).JSON(models.ErrorResponse(
+				"EXPORT_EXPIRED",
+				"This export has expired, please request a new one",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"DOWNLOAD_FAILED",
+			"Failed to download data export",
+			err.Error(),
+		))
+	}
+
+	// Keep the signed link out of caches and the Referer of anything opened next
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("Referrer-Policy", "no-referrer")
+	return c.Download(*export.FilePath, "threads-data-"+export.CreatedAt.UTC().Format("2006-01-02")+".zip")
+}
+
+func invalidDownloadLink(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+		"INVALID_DOWNLOAD_LINK",
+		"Invalid download link",
+		nil,
+	))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type IdentityHandler struct {
+	authService *services.AuthService
+}
+
+func NewIdentityHandler(authService *services.AuthService) *IdentityHandler {
+	return &IdentityHandler{
+		authService: authService,
+	}
+}
+
+// LinkIdentity links an OAuth provider to the current user. With a link token
+// it confirms a link offered by an OAuth login, otherwise it returns the URL
+// of an OAuth flow that links the provider when it completes.
+func (h *IdentityHandler) LinkIdentity(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.OAuthLinkRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return invalidRequestBody(c, err)
+		}
+	}
+
+	provider := c.Params("provider")
+
+	if req.LinkToken != "" {
+		user, err := h.authService.ConfirmOAuthLink(userID, provider, req.LinkToken)
+		if err != nil {
+			return oauthError(c, err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+			"OAuth provider linked successfully",
+			user,
+		))
+	}
+
+	authURL, state, binding, err := h.authService.BeginOAuthLink(c.Context(), userID, provider, req.Redirect)
+	if err != nil {
+		return oauthError(c, err)
+	}
+	setOAuthBinding(c, binding)
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth URL generated",
+		map[string]string{
+			"auth_url": authURL,
+			"state":    state,
+		},
+	))
+}
+
+// UnlinkIdentity removes an OAuth provider from the current user
+func (h *IdentityHandler) UnlinkIdentity(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	user, err := h.authService.UnlinkOAuth(userID, c.Params("provider"))
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth provider unlinked successfully",
+		user,
+	))
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+type MFAHandler struct {
+	mfaService *services.MFAService
+}
+
+func NewMFAHandler(mfaService *services.MFAService) *MFAHandler {
+	return &MFAHandler{
+		mfaService: mfaService,
+	}
+}
+
+// GetStatus returns the current user's two-factor authentication status
+func (h *MFAHandler) GetStatus(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	status, err := h.mfaService.GetStatus(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"GET_MFA_STATUS_FAILED",
+			"Failed to get two-factor authentication status",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Two-factor authentication status retrieved successfully",
+		status,
+	))
+}
+
+// SetupTOTP generates a TOTP secret and otpauth URI for the current user
+func (h *MFAHandler) SetupTOTP(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	setup, err := h.mfaService.SetupTOTP(userID)
+	if err != nil {
+		return mfaError(c, err, "MFA_SETUP_FAILED", "Failed to start two-factor authentication setup")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Scan the QR code with your authenticator app and confirm with a code",
+		setup,
+	))
+}
+
+// EnableTOTP confirms TOTP enrollment and returns recovery codes
+func (h *MFAHandler) EnableTOTP(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	codes, err := h.mfaService.EnableTOTP(userID, &req)
+	if err != nil {
+		return mfaError(c, err, "MFA_ENABLE_FAILED", "Failed to enable two-factor authentication")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Two-factor authentication enabled, store your recovery codes somewhere safe",
+		codes,
+	))
+}
+
+// DisableTOTP turns two-factor authentication off
+func (h *MFAHandler) DisableTOTP(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.DisableMFARequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	if err := h.mfaService.DisableTOTP(userID, &req); err != nil {
+		return mfaError(c, err, "MFA_DISABLE_FAILED", "Failed to disable two-factor authentication")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Two-factor authentication disabled",
+		nil,
+	))
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (h *MFAHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(userID, &req)
+	if err != nil {
+		return mfaError(c, err, "REGENERATE_RECOVERY_CODES_FAILED", "Failed to regenerate recovery codes")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Recovery codes regenerated, previous codes no longer work",
+		codes,
+	))
+}
+
+// VerifyChallenge completes a login with a second factor
+func (h *MFAHandler) VerifyChallenge(c *fiber.Ctx) error {
+	var req models.MFAVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.mfaService.VerifyChallenge(&req, clientInfo(c))
+	if err != nil {
+		if err.Error() == "invalid mfa token" {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"INVALID_MFA_TOKEN",
+				"Invalid or expired two-factor challenge, please login again",
+				nil,
+			))
+		}
+		return mfaError(c, err, "MFA_VERIFICATION_FAILED", "Failed to verify two-factor authentication")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Login successful",
+		response,
+	))
+}
+
+// mfaError maps MFA service errors to responses
+func mfaError(c *fiber.Ctx, err error, code, message string) error {
+	var lockedErr *lockout.LockedError
+	if errors.As(err, &lockedErr) {
+		return accountLocked(c, lockedErr)
+	}
+
+	// Check for validation errors
+	if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"VALIDATION_ERROR",
+			"Validation failed",
+			validationErrors,
+		))
+	}
+
+	switch err.Error() {
+	case "invalid mfa code":
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"INVALID_MFA_CODE",
+			"Invalid two-factor authentication code",
+			nil,
+		))
+	case "mfa already enabled":
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+			"MFA_ALREADY_ENABLED",
+			"Two-factor authentication is already enabled",
+			nil,
+		))
+	case "mfa not enabled", "mfa setup not started":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"MFA_NOT_ENABLED",
+			"Two-factor authentication is not enabled",
+			nil,
+		))
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"USER_NOT_FOUND",
+			"User not found",
+			nil,
+		))
+	case "account suspended":
+		return accountSuspended(c)
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+		code,
+		message,
+		err.Error(),
+	))
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type OAuthServerHandler struct {
+	oauthServerService *services.OAuthServerService
+}
+
+func NewOAuthServerHandler(oauthServerService *services.OAuthServerService) *OAuthServerHandler {
+	return &OAuthServerHandler{
+		oauthServerService: oauthServerService,
+	}
+}
+
+// RegisterClient registers a third-party app owned by the current user
+func (h *OAuthServerHandler) RegisterClient(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.OAuthClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.oauthServerService.RegisterClient(userID, &req)
+	if err != nil {
+		return oauthServerError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.SuccessResponse(
+		"OAuth client registered successfully, store the client secret now as it won't be shown again",
+		response,
+	))
+}
+
+// ListClients returns the third-party apps of the current user
+func (h *OAuthServerHandler) ListClients(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	clients, err := h.oauthServerService.ListClients(userID)
+	if err != nil {
+		return oauthServerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth clients retrieved successfully",
+		clients,
+	))
+}
+
+// DeleteClient removes a third-party app of the current user
+func (h *OAuthServerHandler) DeleteClient(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_CLIENT_ID",
+			"Invalid client ID",
+			nil,
+		))
+	}
+
+	if err := h.oauthServerService.DeleteClient(userID, id); err != nil {
+		return oauthServerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"OAuth client deleted successfully",
+		nil,
+	))
+}
+
+// GetConsent describes an authorization request for the consent page, which
+// passes on the query string the third-party app sent the user with
+func (h *OAuthServerHandler) GetConsent(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.OAuthAuthorizeRequest
+	if err := c.QueryParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	consent, err := h.oauthServerService.GetConsent(userID, &req)
+	if err != nil {
+		return oauthServerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Authorization request retrieved successfully",
+		consent,
+	))
+}
+
+// Authorize records whether the user approved the authorization request and
+// returns the URL to send the browser back to the third-party app with
+func (h *OAuthServerHandler) Authorize(c *fiber.Ctx) error {
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.OAuthAuthorizeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	authTime := claims.IssuedAt.Time
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
+	}
+
+	response, err := h.oauthServerService.Authorize(claims.UserID, authTime, &req)
+	if err != nil {
+		return oauthServerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Authorization request answered",
+		response,
+	))
+}
+
+// Token is the OAuth 2.0 token endpoint. It answers in the format of RFC 6749
+// instead of the service's envelope since OAuth client libraries parse it.
+func (h *OAuthServerHandler) Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	var req models.OAuthTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return tokenError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+
+	// Credentials in the Authorization header take precedence over the form
+	if clientID, clientSecret, ok := basicAuth(c.Get(fiber.HeaderAuthorization)); ok {
+		req.ClientID = clientID
+		req.ClientSecret = clientSecret
+	}
+
+	response, err := h.oauthServerService.Token(&req, clientInfo(c))
+	if err != nil {
+		switch err.Error() {
+		case "invalid request":
+			return tokenError(c, fiber.StatusBadRequest, "invalid_request", "Missing or invalid parameters")
+		case "invalid client":
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+			return tokenError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		case "invalid grant":
+			return tokenError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid, expired or revoked grant")
+		case "unsupported grant type":
+			return tokenError(c, fiber.StatusBadRequest, "unsupported_grant_type", "Grant type is not supported")
+		}
+		return tokenError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue tokens")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// UserInfo is the OpenID Connect user info endpoint
+func (h *OAuthServerHandler) UserInfo(c *fiber.Ctx) error {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(h.oauthServerService.UserInfo(user, claims))
+}
+
+// OpenIDConfiguration publishes the OpenID Connect discovery document
+func (h *OAuthServerHandler) OpenIDConfiguration(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(h.oauthServerService.Discovery())
+}
+
+func tokenError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(models.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// basicAuth reads client credentials from an HTTP Basic header. Both parts
+// are form encoded as RFC 6749 section 2.3.1 requires.
+func basicAuth(header string) (string, string, bool) {
+	encoded, found := strings.CutPrefix(header, "Basic ")
+	if !found {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+
+	rawID, rawSecret, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", false
+	}
+
+	clientID, err := url.QueryUnescape(rawID)
+	if err != nil {
+		return "", "", false
+	}
+	clientSecret, err := url.QueryUnescape(rawSecret)
+	if err != nil {
+		return "", "", false
+	}
+
+	return clientID, clientSecret, true
+}
+
+// oauthServerError maps client registration and consent errors to responses
+func oauthServerError(c *fiber.Ctx, err error) error {
+	// Check for validation errors
+	if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"VALIDATION_ERROR",
+			"Validation failed",
+			validationErrors,
+		))
+	}
+
+	switch err.Error() {
+	case "invalid client":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_CLIENT",
+			"Unknown OAuth client",
+			nil,
+		))
+	case "invalid redirect uri":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_REDIRECT_URI",
+			"Redirect URI is not registered for this client or is not allowed",
+			nil,
+		))
+	case "invalid scope":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_SCOPE",
+			"Requested scope is not allowed for this client",
+			nil,
+		))
+	case "oauth client not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"CLIENT_NOT_FOUND",
+			"OAuth client not found",
+			nil,
+		))
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+		"OAUTH_SERVER_ERROR",
+		"Failed to process OAuth request",
+		err.Error(),
+	))
+}
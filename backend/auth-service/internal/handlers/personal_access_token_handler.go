@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type PersonalAccessTokenHandler struct {
+	patService *services.PersonalAccessTokenService
+}
+
+func NewPersonalAccessTokenHandler(patService *services.PersonalAccessTokenService) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		patService: patService,
+	}
+}
+
+// ListTokens returns the personal access tokens of the current user
+func (h *PersonalAccessTokenHandler) ListTokens(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	tokens, err := h.patService.List(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"GET_TOKENS_FAILED",
+			"Failed to get personal access tokens",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Personal access tokens retrieved successfully",
+		tokens,
+	))
+}
+
+// CreateToken issues a personal access token for the current user
+func (h *PersonalAccessTokenHandler) CreateToken(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.PersonalAccessTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.patService.Create(userID, &req)
+	if err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		if err.Error() == "too many personal access tokens" {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+				"TOO_MANY_TOKENS",
+				"Personal access token limit reached, delete unused tokens first",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"CREATE_TOKEN_FAILED",
+			"Failed to create personal access token",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.SuccessResponse(
+		"Personal access token created, copy it now as it won't be shown again",
+		response,
+	))
+}
+
+// DeleteToken revokes a personal access token of the current user
+func (h *PersonalAccessTokenHandler) DeleteToken(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_TOKEN_ID",
+			"Invalid token ID",
+			nil,
+		))
+	}
+
+	deleted, err := h.patService.Delete(userID, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"DELETE_TOKEN_FAILED",
+			"Failed to delete personal access token",
+			err.Error(),
+		))
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"TOKEN_NOT_FOUND",
+			"Personal access token not found",
+			nil,
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Personal access token deleted successfully",
+		nil,
+	))
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// unauthorized responds when the authenticated user is missing from context
+func unauthorized(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+		"UNAUTHORIZED",
+		"User not authenticated",
+		err.Error(),
+	))
+}
+
+// invalidRequestBody responds when the request body can't be parsed
+func invalidRequestBody(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+		"INVALID_REQUEST",
+		"Invalid request body",
+		err.Error(),
+	))
+}
+
+// accountLocked responds when too many failed attempts locked the account or address
+func accountLocked(c *fiber.Ctx, err *lockout.LockedError) error {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse(
+		"ACCOUNT_LOCKED",
+		"Too many failed attempts, please try again later",
+		fiber.Map{"retry_after": retryAfter},
+	))
+}
+
+// accountSuspended responds when staff suspended the account
+func accountSuspended(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+		"ACCOUNT_SUSPENDED",
+		"This account has been suspended",
+		nil,
+	))
+}
+
+// usernameReserved responds when the username, or something that looks like
+// it, is reserved
+func usernameReserved(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+		"USERNAME_RESERVED",
+		"This username is reserved",
+		nil,
+	))
+}
+
+// clientInfo describes the client of the request
+func clientInfo(c *fiber.Ctx) *models.ClientInfo {
+	return &models.ClientInfo{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
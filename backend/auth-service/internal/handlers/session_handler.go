@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type SessionHandler struct {
+	authService *services.AuthService
+}
+
+func NewSessionHandler(authService *services.AuthService) *SessionHandler {
+	return &SessionHandler{
+		authService: authService,
+	}
+}
+
+// ListSessions returns the devices the current user is signed in on
+func (h *SessionHandler) ListSessions(c *fiber.Ctx) error {
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	sessions, err := h.authService.ListSessions(claims.UserID, claims.SessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"GET_SESSIONS_FAILED",
+			"Failed to get sessions",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Sessions retrieved successfully",
+		sessions,
+	))
+}
+
+// RevokeSession signs the current user out of one device
+func (h *SessionHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_SESSION_ID",
+			"Invalid session ID",
+			nil,
+		))
+	}
+
+	revoked, err := h.authService.RevokeSession(userID, sessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"REVOKE_SESSION_FAILED",
+			"Failed to revoke session",
+			err.Error(),
+		))
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"SESSION_NOT_FOUND",
+			"Session not found",
+			nil,
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Session revoked successfully",
+		nil,
+	))
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"net/url"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
@@ -9,12 +13,16 @@ import (
 )
 
 type UserHandler struct {
-	userService *services.UserService
+	userService     *services.UserService
+	passwordService *services.PasswordService
+	accountService  *services.AccountService
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
+func NewUserHandler(userService *services.UserService, passwordService *services.PasswordService, accountService *services.AccountService) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:     userService,
+		passwordService: passwordService,
+		accountService:  accountService,
 	}
 }
 
@@ -104,6 +112,228 @@ func (h *UserHandler) UpdateProfile(c *fiber.Ctx) error {
 	))
 }
 
+// ChangeUsername changes the current user's username
+func (h *UserHandler) ChangeUsername(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.ChangeUsernameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.userService.ChangeUsername(userID, &req)
+	if err != nil {
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		var cooldownErr *services.UsernameCooldownError
+		if errors.As(err, &cooldownErr) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse(
+				"USERNAME_CHANGE_TOO_SOON",
+				"Your username was changed recently, please wait before changing it again",
+				fiber.Map{"next_change_at": cooldownErr.NextChangeAt},
+			))
+		}
+
+		switch err.Error() {
+		case "username unchanged":
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"USERNAME_UNCHANGED",
+				"This is already your username",
+				nil,
+			))
+		case "username already taken":
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+				"USERNAME_EXISTS",
+				"Username already taken or too similar to an existing one",
+				nil,
+			))
+		case "username reserved":
+			return usernameReserved(c)
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+				"USER_NOT_FOUND",
+				"User not found",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"CHANGE_USERNAME_FAILED",
+			"Failed to change username",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Username changed successfully",
+		response,
+	))
+}
+
+// ChangePassword changes or sets the current user's password
+func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"UNAUTHORIZED",
+			"User not authenticated",
+			err.Error(),
+		))
+	}
+
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"UNAUTHORIZED",
+			"User not authenticated",
+			err.Error(),
+		))
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_REQUEST",
+			"Invalid request body",
+			err.Error(),
+		))
+	}
+
+	response, err := h.passwordService.ChangePassword(userID, claims, &req, clientInfo(c))
+	if err != nil {
+		var lockedErr *lockout.LockedError
+		if errors.As(err, &lockedErr) {
+			return accountLocked(c, lockedErr)
+		}
+
+		// Check for validation errors
+		if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"VALIDATION_ERROR",
+				"Validation failed",
+				validationErrors,
+			))
+		}
+
+		switch err.Error() {
+		case "current password is required":
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"CURRENT_PASSWORD_REQUIRED",
+				"Current password is required",
+				nil,
+			))
+		case "current password is incorrect":
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"INVALID_CREDENTIALS",
+				"Current password is incorrect",
+				nil,
+			))
+		case "recent authentication required":
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"REAUTHENTICATION_REQUIRED",
+				"Please sign in again before setting a password",
+				nil,
+			))
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+				"USER_NOT_FOUND",
+				"User not found",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"CHANGE_PASSWORD_FAILED",
+			"Failed to change password",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Password changed successfully",
+		response,
+	))
+}
+
+// DeleteAccount deletes the current user's account. It can be restored by
+// signing in until the grace period is over.
+func (h *UserHandler) DeleteAccount(c *fiber.Ctx) error {
+	claims, err := middleware.GetClaimsFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	// The body is optional for users without a password
+	var req models.DeleteAccountRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return invalidRequestBody(c, err)
+		}
+	}
+
+	response, err := h.accountService.DeleteAccount(c.Context(), claims, &req)
+	if err != nil {
+		var lockedErr *lockout.LockedError
+		if errors.As(err, &lockedErr) {
+			return accountLocked(c, lockedErr)
+		}
+
+		switch err.Error() {
+		case "password is required":
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+				"PASSWORD_REQUIRED",
+				"Password is required to delete your account",
+				nil,
+			))
+		case "password is incorrect":
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"INVALID_CREDENTIALS",
+				"Password is incorrect",
+				nil,
+			))
+		case "recent authentication required":
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"REAUTHENTICATION_REQUIRED",
+				"Please sign in again before deleting your account",
+				nil,
+			))
+		case "cannot delete last admin":
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+				"LAST_ADMIN",
+				"Grant the admin role to someone else before deleting the last admin account",
+				nil,
+			))
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+				"USER_NOT_FOUND",
+				"User not found",
+				nil,
+			))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"DELETE_ACCOUNT_FAILED",
+			"Failed to delete account",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Account deleted, sign in before purge_after to restore it",
+		response,
+	))
+}
+
 // GetUserByUsername returns user profile by username
 func (h *UserHandler) GetUserByUsername(c *fiber.Ctx) error {
 	username := c.Params("username")
@@ -117,6 +347,17 @@ func (h *UserHandler) GetUserByUsername(c *fiber.Ctx) error {
 
 	user, err := h.userService.GetUserByUsername(username)
 	if err != nil {
+		// Point clients at the user's current username, relative to this route
+		var movedErr *services.UsernameMovedError
+		if errors.As(err, &movedErr) {
+			c.Location("./" + url.PathEscape(movedErr.Username))
+			return c.Status(fiber.StatusTemporaryRedirect).JSON(models.ErrorResponse(
+				"USERNAME_CHANGED",
+				"This user changed their username",
+				fiber.Map{"username": movedErr.Username},
+			))
+		}
+
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
 				"USER_NOT_FOUND",
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/middleware"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type WebAuthnHandler struct {
+	webAuthnService *services.WebAuthnService
+}
+
+func NewWebAuthnHandler(webAuthnService *services.WebAuthnService) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		webAuthnService: webAuthnService,
+	}
+}
+
+// BeginRegistration returns the options for creating a passkey
+func (h *WebAuthnHandler) BeginRegistration(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	options, err := h.webAuthnService.BeginRegistration(userID)
+	if err != nil {
+		return webAuthnError(c, err, "PASSKEY_REGISTRATION_FAILED", "Failed to start passkey registration")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Passkey registration started",
+		options,
+	))
+}
+
+// FinishRegistration stores the passkey created by the browser
+func (h *WebAuthnHandler) FinishRegistration(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	var req models.WebAuthnFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	credential, err := h.webAuthnService.FinishRegistration(userID, &req)
+	if err != nil {
+		return webAuthnError(c, err, "PASSKEY_REGISTRATION_FAILED", "Failed to register passkey")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.SuccessResponse(
+		"Passkey registered successfully",
+		credential,
+	))
+}
+
+// ListCredentials returns the current user's passkeys
+func (h *WebAuthnHandler) ListCredentials(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	credentials, err := h.webAuthnService.ListCredentials(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+			"GET_PASSKEYS_FAILED",
+			"Failed to get passkeys",
+			err.Error(),
+		))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Passkeys retrieved successfully",
+		credentials,
+	))
+}
+
+// DeleteCredential removes one of the current user's passkeys
+func (h *WebAuthnHandler) DeleteCredential(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	credentialID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"INVALID_PASSKEY_ID",
+			"Invalid passkey ID",
+			nil,
+		))
+	}
+
+	if err := h.webAuthnService.DeleteCredential(userID, credentialID); err != nil {
+		return webAuthnError(c, err, "DELETE_PASSKEY_FAILED", "Failed to delete passkey")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Passkey deleted successfully",
+		nil,
+	))
+}
+
+// BeginLogin returns the options for signing in with a passkey
+func (h *WebAuthnHandler) BeginLogin(c *fiber.Ctx) error {
+	options, err := h.webAuthnService.BeginLogin()
+	if err != nil {
+		return webAuthnError(c, err, "PASSKEY_LOGIN_FAILED", "Failed to start passkey login")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Passkey login started",
+		options,
+	))
+}
+
+// FinishLogin signs the user in with a passkey assertion
+func (h *WebAuthnHandler) FinishLogin(c *fiber.Ctx) error {
+	var req models.WebAuthnFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.webAuthnService.FinishLogin(&req, clientInfo(c))
+	if err != nil {
+		return webAuthnError(c, err, "PASSKEY_LOGIN_FAILED", "Failed to login with passkey")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Login successful",
+		response,
+	))
+}
+
+// BeginMFA returns the options for answering a login challenge with a passkey
+func (h *WebAuthnHandler) BeginMFA(c *fiber.Ctx) error {
+	var req models.WebAuthnMFABeginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	options, err := h.webAuthnService.BeginMFA(&req)
+	if err != nil {
+		return webAuthnError(c, err, "MFA_VERIFICATION_FAILED", "Failed to start passkey verification")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Passkey verification started",
+		options,
+	))
+}
+
+// FinishMFA completes a login challenge with a passkey assertion
+func (h *WebAuthnHandler) FinishMFA(c *fiber.Ctx) error {
+	var req models.WebAuthnMFAFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return invalidRequestBody(c, err)
+	}
+
+	response, err := h.webAuthnService.FinishMFA(&req, clientInfo(c))
+	if err != nil {
+		return webAuthnError(c, err, "MFA_VERIFICATION_FAILED", "Failed to verify passkey")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse(
+		"Login successful",
+		response,
+	))
+}
+
+// webAuthnError maps WebAuthn service errors to responses
+func webAuthnError(c *fiber.Ctx, err error, code, message string) error {
+	// Check for validation errors
+	if validationErrors := utils.FormatValidationErrors(err); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"VALIDATION_ERROR",
+			"Validation failed",
+			validationErrors,
+		))
+	}
+
+	switch err.Error() {
+	case "invalid passkey", "invalid webauthn session":
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"INVALID_PASSKEY",
+			"Passkey verification failed, please try again",
+			nil,
+		))
+	case "passkey disabled":
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+			"PASSKEY_DISABLED",
+			"This passkey may have been cloned and has been disabled, please sign in another way",
+			nil,
+		))
+	case "invalid mfa token":
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+			"INVALID_MFA_TOKEN",
+			"Invalid or expired two-factor challenge, please login again",
+			nil,
+		))
+	case "passkey already registered":
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse(
+			"PASSKEY_EXISTS",
+			"This passkey is already registered",
+			nil,
+		))
+	case "no passkeys registered":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse(
+			"NO_PASSKEYS",
+			"No passkeys registered for this account",
+			nil,
+		))
+	case "passkey not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"PASSKEY_NOT_FOUND",
+			"Passkey not found",
+			nil,
+		))
+	case "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse(
+			"USER_NOT_FOUND",
+			"User not found",
+			nil,
+		))
+	case "account suspended":
+		return accountSuspended(c)
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse(
+		code,
+		message,
+		err.Error(),
+	))
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WellKnownHandler struct {
+	jwtManager *utils.JWTManager
+}
+
+func NewWellKnownHandler(jwtManager *utils.JWTManager) *WellKnownHandler {
+	return &WellKnownHandler{
+		jwtManager: jwtManager,
+	}
+}
+
+// JWKS publishes the public keys other services use to verify access tokens
+func (h *WellKnownHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(utils.JWKSMaxAge.Seconds())))
+	return c.Status(fiber.StatusOK).JSON(h.jwtManager.JWKS())
+}
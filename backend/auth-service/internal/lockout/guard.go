@@ -0,0 +1,142 @@
+package lockout
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes that failed attempts are counted in
+const (
+	ScopeAccount = "account"
+	ScopeIP      = "ip"
+	ScopeMFA     = "mfa"
+)
+
+// Key identifies what a failed attempt is counted against
+type Key struct {
+	Scope string
+	Value string
+}
+
+func Account(email string) Key {
+	return Key{Scope: ScopeAccount, Value: strings.ToLower(strings.TrimSpace(email))}
+}
+
+func IP(ip string) Key {
+	return Key{Scope: ScopeIP, Value: ip}
+}
+
+func MFA(userID uuid.UUID) Key {
+	return Key{Scope: ScopeMFA, Value: userID.String()}
+}
+
+func (k Key) String() string {
+	return k.Scope + ":" + k.Value
+}
+
+// Policy decides when failed attempts lead to a lock. Once Threshold failures
+// are reached the key is locked for BaseDelay, doubling with every further
+// failure up to MaxDelay.
+type Policy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// LockedError is returned while a key is locked
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("account locked, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Guard applies per scope policies on top of a Store. Store failures are
+// logged and let the attempt through, so an unavailable store doesn't block
+// every login.
+type Guard struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func NewGuard(store Store, policies map[string]Policy) *Guard {
+	return &Guard{
+		store:    store,
+		policies: policies,
+	}
+}
+
+// Check returns a *LockedError if any of the keys is locked
+func (g *Guard) Check(keys ...Key) error {
+	now := time.Now()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		lockedUntil, err := g.store.LockedUntil(key.String())
+		if err != nil {
+			log.Printf("Failed to check lockout of %s: %v", key.Scope, err)
+			continue
+		}
+		if wait := lockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Fail records a failed attempt against every key and locks the keys that
+// reached their threshold
+func (g *Guard) Fail(keys ...Key) {
+	for _, key := range keys {
+		policy, exists := g.policies[key.Scope]
+		if !exists {
+			continue
+		}
+
+		failures, err := g.store.RecordFailure(key.String(), policy.Window)
+		if err != nil {
+			log.Printf("Failed to record failed attempt for %s: %v", key.Scope, err)
+			continue
+		}
+
+		if delay := policy.delay(failures); delay > 0 {
+			if err := g.store.Lock(key.String(), time.Now().Add(delay)); err != nil {
+				log.Printf("Failed to lock %s: %v", key.Scope, err)
+			}
+		}
+	}
+}
+
+// Succeed clears the failures of the keys after a successful attempt
+func (g *Guard) Succeed(keys ...Key) {
+	for _, key := range keys {
+		if err := g.store.Reset(key.String()); err != nil {
+			log.Printf("Failed to reset failed attempts for %s: %v", key.Scope, err)
+		}
+	}
+}
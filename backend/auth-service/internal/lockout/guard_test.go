@@ -0,0 +1,115 @@
+package lockout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{Threshold: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		want     time.Duration
+	}{
+		{"below threshold", policy, 4, 0},
+		{"at threshold", policy, 5, 30 * time.Second},
+		{"one past threshold doubles", policy, 6, time.Minute},
+		{"two past threshold doubles twice", policy, 7, 2 * time.Minute},
+		{"five past threshold", policy, 10, 15 * time.Minute},
+		{"capped at max delay", policy, 50, 15 * time.Minute},
+		{"disabled without threshold", Policy{BaseDelay: time.Second, MaxDelay: time.Minute}, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.failures); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardLocksAfterThreshold(t *testing.T) {
+	policy := Policy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+	tests := []struct {
+		name     string
+		failures int
+		succeed  bool
+		locked   bool
+		minWait  time.Duration
+		maxWait  time.Duration
+	}{
+		{name: "below threshold", failures: 2},
+		{name: "at threshold", failures: 3, locked: true, minWait: 59 * time.Second, maxWait: time.Minute},
+		{name: "backoff doubles", failures: 5, locked: true, minWait: 3*time.Minute + 59*time.Second, maxWait: 4 * time.Minute},
+		{name: "success clears failures", failures: 5, succeed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := NewGuard(NewMemoryStore(), map[string]Policy{ScopeAccount: policy})
+			key := Account(" User@Example.com ")
+
+			for i := 0; i < tt.failures; i++ {
+				guard.Fail(key)
+			}
+			if tt.succeed {
+				guard.Succeed(key)
+			}
+
+			err := guard.Check(Account("user@example.com"))
+			var locked *LockedError
+			if errors.As(err, &locked) != tt.locked {
+				t.Fatalf("Check = %v, want locked %v", err, tt.locked)
+			}
+			if tt.locked && (locked.RetryAfter < tt.minWait || locked.RetryAfter > tt.maxWait) {
+				t.Errorf("RetryAfter = %s, want between %s and %s", locked.RetryAfter, tt.minWait, tt.maxWait)
+			}
+
+			// Other accounts and scopes without a policy are unaffected
+			if err := guard.Check(Account("other@example.com"), IP("127.0.0.1")); err != nil {
+				t.Errorf("Check of other keys = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestGuardReportsLongestLock(t *testing.T) {
+	guard := NewGuard(NewMemoryStore(), map[string]Policy{
+		ScopeAccount: {Threshold: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		ScopeIP:      {Threshold: 1, BaseDelay: 10 * time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	})
+	account, ip := Account("user@example.com"), IP("203.0.113.7")
+
+	guard.Fail(account, ip)
+
+	var locked *LockedError
+	if err := guard.Check(account, ip); !errors.As(err, &locked) {
+		t.Fatalf("Check = %v, want *LockedError", err)
+	}
+	if locked.RetryAfter <= time.Minute {
+		t.Errorf("RetryAfter = %s, want the IP lock of 10m", locked.RetryAfter)
+	}
+}
+
+func TestMemoryStoreForgetsFailuresAfterWindow(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, err := store.RecordFailure("account:a", time.Nanosecond); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	failures, err := store.RecordFailure("account:a", time.Hour)
+	if err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if failures != 1 {
+		t.Errorf("failures = %d, want 1 after the window passed", failures)
+	}
+}
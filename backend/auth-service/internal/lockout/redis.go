@@ -0,0 +1,87 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "auth:lockout:"
+
+// RedisStore shares failure counters and locks between replicas. Redis
+// expires the keys on its own.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) RecordFailure(key string, window time.Duration) (int, error) {
+	ctx := context.Background()
+	failuresKey := redisKeyPrefix + "failures:" + key
+
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, failuresKey)
+		pipe.PExpire(ctx, failuresKey, window)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record failed attempt: %w", err)
+	}
+
+	return int(incr.Val()), nil
+}
+
+func (s *RedisStore) Lock(key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value := strconv.FormatInt(until.UnixMilli(), 10)
+	if err := s.client.Set(context.Background(), redisKeyPrefix+"lock:"+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to lock: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) LockedUntil(key string) (time.Time, error) {
+	value, err := s.client.Get(context.Background(), redisKeyPrefix+"lock:"+key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get lock: %w", err)
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid lock value: %w", err)
+	}
+	return time.UnixMilli(millis), nil
+}
+
+func (s *RedisStore) Reset(key string) error {
+	err := s.client.Del(context.Background(), redisKeyPrefix+"failures:"+key, redisKeyPrefix+"lock:"+key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reset failed attempts: %w", err)
+	}
+	return nil
+}
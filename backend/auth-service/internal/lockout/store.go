@@ -0,0 +1,20 @@
+package lockout
+
+import "time"
+
+// Store counts failed attempts per key and holds temporary locks
+type Store interface {
+	// RecordFailure increments the failure counter of the key and returns the
+	// new count. The counter is forgotten after window without failures.
+	RecordFailure(key string, window time.Duration) (int, error)
+
+	// Lock rejects attempts for the key until the given time
+	Lock(key string, until time.Time) error
+
+	// LockedUntil returns when the lock on the key ends, or the zero time if
+	// the key is not locked
+	LockedUntil(key string) (time.Time, error)
+
+	// Reset clears the failure counter and lock of the key
+	Reset(key string) error
+}
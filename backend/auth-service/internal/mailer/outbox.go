@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxMailer writes messages to .eml files in a directory, or to stdout when
+// no directory is configured, so the service runs without a mail server
+type OutboxMailer struct {
+	mu   sync.Mutex
+	from string
+	dir  string
+}
+
+func NewOutboxMailer(from, dir string) *OutboxMailer {
+	return &OutboxMailer{
+		from: from,
+		dir:  dir,
+	}
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data := render(m.from, msg)
+
+	if m.dir == "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		fmt.Fprintf(os.Stdout, "----- outbox message -----\n%s\n--------------------------\n", data)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.New().String()[:8])
+	if err := os.WriteFile(filepath.Join(m.dir, name), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write outbox message: %w", err)
+	}
+	return nil
+}
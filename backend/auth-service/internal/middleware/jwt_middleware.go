@@ -5,6 +5,7 @@ import (
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
@@ -19,8 +20,10 @@ func NewAuthMiddleware(authService *services.AuthService) *AuthMiddleware {
 	}
 }
 
-// JWTMiddleware validates JWT tokens and adds user info to context
-func (m *AuthMiddleware) JWTMiddleware() fiber.Handler {
+// JWTMiddleware validates JWT tokens and adds user info to context. Personal
+// access tokens are accepted only on routes that list the scopes they need,
+// and must hold all of them.
+func (m *AuthMiddleware) JWTMiddleware(scopes ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get Authorization header
 		authHeader := c.Get("Authorization")
@@ -44,9 +47,16 @@ func (m *AuthMiddleware) JWTMiddleware() fiber.Handler {
 
 		token := tokenParts[1]
 
-		// Validate token and get user
-		user, err := m.authService.ValidateToken(token)
+		// Validate token, check revocation and get user
+		user, claims, err := m.authService.Authenticate(token)
 		if err != nil {
+			if err.Error() == "account suspended" {
+				return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+					"ACCOUNT_SUSPENDED",
+					"This account has been suspended",
+					nil,
+				))
+			}
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
 				"UNAUTHORIZED",
 				"Invalid or expired token",
@@ -54,10 +64,37 @@ func (m *AuthMiddleware) JWTMiddleware() fiber.Handler {
 			))
 		}
 
+		// Tokens of third-party apps only reach routes guarded by ScopeMiddleware
+		if claims.ClientID != "" {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"INSUFFICIENT_SCOPE",
+				"Token issued to a third-party app can't access this resource",
+				nil,
+			))
+		}
+
+		if claims.PersonalAccessTokenID != uuid.Nil {
+			if len(scopes) == 0 {
+				return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+					"INSUFFICIENT_SCOPE",
+					"Personal access tokens can't access this resource",
+					nil,
+				))
+			}
+			if !claims.HasScopes(scopes...) {
+				return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+					"INSUFFICIENT_SCOPE",
+					"Personal access token was not granted the required scope",
+					fiber.Map{"required_scopes": scopes},
+				))
+			}
+		}
+
 		// Add user to context
 		c.Locals("user", user)
 		c.Locals("userID", user.ID)
 		c.Locals("username", user.Username)
+		c.Locals("claims", claims)
 
 		return c.Next()
 	}
@@ -80,9 +117,9 @@ func (m *AuthMiddleware) OptionalJWTMiddleware() fiber.Handler {
 
 		token := tokenParts[1]
 
-		// Validate token and get user
-		user, err := m.authService.ValidateToken(token)
-		if err != nil {
+		// Validate token, check revocation and get user
+		user, claims, err := m.authService.Authenticate(token)
+		if err != nil || claims.Scoped() {
 			return c.Next() // Continue without user context
 		}
 
@@ -90,6 +127,77 @@ func (m *AuthMiddleware) OptionalJWTMiddleware() fiber.Handler {
 		c.Locals("user", user)
 		c.Locals("userID", user.ID)
 		c.Locals("username", user.Username)
+		c.Locals("claims", claims)
+
+		return c.Next()
+	}
+}
+
+// ScopeMiddleware validates JWT tokens like JWTMiddleware but also accepts
+// tokens of third-party apps that were granted every given scope
+func (m *AuthMiddleware) ScopeMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Extract token from "Bearer <token>"
+		tokenParts := strings.Split(c.Get("Authorization"), " ")
+		if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"UNAUTHORIZED",
+				"Missing or invalid authorization header",
+				nil,
+			))
+		}
+
+		// Validate token, check revocation and get user
+		user, claims, err := m.authService.Authenticate(tokenParts[1])
+		if err != nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"UNAUTHORIZED",
+				"Invalid or expired token",
+				err.Error(),
+			))
+		}
+
+		if !claims.HasScopes(scopes...) {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"INSUFFICIENT_SCOPE",
+				"Token was not granted the required scope",
+				fiber.Map{"required_scopes": scopes},
+			))
+		}
+
+		// Add user to context
+		c.Locals("user", user)
+		c.Locals("userID", user.ID)
+		c.Locals("username", user.Username)
+		c.Locals("claims", claims)
+
+		return c.Next()
+	}
+}
+
+// RequirePermission rejects requests whose token lacks any of the given
+// permissions. It runs after JWTMiddleware.
+func (m *AuthMiddleware) RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := GetClaimsFromContext(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse(
+				"UNAUTHORIZED",
+				"User not authenticated",
+				nil,
+			))
+		}
+
+		if !claims.HasPermissions(permissions...) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse(
+				"FORBIDDEN",
+				"You don't have permission to perform this action",
+				fiber.Map{"required_permissions": permissions},
+			))
+		}
 
 		return c.Next()
 	}
@@ -121,3 +229,12 @@ func GetUsernameFromContext(c *fiber.Ctx) (string, error) {
 	}
 	return username, nil
 }
+
+// GetClaimsFromContext extracts the validated token claims from fiber context
+func GetClaimsFromContext(c *fiber.Ctx) (*utils.JWTClaims, error) {
+	claims, ok := c.Locals("claims").(*utils.JWTClaims)
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Token claims not found in context")
+	}
+	return claims, nil
+}
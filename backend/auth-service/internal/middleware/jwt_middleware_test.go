@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/services"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// newPersonalAccessTokenTest serves one route behind JWTMiddleware with the
+// given scopes. Requests carry a personal access token holding tokenScopes.
+func newPersonalAccessTokenTest(t *testing.T, routeScopes []string, tokenScopes string) (*fiber.App, *http.Request) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	token := models.PersonalAccessTokenPrefix + "secret"
+	userID := uuid.New()
+	mock.ExpectQuery(`FROM personal_access_tokens WHERE token_hash`).
+		WithArgs(utils.HashToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "token_prefix", "token_hash", "scopes", "expires_at", "last_used_at", "created_at"}).
+			AddRow(uuid.New(), userID, "bot", "thr_pat_secr", utils.HashToken(token), tokenScopes, nil, nil, time.Now()))
+	mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "credential_version", "created_at"}).
+			AddRow(userID, "john_doe", "john@example.com", 1, time.Now()))
+	mock.ExpectExec(`UPDATE personal_access_tokens SET last_used_at`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	authService := services.NewAuthService(
+		database.NewUserRepository(sqlxDB), nil, nil, nil, nil, nil,
+		database.NewPersonalAccessTokenRepository(sqlxDB),
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+
+	app := fiber.New()
+	app.Get("/resource", NewAuthMiddleware(authService).JWTMiddleware(routeScopes...), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return app, req
+}
+
+func TestJWTMiddlewarePersonalAccessTokenScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		routeScopes []string
+		tokenScopes string
+		wantStatus  int
+	}{
+		{name: "route that names no scopes", tokenScopes: "{profile:read,profile:write,threads:read,threads:write}", wantStatus: fiber.StatusForbidden},
+		{name: "token lacking the route's scope", routeScopes: []string{models.ScopeProfileWrite}, tokenScopes: "{profile:read}", wantStatus: fiber.StatusForbidden},
+		{name: "token lacking one of the route's scopes", routeScopes: []string{models.ScopeProfileRead, models.ScopeProfileWrite}, tokenScopes: "{profile:read}", wantStatus: fiber.StatusForbidden},
+		{name: "token with the route's scope", routeScopes: []string{models.ScopeProfileRead}, tokenScopes: "{profile:read}", wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, req := newPersonalAccessTokenTest(t, tt.routeScopes, tt.tokenScopes)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
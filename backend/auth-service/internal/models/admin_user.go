@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AdminUser is a user as support staff see it
+type AdminUser struct {
+	*User
+	HasPassword bool            `json:"has_password"`
+	Roles       []string        `json:"roles,omitempty"`
+	Suspension  *UserSuspension `json:"suspension"`
+	// DeletedAt is set while the account is pending deletion
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// UserSuspension describes the suspension of a user. Active is false once
+// SuspendedUntil has passed.
+type UserSuspension struct {
+	Active         bool       `json:"active"`
+	SuspendedAt    time.Time  `json:"suspended_at"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+	Reason         string     `json:"reason"`
+	SuspendedBy    *uuid.UUID `json:"suspended_by"`
+}
+
+// NewAdminUser describes the user for the admin API
+func NewAdminUser(user *User) *AdminUser {
+	adminUser := &AdminUser{
+		User:        user,
+		HasPassword: user.PasswordHash != "",
+		DeletedAt:   user.DeletedAt,
+	}
+
+	if user.SuspendedAt != nil {
+		adminUser.Suspension = &UserSuspension{
+			Active:         user.Suspended(),
+			SuspendedAt:    *user.SuspendedAt,
+			SuspendedUntil: user.SuspendedUntil,
+			SuspendedBy:    user.SuspendedBy,
+		}
+		if user.SuspensionReason != nil {
+			adminUser.Suspension.Reason = *user.SuspensionReason
+		}
+	}
+
+	// Remove password hash from response
+	user.PasswordHash = ""
+
+	return adminUser
+}
+
+// Request/Response models
+
+type UserSearchQuery struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+type UserSearchResponse struct {
+	Users  []AdminUser `json:"users"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// SuspendUserRequest suspends a user until the given time, or until lifted
+// when Until is omitted
+type SuspendUserRequest struct {
+	Reason string     `json:"reason" validate:"required,max=500"`
+	Until  *time.Time `json:"until"`
+}
+
+// AdminActionRequest carries the reason of an admin action for the audit log
+type AdminActionRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Data export statuses
+const (
+	DataExportPending   = "pending"
+	DataExportRunning   = "running"
+	DataExportCompleted = "completed"
+	DataExportFailed    = "failed"
+	DataExportExpired   = "expired"
+)
+
+// DataExport is a job building a ZIP archive of everything stored about a
+// user
+type DataExport struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Status      string     `json:"status" db:"status"`
+	FilePath    *string    `json:"-" db:"file_path"`
+	Error       *string    `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	StartedAt   *time.Time `json:"-" db:"started_at"`
+	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"`
+	DownloadURL string     `json:"download_url,omitempty" db:"-"`
+}
+
+// Downloadable reports whether the archive is ready and not yet expired
+func (e *DataExport) Downloadable(now time.Time) bool {
+	return e.Status == DataExportCompleted && e.FilePath != nil && e.ExpiresAt != nil && now.Before(*e.ExpiresAt)
+}
+
+// DataExportManifest describes the files of an export archive
+type DataExportManifest struct {
+	ExportID    uuid.UUID `json:"export_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Files       []string  `json:"files"`
+}
+
+// AccountExport is account.json of an export archive
+type AccountExport struct {
+	*User
+	HasPassword bool     `json:"has_password"`
+	Roles       []string `json:"roles"`
+}
+
+// SessionExport includes the revocation time sessions otherwise keep internal
+type SessionExport struct {
+	Session
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// AdminActionExport is an entry of admin_actions.json of an export archive,
+// an audit log entry about the user without the admin and their IP address
+type AdminActionExport struct {
+	Action    string       `json:"action"`
+	Details   AuditDetails `json:"details,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// SecurityExport is security.json of an export archive
+type SecurityExport struct {
+	TOTPEnabledAt        *time.Time            `json:"totp_enabled_at"`
+	Passkeys             []WebAuthnCredential  `json:"passkeys"`
+	PersonalAccessTokens []PersonalAccessToken `json:"personal_access_tokens"`
+	AuthorizedApps       []OAuthConsent        `json:"authorized_apps"`
+}
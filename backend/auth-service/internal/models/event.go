@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published to other services
+const (
+	EventUserDeleted         = "user.deleted"
+	EventUserUsernameChanged = "user.username_changed"
+)
+
+// Event is a message for other services, stored in the outbox until it has
+// been published
+type Event struct {
+	ID        uuid.UUID `db:"id"`
+	Type      string    `db:"event_type"`
+	Payload   []byte    `db:"payload"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// NewEvent encodes data as the payload of an event of the given type
+func NewEvent(eventType string, data interface{}) (*Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Message returns the JSON body consumers receive
+func (e *Event) Message() ([]byte, error) {
+	return json.Marshal(struct {
+		ID         uuid.UUID       `json:"id"`
+		Type       string          `json:"type"`
+		OccurredAt time.Time       `json:"occurred_at"`
+		Data       json.RawMessage `json:"data"`
+	}{
+		ID:         e.ID,
+		Type:       e.Type,
+		OccurredAt: e.CreatedAt,
+		Data:       e.Payload,
+	})
+}
+
+// UserDeletedEvent tells other services to erase or anonymize the data of a
+// user whose account was permanently deleted
+type UserDeletedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// UsernameChangedEvent tells other services that keep a copy of usernames
+// to update it
+type UsernameChangedEvent struct {
+	UserID      uuid.UUID `json:"user_id"`
+	OldUsername string    `json:"old_username"`
+	NewUsername string    `json:"new_username"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
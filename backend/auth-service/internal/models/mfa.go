@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFA methods offered in a login challenge
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
+type UserMFA struct {
+	UserID           uuid.UUID  `json:"user_id" db:"user_id"`
+	TOTPSecret       string     `json:"-" db:"totp_secret"`
+	TOTPEnabledAt    *time.Time `json:"totp_enabled_at" db:"totp_enabled_at"`
+	TOTPLastUsedStep *int64     `json:"-" db:"totp_last_used_step"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Request/Response models
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code,omitempty" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type DisableMFARequest struct {
+	Code         string `json:"code,omitempty" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatusResponse struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// MFAChallengeResponse is returned by login instead of AuthResponse when the
+// user has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	ExpiresIn   int64    `json:"expires_in"`
+	Methods     []string `json:"methods"`
+	RedirectURL string   `json:"redirect_url,omitempty"`
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Scopes third-party apps can request, with the text of the consent screen
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedScopes lists the scopes in the order the consent screen shows them
+var SupportedScopes = []OAuthScopeInfo{
+	{Name: ScopeOpenID, Description: "Sign you in with your account"},
+	{Name: ScopeProfile, Description: "See your username, display name and profile picture"},
+	{Name: ScopeEmail, Description: "See your email address"},
+}
+
+type OAuthClient struct {
+	ID               uuid.UUID      `json:"id" db:"id"`
+	ClientID         string         `json:"client_id" db:"client_id"`
+	ClientSecretHash *string        `json:"-" db:"client_secret_hash"`
+	Name             string         `json:"name" db:"name"`
+	RedirectURIs     pq.StringArray `json:"redirect_uris" db:"redirect_uris"`
+	Scopes           pq.StringArray `json:"scopes" db:"scopes"`
+	Public           bool           `json:"public" db:"public"`
+	OwnerID          uuid.UUID      `json:"owner_id" db:"owner_id"`
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
+}
+
+// OAuthConsent records the scopes a user granted a client
+type OAuthConsent struct {
+	UserID    uuid.UUID      `json:"user_id" db:"user_id"`
+	ClientID  string         `json:"client_id" db:"client_id"`
+	Scopes    pq.StringArray `json:"scopes" db:"scopes"`
+	GrantedAt time.Time      `json:"granted_at" db:"granted_at"`
+}
+
+// Request/Response models
+
+type OAuthClientRequest struct {
+	Name         string   `json:"name" validate:"required,min=1,max=100"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,max=10,dive,url"`
+	Scopes       []string `json:"scopes" validate:"omitempty,dive,oneof=openid profile email"`
+	Public       bool     `json:"public"`
+}
+
+// OAuthClientCreatedResponse is the only time the client secret is shown
+type OAuthClientCreatedResponse struct {
+	Client       *OAuthClient `json:"client"`
+	ClientSecret string       `json:"client_secret,omitempty"`
+}
+
+// OAuthAuthorizeRequest carries the parameters of an authorization request.
+// The consent page forwards them from its URL, adding approve when the user
+// answers.
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type" query:"response_type" validate:"required,eq=code"`
+	ClientID            string `json:"client_id" query:"client_id" validate:"required"`
+	RedirectURI         string `json:"redirect_uri" query:"redirect_uri" validate:"required"`
+	Scope               string `json:"scope" query:"scope"`
+	State               string `json:"state" query:"state"`
+	CodeChallenge       string `json:"code_challenge" query:"code_challenge" validate:"required"`
+	CodeChallengeMethod string `json:"code_challenge_method" query:"code_challenge_method" validate:"required,eq=S256"`
+	Nonce               string `json:"nonce" query:"nonce"`
+	Approve             bool   `json:"approve"`
+}
+
+type OAuthScopeInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// OAuthConsentResponse is what the consent page shows the user
+type OAuthConsentResponse struct {
+	ClientID     string           `json:"client_id"`
+	ClientName   string           `json:"client_name"`
+	RedirectURI  string           `json:"redirect_uri"`
+	Scopes       []OAuthScopeInfo `json:"scopes"`
+	ConsentGiven bool             `json:"consent_given"`
+}
+
+// OAuthAuthorizeResponse tells the consent page where to send the browser
+type OAuthAuthorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthTokenRequest is the form body of the token endpoint (RFC 6749)
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// OAuthErrorResponse is the error format OAuth clients expect from the token endpoint
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OpenIDConfiguration is the OIDC discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token so they can
+// be told apart from JWTs and found by secret scanners
+const PersonalAccessTokenPrefix = "thr_pat_"
+
+// Scopes of personal access tokens
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeThreadsRead  = "threads:read"
+	ScopeThreadsWrite = "threads:write"
+)
+
+type PersonalAccessToken struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	UserID      uuid.UUID      `json:"user_id" db:"user_id"`
+	Name        string         `json:"name" db:"name"`
+	TokenPrefix string         `json:"token_prefix" db:"token_prefix"`
+	TokenHash   string         `json:"-" db:"token_hash"`
+	Scopes      pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt   *time.Time     `json:"expires_at" db:"expires_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at" db:"last_used_at"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+}
+
+// Active reports whether the token can still be used
+func (t *PersonalAccessToken) Active(now time.Time) bool {
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// Request/Response models
+
+type PersonalAccessTokenRequest struct {
+	Name   string   `json:"name" validate:"required,min=1,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=profile:read profile:write threads:read threads:write"`
+	// ExpiresInDays of 0 creates a token that never expires
+	ExpiresInDays int `json:"expires_in_days" validate:"min=0,max=365"`
+}
+
+// PersonalAccessTokenCreatedResponse is the only time the token is shown
+type PersonalAccessTokenCreatedResponse struct {
+	Token               string               `json:"token"`
+	PersonalAccessToken *PersonalAccessToken `json:"personal_access_token"`
+}
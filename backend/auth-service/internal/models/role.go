@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Built-in roles
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+// Permissions checked by RequirePermission
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersManage     = "users:manage"
+	PermissionRolesManage     = "roles:manage"
+	PermissionContentModerate = "content:moderate"
+)
+
+// Audit log actions
+const (
+	AuditActionRoleGrant         = "role.grant"
+	AuditActionRoleRevoke        = "role.revoke"
+	AuditActionUserSuspend       = "user.suspend"
+	AuditActionUserUnsuspend     = "user.unsuspend"
+	AuditActionUserForceLogout   = "user.force_logout"
+	AuditActionUserPasswordReset = "user.force_password_reset"
+)
+
+type Role struct {
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	Permissions pq.StringArray `json:"permissions" db:"permissions"`
+}
+
+type UserRole struct {
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	Role      string     `json:"role" db:"role"`
+	GrantedBy *uuid.UUID `json:"granted_by" db:"granted_by"`
+	GrantedAt time.Time  `json:"granted_at" db:"granted_at"`
+}
+
+// UserAccess is what a user may do, as carried in access tokens
+type UserAccess struct {
+	Roles       []string
+	Permissions []string
+}
+
+type AuditLogEntry struct {
+	ID           uuid.UUID    `json:"id" db:"id"`
+	ActorID      *uuid.UUID   `json:"actor_id" db:"actor_id"`
+	TargetUserID *uuid.UUID   `json:"target_user_id" db:"target_user_id"`
+	Action       string       `json:"action" db:"action"`
+	Details      AuditDetails `json:"details,omitempty" db:"details"`
+	IPAddress    string       `json:"ip_address" db:"ip_address"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
+}
+
+// AuditDetails holds action specific data of an audit log entry
+type AuditDetails map[string]string
+
+// Implement driver.Valuer interface for AuditDetails
+func (d AuditDetails) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+	return json.Marshal(d)
+}
+
+// Implement sql.Scanner interface for AuditDetails
+func (d *AuditDetails) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, d)
+}
+
+// Request/Response models
+
+type GrantRoleRequest struct {
+	Role   string `json:"role" validate:"required,max=50"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type AuditLogQuery struct {
+	UserID *uuid.UUID
+	Action string
+	Limit  int
+	Offset int
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Security event types
+const (
+	SecurityEventLogin              = "login"
+	SecurityEventPasswordChange     = "password.change"
+	SecurityEventPasswordReset      = "password.reset"
+	SecurityEventTOTPEnable         = "mfa.totp_enable"
+	SecurityEventTOTPDisable        = "mfa.totp_disable"
+	SecurityEventRecoveryCodesRenew = "mfa.recovery_codes_regenerate"
+	SecurityEventPasskeyAdd         = "mfa.passkey_add"
+	SecurityEventPasskeyRemove      = "mfa.passkey_remove"
+	SecurityEventSessionRevoke      = "session.revoke"
+	SecurityEventAllSessionsRevoke  = "session.revoke_all"
+)
+
+// SecurityEvent records a sign-in to the user's account or a change to how
+// they sign in. The client is unknown for events that don't come from a
+// request of the user.
+type SecurityEvent struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"-" db:"user_id"`
+	EventType string    `json:"event_type" db:"event_type"`
+	IPAddress string    `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent string    `json:"user_agent,omitempty" db:"user_agent"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
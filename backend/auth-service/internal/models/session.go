@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	// ClientID and Scope are set for sessions of third-party OAuth apps
+	ClientID   *string    `json:"client_id,omitempty" db:"client_id"`
+	Scope      string     `json:"scope,omitempty" db:"scope"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"-" db:"revoked_at"`
+	LastSeenAt time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	Current    bool       `json:"current" db:"-"`
+}
+
+// Active reports whether the session can still be used
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// OAuthClientID returns the third-party app of the session, empty for the first-party app
+func (s *Session) OAuthClientID() string {
+	if s.ClientID == nil {
+		return ""
+	}
+	return *s.ClientID
+}
@@ -9,29 +9,45 @@ import (
 )
 
 type User struct {
-	ID              uuid.UUID  `json:"id" db:"id"`
-	Username        string     `json:"username" db:"username" validate:"required,min=3,max=50"`
-	DisplayName     string     `json:"display_name" db:"display_name" validate:"required,min=1,max=100"`
-	Email           string     `json:"email" db:"email" validate:"required,email"`
-	PasswordHash    string     `json:"-" db:"password_hash"`
-	Bio             *string    `json:"bio" db:"bio"`
-	ProfileImageURL *string    `json:"profile_image_url" db:"profile_image_url"`
-	OAuthProviders  *OAuthData `json:"oauth_providers,omitempty" db:"oauth_providers"`
-	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	ID                uuid.UUID  `json:"id" db:"id"`
+	Username          string     `json:"username" db:"username" validate:"required,min=3,max=50"`
+	DisplayName       string     `json:"display_name" db:"display_name" validate:"required,min=1,max=100"`
+	Email             string     `json:"email" db:"email" validate:"required,email"`
+	PasswordHash      string     `json:"-" db:"password_hash"`
+	Bio               *string    `json:"bio" db:"bio"`
+	ProfileImageURL   *string    `json:"profile_image_url" db:"profile_image_url"`
+	OAuthProviders    OAuthData  `json:"oauth_providers,omitempty" db:"oauth_providers"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	CredentialVersion int        `json:"-" db:"credential_version"`
+	SuspendedAt       *time.Time `json:"-" db:"suspended_at"`
+	SuspendedUntil    *time.Time `json:"-" db:"suspended_until"`
+	SuspensionReason  *string    `json:"-" db:"suspension_reason"`
+	SuspendedBy       *uuid.UUID `json:"-" db:"suspended_by"`
+	DeletedAt         *time.Time `json:"-" db:"deleted_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
 }
 
-type OAuthData struct {
-	Google   *OAuthUserData `json:"google,omitempty"`
-	Facebook *OAuthUserData `json:"facebook,omitempty"`
+// Suspended reports whether staff suspended the account and the suspension
+// hasn't run out
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil && (u.SuspendedUntil == nil || time.Now().Before(*u.SuspendedUntil))
 }
 
+// OAuthData holds the linked identity of each OAuth provider, keyed by provider name
+type OAuthData map[string]*OAuthUserData
+
 type OAuthUserData struct {
 	ID    string `json:"id"`
 	Email string `json:"email"`
+	// PrivateEmail marks relay addresses such as Apple's "Hide My Email"
+	PrivateEmail bool `json:"private_email,omitempty"`
 }
 
 // Implement driver.Valuer interface for OAuthData
 func (o OAuthData) Value() (driver.Value, error) {
+	if o == nil {
+		return nil, nil
+	}
 	return json.Marshal(o)
 }
 
@@ -51,10 +67,10 @@ func (o *OAuthData) Scan(value interface{}) error {
 
 // Request/Response models
 type RegisterRequest struct {
-	Username    string `json:"username" validate:"required,min=3,max=50"`
+	Username    string `json:"username" validate:"required,min=3,max=30,username"`
 	DisplayName string `json:"display_name" validate:"required,min=1,max=100"`
 	Email       string `json:"email" validate:"required,email"`
-	Password    string `json:"password" validate:"required,min=6"`
+	Password    string `json:"password" validate:"required"`
 }
 
 type LoginRequest struct {
@@ -68,17 +84,146 @@ type UpdateProfileRequest struct {
 	ProfileImageURL *string `json:"profile_image_url,omitempty"`
 }
 
+// ChangeUsernameRequest changes the current user's username
+type ChangeUsernameRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=30,username"`
+}
+
+// UsernameChange is an entry of a user's username history
+type UsernameChange struct {
+	ID            uuid.UUID `json:"id" db:"id"`
+	UserID        uuid.UUID `json:"-" db:"user_id"`
+	OldUsername   string    `json:"old_username" db:"old_username"`
+	NewUsername   string    `json:"new_username" db:"new_username"`
+	ChangedAt     time.Time `json:"changed_at" db:"changed_at"`
+	ReservedUntil time.Time `json:"reserved_until" db:"reserved_until"`
+}
+
+type ChangeUsernameResponse struct {
+	User *User `json:"user"`
+	// NextChangeAt is when the username can be changed again
+	NextChangeAt time.Time `json:"next_change_at"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password,omitempty"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// DeleteAccountRequest confirms the deletion with the password. Users without
+// a password must have signed in recently instead.
+type DeleteAccountRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+type AccountDeletionResponse struct {
+	DeletedAt time.Time `json:"deleted_at"`
+	// PurgeAfter is when the account is deleted permanently unless the user
+	// signs in before
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ClientInfo describes where a request came from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
 type AuthResponse struct {
-	User        *User  `json:"user"`
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int64  `json:"expires_in"`
+	User             *User  `json:"user"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	// RedirectURL is where an OAuth login asked to return to
+	RedirectURL string `json:"redirect_url,omitempty"`
+	// Scope is set for tokens issued to third-party OAuth apps
+	Scope string `json:"scope,omitempty"`
+	// AccountRestored reports that signing in cancelled a pending deletion
+	AccountRestored bool `json:"account_restored,omitempty"`
 }
 
 type OAuthUserInfo struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Picture  string `json:"picture"`
-	Provider string `json:"provider"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	Provider      string `json:"provider"`
+	PrivateEmail  bool   `json:"private_email"`
+}
+
+// OAuthCallbackRequest holds the parameters a provider sends to the callback,
+// in the query string or, for Apple, as a form POST
+type OAuthCallbackRequest struct {
+	Code  string `json:"code" form:"code"`
+	State string `json:"state" form:"state"`
+	// User is the JSON name and email Apple sends with the first authorization
+	User string `json:"user" form:"user"`
+	// Binding is the state-binding cookie of the browser, set when the flow
+	// started
+	Binding string `json:"-" form:"-"`
+}
+
+// OAuthLinkRequest links a provider to the current user. With a link token
+// it confirms a link offered by an OAuth login, without one it starts an
+// OAuth flow whose callback links the identity.
+type OAuthLinkRequest struct {
+	LinkToken string `json:"link_token"`
+	Redirect  string `json:"redirect"`
+}
+
+// OAuthLinkRequiredResponse is returned when an OAuth login matches an
+// existing account by an email the provider didn't verify. The owner confirms
+// by signing in and presenting the link token.
+type OAuthLinkRequiredResponse struct {
+	LinkToken string `json:"link_token"`
+	Provider  string `json:"provider"`
+	Email     string `json:"email"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// OAuthCallbackResult is the outcome of an OAuth callback, exactly one field is set
+type OAuthCallbackResult struct {
+	Auth         *AuthResponse
+	Challenge    *MFAChallengeResponse
+	LinkRequired *OAuthLinkRequiredResponse
+	Linked       *User
+	// HandOffCode replaces Auth, Challenge and LinkRequired in redirect mode
+	HandOffCode string
+	// LinkPending marks a HandOffCode that trades for a link request
+	LinkPending bool
+}
+
+// OAuthExchangeRequest trades the code of a redirect mode OAuth callback
+type OAuthExchangeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// OAuthProviderInfo describes a sign-in option for the frontend
+type OAuthProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
 }
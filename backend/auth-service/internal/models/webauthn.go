@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MFAMethodWebAuthn is offered in a login challenge when the user has a passkey
+const MFAMethodWebAuthn = "webauthn"
+
+// WebAuthn ceremonies tracked in webauthn_sessions
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+	WebAuthnCeremonyMFA          = "mfa"
+)
+
+type WebAuthnCredential struct {
+	ID              uuid.UUID      `json:"id" db:"id"`
+	UserID          uuid.UUID      `json:"user_id" db:"user_id"`
+	CredentialID    []byte         `json:"-" db:"credential_id"`
+	PublicKey       []byte         `json:"-" db:"public_key"`
+	AttestationType string         `json:"-" db:"attestation_type"`
+	AAGUID          []byte         `json:"-" db:"aaguid"`
+	SignCount       int64          `json:"-" db:"sign_count"`
+	Transports      pq.StringArray `json:"transports" db:"transports"`
+	BackupEligible  bool           `json:"backup_eligible" db:"backup_eligible"`
+	BackupState     bool           `json:"backup_state" db:"backup_state"`
+	CloneWarning    bool           `json:"clone_warning" db:"clone_warning"`
+	Name            string         `json:"name" db:"name"`
+	LastUsedAt      *time.Time     `json:"last_used_at" db:"last_used_at"`
+	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
+}
+
+type WebAuthnSession struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      *uuid.UUID `json:"user_id" db:"user_id"`
+	Ceremony    string     `json:"ceremony" db:"ceremony"`
+	SessionHash string     `json:"-" db:"session_hash"`
+	Data        []byte     `json:"-" db:"data"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Request/Response models
+
+// WebAuthnFinishRequest carries the browser's PublicKeyCredential untouched so
+// the WebAuthn library can parse it
+type WebAuthnFinishRequest struct {
+	SessionToken string          `json:"session_token" validate:"required"`
+	Credential   json.RawMessage `json:"credential" validate:"required"`
+	Name         string          `json:"name,omitempty" validate:"omitempty,max=100"`
+}
+
+type WebAuthnMFABeginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+type WebAuthnMFAFinishRequest struct {
+	MFAToken     string          `json:"mfa_token" validate:"required"`
+	SessionToken string          `json:"session_token" validate:"required"`
+	Credential   json.RawMessage `json:"credential" validate:"required"`
+}
+
+// WebAuthnBeginResponse holds the options for navigator.credentials.create or
+// navigator.credentials.get, and the token that ties the finish call to them
+type WebAuthnBeginResponse struct {
+	SessionToken string      `json:"session_token"`
+	ExpiresIn    int64       `json:"expires_in"`
+	Options      interface{} `json:"options"`
+}
@@ -0,0 +1,78 @@
+package oauthstate
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresStore shares pending OAuth flows between replicas
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+const (
+	saveStateQuery = `
+		INSERT INTO oauth_states (state_hash, provider, code_verifier, nonce, redirect_url, link_user_id, binding_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	consumeStateQuery = `
+		DELETE FROM oauth_states
+		WHERE state_hash = $1 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING provider, code_verifier, nonce, redirect_url, link_user_id, binding_hash, expires_at`
+
+	purgeExpiredStatesQuery = `DELETE FROM oauth_states WHERE expires_at <= CURRENT_TIMESTAMP`
+)
+
+func (s *PostgresStore) Save(state string, data *State) error {
+	_, err := s.db.Exec(
+		saveStateQuery,
+		utils.HashToken(state),
+		data.Provider,
+		data.CodeVerifier,
+		data.Nonce,
+		data.RedirectURL,
+		data.LinkUserID,
+		data.BindingHash,
+		data.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save oauth state: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Consume(state string) (*State, error) {
+	var data State
+
+	err := s.db.QueryRow(consumeStateQuery, utils.HashToken(state)).Scan(
+		&data.Provider,
+		&data.CodeVerifier,
+		&data.Nonce,
+		&data.RedirectURL,
+		&data.LinkUserID,
+		&data.BindingHash,
+		&data.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume oauth state: %w", err)
+	}
+
+	return &data, nil
+}
+
+func (s *PostgresStore) PurgeExpired() error {
+	if _, err := s.db.Exec(purgeExpiredStatesQuery); err != nil {
+		return fmt.Errorf("failed to purge expired oauth states: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package oauthstate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var stateColumns = []string{"provider", "code_verifier", "nonce", "redirect_url", "link_user_id", "binding_hash", "expires_at"}
+
+func newTestStore(t *testing.T) (*PostgresStore, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet database expectations: %v", err)
+		}
+		db.Close()
+	})
+
+	return NewPostgresStore(sqlx.NewDb(db, "postgres")), mock
+}
+
+// Only the hash of the state is stored, a database dump can't be used to
+// finish someone's login
+func TestPostgresStoreSave(t *testing.T) {
+	store, mock := newTestStore(t)
+	linkUserID := uuid.New()
+	data := &State{
+		Provider:     "google",
+		CodeVerifier: "verifier",
+		Nonce:        "nonce",
+		RedirectURL:  "/feed",
+		LinkUserID:   &linkUserID,
+		BindingHash:  utils.HashToken("binding"),
+		ExpiresAt:    time.Now().Add(10 * time.Minute),
+	}
+
+	mock.ExpectExec(`INSERT INTO oauth_states`).
+		WithArgs(utils.HashToken("state"), "google", "verifier", "nonce", "/feed", &linkUserID, data.BindingHash, data.ExpiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.Save("state", data); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+}
+
+func TestPostgresStoreConsume(t *testing.T) {
+	store, mock := newTestStore(t)
+	expiresAt := time.Now().Add(5 * time.Minute)
+
+	mock.ExpectQuery(`DELETE FROM oauth_states\s+WHERE state_hash = \$1 AND expires_at > CURRENT_TIMESTAMP`).
+		WithArgs(utils.HashToken("state")).
+		WillReturnRows(sqlmock.NewRows(stateColumns).
+			AddRow("google", "verifier", "nonce", "/feed", nil, utils.HashToken("binding"), expiresAt))
+
+	data, err := store.Consume("state")
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if data == nil || data.Provider != "google" || data.CodeVerifier != "verifier" || data.Nonce != "nonce" || data.LinkUserID != nil {
+		t.Fatalf("Consume = %+v, want the saved google flow", data)
+	}
+
+	// The row is gone, the same state can't complete a second callback
+	mock.ExpectQuery(`DELETE FROM oauth_states`).
+		WithArgs(utils.HashToken("state")).
+		WillReturnRows(sqlmock.NewRows(stateColumns))
+
+	data, err = store.Consume("state")
+	if err != nil {
+		t.Fatalf("second Consume: %v", err)
+	}
+	if data != nil {
+		t.Fatalf("second Consume = %+v, want nil", data)
+	}
+}
@@ -0,0 +1,76 @@
+package oauthstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "auth:oauth_state:"
+
+// RedisStore shares pending OAuth flows between replicas. Redis expires the
+// keys on its own.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) Save(state string, data *State) error {
+	ttl := time.Until(data.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode oauth state: %w", err)
+	}
+
+	if err := s.client.Set(context.Background(), redisKeyPrefix+utils.HashToken(state), value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save oauth state: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Consume(state string) (*State, error) {
+	// GETDEL makes sure two callbacks racing with the same state can't both get it
+	value, err := s.client.GetDel(context.Background(), redisKeyPrefix+utils.HashToken(state)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume oauth state: %w", err)
+	}
+
+	var data State
+	if err := json.Unmarshal(value, &data); err != nil {
+		return nil, fmt.Errorf("invalid oauth state: %w", err)
+	}
+	if time.Now().After(data.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &data, nil
+}
+
+func (s *RedisStore) PurgeExpired() error {
+	return nil
+}
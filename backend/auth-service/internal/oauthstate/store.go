@@ -0,0 +1,40 @@
+package oauthstate
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// State is what the auth-service remembers between sending a user to an
+// OAuth provider and the provider's callback
+type State struct {
+	Provider string `json:"provider"`
+	// CodeVerifier is the PKCE secret whose challenge went out with the
+	// authorization request
+	CodeVerifier string `json:"code_verifier"`
+	// Nonce must come back in the OIDC ID token
+	Nonce string `json:"nonce"`
+	// RedirectURL is the validated page to send the user to after login
+	RedirectURL string `json:"redirect_url"`
+	// LinkUserID is set when the flow links the identity to a signed in user
+	// instead of signing in
+	LinkUserID *uuid.UUID `json:"link_user_id,omitempty"`
+	// BindingHash is the SHA-256 of the cookie set in the browser that
+	// started the flow, the callback must come with it
+	BindingHash string    `json:"binding_hash"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Store keeps pending OAuth flows. Each state can be consumed only once.
+type Store interface {
+	// Save remembers the flow under the state parameter sent to the provider
+	Save(state string, data *State) error
+
+	// Consume removes and returns the flow of the state parameter. It returns
+	// nil if the state is unknown, already used or expired.
+	Consume(state string) (*State, error)
+
+	// PurgeExpired removes flows that were never completed
+	PurgeExpired() error
+}
@@ -0,0 +1,79 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is how many hex characters of the SHA-1 hash name a range file
+const prefixLength = 5
+
+// BreachCorpus looks passwords up in a local copy of a breached password
+// corpus, laid out like the Have I Been Pwned range API: the directory holds
+// one file per 5 character SHA-1 prefix, e.g. 5BAA6.txt, whose lines are the
+// remaining 35 characters of a hash and how often it was seen, as in
+// 1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493. Only the file of the
+// password's prefix is read, so it works offline and without loading the
+// whole corpus.
+type BreachCorpus struct {
+	dir string
+}
+
+// NewBreachCorpus opens the corpus in dir. It returns nil if dir is empty,
+// which disables the check, and an error if dir can't be read, so a
+// misconfigured corpus stops the service instead of letting breached
+// passwords through.
+func NewBreachCorpus(dir string) (*BreachCorpus, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password corpus %s is not a directory", dir)
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to read breached password corpus: %w", err)
+	}
+
+	return &BreachCorpus{dir: dir}, nil
+}
+
+// Contains reports whether the password appears in the corpus. Prefixes
+// without a file count as not breached, so a partial corpus still works.
+func (c *BreachCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	file, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open breached password range: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padding entries of the range API have a count of 0
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password range: %w", err)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// "password" hashes to 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+func writeCorpus(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	ranges := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n"
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(ranges), 0o600); err != nil {
+		t.Fatalf("write range: %v", err)
+	}
+	return dir
+}
+
+func TestNewBreachCorpus(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", "", true, false},
+		{"directory", writeCorpus(t), false, false},
+		{"missing directory", filepath.Join(t.TempDir(), "missing"), true, true},
+		{"not a directory", file, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpus, err := NewBreachCorpus(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewBreachCorpus error = %v, want error %v", err, tt.wantErr)
+			}
+			if (corpus == nil) != tt.wantNil {
+				t.Errorf("NewBreachCorpus = %v, want nil %v", corpus, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestBreachCorpusContains(t *testing.T) {
+	corpus, err := NewBreachCorpus(writeCorpus(t))
+	if err != nil {
+		t.Fatalf("NewBreachCorpus: %v", err)
+	}
+
+	tests := []struct {
+		password string
+		breached bool
+	}{
+		{"password", true},
+		{"correct horse battery staple", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			breached, err := corpus.Contains(tt.password)
+			if err != nil {
+				t.Fatalf("Contains: %v", err)
+			}
+			if breached != tt.breached {
+				t.Errorf("Contains = %v, want %v", breached, tt.breached)
+			}
+		})
+	}
+}
+
+func TestPolicyRejectsWhenCorpusUnreadable(t *testing.T) {
+	dir := writeCorpus(t)
+	corpus, err := NewBreachCorpus(dir)
+	if err != nil {
+		t.Fatalf("NewBreachCorpus: %v", err)
+	}
+	// A directory where the range file should be can't be read
+	if err := os.Mkdir(filepath.Join(dir, "87457.txt"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	policy := NewPolicy(8, 0, corpus)
+	// "Tr0ub4dor&3" hashes to 874572E7...
+	err = policy.Check("Tr0ub4dor&3", PersonalInfo{})
+	if err == nil {
+		t.Fatal("Check accepted a password it couldn't screen")
+	}
+	if _, ok := err.(*Violation); ok {
+		t.Errorf("Check = %v, want a read error", err)
+	}
+}
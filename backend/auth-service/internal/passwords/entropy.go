@@ -0,0 +1,102 @@
+package passwords
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// keyboardRows are walked in either direction by passwords like qwerty or 0987
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// patternBits is what a character continuing a repeat, sequence or keyboard
+// walk adds, instead of the full size of the character pool
+const patternBits = 1
+
+// Entropy estimates the strength of the password in bits. Every character is
+// worth log2 of the pool its character classes span, except those that merely
+// continue a pattern: a repeat (aaaa), an alphabetic or numeric sequence
+// (abcd, 4321) or a walk along a keyboard row (qwerty).
+func Entropy(password string) float64 {
+	runes := []rune(strings.ToLower(password))
+	if len(runes) == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(poolSize(password)))
+
+	var bits float64
+	for i := range runes {
+		if continuesPattern(runes, i) {
+			bits += patternBits
+		} else {
+			bits += bitsPerChar
+		}
+	}
+	return bits
+}
+
+// poolSize is the number of characters an attacker has to try per position
+// given the character classes the password uses
+func poolSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return size
+}
+
+// continuesPattern reports whether the character at i repeats the previous
+// one or extends a sequence or keyboard walk of the two before it
+func continuesPattern(runes []rune, i int) bool {
+	if i == 0 {
+		return false
+	}
+	if runes[i] == runes[i-1] {
+		return true
+	}
+	if i < 2 {
+		return false
+	}
+
+	step := runes[i] - runes[i-1]
+	if (step == 1 || step == -1) && runes[i-1]-runes[i-2] == step {
+		return true
+	}
+
+	for _, row := range keyboardRows {
+		a, b, c := strings.IndexRune(row, runes[i-2]), strings.IndexRune(row, runes[i-1]), strings.IndexRune(row, runes[i])
+		if a >= 0 && b >= 0 && c >= 0 && (c-b == 1 || c-b == -1) && b-a == c-b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package passwords
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	lowerBits := math.Log2(26)
+
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"a", lowerBits},
+		// Only the first character of a pattern counts in full, the
+		// second one of a sequence or walk too as it sets the direction
+		{"aaaa", lowerBits + 3*patternBits},
+		{"abcd", 2*lowerBits + 2*patternBits},
+		{"dcba", 2*lowerBits + 2*patternBits},
+		{"qwer", 2*lowerBits + 2*patternBits},
+		{"axqz", 4 * lowerBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := Entropy(tt.password); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Entropy(%q) = %.2f, want %.2f", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+// Patterns score below the default minimum of 35 bits however long they
+// are, passphrases and mixed passwords above it
+func TestEntropyRanksPasswords(t *testing.T) {
+	const minEntropy = 35
+
+	weak := []string{"qwertyuiop", "QWERTYUIOP", "1234567890", "0987654321", "abcdefghijkl", "aaaaaaaaaaaa", "asdfghjkl"}
+	for _, password := range weak {
+		if bits := Entropy(password); bits >= minEntropy {
+			t.Errorf("Entropy(%q) = %.1f, want below %d", password, bits, minEntropy)
+		}
+	}
+
+	strong := []string{"correct horse battery staple", "purple monkey dishwasher", "Tr0ub4dor&3", "gh7#Kp2!x"}
+	for _, password := range strong {
+		if bits := Entropy(password); bits < minEntropy {
+			t.Errorf("Entropy(%q) = %.1f, want at least %d", password, bits, minEntropy)
+		}
+	}
+}
+
+func TestContinuesPattern(t *testing.T) {
+	tests := []struct {
+		password string
+		i        int
+		want     bool
+	}{
+		{"a", 0, false},
+		{"aa", 1, true},
+		{"ab", 1, false},
+		{"abc", 2, true},
+		{"cba", 2, true},
+		{"abd", 2, false},
+		{"aba", 2, false},
+		{"123", 2, true},
+		{"321", 2, true},
+		{"qwe", 2, true},
+		{"ewq", 2, true},
+		{"zxc", 2, true},
+		{"poi", 2, true},
+		// Keyboard neighbours across rows don't make a walk
+		{"qaz", 2, false},
+		{"qwr", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := continuesPattern([]rune(tt.password), tt.i); got != tt.want {
+				t.Errorf("continuesPattern(%q, %d) = %v, want %v", tt.password, tt.i, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxLength is the longest password bcrypt hashes in full
+const MaxLength = 72
+
+// Violation explains why a password was rejected
+type Violation struct {
+	// Reason is one of too_short, too_long, personal_info, breached and weak
+	Reason  string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return "password rejected: " + v.Reason
+}
+
+// PersonalInfo is what a password must not contain
+type PersonalInfo struct {
+	Username string
+	Email    string
+}
+
+// Policy decides which passwords are acceptable
+type Policy struct {
+	minLength  int
+	minEntropy float64
+	// breaches is nil when no corpus is configured
+	breaches *BreachCorpus
+}
+
+func NewPolicy(minLength int, minEntropy float64, breaches *BreachCorpus) *Policy {
+	return &Policy{
+		minLength:  minLength,
+		minEntropy: minEntropy,
+		breaches:   breaches,
+	}
+}
+
+// Check returns a Violation if the password is too short or long, contains
+// the user's username or email, appears in the breach corpus or is too easy
+// to guess. Any other error means the breach corpus couldn't be read, the
+// password is then rejected rather than let through unscreened.
+func (p *Policy) Check(password string, info PersonalInfo) error {
+	if len([]rune(password)) < p.minLength {
+		return &Violation{"too_short", fmt.Sprintf("Password must be at least %d characters", p.minLength)}
+	}
+	if len(password) > MaxLength {
+		return &Violation{"too_long", fmt.Sprintf("Password must not exceed %d bytes", MaxLength)}
+	}
+
+	if containsPersonalInfo(password, info) {
+		return &Violation{"personal_info", "Password must not contain your username or email"}
+	}
+
+	if p.breaches != nil {
+		breached, err := p.breaches.Contains(password)
+		if err != nil {
+			return fmt.Errorf("failed to check password against breach corpus: %w", err)
+		}
+		if breached {
+			return &Violation{"breached", "This password has appeared in a data breach, please choose another one"}
+		}
+	}
+
+	if Entropy(password) < p.minEntropy {
+		return &Violation{"weak", "Password is too easy to guess, use a longer passphrase or mix letters, numbers and symbols"}
+	}
+
+	return nil
+}
+
+// containsPersonalInfo reports whether the password contains the username,
+// the email or its local part, ignoring case and parts too short to matter
+func containsPersonalInfo(password string, info PersonalInfo) bool {
+	password = strings.ToLower(password)
+
+	parts := []string{info.Username, info.Email}
+	if at := strings.LastIndex(info.Email, "@"); at > 0 {
+		parts = append(parts, info.Email[:at])
+	}
+
+	for _, part := range parts {
+		if part = strings.ToLower(part); len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
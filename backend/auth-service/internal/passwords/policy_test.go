@@ -0,0 +1,71 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestContainsPersonalInfo(t *testing.T) {
+	info := PersonalInfo{Username: "wonderland", Email: "alice.smith@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		info     PersonalInfo
+		want     bool
+	}{
+		{name: "unrelated", password: "correct horse battery staple", info: info},
+		{name: "username", password: "i love wonderland!", info: info, want: true},
+		{name: "username in another case", password: "WonderLand2024", info: info, want: true},
+		{name: "email", password: "alice.smith@example.com1", info: info, want: true},
+		{name: "email local part", password: "Alice.Smith-rocks", info: info, want: true},
+		{name: "part of the local part", password: "alice rocks", info: info},
+		{name: "short username", password: "al is my best friend", info: PersonalInfo{Username: "al"}},
+		{name: "no email", password: "correct horse battery staple", info: PersonalInfo{Username: "wonderland"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsPersonalInfo(tt.password, tt.info); got != tt.want {
+				t.Errorf("containsPersonalInfo(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := NewPolicy(8, 35, nil)
+	info := PersonalInfo{Username: "wonderland", Email: "alice.smith@example.com"}
+
+	tests := []struct {
+		password   string
+		wantReason string
+	}{
+		{password: "correct horse battery staple"},
+		{password: "Tr0ub4dor&3"},
+		{password: "gh7#Kp", wantReason: "too_short"},
+		{password: strings.Repeat("correct horse ", 6), wantReason: "too_long"},
+		{password: "wonderland is my passphrase", wantReason: "personal_info"},
+		{password: "alice.smith was here 2024", wantReason: "personal_info"},
+		{password: "qwertyuiop", wantReason: "weak"},
+		{password: "abcdefghijklmnop", wantReason: "weak"},
+		{password: "aaaaaaaaaaaaaaaa", wantReason: "weak"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			err := policy.Check(tt.password, info)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("Check = %v, want accepted", err)
+				}
+				return
+			}
+			var violation *Violation
+			if !errors.As(err, &violation) || violation.Reason != tt.wantReason {
+				t.Errorf("Check = %v, want %s", err, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-process Store, suitable for tests and single instance development
+type MemoryStore struct {
+	mu            sync.RWMutex
+	tokens        map[string]time.Time
+	revokedBefore map[uuid.UUID]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:        make(map[string]time.Time),
+		revokedBefore: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (s *MemoryStore) RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) RevokeAllForUser(userID uuid.UUID, issuedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	issuedBefore = issuedBefore.Truncate(time.Microsecond)
+	if current, exists := s.revokedBefore[userID]; !exists || issuedBefore.After(current) {
+		s.revokedBefore[userID] = issuedBefore
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.tokens[jti]; exists {
+		return true, nil
+	}
+
+	if before, exists := s.revokedBefore[userID]; exists && !issuedAt.Truncate(time.Microsecond).After(before) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) PurgeExpired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for jti, expiresAt := range s.tokens {
+		if now.After(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryStoreRevokeAllForUser(t *testing.T) {
+	cutoff := time.Date(2026, 1, 2, 3, 4, 5, 678901234, time.UTC)
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{"issued well before the cutoff", cutoff.Add(-time.Hour), true},
+		{"issued earlier in the same second", cutoff.Add(-500 * time.Millisecond), true},
+		{"issued at the cutoff", cutoff, true},
+		{"issued in the same microsecond", cutoff.Add(-234 * time.Nanosecond), true},
+		{"issued the next microsecond", cutoff.Truncate(time.Microsecond).Add(time.Microsecond), false},
+		{"issued later in the same second", cutoff.Add(100 * time.Millisecond), false},
+		{"issued after the cutoff", cutoff.Add(time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			userID := uuid.New()
+			if err := store.RevokeAllForUser(userID, cutoff); err != nil {
+				t.Fatalf("RevokeAllForUser: %v", err)
+			}
+
+			revoked, err := store.IsRevoked(uuid.NewString(), userID, tt.issuedAt)
+			if err != nil {
+				t.Fatalf("IsRevoked: %v", err)
+			}
+			if revoked != tt.revoked {
+				t.Errorf("IsRevoked = %v, want %v", revoked, tt.revoked)
+			}
+
+			// Other users are unaffected
+			revoked, err = store.IsRevoked(uuid.NewString(), uuid.New(), tt.issuedAt)
+			if err != nil {
+				t.Fatalf("IsRevoked: %v", err)
+			}
+			if revoked {
+				t.Error("token of another user is revoked")
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeepsLatestCutoff(t *testing.T) {
+	store := NewMemoryStore()
+	userID := uuid.New()
+	now := time.Now()
+
+	if err := store.RevokeAllForUser(userID, now); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+	// An older cutoff arriving late must not reinstate tokens
+	if err := store.RevokeAllForUser(userID, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+
+	revoked, err := store.IsRevoked(uuid.NewString(), userID, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("IsRevoked: %v", err)
+	}
+	if !revoked {
+		t.Error("token issued before the latest cutoff is not revoked")
+	}
+}
+
+func TestMemoryStoreRevokeToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		purged    bool
+	}{
+		{"unexpired token stays revoked", time.Now().Add(time.Hour), false},
+		{"expired token is purged", time.Now().Add(-time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			userID := uuid.New()
+			jti := uuid.NewString()
+
+			if err := store.RevokeToken(jti, userID, tt.expiresAt); err != nil {
+				t.Fatalf("RevokeToken: %v", err)
+			}
+
+			revoked, err := store.IsRevoked(jti, userID, time.Now())
+			if err != nil {
+				t.Fatalf("IsRevoked: %v", err)
+			}
+			if !revoked {
+				t.Fatal("revoked token is accepted")
+			}
+
+			if err := store.PurgeExpired(); err != nil {
+				t.Fatalf("PurgeExpired: %v", err)
+			}
+			if _, exists := store.tokens[jti]; exists == tt.purged {
+				t.Errorf("token kept after purge = %v, want %v", exists, !tt.purged)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package revocation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresStore persists revocations so they are shared between replicas and survive restarts
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+const (
+	revokeTokenQuery = `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`
+
+	revokeAllForUserQuery = `
+		INSERT INTO user_token_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before)`
+
+	isRevokedQuery = `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS(SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND revoked_before >= $3)`
+
+	purgeExpiredQuery = `DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`
+)
+
+func (s *PostgresStore) RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error {
+	if _, err := s.db.Exec(revokeTokenQuery, jti, userID, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) RevokeAllForUser(userID uuid.UUID, issuedBefore time.Time) error {
+	// Postgres would round to the nearest microsecond, possibly past tokens
+	// issued right after the cutoff
+	if _, err := s.db.Exec(revokeAllForUserQuery, userID, issuedBefore.Truncate(time.Microsecond)); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	if err := s.db.QueryRow(isRevokedQuery, jti, userID, issuedAt.Truncate(time.Microsecond)).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (s *PostgresStore) PurgeExpired() error {
+	if _, err := s.db.Exec(purgeExpiredQuery); err != nil {
+		return fmt.Errorf("failed to purge expired revocations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package revocation
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store keeps track of access tokens that must be rejected before they expire.
+// Revocation cutoffs and issue times are compared with microsecond precision,
+// the precision Postgres stores timestamps with, so a token issued right after
+// a user wide revocation stays valid.
+type Store interface {
+	// RevokeToken rejects a single token, identified by its jti, until it expires
+	RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error
+
+	// RevokeAllForUser rejects every token of the user issued at or before the given time
+	RevokeAllForUser(userID uuid.UUID, issuedBefore time.Time) error
+
+	// IsRevoked reports whether a token was revoked individually or by a user wide revocation
+	IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+
+	// PurgeExpired removes revocations of tokens that have expired anyway
+	PurgeExpired() error
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+)
+
+// purgeBatchSize bounds the accounts purged per PurgeDeletedAccounts call
+const purgeBatchSize = 100
+
+// AccountService deletes accounts. A deleted account is kept for a grace
+// period in which signing in restores it, then purged and announced to the
+// other services with a user.deleted event.
+type AccountService struct {
+	userRepo    *database.UserRepository
+	authService *AuthService
+	mailer      mailer.Mailer
+	gracePeriod time.Duration
+}
+
+func NewAccountService(
+	userRepo *database.UserRepository,
+	authService *AuthService,
+	mailer mailer.Mailer,
+	gracePeriod time.Duration,
+) *AccountService {
+	return &AccountService{
+		userRepo:    userRepo,
+		authService: authService,
+		mailer:      mailer,
+		gracePeriod: gracePeriod,
+	}
+}
+
+// DeleteAccount deletes the current user's account after confirming the
+// password, or a recent sign-in for users without one, and signs them out
+// everywhere
+func (s *AccountService) DeleteAccount(ctx context.Context, claims *utils.JWTClaims, req *models.DeleteAccountRequest) (*models.AccountDeletionResponse, error) {
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	if user.PasswordHash != "" {
+		if req.Password == "" {
+			return nil, fmt.Errorf("password is required")
+		}
+		ok, err := s.authService.confirmPassword(user, req.Password)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("password is incorrect")
+		}
+	} else if !claims.AuthenticatedWithin(recentAuthWindow) {
+		return nil, fmt.Errorf("recent authentication required")
+	}
+
+	deletedUser, err := s.userRepo.SoftDelete(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if deletedUser == nil {
+		return nil, fmt.Errorf("cannot delete last admin")
+	}
+
+	if err := s.authService.LogoutAll(user.ID); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	response := &models.AccountDeletionResponse{
+		DeletedAt:  *deletedUser.DeletedAt,
+		PurgeAfter: deletedUser.DeletedAt.Add(s.gracePeriod),
+	}
+
+	// The deletion stands even if the email can't be sent
+	if err := s.sendDeletionNotice(ctx, deletedUser, response.PurgeAfter); err != nil {
+		log.Println("Failed to send account deletion email:", err)
+	}
+
+	return response, nil
+}
+
+func (s *AccountService) sendDeletionNotice(ctx context.Context, user *models.User, purgeAfter time.Time) error {
+	return s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account @%s has been deleted and you were signed out everywhere. It will be removed permanently, together with your threads and follows, on %s.\n\nChanged your mind? Sign in before then to restore it.\n",
+			user.DisplayName,
+			user.Username,
+			purgeAfter.UTC().Format("January 2, 2006 at 15:04 UTC"),
+		),
+	})
+}
+
+// PurgeDeletedAccounts permanently deletes accounts whose grace period is
+// over and queues a user.deleted event for each. It returns how many were
+// purged.
+func (s *AccountService) PurgeDeletedAccounts() (int, error) {
+	cutoff := time.Now().Add(-s.gracePeriod)
+
+	users, err := s.userRepo.ListDeletedBefore(cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		event, err := models.NewEvent(models.EventUserDeleted, &models.UserDeletedEvent{
+			UserID:    user.ID,
+			Username:  user.Username,
+			DeletedAt: *user.DeletedAt,
+		})
+		if err != nil {
+			return purged, fmt.Errorf("failed to encode event: %w", err)
+		}
+
+		ok, err := s.userRepo.Purge(user.ID, cutoff, event)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
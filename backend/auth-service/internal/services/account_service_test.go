@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/revocation"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var deletedUserColumns = []string{"id", "username", "display_name", "email", "password_hash", "deleted_at", "created_at"}
+
+type accountTest struct {
+	service *AccountService
+	mock    sqlmock.Sqlmock
+	mailer  *recordingMailer
+	user    *models.User
+}
+
+func newAccountTest(t *testing.T, password string) *accountTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	recorder := newRecordingMailer()
+	authService := &AuthService{
+		refreshTokenRepo:  database.NewRefreshTokenRepository(db),
+		sessionRepo:       database.NewSessionRepository(db),
+		patRepo:           database.NewPersonalAccessTokenRepository(db),
+		securityEventRepo: database.NewSecurityEventRepository(db),
+		revocationStore:   revocation.NewMemoryStore(),
+		loginGuard: lockout.NewGuard(lockout.NewMemoryStore(), map[string]lockout.Policy{
+			lockout.ScopeAccount: {Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		}),
+	}
+
+	user := &models.User{ID: uuid.New(), Username: "alice", DisplayName: "Alice", Email: "alice@example.com"}
+	if password != "" {
+		hash, err := utils.HashPassword(password)
+		if err != nil {
+			t.Fatalf("HashPassword: %v", err)
+		}
+		user.PasswordHash = hash
+	}
+
+	return &accountTest{
+		service: NewAccountService(database.NewUserRepository(db), authService, recorder, 30*24*time.Hour),
+		mock:    mock,
+		mailer:  recorder,
+		user:    user,
+	}
+}
+
+func (a *accountTest) expectUser() {
+	a.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(a.user.ID).
+		WillReturnRows(sqlmock.NewRows(deletedUserColumns).
+			AddRow(a.user.ID, a.user.Username, a.user.DisplayName, a.user.Email, a.user.PasswordHash, nil, time.Now().Add(-time.Hour)))
+}
+
+// expectSoftDelete expects the deletion, refused when the user is the last
+// admin
+func (a *accountTest) expectSoftDelete(lastAdmin bool) {
+	a.mock.ExpectBegin()
+	a.mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM user_roles`).
+		WithArgs(a.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(lastAdmin))
+	if lastAdmin {
+		a.mock.ExpectQuery(`FROM user_roles ur`).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(a.user.ID))
+		a.mock.ExpectRollback()
+		return
+	}
+	a.mock.ExpectQuery(`UPDATE users SET deleted_at = CURRENT_TIMESTAMP`).
+		WithArgs(a.user.ID).
+		WillReturnRows(sqlmock.NewRows(deletedUserColumns).
+			AddRow(a.user.ID, a.user.Username, a.user.DisplayName, a.user.Email, a.user.PasswordHash, time.Now(), time.Now().Add(-time.Hour)))
+	a.mock.ExpectCommit()
+
+	a.mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WithArgs(a.user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	a.mock.ExpectExec(`UPDATE sessions SET revoked_at`).WithArgs(a.user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	a.mock.ExpectExec(`DELETE FROM personal_access_tokens WHERE user_id`).WithArgs(a.user.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	a.mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(a.user.ID, models.SecurityEventAllSessionsRevoke, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// claimsAuthenticated returns the claims of a token from a sign-in the given
+// time ago
+func claimsAuthenticated(userID uuid.UUID, ago time.Duration) *utils.JWTClaims {
+	return &utils.JWTClaims{UserID: userID, AuthTime: jwt.NewNumericDate(time.Now().Add(-ago))}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	tests := []struct {
+		name string
+		// password is the account's, empty for users without one
+		password string
+		req      models.DeleteAccountRequest
+		// signedInAgo is how long ago the token's sign-in was
+		signedInAgo time.Duration
+		lastAdmin   bool
+		wantErr     string
+	}{
+		{name: "password confirmed", password: password, req: models.DeleteAccountRequest{Password: password}, signedInAgo: time.Hour},
+		{name: "password missing", password: password, signedInAgo: time.Minute, wantErr: "password is required"},
+		{name: "wrong password", password: password, req: models.DeleteAccountRequest{Password: "guess"}, wantErr: "password is incorrect"},
+		{name: "no password, recent sign-in", signedInAgo: time.Minute},
+		{name: "no password, stale sign-in", signedInAgo: time.Hour, wantErr: "recent authentication required"},
+		{name: "last admin", password: password, req: models.DeleteAccountRequest{Password: password}, lastAdmin: true, wantErr: "cannot delete last admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAccountTest(t, tt.password)
+
+			a.expectUser()
+			if tt.wantErr == "" || tt.lastAdmin {
+				a.expectSoftDelete(tt.lastAdmin)
+			}
+
+			response, err := a.service.DeleteAccount(context.Background(), claimsAuthenticated(a.user.ID, tt.signedInAgo), &tt.req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("DeleteAccount = %v, want %s", err, tt.wantErr)
+				}
+				if a.mailer.count() != 0 {
+					t.Errorf("sent %d messages, want none", a.mailer.count())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DeleteAccount: %v", err)
+			}
+			if got := response.PurgeAfter.Sub(response.DeletedAt); got != a.service.gracePeriod {
+				t.Errorf("purge after %s, want the %s grace period", got, a.service.gracePeriod)
+			}
+			if a.mailer.count() != 1 {
+				t.Errorf("sent %d messages, want the deletion notice", a.mailer.count())
+			}
+		})
+	}
+}
+
+// Guessing the password through a stolen session locks the account like
+// failed logins do
+func TestDeleteAccountLockout(t *testing.T) {
+	const password = "correct horse battery staple"
+	a := newAccountTest(t, password)
+	claims := claimsAuthenticated(a.user.ID, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		a.expectUser()
+		if _, err := a.service.DeleteAccount(context.Background(), claims, &models.DeleteAccountRequest{Password: "guess"}); err == nil || err.Error() != "password is incorrect" {
+			t.Fatalf("attempt %d: DeleteAccount = %v, want password is incorrect", i+1, err)
+		}
+	}
+
+	a.expectUser()
+	_, err := a.service.DeleteAccount(context.Background(), claims, &models.DeleteAccountRequest{Password: password})
+	var lockedErr *lockout.LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("DeleteAccount while locked = %v, want a lockout", err)
+	}
+}
+
+// cutoffArg matches the cutoff a purge is made with and remembers the first
+// one, later ones must equal it
+type cutoffArg struct {
+	cutoff *time.Time
+}
+
+func (c *cutoffArg) Match(value driver.Value) bool {
+	cutoff, ok := value.(time.Time)
+	if !ok {
+		return false
+	}
+	if c.cutoff == nil {
+		c.cutoff = &cutoff
+		return true
+	}
+	return cutoff.Equal(*c.cutoff)
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	db, mock := newMockDB(t)
+	gracePeriod := 30 * 24 * time.Hour
+	service := NewAccountService(database.NewUserRepository(db), nil, nil, gracePeriod)
+
+	purgedID, restoredID := uuid.New(), uuid.New()
+	deletedAt := time.Now().Add(-gracePeriod - time.Hour)
+	cutoff := &cutoffArg{}
+
+	mock.ExpectQuery(`FROM users WHERE deleted_at <=`).
+		WithArgs(cutoff, purgeBatchSize).
+		WillReturnRows(sqlmock.NewRows(deletedUserColumns).
+			AddRow(purgedID, "alice", "Alice", "alice@example.com", "", deletedAt, deletedAt.Add(-time.Hour)).
+			AddRow(restoredID, "bob", "Bob", "bob@example.com", "", deletedAt, deletedAt.Add(-time.Hour)))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1 AND deleted_at <= \$2`).
+		WithArgs(purgedID, cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO event_outbox`).
+		WithArgs(sqlmock.AnyArg(), models.EventUserDeleted, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Signing in restored the account after it was listed, so the purge with
+	// the same cutoff finds nothing to delete
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1 AND deleted_at <= \$2`).
+		WithArgs(restoredID, cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	purged, err := service.PurgeDeletedAccounts()
+	if err != nil {
+		t.Fatalf("PurgeDeletedAccounts: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d accounts, want 1", purged)
+	}
+	if cutoff.cutoff == nil || time.Since(cutoff.cutoff.Add(gracePeriod)) > time.Minute {
+		t.Errorf("cutoff = %v, want the grace period ago", cutoff.cutoff)
+	}
+}
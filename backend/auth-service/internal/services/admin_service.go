@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+// Page size of the admin API's lists
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// AdminService backs the admin API. Every change it makes is written to the
+// audit log together with the admin who made it.
+type AdminService struct {
+	userRepo        *database.UserRepository
+	roleRepo        *database.RoleRepository
+	auditLogRepo    *database.AuditLogRepository
+	authService     *AuthService
+	passwordService *PasswordService
+}
+
+func NewAdminService(
+	userRepo *database.UserRepository,
+	roleRepo *database.RoleRepository,
+	auditLogRepo *database.AuditLogRepository,
+	authService *AuthService,
+	passwordService *PasswordService,
+) *AdminService {
+	return &AdminService{
+		userRepo:        userRepo,
+		roleRepo:        roleRepo,
+		auditLogRepo:    auditLogRepo,
+		authService:     authService,
+		passwordService: passwordService,
+	}
+}
+
+// ListRoles returns every role with its permissions
+func (s *AdminService) ListRoles() ([]models.Role, error) {
+	return s.roleRepo.ListRoles()
+}
+
+// ListUserRoles returns the roles granted to a user
+func (s *AdminService) ListUserRoles(userID uuid.UUID) ([]models.UserRole, error) {
+	if _, err := s.getUser(userID); err != nil {
+		return nil, err
+	}
+	return s.roleRepo.ListUserRoles(userID)
+}
+
+// GrantRole gives a user a role. The user's current access tokens are
+// rejected by their credential version, so the next refresh carries the new
+// permissions.
+func (s *AdminService) GrantRole(actorID, userID uuid.UUID, req *models.GrantRoleRequest, client *models.ClientInfo) ([]models.UserRole, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	if _, err := s.getUser(userID); err != nil {
+		return nil, err
+	}
+
+	exists, err := s.roleRepo.RoleExists(req.Role)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("role not found")
+	}
+
+	granted, err := s.roleRepo.Grant(userID, req.Role, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !granted {
+		return nil, fmt.Errorf("role already granted")
+	}
+
+	s.audit(actorID, userID, models.AuditActionRoleGrant, models.AuditDetails{
+		"role":   req.Role,
+		"reason": req.Reason,
+	}, client)
+
+	return s.roleRepo.ListUserRoles(userID)
+}
+
+// RevokeRole takes a role from a user. The last admin can't lose the admin role.
+func (s *AdminService) RevokeRole(actorID, userID uuid.UUID, role, reason string, client *models.ClientInfo) ([]models.UserRole, error) {
+	roles, err := s.ListUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasRole := false
+	for _, userRole := range roles {
+		hasRole = hasRole || userRole.Role == role
+	}
+	if !hasRole {
+		return nil, fmt.Errorf("role not granted")
+	}
+
+	revoked, err := s.roleRepo.Revoke(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		return nil, fmt.Errorf("cannot revoke last admin")
+	}
+
+	s.audit(actorID, userID, models.AuditActionRoleRevoke, models.AuditDetails{
+		"role":   role,
+		"reason": reason,
+	}, client)
+
+	return s.roleRepo.ListUserRoles(userID)
+}
+
+// AuditLog returns audit log entries, newest first
+func (s *AdminService) AuditLog(query *models.AuditLogQuery) ([]models.AuditLogEntry, error) {
+	query.Limit, query.Offset = pageBounds(query.Limit, query.Offset)
+	return s.auditLogRepo.List(query)
+}
+
+// SearchUsers finds users by ID, or by part of their email or username. An
+// empty query lists every user, newest first.
+func (s *AdminService) SearchUsers(query *models.UserSearchQuery) (*models.UserSearchResponse, error) {
+	query.Limit, query.Offset = pageBounds(query.Limit, query.Offset)
+
+	users, total, err := s.userRepo.Search(query.Query, query.Limit, query.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.UserSearchResponse{
+		Users:  make([]models.AdminUser, 0, len(users)),
+		Total:  total,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+	for i := range users {
+		response.Users = append(response.Users, *models.NewAdminUser(&users[i]))
+	}
+
+	return response, nil
+}
+
+// GetUser returns a user with their sign-in methods, roles and suspension
+func (s *AdminService) GetUser(userID uuid.UUID) (*models.AdminUser, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.adminUser(user)
+}
+
+// SuspendUser blocks a user from signing in and ends all their sessions until
+// the suspension runs out or is lifted. Suspending again replaces the reason
+// and end of the current suspension.
+func (s *AdminService) SuspendUser(actorID, userID uuid.UUID, req *models.SuspendUserRequest, client *models.ClientInfo) (*models.AdminUser, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		return nil, fmt.Errorf("suspension end in the past")
+	}
+
+	if actorID == userID {
+		return nil, fmt.Errorf("cannot suspend yourself")
+	}
+
+	user, err := s.userRepo.Suspend(userID, req.Until, req.Reason, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	details := models.AuditDetails{"reason": req.Reason}
+	if req.Until != nil {
+		details["until"] = req.Until.UTC().Format(time.RFC3339)
+	}
+	s.audit(actorID, userID, models.AuditActionUserSuspend, details, client)
+
+	if err := s.authService.LogoutAll(userID); err != nil {
+		return nil, err
+	}
+
+	return s.adminUser(user)
+}
+
+// UnsuspendUser lifts the suspension of a user
+func (s *AdminService) UnsuspendUser(actorID, userID uuid.UUID, req *models.AdminActionRequest, client *models.ClientInfo) (*models.AdminUser, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	user, err := s.userRepo.Unsuspend(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if _, err := s.getUser(userID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("user not suspended")
+	}
+
+	s.audit(actorID, userID, models.AuditActionUserUnsuspend, models.AuditDetails{
+		"reason": req.Reason,
+	}, client)
+
+	return s.adminUser(user)
+}
+
+// ForceLogout ends every session of a user and expires their access tokens
+func (s *AdminService) ForceLogout(actorID, userID uuid.UUID, req *models.AdminActionRequest, client *models.ClientInfo) error {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if _, err := s.getUser(userID); err != nil {
+		return err
+	}
+
+	if err := s.authService.LogoutAll(userID); err != nil {
+		return err
+	}
+
+	s.audit(actorID, userID, models.AuditActionUserForceLogout, models.AuditDetails{
+		"reason": req.Reason,
+	}, client)
+
+	return nil
+}
+
+// ForcePasswordReset clears a user's password, signs them out everywhere and
+// emails them a link to choose a new one
+func (s *AdminService) ForcePasswordReset(ctx context.Context, actorID, userID uuid.UUID, req *models.AdminActionRequest, client *models.ClientInfo) error {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.passwordService.ForcePasswordReset(ctx, user); err != nil {
+		return err
+	}
+
+	s.audit(actorID, userID, models.AuditActionUserPasswordReset, models.AuditDetails{
+		"reason": req.Reason,
+	}, client)
+
+	return nil
+}
+
+func (s *AdminService) adminUser(user *models.User) (*models.AdminUser, error) {
+	access, err := s.roleRepo.GetUserAccess(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	adminUser := models.NewAdminUser(user)
+	adminUser.Roles = access.Roles
+	return adminUser, nil
+}
+
+func (s *AdminService) getUser(userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// pageBounds applies the default and maximum page size
+func pageBounds(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// audit records an admin action. A failed write is logged rather than
+// undoing the action that already happened.
+func (s *AdminService) audit(actorID, targetUserID uuid.UUID, action string, details models.AuditDetails, client *models.ClientInfo) {
+	err := s.auditLogRepo.Create(&models.AuditLogEntry{
+		ActorID:      &actorID,
+		TargetUserID: &targetUserID,
+		Action:       action,
+		Details:      details,
+		IPAddress:    client.IP,
+	})
+	if err != nil {
+		log.Printf("Failed to audit %s by %s on user %s: %v", action, actorID, targetUserID, err)
+	}
+}
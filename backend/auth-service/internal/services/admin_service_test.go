@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	suspendedUserColumns = append(userColumns[:len(userColumns):len(userColumns)], "credential_version", "suspended_at", "suspended_until", "suspension_reason")
+	userRoleColumns      = []string{"user_id", "role", "granted_by", "granted_at"}
+)
+
+// auditDetails matches the JSON details of an audit log entry
+type auditDetails models.AuditDetails
+
+func (d auditDetails) Match(value driver.Value) bool {
+	data, ok := value.([]byte)
+	if !ok {
+		return false
+	}
+	var details models.AuditDetails
+	return json.Unmarshal(data, &details) == nil && maps.Equal(details, models.AuditDetails(d))
+}
+
+type adminTest struct {
+	*passwordTest
+	service *AdminService
+	actorID uuid.UUID
+	client  *models.ClientInfo
+}
+
+func newAdminTest(t *testing.T) *adminTest {
+	t.Helper()
+
+	p := newPasswordTest(t)
+	return &adminTest{
+		passwordTest: p,
+		service: NewAdminService(
+			database.NewUserRepository(p.db),
+			database.NewRoleRepository(p.db),
+			database.NewAuditLogRepository(p.db),
+			p.service.authService,
+			p.service,
+		),
+		actorID: uuid.New(),
+		client:  &models.ClientInfo{IP: "203.0.113.7"},
+	}
+}
+
+// expectAudit expects one audit log entry of the action on the user
+func (a *adminTest) expectAudit(action string, details auditDetails) {
+	a.mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(a.actorID, a.user.ID, action, details, a.client.IP).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func (a *adminTest) expectRoles(roles ...string) {
+	rows := sqlmock.NewRows(userRoleColumns)
+	for _, role := range roles {
+		rows.AddRow(a.user.ID, role, a.actorID, time.Now())
+	}
+	a.mock.ExpectQuery(`FROM user_roles WHERE user_id`).WithArgs(a.user.ID).WillReturnRows(rows)
+}
+
+func TestSuspendUser(t *testing.T) {
+	a := newAdminTest(t)
+	until := time.Now().Add(24 * time.Hour)
+	issuedBefore := time.Now().Add(-time.Minute)
+
+	// The suspension bumps the credential version in the same statement
+	a.mock.ExpectQuery(`(?s)UPDATE users SET\s+suspended_at = CURRENT_TIMESTAMP.*credential_version = credential_version \+ 1`).
+		WithArgs(a.user.ID, timeNear{until}, "spam", a.actorID).
+		WillReturnRows(sqlmock.NewRows(suspendedUserColumns).
+			AddRow(a.user.ID, a.user.Username, a.user.DisplayName, a.user.Email, "password-hash", nil, time.Now(), 4, time.Now(), until, "spam"))
+	a.expectAudit(models.AuditActionUserSuspend, auditDetails{
+		"reason": "spam",
+		"until":  until.UTC().Format(time.RFC3339),
+	})
+	a.expectLogoutAll()
+	a.expectRoles()
+
+	adminUser, err := a.service.SuspendUser(a.actorID, a.user.ID, &models.SuspendUserRequest{Reason: "spam", Until: &until}, a.client)
+	if err != nil {
+		t.Fatalf("SuspendUser: %v", err)
+	}
+	if adminUser.Suspension == nil || !adminUser.Suspension.Active || adminUser.Suspension.Reason != "spam" {
+		t.Errorf("suspension = %+v, want an active one for spam", adminUser.Suspension)
+	}
+	if adminUser.CredentialVersion != 4 {
+		t.Errorf("credential version = %d, want 4", adminUser.CredentialVersion)
+	}
+
+	revoked, err := a.revocation.IsRevoked(uuid.New().String(), a.user.ID, issuedBefore)
+	if err != nil || !revoked {
+		t.Errorf("token issued before the suspension revoked = %v, %v, want true", revoked, err)
+	}
+}
+
+func TestSuspendUserRefuses(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		self    bool
+		req     *models.SuspendUserRequest
+		wantErr string
+	}{
+		{name: "without a reason", req: &models.SuspendUserRequest{}, wantErr: "validation failed"},
+		{name: "end in the past", req: &models.SuspendUserRequest{Reason: "spam", Until: &past}, wantErr: "suspension end in the past"},
+		{name: "own account", self: true, req: &models.SuspendUserRequest{Reason: "spam"}, wantErr: "cannot suspend yourself"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdminTest(t)
+			if tt.self {
+				a.actorID = a.user.ID
+			}
+
+			_, err := a.service.SuspendUser(a.actorID, a.user.ID, tt.req, a.client)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("SuspendUser = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRejectsSuspendedUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		suspended      bool
+		suspendedUntil *time.Time
+		// version is the user's credential version, the token carries 3
+		version int
+		wantErr string
+	}{
+		{name: "active user", version: 3},
+		{name: "suspended indefinitely", suspended: true, version: 3, wantErr: "account suspended"},
+		{name: "suspended until tomorrow", suspended: true, suspendedUntil: ptr(time.Now().Add(24 * time.Hour)), version: 3, wantErr: "account suspended"},
+		{name: "suspension ran out", suspended: true, suspendedUntil: ptr(time.Now().Add(-time.Hour)), version: 3},
+		{name: "suspension lifted", version: 4, wantErr: "token has been revoked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPasswordTest(t)
+			authService := p.service.authService
+
+			token, err := authService.jwtManager.GenerateToken(utils.TokenParams{
+				UserID:            p.user.ID,
+				Username:          p.user.Username,
+				Email:             p.user.Email,
+				CredentialVersion: 3,
+			})
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+
+			var suspendedAt interface{}
+			if tt.suspended {
+				suspendedAt = time.Now().Add(-time.Hour)
+			}
+			p.mock.ExpectQuery(`FROM users WHERE id`).
+				WithArgs(p.user.ID).
+				WillReturnRows(sqlmock.NewRows(suspendedUserColumns).
+					AddRow(p.user.ID, p.user.Username, p.user.DisplayName, p.user.Email, "password-hash", nil, time.Now(),
+						tt.version, suspendedAt, tt.suspendedUntil, nil))
+
+			_, err = authService.ValidateToken(token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateToken: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ValidateToken = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// The search query is matched literally, its LIKE wildcards must not widen it
+func TestSearchUsersEscapesWildcards(t *testing.T) {
+	tests := []struct {
+		query   string
+		pattern string
+	}{
+		{query: "", pattern: "%%"},
+		{query: "alice", pattern: "%alice%"},
+		{query: "50%_off", pattern: `%50\%\_off%`},
+		{query: `back\slash`, pattern: `%back\\slash%`},
+		{query: "%", pattern: `%\%%`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			a := newAdminTest(t)
+
+			a.mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users`).
+				WithArgs(tt.query, tt.pattern).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			a.mock.ExpectQuery(`FROM users\s+WHERE \$1 = ''`).
+				WithArgs(tt.query, tt.pattern, defaultListLimit, 0).
+				WillReturnRows(sqlmock.NewRows(userColumns).
+					AddRow(a.user.ID, a.user.Username, a.user.DisplayName, a.user.Email, "password-hash", nil, time.Now()))
+
+			response, err := a.service.SearchUsers(&models.UserSearchQuery{Query: tt.query})
+			if err != nil {
+				t.Fatalf("SearchUsers: %v", err)
+			}
+			if response.Total != 1 || len(response.Users) != 1 || response.Users[0].User.PasswordHash != "" {
+				t.Errorf("response %+v, want the user without a password hash", response)
+			}
+		})
+	}
+}
+
+// Every admin action leaves exactly one audit log entry
+func TestAdminActionsAreAudited(t *testing.T) {
+	reason := &models.AdminActionRequest{Reason: "support ticket 42"}
+
+	tests := []struct {
+		name   string
+		action string
+		role   string
+		// before and after set up the database around the audit entry
+		before, after func(a *adminTest)
+		run           func(a *adminTest) error
+	}{
+		{
+			name:   "grant role",
+			action: models.AuditActionRoleGrant,
+			role:   "moderator",
+			before: func(a *adminTest) {
+				a.expectUserByID()
+				a.mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM roles`).
+					WithArgs("moderator").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				a.mock.ExpectExec(`INSERT INTO user_roles`).
+					WithArgs(a.user.ID, "moderator", a.actorID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			after: func(a *adminTest) {
+				a.expectRoles("moderator")
+			},
+			run: func(a *adminTest) error {
+				_, err := a.service.GrantRole(a.actorID, a.user.ID, &models.GrantRoleRequest{Role: "moderator", Reason: reason.Reason}, a.client)
+				return err
+			},
+		},
+		{
+			name:   "revoke role",
+			action: models.AuditActionRoleRevoke,
+			role:   "moderator",
+			before: func(a *adminTest) {
+				a.expectUserByID()
+				a.expectRoles("moderator")
+				a.mock.ExpectExec(`DELETE FROM user_roles`).
+					WithArgs(a.user.ID, "moderator").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			after: func(a *adminTest) {
+				a.expectRoles()
+			},
+			run: func(a *adminTest) error {
+				_, err := a.service.RevokeRole(a.actorID, a.user.ID, "moderator", reason.Reason, a.client)
+				return err
+			},
+		},
+		{
+			name:   "unsuspend",
+			action: models.AuditActionUserUnsuspend,
+			before: func(a *adminTest) {
+				a.mock.ExpectQuery(`UPDATE users SET suspended_at = NULL`).
+					WithArgs(a.user.ID).
+					WillReturnRows(sqlmock.NewRows(userColumns).
+						AddRow(a.user.ID, a.user.Username, a.user.DisplayName, a.user.Email, "password-hash", nil, time.Now()))
+			},
+			after: func(a *adminTest) {
+				a.expectRoles()
+			},
+			run: func(a *adminTest) error {
+				_, err := a.service.UnsuspendUser(a.actorID, a.user.ID, reason, a.client)
+				return err
+			},
+		},
+		{
+			name:   "force logout",
+			action: models.AuditActionUserForceLogout,
+			before: func(a *adminTest) {
+				a.expectUserByID()
+				a.expectLogoutAll()
+			},
+			run: func(a *adminTest) error {
+				return a.service.ForceLogout(a.actorID, a.user.ID, reason, a.client)
+			},
+		},
+		{
+			name:   "force password reset",
+			action: models.AuditActionUserPasswordReset,
+			before: func(a *adminTest) {
+				a.expectUserByID()
+				a.expectPasswordUpdate("", 1)
+				a.expectLogoutAll()
+				expectIssue(a.mock, a.user.ID, models.TokenPurposePasswordReset)
+			},
+			run: func(a *adminTest) error {
+				return a.service.ForcePasswordReset(context.Background(), a.actorID, a.user.ID, reason, a.client)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdminTest(t)
+
+			details := auditDetails{"reason": reason.Reason}
+			if tt.role != "" {
+				details["role"] = tt.role
+			}
+			tt.before(a)
+			a.expectAudit(tt.action, details)
+			if tt.after != nil {
+				tt.after(a)
+			}
+
+			if err := tt.run(a); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		})
+	}
+}
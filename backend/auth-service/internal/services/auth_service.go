@@ -2,34 +2,102 @@ package services
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"log"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/oauthstate"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/passwords"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/revocation"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 )
 
+const mfaChallengeTTL = 5 * time.Minute
+
+// oauthStateTTL bounds how long a user may spend on a provider's login page
+const oauthStateTTL = 10 * time.Minute
+
+// oauthLinkTTL bounds how long a user has to confirm linking a provider whose
+// email matched their account
+const oauthLinkTTL = 15 * time.Minute
+
+// oauthHandOffTTL bounds the trip of a redirect mode authorization code from
+// the callback to the frontend
+const oauthHandOffTTL = time.Minute
+
+// sessionTouchInterval limits how often last_seen_at is written for a session
+const sessionTouchInterval = 5 * time.Minute
+
 type AuthService struct {
-	userRepo     *database.UserRepository
-	jwtManager   *utils.JWTManager
-	oauthManager *utils.OAuthManager
+	userRepo          *database.UserRepository
+	refreshTokenRepo  *database.RefreshTokenRepository
+	sessionRepo       *database.SessionRepository
+	oneTimeTokenRepo  *database.OneTimeTokenRepository
+	mfaRepo           *database.MFARepository
+	webAuthnRepo      *database.WebAuthnRepository
+	patRepo           *database.PersonalAccessTokenRepository
+	roleRepo          *database.RoleRepository
+	securityEventRepo *database.SecurityEventRepository
+	revocationStore   revocation.Store
+	loginGuard        *lockout.Guard
+	verification      *VerificationService
+	jwtManager        *utils.JWTManager
+	oauthManager      *utils.OAuthManager
+	oauthStateStore   oauthstate.Store
+	usernamePolicy    *usernames.Policy
+	passwordPolicy    *passwords.Policy
 }
 
 func NewAuthService(
 	userRepo *database.UserRepository,
+	refreshTokenRepo *database.RefreshTokenRepository,
+	sessionRepo *database.SessionRepository,
+	oneTimeTokenRepo *database.OneTimeTokenRepository,
+	mfaRepo *database.MFARepository,
+	webAuthnRepo *database.WebAuthnRepository,
+	patRepo *database.PersonalAccessTokenRepository,
+	roleRepo *database.RoleRepository,
+	securityEventRepo *database.SecurityEventRepository,
+	revocationStore revocation.Store,
+	loginGuard *lockout.Guard,
+	verification *VerificationService,
 	jwtManager *utils.JWTManager,
 	oauthManager *utils.OAuthManager,
+	oauthStateStore oauthstate.Store,
+	usernamePolicy *usernames.Policy,
+	passwordPolicy *passwords.Policy,
 ) *AuthService {
 	return &AuthService{
-		userRepo:     userRepo,
-		jwtManager:   jwtManager,
-		oauthManager: oauthManager,
+		userRepo:          userRepo,
+		refreshTokenRepo:  refreshTokenRepo,
+		sessionRepo:       sessionRepo,
+		oneTimeTokenRepo:  oneTimeTokenRepo,
+		mfaRepo:           mfaRepo,
+		webAuthnRepo:      webAuthnRepo,
+		patRepo:           patRepo,
+		roleRepo:          roleRepo,
+		securityEventRepo: securityEventRepo,
+		revocationStore:   revocationStore,
+		loginGuard:        loginGuard,
+		verification:      verification,
+		jwtManager:        jwtManager,
+		oauthManager:      oauthManager,
+		oauthStateStore:   oauthStateStore,
+		usernamePolicy:    usernamePolicy,
+		passwordPolicy:    passwordPolicy,
 	}
 }
 
-func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthResponse, error) {
+func (s *AuthService) Register(req *models.RegisterRequest, client *models.ClientInfo) (*models.AuthResponse, error) {
 	// Validate request
 	if err := utils.ValidateStruct(req); err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
@@ -44,7 +112,11 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 		return nil, fmt.Errorf("email already registered")
 	}
 
-	// Check if username already exists
+	if err := s.usernamePolicy.Check(req.Username); err != nil {
+		return nil, err
+	}
+
+	// Check if username or a look-alike already exists
 	usernameExists, err := s.userRepo.UsernameExists(req.Username)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check username existence: %w", err)
@@ -53,6 +125,10 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 		return nil, fmt.Errorf("username already taken")
 	}
 
+	if err := checkPassword(s.passwordPolicy, "password", req.Password, passwords.PersonalInfo{Username: req.Username, Email: req.Email}); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -71,158 +147,441 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
+	if createdUser == nil {
+		return nil, fmt.Errorf("username already taken")
+	}
 
-	// Generate JWT token
-	token, err := s.jwtManager.GenerateToken(createdUser.ID, createdUser.Username, createdUser.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+	// Send verification email, the user can request another one if this fails
+	if err := s.verification.SendVerificationEmail(context.Background(), createdUser); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", createdUser.ID, err)
 	}
 
-	return &models.AuthResponse{
-		User:        createdUser,
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   s.jwtManager.GetExpiresIn(),
-	}, nil
+	// Generate access and refresh tokens
+	return s.IssueTokens(createdUser, time.Now(), client)
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.AuthResponse, error) {
+func (s *AuthService) Login(req *models.LoginRequest, client *models.ClientInfo) (*models.AuthResponse, *models.MFAChallengeResponse, error) {
 	// Validate request
 	if err := utils.ValidateStruct(req); err != nil {
-		return nil, fmt.Errorf("validation failed: %w", err)
+		return nil, nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	// Reject locked accounts and addresses before spending time on bcrypt.
+	// Unknown emails are tracked too so lockouts don't reveal which exist.
+	attemptKeys := []lockout.Key{lockout.Account(req.Email), lockout.IP(client.IP)}
+	if err := s.loginGuard.Check(attemptKeys...); err != nil {
+		return nil, nil, err
 	}
 
 	// Get user by email
 	user, err := s.userRepo.GetByEmail(req.Email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
 	}
 	if user == nil {
-		return nil, fmt.Errorf("invalid email or password")
+		s.loginGuard.Fail(attemptKeys...)
+		return nil, nil, fmt.Errorf("invalid email or password")
 	}
 
 	// Verify password
 	if err := utils.VerifyPassword(user.PasswordHash, req.Password); err != nil {
-		return nil, fmt.Errorf("invalid email or password")
+		s.loginGuard.Fail(attemptKeys...)
+		return nil, nil, fmt.Errorf("invalid email or password")
 	}
 
-	// Generate JWT token
-	token, err := s.jwtManager.GenerateToken(user.ID, user.Username, user.Email)
+	// Only the account is cleared, an address keeps its count across accounts
+	s.loginGuard.Succeed(lockout.Account(req.Email))
+
+	return s.completeLogin(user, client)
+}
+
+// RefreshToken rotates a refresh token and issues a new token pair. Presenting
+// a token that was already rotated or revoked is treated as token theft and
+// revokes every token in its family.
+func (s *AuthService) RefreshToken(req *models.RefreshTokenRequest) (*models.AuthResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	return s.rotateRefreshToken(req.RefreshToken, "")
+}
+
+// RefreshClientToken rotates a refresh token issued to a third-party app. The
+// token only works for the client it was issued to.
+func (s *AuthService) RefreshClientToken(clientID, refreshToken string) (*models.AuthResponse, error) {
+	return s.rotateRefreshToken(refreshToken, clientID)
+}
+
+func (s *AuthService) rotateRefreshToken(refreshToken, clientID string) (*models.AuthResponse, error) {
+	storedToken, err := s.refreshTokenRepo.GetByHash(utils.HashToken(refreshToken))
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if storedToken == nil {
+		return nil, fmt.Errorf("invalid refresh token")
 	}
 
-	// Remove password hash from response
-	user.PasswordHash = ""
+	if storedToken.RevokedAt != nil {
+		if err := s.refreshTokenRepo.RevokeFamily(storedToken.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return nil, fmt.Errorf("refresh token reuse detected")
+	}
 
-	return &models.AuthResponse{
-		User:        user,
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   s.jwtManager.GetExpiresIn(),
-	}, nil
+	if time.Now().After(storedToken.ExpiresAt) {
+		return nil, fmt.Errorf("refresh token expired")
+	}
+
+	// The refresh token family is the session, a revoked session ends it
+	session, err := s.sessionRepo.GetByID(storedToken.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || !session.Active(time.Now()) {
+		if err := s.refreshTokenRepo.RevokeFamily(storedToken.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	// Tokens of third-party apps can't be refreshed by the first-party app
+	// or by another client, and the other way around
+	if session.OAuthClientID() != clientID {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	user, err := s.userRepo.GetByID(storedToken.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.DeletedAt != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	// Mark the presented token as replaced before issuing its successor so that
+	// two concurrent refreshes with the same token cannot both succeed
+	successorID := uuid.New()
+	rotated, err := s.refreshTokenRepo.Rotate(storedToken.ID, successorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		if err := s.refreshTokenRepo.RevokeFamily(storedToken.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return nil, fmt.Errorf("refresh token reuse detected")
+	}
+
+	response, err := s.issueTokenPair(user, session, successorID, storedToken.AuthTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionRepo.Extend(session.ID, s.jwtManager.GetRefreshExpiry()); err != nil {
+		return nil, err
+	}
+
+	return response, nil
 }
 
-func (s *AuthService) GetGoogleAuthURL(state string) string {
-	return s.oauthManager.GetGoogleAuthURL(state)
+// OAuthProviders lists the configured sign-in providers
+func (s *AuthService) OAuthProviders() []models.OAuthProviderInfo {
+	return s.oauthManager.Providers()
 }
 
-func (s *AuthService) GetFacebookAuthURL(state string) string {
-	return s.oauthManager.GetFacebookAuthURL(state)
+// BeginOAuth starts a login with the provider. The returned state is bound
+// to a PKCE verifier, an OIDC nonce and the page to return to afterwards.
+// The returned binding must reach the callback from the browser that started
+// the flow, so a callback URL someone else started can't sign the user in.
+func (s *AuthService) BeginOAuth(ctx context.Context, providerName, redirectURL string) (string, string, string, error) {
+	return s.beginOAuth(ctx, providerName, redirectURL, nil)
 }
 
-func (s *AuthService) HandleGoogleCallback(ctx context.Context, code string) (*models.AuthResponse, error) {
-	// Exchange code for user info
-	userInfo, err := s.oauthManager.ExchangeGoogleCode(ctx, code)
+// BeginOAuthLink starts a flow whose callback links the provider identity to
+// the signed in user instead of signing in
+func (s *AuthService) BeginOAuthLink(ctx context.Context, userID uuid.UUID, providerName, redirectURL string) (string, string, string, error) {
+	user, err := s.userRepo.GetByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange Google code: %w", err)
+		return "", "", "", fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return "", "", "", fmt.Errorf("user not found")
+	}
+	if _, linked := user.OAuthProviders[providerName]; linked {
+		return "", "", "", fmt.Errorf("identity already linked")
 	}
 
-	return s.handleOAuthUser(userInfo)
+	return s.beginOAuth(ctx, providerName, redirectURL, &userID)
 }
 
-func (s *AuthService) HandleFacebookCallback(ctx context.Context, code string) (*models.AuthResponse, error) {
-	// Exchange code for user info
-	userInfo, err := s.oauthManager.ExchangeFacebookCode(ctx, code)
+func (s *AuthService) beginOAuth(ctx context.Context, providerName, redirectURL string, linkUserID *uuid.UUID) (string, string, string, error) {
+	provider, exists := s.oauthManager.Provider(providerName)
+	if !exists {
+		return "", "", "", fmt.Errorf("unknown oauth provider")
+	}
+
+	redirectURL, err := s.oauthManager.ValidateRedirect(redirectURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange Facebook code: %w", err)
+		return "", "", "", err
 	}
 
-	return s.handleOAuthUser(userInfo)
+	state, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	nonce, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	binding, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, codeVerifier, nonce)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	err = s.oauthStateStore.Save(state, &oauthstate.State{
+		Provider:     provider.Name(),
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		RedirectURL:  redirectURL,
+		LinkUserID:   linkUserID,
+		BindingHash:  utils.HashToken(binding),
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	})
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return authURL, state, binding, nil
 }
 
-func (s *AuthService) handleOAuthUser(userInfo *models.OAuthUserInfo) (*models.AuthResponse, error) {
-	// Check if user exists with this OAuth provider
-	existingUser, err := s.userRepo.GetByOAuth(userInfo.Provider, userInfo.ID)
+func (s *AuthService) HandleOAuthCallback(ctx context.Context, providerName string, callback *models.OAuthCallbackRequest, client *models.ClientInfo) (*models.OAuthCallbackResult, error) {
+	provider, exists := s.oauthManager.Provider(providerName)
+	if !exists {
+		return nil, fmt.Errorf("unknown oauth provider")
+	}
+
+	// The state is consumed whatever happens next, so it can't be replayed
+	flow, err := s.oauthStateStore.Consume(callback.State)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check OAuth user: %w", err)
+		return nil, err
+	}
+	if flow == nil || flow.Provider != provider.Name() {
+		return nil, fmt.Errorf("invalid oauth state")
+	}
+	// A state that comes back without the binding of the browser it was
+	// issued to was started by someone else
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(callback.Binding)), []byte(flow.BindingHash)) != 1 {
+		return nil, fmt.Errorf("invalid oauth state")
 	}
 
-	var user *models.User
+	// Exchange code for user info
+	userInfo, err := provider.Exchange(ctx, callback, flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
 
-	if existingUser != nil {
-		// User exists, update their info if needed
-		user = existingUser
-	} else {
-		// Check if user exists with the same email
-		emailUser, err := s.userRepo.GetByEmail(userInfo.Email)
+	if flow.LinkUserID != nil {
+		user, err := s.linkIdentity(*flow.LinkUserID, userInfo)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check email user: %w", err)
+			return nil, err
 		}
+		return &models.OAuthCallbackResult{Linked: user}, nil
+	}
 
-		if emailUser != nil {
-			// Link OAuth to existing email account
-			user, err = s.linkOAuthToUser(emailUser, userInfo)
-			if err != nil {
-				return nil, fmt.Errorf("failed to link OAuth: %w", err)
-			}
-		} else {
-			// Create new user
-			user, err = s.createOAuthUser(userInfo)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create OAuth user: %w", err)
-			}
+	user, linkTo, err := s.resolveOAuthUser(userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// In redirect mode the browser carries a one-time code back to the
+	// frontend, which trades it with ExchangeOAuthCode for tokens or, when
+	// the owner of the matched account has to confirm, for the link request
+	if s.oauthManager.RedirectMode() {
+		payload := models.TokenPayload{"redirect_url": flow.RedirectURL}
+		if linkTo != nil {
+			user = linkTo
+			payload["link_provider"] = userInfo.Provider
+			payload["link_id"] = userInfo.ID
+			payload["link_email"] = userInfo.Email
 		}
+
+		code, err := s.createHandOffCode(user, payload)
+		if err != nil {
+			return nil, err
+		}
+		return &models.OAuthCallbackResult{HandOffCode: code, LinkPending: linkTo != nil}, nil
 	}
 
-	// Generate JWT token
-	token, err := s.jwtManager.GenerateToken(user.ID, user.Username, user.Email)
+	if linkTo != nil {
+		linkRequired, err := s.createLinkRequest(linkTo, userInfo)
+		if err != nil {
+			return nil, err
+		}
+		return &models.OAuthCallbackResult{LinkRequired: linkRequired}, nil
+	}
+
+	response, challenge, err := s.completeLogin(user, client)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, err
 	}
 
-	// Remove password hash from response
-	user.PasswordHash = ""
+	if challenge != nil {
+		challenge.RedirectURL = flow.RedirectURL
+	} else {
+		response.RedirectURL = flow.RedirectURL
+	}
 
-	return &models.AuthResponse{
-		User:        user,
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   s.jwtManager.GetExpiresIn(),
-	}, nil
+	return &models.OAuthCallbackResult{Auth: response, Challenge: challenge}, nil
 }
 
-func (s *AuthService) createOAuthUser(userInfo *models.OAuthUserInfo) (*models.User, error) {
-	// Generate unique username from name and email
-	username := s.generateUniqueUsername(userInfo.Name, userInfo.Email)
+// OAuthRedirectMode reports whether OAuth callbacks hand off to the frontend
+// with a redirect instead of answering with JSON
+func (s *AuthService) OAuthRedirectMode() bool {
+	return s.oauthManager.RedirectMode()
+}
 
-	// Create OAuth data
-	oauthData := &models.OAuthData{}
-	oauthUserData := &models.OAuthUserData{
-		ID:    userInfo.ID,
-		Email: userInfo.Email,
+// OAuthFrontendCallbackURL returns the frontend page that receives OAuth
+// results in redirect mode
+func (s *AuthService) OAuthFrontendCallbackURL(params url.Values) string {
+	return s.oauthManager.FrontendCallbackURL(params)
+}
+
+// ExchangeOAuthCode trades the one-time code of an OAuth callback for the
+// result of the login, or the link request of an identity whose email
+// matched an account. Each code works once, shortly after the callback.
+func (s *AuthService) ExchangeOAuthCode(req *models.OAuthExchangeRequest, client *models.ClientInfo) (*models.OAuthCallbackResult, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	storedToken, err := s.oneTimeTokenRepo.Consume(models.TokenPurposeOAuthExchange, utils.HashToken(req.Code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	if storedToken == nil {
+		return nil, fmt.Errorf("invalid authorization code")
+	}
+
+	user, err := s.userRepo.GetByID(storedToken.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("invalid authorization code")
+	}
+
+	if provider := storedToken.Payload["link_provider"]; provider != "" {
+		linkRequired, err := s.createLinkRequest(user, &models.OAuthUserInfo{
+			ID:       storedToken.Payload["link_id"],
+			Email:    storedToken.Payload["link_email"],
+			Provider: provider,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &models.OAuthCallbackResult{LinkRequired: linkRequired}, nil
+	}
+
+	response, challenge, err := s.completeLogin(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURL := storedToken.Payload["redirect_url"]
+	if challenge != nil {
+		challenge.RedirectURL = redirectURL
+	} else {
+		response.RedirectURL = redirectURL
+	}
+
+	return &models.OAuthCallbackResult{Auth: response, Challenge: challenge}, nil
+}
+
+func (s *AuthService) createHandOffCode(user *models.User, payload models.TokenPayload) (string, error) {
+	code, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeOAuthExchange,
+		TokenHash: utils.HashToken(code),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(oauthHandOffTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
 	}
 
-	switch userInfo.Provider {
-	case "google":
-		oauthData.Google = oauthUserData
-	case "facebook":
-		oauthData.Facebook = oauthUserData
+	return code, nil
+}
+
+// resolveOAuthUser finds or creates the account of an OAuth identity. It
+// returns the account to link to instead when the identity's email matches
+// it but either the provider or the account didn't verify the email, its
+// owner has to confirm.
+func (s *AuthService) resolveOAuthUser(userInfo *models.OAuthUserInfo) (*models.User, *models.User, error) {
+	// Check if user exists with this OAuth provider
+	existingUser, err := s.userRepo.GetByOAuth(userInfo.Provider, userInfo.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check OAuth user: %w", err)
+	}
+
+	if existingUser != nil {
+		return existingUser, nil, nil
+	}
+
+	// Check if user exists with the same email
+	emailUser, err := s.userRepo.GetByEmail(userInfo.Email)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check email user: %w", err)
+	}
+
+	if emailUser != nil {
+		// Anyone can claim any address at a provider that doesn't verify
+		// emails, and anyone can sign up with an address they don't own, so
+		// the account owner has to confirm unless both sides verified it
+		if !userInfo.EmailVerified || emailUser.EmailVerifiedAt == nil {
+			return nil, emailUser, nil
+		}
+
+		// Link OAuth to existing email account
+		user, err := s.linkIdentity(emailUser.ID, userInfo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to link OAuth: %w", err)
+		}
+		return user, nil, nil
+	}
+
+	// Create new user
+	user, err := s.createOAuthUser(userInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OAuth user: %w", err)
+	}
+	return user, nil, nil
+}
+
+func (s *AuthService) createOAuthUser(userInfo *models.OAuthUserInfo) (*models.User, error) {
+	// Create OAuth data
+	oauthData := models.OAuthData{
+		userInfo.Provider: {
+			ID:           userInfo.ID,
+			Email:        userInfo.Email,
+			PrivateEmail: userInfo.PrivateEmail,
+		},
 	}
 
 	// Create user
 	user := &models.User{
-		Username:       username,
 		DisplayName:    userInfo.Name,
 		Email:          userInfo.Email,
 		PasswordHash:   "", // OAuth users don't have password
@@ -233,64 +592,161 @@ func (s *AuthService) createOAuthUser(userInfo *models.OAuthUserInfo) (*models.U
 		user.ProfileImageURL = &userInfo.Picture
 	}
 
-	createdUser, err := s.userRepo.Create(user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create OAuth user: %w", err)
+	// Generate unique username from name and email, again if a look-alike
+	// was registered since it was checked
+	var createdUser *models.User
+	var err error
+	for attempt := 0; createdUser == nil; attempt++ {
+		if attempt == 3 {
+			return nil, fmt.Errorf("failed to create OAuth user: username already taken")
+		}
+
+		user.Username = s.generateUniqueUsername(userInfo.Name, userInfo.Email)
+		createdUser, err = s.userRepo.Create(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OAuth user: %w", err)
+		}
+	}
+
+	// The provider already verified the address
+	if userInfo.EmailVerified {
+		if err := s.userRepo.MarkEmailVerified(createdUser.ID); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		createdUser.EmailVerifiedAt = &now
 	}
 
 	return createdUser, nil
 }
 
-func (s *AuthService) linkOAuthToUser(user *models.User, userInfo *models.OAuthUserInfo) (*models.User, error) {
-	// Initialize OAuth data if nil
-	if user.OAuthProviders == nil {
-		user.OAuthProviders = &models.OAuthData{}
+// createLinkRequest remembers an OAuth identity that may be linked to the
+// user once they sign in and confirm it
+func (s *AuthService) createLinkRequest(user *models.User, userInfo *models.OAuthUserInfo) (*models.OAuthLinkRequiredResponse, error) {
+	token, err := s.jwtManager.GenerateActionToken(user.ID, models.TokenPurposeOAuthLink, user.Email, oauthLinkTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate link token: %w", err)
 	}
 
-	oauthUserData := &models.OAuthUserData{
-		ID:    userInfo.ID,
-		Email: userInfo.Email,
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeOAuthLink,
+		TokenHash: utils.HashToken(token),
+		Payload: models.TokenPayload{
+			"provider": userInfo.Provider,
+			"id":       userInfo.ID,
+			"email":    userInfo.Email,
+		},
+		ExpiresAt: time.Now().Add(oauthLinkTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store link token: %w", err)
 	}
 
-	// Add new OAuth provider
-	switch userInfo.Provider {
-	case "google":
-		user.OAuthProviders.Google = oauthUserData
-	case "facebook":
-		user.OAuthProviders.Facebook = oauthUserData
+	return &models.OAuthLinkRequiredResponse{
+		LinkToken: token,
+		Provider:  userInfo.Provider,
+		Email:     user.Email,
+		ExpiresIn: int64(oauthLinkTTL.Seconds()),
+	}, nil
+}
+
+// ConfirmOAuthLink links the identity of a link token to the signed in user.
+// The token only works for the account it was issued for.
+func (s *AuthService) ConfirmOAuthLink(userID uuid.UUID, providerName, linkToken string) (*models.User, error) {
+	claims, err := s.jwtManager.ValidateActionToken(linkToken, models.TokenPurposeOAuthLink)
+	if err != nil || claims.UserID != userID {
+		return nil, fmt.Errorf("invalid link token")
+	}
+
+	storedToken, err := s.oneTimeTokenRepo.Consume(models.TokenPurposeOAuthLink, utils.HashToken(linkToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume link token: %w", err)
+	}
+	if storedToken == nil || storedToken.UserID != userID || storedToken.Payload["provider"] != providerName {
+		return nil, fmt.Errorf("invalid link token")
+	}
+
+	return s.linkIdentity(userID, &models.OAuthUserInfo{
+		ID:       storedToken.Payload["id"],
+		Email:    storedToken.Payload["email"],
+		Provider: providerName,
+	})
+}
+
+// UnlinkOAuth removes a provider from the user, unless it is their last way
+// to sign in
+func (s *AuthService) UnlinkOAuth(userID uuid.UUID, providerName string) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if _, linked := user.OAuthProviders[providerName]; !linked {
+		return nil, fmt.Errorf("identity not found")
 	}
 
-	// Update user with OAuth data
-	updatedUser, err := s.userRepo.UpdateOAuth(user.ID, user.OAuthProviders)
+	// The query re-checks the remaining login methods so concurrent unlinks
+	// can't leave the account without any
+	updatedUser, err := s.userRepo.UnlinkOAuth(userID, providerName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update user OAuth: %w", err)
+		return nil, err
+	}
+	if updatedUser == nil {
+		return nil, fmt.Errorf("cannot remove last login method")
 	}
 
 	return updatedUser, nil
 }
 
-func (s *AuthService) generateUniqueUsername(name, email string) string {
-	// Clean name and create base username
-	baseUsername := strings.ToLower(strings.ReplaceAll(name, " ", ""))
-	if baseUsername == "" {
-		// Use email prefix if name is empty
-		emailParts := strings.Split(email, "@")
-		baseUsername = emailParts[0]
+// linkIdentity attaches an OAuth identity to the user. An identity belongs to
+// a single account.
+func (s *AuthService) linkIdentity(userID uuid.UUID, userInfo *models.OAuthUserInfo) (*models.User, error) {
+	owner, err := s.userRepo.GetByOAuth(userInfo.Provider, userInfo.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check OAuth user: %w", err)
+	}
+	if owner != nil && owner.ID != userID {
+		return nil, fmt.Errorf("identity linked to another account")
+	}
+
+	user, err := s.userRepo.LinkOAuth(userID, userInfo.Provider, &models.OAuthUserData{
+		ID:           userInfo.ID,
+		Email:        userInfo.Email,
+		PrivateEmail: userInfo.PrivateEmail,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
 	}
 
-	// Remove special characters
-	baseUsername = strings.ReplaceAll(baseUsername, ".", "")
-	baseUsername = strings.ReplaceAll(baseUsername, "-", "")
+	return user, nil
+}
+
+// generateUniqueUsername derives a username from the OAuth profile that
+// satisfies the username policy and doesn't look like an existing one
+func (s *AuthService) generateUniqueUsername(name, email string) string {
+	// Use email prefix if name is empty
+	if strings.TrimSpace(name) == "" {
+		name = strings.Split(email, "@")[0]
+	}
+	baseUsername := usernames.Sanitize(name)
 
 	// Try base username first
 	username := baseUsername
 	counter := 1
 
-	// Keep trying until we find a unique username
+	// Keep trying until we find an allowed, unique username
 	for {
-		exists, err := s.userRepo.UsernameExists(username)
-		if err == nil && !exists {
-			break
+		if s.usernamePolicy.Check(username) == nil {
+			exists, err := s.userRepo.UsernameExists(username)
+			if err == nil && !exists {
+				break
+			}
 		}
 
 		// Add counter to make it unique
@@ -307,24 +763,477 @@ func (s *AuthService) generateUniqueUsername(name, email string) string {
 	return username
 }
 
-func (s *AuthService) ValidateToken(tokenString string) (*models.User, error) {
+// completeLogin issues tokens for a user who passed the first factor, or a
+// challenge when the user has two-factor authentication enabled
+func (s *AuthService) completeLogin(user *models.User, client *models.ClientInfo) (*models.AuthResponse, *models.MFAChallengeResponse, error) {
+	if user.Suspended() {
+		return nil, nil, fmt.Errorf("account suspended")
+	}
+
+	mfa, err := s.mfaRepo.GetByUserID(user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get MFA status: %w", err)
+	}
+
+	if mfa == nil || mfa.TOTPEnabledAt == nil {
+		// Generate access and refresh tokens
+		response, err := s.IssueTokens(user, time.Now(), client)
+		return response, nil, err
+	}
+
+	challenge, err := s.createMFAChallenge(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, challenge, nil
+}
+
+// createMFAChallenge issues a short-lived token that can only be exchanged for
+// an AuthResponse together with a valid second factor
+func (s *AuthService) createMFAChallenge(user *models.User) (*models.MFAChallengeResponse, error) {
+	token, err := s.jwtManager.GenerateActionToken(user.ID, models.TokenPurposeMFAChallenge, "", mfaChallengeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeMFAChallenge,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store mfa token: %w", err)
+	}
+
+	methods := []string{models.MFAMethodTOTP, models.MFAMethodRecoveryCode}
+	passkeys, err := s.webAuthnRepo.ListByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) > 0 {
+		methods = append(methods, models.MFAMethodWebAuthn)
+	}
+
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(mfaChallengeTTL.Seconds()),
+		Methods:     methods,
+	}, nil
+}
+
+// CheckMFAChallenge makes sure a login challenge is still open for the user.
+// It runs before the second factor is checked so a used or replayed challenge
+// can't spend a recovery code or TOTP step.
+func (s *AuthService) CheckMFAChallenge(mfaToken string, userID uuid.UUID) error {
+	storedToken, err := s.oneTimeTokenRepo.Get(models.TokenPurposeMFAChallenge, utils.HashToken(mfaToken))
+	if err != nil {
+		return fmt.Errorf("failed to get mfa token: %w", err)
+	}
+	if storedToken == nil || storedToken.UserID != userID {
+		return fmt.Errorf("invalid mfa token")
+	}
+	return nil
+}
+
+// CompleteMFAChallenge redeems a login challenge once the second factor has
+// been verified. A challenge completes a single login.
+func (s *AuthService) CompleteMFAChallenge(mfaToken string, userID uuid.UUID, client *models.ClientInfo) (*models.AuthResponse, error) {
+	storedToken, err := s.oneTimeTokenRepo.Consume(models.TokenPurposeMFAChallenge, utils.HashToken(mfaToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume mfa token: %w", err)
+	}
+	if storedToken == nil || storedToken.UserID != userID {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+
+	return s.IssueTokens(user, time.Now(), client)
+}
+
+// IssueTokens starts a new refresh token family for a user who authenticated
+// at authTime. Signing in to an account that is pending deletion restores it.
+func (s *AuthService) IssueTokens(user *models.User, authTime time.Time, client *models.ClientInfo) (*models.AuthResponse, error) {
+	restored := false
+	if user.DeletedAt != nil && !user.Suspended() {
+		if err := s.userRepo.Restore(user.ID); err != nil {
+			return nil, err
+		}
+		user.DeletedAt = nil
+		restored = true
+	}
+
+	session, err := s.sessionRepo.Create(&models.Session{
+		UserID:    user.ID,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IP,
+		ExpiresAt: s.jwtManager.GetRefreshExpiry(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.issueTokenPair(user, session, uuid.New(), authTime)
+	if err != nil {
+		return nil, err
+	}
+	response.AccountRestored = restored
+
+	s.RecordSecurityEvent(user.ID, models.SecurityEventLogin, client)
+
+	return response, nil
+}
+
+// IssueClientTokens starts a session for a third-party app the user granted
+// the given scope. Its tokens carry the client and the scope.
+func (s *AuthService) IssueClientTokens(user *models.User, authTime time.Time, clientID, scope string, client *models.ClientInfo) (*models.AuthResponse, error) {
+	session, err := s.sessionRepo.Create(&models.Session{
+		UserID:    user.ID,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IP,
+		ClientID:  &clientID,
+		Scope:     scope,
+		ExpiresAt: s.jwtManager.GetRefreshExpiry(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokenPair(user, session, uuid.New(), authTime)
+}
+
+// issueTokenPair generates an access token and a refresh token for the given
+// session, which is also the refresh token family
+func (s *AuthService) issueTokenPair(user *models.User, session *models.Session, refreshTokenID uuid.UUID, authTime time.Time) (*models.AuthResponse, error) {
+	// Every way of signing in or refreshing ends here
+	if user.Suspended() {
+		return nil, fmt.Errorf("account suspended")
+	}
+
+	// Roles are read on every issue and refresh so grants and revocations
+	// reach the user's next token. Third-party apps never act with them.
+	access := &models.UserAccess{}
+	if session.ClientID == nil {
+		var err error
+		access, err = s.roleRepo.GetUserAccess(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Generate JWT token
+	accessToken, err := s.jwtManager.GenerateToken(utils.TokenParams{
+		UserID:            user.ID,
+		Username:          user.Username,
+		Email:             user.Email,
+		AuthTime:          authTime,
+		CredentialVersion: user.CredentialVersion,
+		SessionID:         session.ID,
+		Roles:             access.Roles,
+		Permissions:       access.Permissions,
+		Scope:             session.Scope,
+		ClientID:          session.OAuthClientID(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	// Generate opaque refresh token, only its hash is persisted
+	refreshToken, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	_, err = s.refreshTokenRepo.Create(&models.RefreshToken{
+		ID:        refreshTokenID,
+		UserID:    user.ID,
+		FamilyID:  session.ID,
+		TokenHash: utils.HashToken(refreshToken),
+		AuthTime:  authTime,
+		ExpiresAt: s.jwtManager.GetRefreshExpiry(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	// Remove password hash from response
+	user.PasswordHash = ""
+
+	return &models.AuthResponse{
+		User:             user,
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        s.jwtManager.GetExpiresIn(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: s.jwtManager.GetRefreshExpiresIn(),
+		Scope:            session.Scope,
+	}, nil
+}
+
+// ValidateToken checks a token for other services. Personal access tokens
+// must hold every given scope, tokens of third-party apps are rejected since
+// the response carries no scope to limit them by. Tokens of suspended users
+// fail in Authenticate.
+func (s *AuthService) ValidateToken(tokenString string, scopes ...string) (*models.User, error) {
+	user, claims, err := s.Authenticate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID != "" {
+		return nil, fmt.Errorf("token issued to a third-party app")
+	}
+	if claims.PersonalAccessTokenID != uuid.Nil && (len(scopes) == 0 || !claims.HasScopes(scopes...)) {
+		return nil, fmt.Errorf("insufficient scope")
+	}
+	return user, nil
+}
+
+// Authenticate validates an access token, checks it against the revocation
+// store and returns the token owner together with the token claims. Personal
+// access tokens are accepted too and described by claims without a session.
+func (s *AuthService) Authenticate(tokenString string) (*models.User, *utils.JWTClaims, error) {
+	if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+		return s.authenticatePersonalAccessToken(tokenString)
+	}
+
 	// Validate JWT token
 	claims, err := s.jwtManager.ValidateToken(tokenString)
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %w", err)
+		return nil, nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	// Reject tokens revoked by logout
+	revoked, err := s.revocationStore.IsRevoked(claims.ID, claims.UserID, claims.IssueTime())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, nil, fmt.Errorf("token has been revoked")
+	}
+
+	// Reject tokens of sessions the user signed out of
+	if claims.SessionID != uuid.Nil {
+		if err := s.checkSession(claims.SessionID); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// Get user from database
 	user, err := s.userRepo.GetByID(claims.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
 	}
-	if user == nil {
-		return nil, fmt.Errorf("user not found")
+	if user == nil || user.DeletedAt != nil {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+	if user.Suspended() {
+		return nil, nil, fmt.Errorf("account suspended")
+	}
+
+	// Tokens issued before the last credential change are no longer valid
+	if claims.CredentialVersion != user.CredentialVersion {
+		return nil, nil, fmt.Errorf("token has been revoked")
 	}
 
 	// Remove password hash from response
 	user.PasswordHash = ""
 
-	return user, nil
+	return user, claims, nil
+}
+
+func (s *AuthService) authenticatePersonalAccessToken(tokenString string) (*models.User, *utils.JWTClaims, error) {
+	token, err := s.patRepo.GetByHash(utils.HashToken(tokenString))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || !token.Active(time.Now()) {
+		return nil, nil, fmt.Errorf("invalid token: unknown or expired personal access token")
+	}
+
+	user, err := s.userRepo.GetByID(token.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.DeletedAt != nil {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+	if user.Suspended() {
+		return nil, nil, fmt.Errorf("account suspended")
+	}
+
+	if err := s.patRepo.Touch(token.ID); err != nil {
+		log.Printf("Failed to update personal access token %s: %v", token.ID, err)
+	}
+
+	// Remove password hash from response
+	user.PasswordHash = ""
+
+	return user, &utils.JWTClaims{
+		UserID:                user.ID,
+		Username:              user.Username,
+		Email:                 user.Email,
+		CredentialVersion:     user.CredentialVersion,
+		Scope:                 strings.Join(token.Scopes, " "),
+		PersonalAccessTokenID: token.ID,
+	}, nil
+}
+
+// Logout revokes the given access token and ends its session. A refresh token
+// from before sessions existed can be passed to revoke its family as well.
+func (s *AuthService) Logout(claims *utils.JWTClaims, req *models.LogoutRequest) error {
+	if err := s.revocationStore.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	if claims.SessionID != uuid.Nil {
+		if _, err := s.RevokeSession(claims.UserID, claims.SessionID); err != nil {
+			return err
+		}
+	}
+
+	if req.RefreshToken == "" {
+		return nil
+	}
+
+	storedToken, err := s.refreshTokenRepo.GetByHash(utils.HashToken(req.RefreshToken))
+	if err != nil {
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	// Ignore unknown tokens and tokens belonging to someone else
+	if storedToken == nil || storedToken.UserID != claims.UserID {
+		return nil
+	}
+
+	if err := s.refreshTokenRepo.RevokeFamily(storedToken.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// confirmPassword checks the password a signed in user confirms a sensitive
+// change with. Wrong passwords count against the account's login lockout, so
+// a stolen session can't be used to guess it.
+func (s *AuthService) confirmPassword(user *models.User, password string) (bool, error) {
+	key := lockout.Account(user.Email)
+	if err := s.loginGuard.Check(key); err != nil {
+		return false, err
+	}
+
+	if err := utils.VerifyPassword(user.PasswordHash, password); err != nil {
+		s.loginGuard.Fail(key)
+		return false, nil
+	}
+
+	s.loginGuard.Succeed(key)
+	return true, nil
+}
+
+// RevokeRefreshTokens revokes every refresh token of the user, access tokens
+// are left to expire or to be rejected by their credential version
+func (s *AuthService) RevokeRefreshTokens(userID uuid.UUID) error {
+	if err := s.refreshTokenRepo.RevokeAllForUser(userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return s.sessionRepo.RevokeAllForUser(userID)
+}
+
+// LogoutAll revokes every access, refresh and personal access token issued
+// to the user so far
+func (s *AuthService) LogoutAll(userID uuid.UUID) error {
+	if err := s.RevokeRefreshTokens(userID); err != nil {
+		return err
+	}
+
+	if err := s.patRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+
+	if err := s.revocationStore.RevokeAllForUser(userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+
+	s.RecordSecurityEvent(userID, models.SecurityEventAllSessionsRevoke, nil)
+	return nil
+}
+
+// RecordSecurityEvent adds to the user's security events. client is nil when
+// the event doesn't come from a request of the user. Failures are only
+// logged, the event already happened.
+func (s *AuthService) RecordSecurityEvent(userID uuid.UUID, eventType string, client *models.ClientInfo) {
+	event := &models.SecurityEvent{UserID: userID, EventType: eventType}
+	if client != nil {
+		event.IPAddress = client.IP
+		event.UserAgent = client.UserAgent
+	}
+
+	if err := s.securityEventRepo.Create(event); err != nil {
+		log.Printf("Failed to record %s for user %s: %v", eventType, userID, err)
+	}
+}
+
+// ListSessions returns the user's active sessions, marking the one the
+// request was made with
+func (s *AuthService) ListSessions(userID, currentSessionID uuid.UUID) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.ListActive(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession signs the user out of one session. Its refresh tokens stop
+// working and its access tokens are rejected by Authenticate. It returns
+// false if the user has no such active session.
+func (s *AuthService) RevokeSession(userID, sessionID uuid.UUID) (bool, error) {
+	revoked, err := s.sessionRepo.Revoke(userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if !revoked {
+		return false, nil
+	}
+
+	if err := s.refreshTokenRepo.RevokeFamily(sessionID); err != nil {
+		return false, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	s.RecordSecurityEvent(userID, models.SecurityEventSessionRevoke, nil)
+	return true, nil
+}
+
+// checkSession rejects revoked or expired sessions and records activity on
+// the others
+func (s *AuthService) checkSession(sessionID uuid.UUID) error {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if session == nil || !session.Active(now) {
+		return fmt.Errorf("token has been revoked")
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := s.sessionRepo.Touch(sessionID); err != nil {
+			log.Printf("Failed to update session %s: %v", sessionID, err)
+		}
+	}
+
+	return nil
 }
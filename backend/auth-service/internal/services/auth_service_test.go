@@ -0,0 +1,286 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+func newTestJWTManager(t *testing.T) *utils.JWTManager {
+	t.Helper()
+
+	jwtManager, err := utils.NewJWTManager(&configs.Config{
+		Server: configs.ServerConfig{Env: "test"},
+		JWT: configs.JWTConfig{
+			Algorithm:        "RS256",
+			ExpiresIn:        15 * time.Minute,
+			RefreshExpiresIn: 24 * time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewJWTManager: %v", err)
+	}
+	return jwtManager
+}
+
+// capture is an argument matcher that accepts any value and remembers it
+type capture struct {
+	value driver.Value
+}
+
+func (c *capture) Match(value driver.Value) bool {
+	c.value = value
+	return true
+}
+
+var (
+	refreshTokenColumns = []string{"id", "user_id", "family_id", "token_hash", "auth_time", "expires_at", "revoked_at", "replaced_by", "created_at"}
+	sessionColumns      = []string{"id", "user_id", "user_agent", "ip_address", "client_id", "scope", "expires_at", "revoked_at", "last_seen_at", "created_at"}
+)
+
+// refreshTest describes the stored state a refresh token is presented against
+type refreshTest struct {
+	name string
+	// tokenRevoked marks the presented token as already rotated or revoked
+	tokenRevoked bool
+	tokenExpired bool
+	// sessionRevoked ends the session, i.e. the token family
+	sessionRevoked bool
+	sessionClient  *string
+	clientID       string
+	// lostRace makes the rotation find the token already replaced by a
+	// concurrent refresh
+	lostRace bool
+	wantErr  string
+	// wantFamilyRevoked expects every token of the family to be revoked
+	wantFamilyRevoked bool
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	thirdParty := "third-party-app"
+
+	tests := []refreshTest{
+		{name: "rotates a live token"},
+		{name: "rotates a third-party token for its client", sessionClient: &thirdParty, clientID: thirdParty},
+		{name: "reuse of a rotated token revokes the family", tokenRevoked: true, wantErr: "refresh token reuse detected", wantFamilyRevoked: true},
+		{name: "losing a concurrent rotation revokes the family", lostRace: true, wantErr: "refresh token reuse detected", wantFamilyRevoked: true},
+		{name: "expired token", tokenExpired: true, wantErr: "refresh token expired"},
+		{name: "revoked session revokes the family", sessionRevoked: true, wantErr: "invalid refresh token", wantFamilyRevoked: true},
+		{name: "first-party token presented by a client", clientID: thirdParty, wantErr: "invalid refresh token"},
+		{name: "third-party token presented by the first-party app", sessionClient: &thirdParty, wantErr: "invalid refresh token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runRefreshTest(t, tt)
+		})
+	}
+}
+
+func runRefreshTest(t *testing.T, tt refreshTest) {
+	db, mock := newMockDB(t)
+	service := &AuthService{
+		userRepo:         database.NewUserRepository(db),
+		refreshTokenRepo: database.NewRefreshTokenRepository(db),
+		sessionRepo:      database.NewSessionRepository(db),
+		roleRepo:         database.NewRoleRepository(db),
+		jwtManager:       newTestJWTManager(t),
+	}
+
+	now := time.Now()
+	userID, tokenID, sessionID := uuid.New(), uuid.New(), uuid.New()
+	authTime := now.Add(-time.Hour).Truncate(time.Second)
+	presented := "presented-refresh-token"
+
+	tokenExpiresAt := now.Add(time.Hour)
+	if tt.tokenExpired {
+		tokenExpiresAt = now.Add(-time.Minute)
+	}
+	var tokenRevokedAt interface{}
+	if tt.tokenRevoked {
+		tokenRevokedAt = now.Add(-time.Minute)
+	}
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash`).
+		WithArgs(utils.HashToken(presented)).
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+			AddRow(tokenID, userID, sessionID, utils.HashToken(presented), authTime, tokenExpiresAt, tokenRevokedAt, nil, now.Add(-time.Hour)))
+
+	revokeFamily := func() {
+		mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP\s+WHERE family_id`).
+			WithArgs(sessionID).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+	}
+
+	if tt.tokenRevoked {
+		revokeFamily()
+	}
+	if !tt.tokenRevoked && !tt.tokenExpired {
+		var sessionRevokedAt interface{}
+		if tt.sessionRevoked {
+			sessionRevokedAt = now.Add(-time.Minute)
+		}
+		var sessionClient interface{}
+		if tt.sessionClient != nil {
+			sessionClient = *tt.sessionClient
+		}
+		mock.ExpectQuery(`FROM sessions WHERE id`).
+			WithArgs(sessionID).
+			WillReturnRows(sqlmock.NewRows(sessionColumns).
+				AddRow(sessionID, userID, "test", "127.0.0.1", sessionClient, "", now.Add(time.Hour), sessionRevokedAt, now, now.Add(-time.Hour)))
+	}
+	if tt.sessionRevoked {
+		revokeFamily()
+	}
+
+	clientMatches := (tt.sessionClient == nil && tt.clientID == "") || (tt.sessionClient != nil && *tt.sessionClient == tt.clientID)
+	reachesUser := !tt.tokenRevoked && !tt.tokenExpired && !tt.sessionRevoked && clientMatches
+	successorID := &capture{}
+	if reachesUser {
+		mock.ExpectQuery(`FROM users WHERE id`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "credential_version", "created_at"}).
+				AddRow(userID, "john_doe", "john@example.com", 4, now.Add(-24*time.Hour)))
+
+		affected := int64(1)
+		if tt.lostRace {
+			affected = 0
+		}
+		mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by`).
+			WithArgs(tokenID, successorID).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+	if tt.lostRace {
+		revokeFamily()
+	}
+
+	storedHash := &capture{}
+	if tt.wantErr == "" {
+		if tt.sessionClient == nil {
+			mock.ExpectQuery(`FROM user_roles WHERE user_id`).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "role", "granted_by", "granted_at"}))
+		}
+		// The successor joins the family of the presented token
+		mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+			WithArgs(sqlmock.AnyArg(), userID, sessionID, storedHash, authTime, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+				AddRow(uuid.New(), userID, sessionID, "", authTime, now.Add(24*time.Hour), nil, nil, now))
+		mock.ExpectExec(`UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, expires_at`).
+			WithArgs(sessionID, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	response, err := service.rotateRefreshToken(presented, tt.clientID)
+	if tt.wantErr != "" {
+		if err == nil || err.Error() != tt.wantErr {
+			t.Fatalf("rotateRefreshToken = %v, want %q", err, tt.wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("rotateRefreshToken: %v", err)
+	}
+
+	if response.RefreshToken == presented {
+		t.Error("refresh token was not rotated")
+	}
+	if storedHash.value != utils.HashToken(response.RefreshToken) {
+		t.Error("stored hash doesn't belong to the returned refresh token")
+	}
+
+	claims, err := service.jwtManager.ValidateToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.SessionID != sessionID || claims.CredentialVersion != 4 || claims.ClientID != tt.clientID {
+		t.Errorf("claims = sid %s cv %d client %q, want sid %s cv 4 client %q",
+			claims.SessionID, claims.CredentialVersion, claims.ClientID, sessionID, tt.clientID)
+	}
+	if !claims.AuthTime.Time.Equal(authTime) {
+		t.Errorf("auth_time = %s, want the original %s", claims.AuthTime.Time, authTime)
+	}
+}
+
+func TestRotateRefreshTokenUnknown(t *testing.T) {
+	db, mock := newMockDB(t)
+	service := &AuthService{refreshTokenRepo: database.NewRefreshTokenRepository(db)}
+
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash`).
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns))
+
+	if _, err := service.rotateRefreshToken("unknown", ""); err == nil || err.Error() != "invalid refresh token" {
+		t.Fatalf("rotateRefreshToken = %v, want invalid refresh token", err)
+	}
+}
+
+func TestResolveOAuthUserLinksVerifiedEmailsOnly(t *testing.T) {
+	tests := []struct {
+		name             string
+		providerVerified bool
+		accountVerified  bool
+		wantLinked       bool
+	}{
+		{name: "both verified", providerVerified: true, accountVerified: true, wantLinked: true},
+		{name: "provider didn't verify", accountVerified: true},
+		{name: "account never verified", providerVerified: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			service := &AuthService{userRepo: database.NewUserRepository(db)}
+
+			userID := uuid.New()
+			userInfo := &models.OAuthUserInfo{
+				ID:            "12345",
+				Email:         "alice@example.com",
+				Provider:      "github",
+				EmailVerified: tt.providerVerified,
+			}
+			var verifiedAt interface{}
+			if tt.accountVerified {
+				verifiedAt = time.Now().Add(-time.Hour)
+			}
+			account := func() *sqlmock.Rows {
+				return sqlmock.NewRows(userColumns).
+					AddRow(userID, "alice", "Alice", userInfo.Email, "password-hash", verifiedAt, time.Now())
+			}
+
+			mock.ExpectQuery(`FROM users WHERE oauth_providers`).
+				WithArgs(userInfo.Provider, userInfo.ID).
+				WillReturnRows(sqlmock.NewRows(userColumns))
+			mock.ExpectQuery(`FROM users WHERE email`).
+				WithArgs(userInfo.Email).
+				WillReturnRows(account())
+			if tt.wantLinked {
+				mock.ExpectQuery(`FROM users WHERE oauth_providers`).
+					WithArgs(userInfo.Provider, userInfo.ID).
+					WillReturnRows(sqlmock.NewRows(userColumns))
+				mock.ExpectQuery(`UPDATE users SET oauth_providers`).
+					WithArgs(userID, userInfo.Provider, sqlmock.AnyArg()).
+					WillReturnRows(account())
+			}
+
+			user, linkTo, err := service.resolveOAuthUser(userInfo)
+			if err != nil {
+				t.Fatalf("resolveOAuthUser: %v", err)
+			}
+			if tt.wantLinked {
+				if user == nil || user.ID != userID || linkTo != nil {
+					t.Errorf("resolveOAuthUser = %v, %v, want the linked account", user, linkTo)
+				}
+				return
+			}
+			if user != nil || linkTo == nil || linkTo.ID != userID {
+				t.Errorf("resolveOAuthUser = %v, %v, want the account to confirm the link", user, linkTo)
+			}
+		})
+	}
+}
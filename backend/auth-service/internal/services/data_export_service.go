@@ -0,0 +1,501 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// staleExportAfter is when a running export is assumed to have lost its
+	// worker and is claimed again
+	staleExportAfter = time.Hour
+
+	// maxExportSourceSize bounds the response of another service's export
+	maxExportSourceSize = 256 << 20
+)
+
+// DataExportService builds takeout archives: a ZIP of JSON files with
+// everything stored about a user, here and in the other services. Exports
+// run in the background and are downloaded through a signed link.
+type DataExportService struct {
+	exportRepo        *database.DataExportRepository
+	userRepo          *database.UserRepository
+	sessionRepo       *database.SessionRepository
+	mfaRepo           *database.MFARepository
+	webAuthnRepo      *database.WebAuthnRepository
+	patRepo           *database.PersonalAccessTokenRepository
+	roleRepo          *database.RoleRepository
+	auditLogRepo      *database.AuditLogRepository
+	securityEventRepo *database.SecurityEventRepository
+	oauthClientRepo   *database.OAuthClientRepository
+	mailer            mailer.Mailer
+	signer            *utils.URLSigner
+	httpClient        *http.Client
+	// maxSourceSize is maxExportSourceSize, lowered by tests
+	maxSourceSize int64
+	cfg           configs.ExportConfig
+}
+
+func NewDataExportService(
+	exportRepo *database.DataExportRepository,
+	userRepo *database.UserRepository,
+	sessionRepo *database.SessionRepository,
+	mfaRepo *database.MFARepository,
+	webAuthnRepo *database.WebAuthnRepository,
+	patRepo *database.PersonalAccessTokenRepository,
+	roleRepo *database.RoleRepository,
+	auditLogRepo *database.AuditLogRepository,
+	securityEventRepo *database.SecurityEventRepository,
+	oauthClientRepo *database.OAuthClientRepository,
+	mailer mailer.Mailer,
+	cfg configs.ExportConfig,
+) *DataExportService {
+	return &DataExportService{
+		exportRepo:        exportRepo,
+		userRepo:          userRepo,
+		sessionRepo:       sessionRepo,
+		mfaRepo:           mfaRepo,
+		webAuthnRepo:      webAuthnRepo,
+		patRepo:           patRepo,
+		roleRepo:          roleRepo,
+		auditLogRepo:      auditLogRepo,
+		securityEventRepo: securityEventRepo,
+		oauthClientRepo:   oauthClientRepo,
+		mailer:            mailer,
+		signer:            utils.NewURLSigner(cfg.SigningKey),
+		httpClient:        &http.Client{Timeout: 30 * time.Second},
+		maxSourceSize:     maxExportSourceSize,
+		cfg:               cfg,
+	}
+}
+
+// RequestExport queues an export of the user's data
+func (s *DataExportService) RequestExport(userID uuid.UUID) (*models.DataExport, error) {
+	export, err := s.exportRepo.Create(userID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil {
+		return nil, fmt.Errorf("export already in progress")
+	}
+	return export, nil
+}
+
+// ListExports returns the user's exports, newest first, with a download link
+// for those that are ready
+func (s *DataExportService) ListExports(userID uuid.UUID) ([]models.DataExport, error) {
+	exports, err := s.exportRepo.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range exports {
+		if exports[i].Downloadable(now) {
+			exports[i].DownloadURL = s.downloadURL(&exports[i])
+		}
+	}
+
+	return exports, nil
+}
+
+// OpenDownload checks a download link and returns the archive it points to.
+// The link is the credential, so it works without signing in.
+func (s *DataExportService) OpenDownload(id uuid.UUID, expires, signature string) (*models.DataExport, error) {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !s.signer.Verify(exportResource(id), time.Unix(expiresUnix, 0), signature) {
+		return nil, fmt.Errorf("invalid download link")
+	}
+
+	export, err := s.exportRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || !export.Downloadable(time.Now()) {
+		return nil, fmt.Errorf("export expired")
+	}
+
+	return export, nil
+}
+
+// ProcessNext builds the oldest pending export. It returns false when the
+// queue is empty.
+func (s *DataExportService) ProcessNext(ctx context.Context) (bool, error) {
+	export, err := s.exportRepo.ClaimNext(time.Now().Add(-staleExportAfter))
+	if err != nil {
+		return false, err
+	}
+	if export == nil {
+		return false, nil
+	}
+
+	user, err := s.userRepo.GetByID(export.UserID)
+	if err != nil {
+		return true, err
+	}
+	if user == nil {
+		return true, s.exportRepo.Fail(export.ID, "user not found")
+	}
+
+	filePath, err := s.buildArchive(ctx, export, user)
+	if err != nil {
+		// The user sees only which step failed, details stay in the logs
+		log.Printf("Failed to build data export %s: %v", export.ID, err)
+		return true, s.exportRepo.Fail(export.ID, exportFailureReason(err))
+	}
+
+	expiresAt := time.Now().Add(s.cfg.TTL)
+	if err := s.exportRepo.Complete(export.ID, filePath, expiresAt); err != nil {
+		os.Remove(filePath)
+		return true, err
+	}
+	export.Status = models.DataExportCompleted
+	export.FilePath = &filePath
+	export.ExpiresAt = &expiresAt
+
+	if err := s.sendReadyNotice(ctx, user, export); err != nil {
+		log.Println("Failed to send data export email:", err)
+	}
+
+	return true, nil
+}
+
+// PurgeExpired removes archives whose download window is over, and those
+// left behind by exports that no longer exist, e.g. of purged accounts
+func (s *DataExportService) PurgeExpired() error {
+	exports, err := s.exportRepo.ListExpired()
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.FilePath != nil {
+			if err := os.Remove(*export.FilePath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove data export archive: %w", err)
+			}
+		}
+		if err := s.exportRepo.MarkExpired(export.ID); err != nil {
+			return err
+		}
+	}
+
+	return s.purgeOrphanedArchives()
+}
+
+func (s *DataExportService) purgeOrphanedArchives() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read export directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		id, err := uuid.Parse(strings.TrimSuffix(entry.Name(), ".zip"))
+		if err != nil || entry.IsDir() {
+			continue
+		}
+
+		export, err := s.exportRepo.GetByID(id)
+		if err != nil {
+			return err
+		}
+		if export != nil && export.Status != models.DataExportExpired && export.Status != models.DataExportFailed {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.cfg.Dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove data export archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// exportStepError names the part of an export that failed
+type exportStepError struct {
+	step string
+	err  error
+}
+
+func (e *exportStepError) Error() string {
+	return e.step + ": " + e.err.Error()
+}
+
+func (e *exportStepError) Unwrap() error {
+	return e.err
+}
+
+func exportFailureReason(err error) string {
+	if stepErr, ok := err.(*exportStepError); ok {
+		return "failed to export " + stepErr.step
+	}
+	return "failed to write archive"
+}
+
+// buildArchive writes the export's ZIP and returns its path. The archive is
+// written under a temporary name so a crash never leaves a partial file
+// behind a completed export.
+func (s *DataExportService) buildArchive(ctx context.Context, export *models.DataExport, user *models.User) (string, error) {
+	files, err := s.collectFiles(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.cfg.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	filePath := filepath.Join(s.cfg.Dir, export.ID.String()+".zip")
+	tmpPath := filePath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	archive := zip.NewWriter(file)
+	manifest := models.DataExportManifest{
+		ExportID:    export.ID,
+		UserID:      user.ID,
+		GeneratedAt: time.Now().UTC(),
+	}
+	for _, exportFile := range files {
+		manifest.Files = append(manifest.Files, exportFile.name)
+	}
+	files = append([]exportFile{{"manifest.json", manifest}}, files...)
+
+	for _, exportFile := range files {
+		data, err := json.MarshalIndent(exportFile.value, "", "  ")
+		if err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to encode %s: %w", exportFile.name, err)
+		}
+		writer, err := archive.Create(exportFile.name)
+		if err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to add %s to archive: %w", exportFile.name, err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to write %s to archive: %w", exportFile.name, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return "", fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	return filePath, nil
+}
+
+// exportFile is a file of the archive, value is encoded as indented JSON
+type exportFile struct {
+	name  string
+	value interface{}
+}
+
+// collectFiles gathers the user's data from this service and from every
+// configured source
+func (s *DataExportService) collectFiles(ctx context.Context, user *models.User) ([]exportFile, error) {
+	access, err := s.roleRepo.GetUserAccess(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"account", err}
+	}
+
+	// Remove password hash from export
+	hasPassword := user.PasswordHash != ""
+	user.PasswordHash = ""
+
+	files := []exportFile{
+		{"account.json", &models.AccountExport{User: user, HasPassword: hasPassword, Roles: access.Roles}},
+		{"identities.json", user.OAuthProviders},
+	}
+
+	usernames, err := s.userRepo.ListUsernameHistory(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"username history", err}
+	}
+	files = append(files, exportFile{"username_history.json", usernames})
+
+	sessions, err := s.sessionRepo.ListByUserID(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"sessions", err}
+	}
+	sessionExports := make([]models.SessionExport, 0, len(sessions))
+	for _, session := range sessions {
+		sessionExports = append(sessionExports, models.SessionExport{Session: session, RevokedAt: session.RevokedAt})
+	}
+	files = append(files, exportFile{"sessions.json", sessionExports})
+
+	security, err := s.collectSecurity(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"security settings", err}
+	}
+	files = append(files, exportFile{"security.json", security})
+
+	events, err := s.securityEventRepo.ListByUserID(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"security events", err}
+	}
+	files = append(files, exportFile{"security_events.json", events})
+
+	actions, err := s.collectAdminActions(user.ID)
+	if err != nil {
+		return nil, &exportStepError{"admin actions", err}
+	}
+	files = append(files, exportFile{"admin_actions.json", actions})
+
+	for _, source := range s.cfg.Sources {
+		data, err := s.fetchSource(ctx, source, user.ID)
+		if err != nil {
+			return nil, &exportStepError{source.Name + " data", err}
+		}
+		if data != nil {
+			files = append(files, exportFile{"services/" + source.Name + ".json", json.RawMessage(data)})
+		}
+	}
+
+	return files, nil
+}
+
+func (s *DataExportService) collectSecurity(userID uuid.UUID) (*models.SecurityExport, error) {
+	security := &models.SecurityExport{}
+
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa != nil {
+		security.TOTPEnabledAt = mfa.TOTPEnabledAt
+	}
+
+	if security.Passkeys, err = s.webAuthnRepo.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if security.PersonalAccessTokens, err = s.patRepo.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if security.AuthorizedApps, err = s.oauthClientRepo.ListConsents(userID); err != nil {
+		return nil, err
+	}
+
+	return security, nil
+}
+
+// collectAdminActions returns what admins did to the account. Which admin it
+// was and their IP address are theirs, not the user's, and are left out.
+func (s *DataExportService) collectAdminActions(userID uuid.UUID) ([]models.AdminActionExport, error) {
+	entries, err := s.auditLogRepo.ListForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]models.AdminActionExport, 0, len(entries))
+	for _, entry := range entries {
+		actions = append(actions, models.AdminActionExport{
+			Action:    entry.Action,
+			Details:   entry.Details,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+	return actions, nil
+}
+
+// fetchSource asks another service for its data about the user. The
+// contract: GET the source URL with {user_id} filled in, authenticated with
+// the internal API token, answering 200 with a JSON document or 404 when the
+// service holds nothing about the user.
+func (s *DataExportService) fetchSource(ctx context.Context, source configs.ExportSource, userID uuid.UUID) ([]byte, error) {
+	sourceURL := strings.ReplaceAll(source.URL, "{user_id}", url.PathEscape(userID.String()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.InternalToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.InternalToken)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.maxSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxSourceSize {
+		return nil, fmt.Errorf("response larger than %d bytes", s.maxSourceSize)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("response is not valid JSON")
+	}
+
+	return data, nil
+}
+
+func (s *DataExportService) sendReadyNotice(ctx context.Context, user *models.User, export *models.DataExport) error {
+	return s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe copy of your data you requested is ready. Download it here:\n\n%s\n\nThe link expires on %s. Anyone with the link can download the archive, so don't share it.\n",
+			user.DisplayName,
+			s.downloadURL(export),
+			export.ExpiresAt.UTC().Format("January 2, 2006 at 15:04 UTC"),
+		),
+	})
+}
+
+// downloadURL returns the signed link to the export's archive, valid until
+// the archive expires
+func (s *DataExportService) downloadURL(export *models.DataExport) string {
+	query := url.Values{
+		"expires":   {strconv.FormatInt(export.ExpiresAt.Unix(), 10)},
+		"signature": {s.signer.Sign(exportResource(export.ID), *export.ExpiresAt)},
+	}
+	return fmt.Sprintf("%s/api/v1/users/exports/%s/download?%s", strings.TrimRight(s.cfg.BaseURL, "/"), export.ID, query.Encode())
+}
+
+func exportResource(id uuid.UUID) string {
+	return "data-export:" + id.String()
+}
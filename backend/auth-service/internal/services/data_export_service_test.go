@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/google/uuid"
+)
+
+var dataExportColumns = []string{"id", "user_id", "status", "file_path", "error", "created_at", "started_at", "completed_at", "expires_at"}
+
+func newDataExportService(t *testing.T, cfg configs.ExportConfig) (*DataExportService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	if cfg.SigningKey == "" {
+		cfg.SigningKey = "export-signing-key"
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+
+	return NewDataExportService(
+		database.NewDataExportRepository(db),
+		database.NewUserRepository(db),
+		database.NewSessionRepository(db),
+		database.NewMFARepository(db),
+		database.NewWebAuthnRepository(db),
+		database.NewPersonalAccessTokenRepository(db),
+		database.NewRoleRepository(db),
+		database.NewAuditLogRepository(db),
+		database.NewSecurityEventRepository(db),
+		database.NewOAuthClientRepository(db),
+		newRecordingMailer(),
+		cfg,
+	), mock
+}
+
+// exportRow returns the export as stored, nil FilePath and ExpiresAt are NULL
+func exportRow(export *models.DataExport) *sqlmock.Rows {
+	return sqlmock.NewRows(dataExportColumns).AddRow(
+		export.ID, export.UserID, export.Status, export.FilePath, nil,
+		time.Now().Add(-time.Hour), nil, nil, export.ExpiresAt)
+}
+
+func TestOpenDownload(t *testing.T) {
+	filePath := "/exports/archive.zip"
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	past := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	tests := []struct {
+		name string
+		// link changes the expires and signature parameters of a valid link
+		link func(expires, signature string) (string, string)
+		// stored is the export as stored, nil if there is none
+		stored  *models.DataExport
+		wantErr string
+	}{
+		{
+			name:   "valid link",
+			stored: &models.DataExport{Status: models.DataExportCompleted, FilePath: &filePath, ExpiresAt: &expiresAt},
+		},
+		{
+			name: "tampered signature",
+			link: func(expires, signature string) (string, string) {
+				return expires, strings.Repeat("0", len(signature))
+			},
+			wantErr: "invalid download link",
+		},
+		{
+			name: "extended expiry",
+			link: func(expires, signature string) (string, string) {
+				return "99999999999", signature
+			},
+			wantErr: "invalid download link",
+		},
+		{
+			name: "malformed expiry",
+			link: func(expires, signature string) (string, string) {
+				return "tomorrow", signature
+			},
+			wantErr: "invalid download link",
+		},
+		{
+			name:    "export gone",
+			wantErr: "export expired",
+		},
+		{
+			name:    "archive already purged",
+			stored:  &models.DataExport{Status: models.DataExportExpired, ExpiresAt: &past},
+			wantErr: "export expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newDataExportService(t, configs.ExportConfig{BaseURL: "https://auth.example"})
+			id := uuid.New()
+
+			link, err := url.Parse(service.downloadURL(&models.DataExport{ID: id, ExpiresAt: &expiresAt}))
+			if err != nil {
+				t.Fatalf("parse download URL: %v", err)
+			}
+			if want := "/api/v1/users/exports/" + id.String() + "/download"; link.Path != want {
+				t.Errorf("download path = %s, want %s", link.Path, want)
+			}
+			expires, signature := link.Query().Get("expires"), link.Query().Get("signature")
+			if tt.link != nil {
+				expires, signature = tt.link(expires, signature)
+			}
+
+			if tt.wantErr != "invalid download link" {
+				rows := sqlmock.NewRows(dataExportColumns)
+				if tt.stored != nil {
+					tt.stored.ID = id
+					rows = exportRow(tt.stored)
+				}
+				mock.ExpectQuery(`FROM data_exports WHERE id`).WithArgs(id).WillReturnRows(rows)
+			}
+
+			export, err := service.OpenDownload(id, expires, signature)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("OpenDownload = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenDownload: %v", err)
+			}
+			if export.FilePath == nil || *export.FilePath != filePath {
+				t.Errorf("file path = %v, want %s", export.FilePath, filePath)
+			}
+		})
+	}
+}
+
+// A link whose signature was valid stops working once it expires
+func TestOpenDownloadExpiredLink(t *testing.T) {
+	service, _ := newDataExportService(t, configs.ExportConfig{})
+	id := uuid.New()
+	expiresAt := time.Now().Add(-time.Second)
+
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	_, err := service.OpenDownload(id, expires, service.signer.Sign(exportResource(id), expiresAt))
+	if err == nil || err.Error() != "invalid download link" {
+		t.Fatalf("OpenDownload = %v, want invalid download link", err)
+	}
+}
+
+func TestProcessNext(t *testing.T) {
+	t.Run("empty queue", func(t *testing.T) {
+		service, mock := newDataExportService(t, configs.ExportConfig{})
+		mock.ExpectQuery(`UPDATE data_exports SET status = 'running'`).
+			WillReturnRows(sqlmock.NewRows(dataExportColumns))
+
+		processed, err := service.ProcessNext(context.Background())
+		if processed || err != nil {
+			t.Errorf("ProcessNext = %v, %v, want false, nil", processed, err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		// expectUser sets up the lookups of the export's user until the
+		// step that fails
+		expectUser func(mock sqlmock.Sqlmock, userID uuid.UUID)
+		wantReason string
+	}{
+		{
+			name: "user gone",
+			expectUser: func(mock sqlmock.Sqlmock, userID uuid.UUID) {
+				mock.ExpectQuery(`FROM users WHERE id`).WithArgs(userID).
+					WillReturnRows(sqlmock.NewRows(userColumns))
+			},
+			wantReason: "user not found",
+		},
+		{
+			name: "step fails",
+			expectUser: func(mock sqlmock.Sqlmock, userID uuid.UUID) {
+				mock.ExpectQuery(`FROM users WHERE id`).WithArgs(userID).
+					WillReturnRows(sqlmock.NewRows(userColumns).
+						AddRow(userID, "alice", "Alice", "alice@example.com", "password-hash", nil, time.Now()))
+				mock.ExpectQuery(`FROM user_roles WHERE user_id`).WithArgs(userID).
+					WillReturnError(errors.New("connection reset by peer"))
+			},
+			// Only the step reaches the user, not the database error
+			wantReason: "failed to export account",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newDataExportService(t, configs.ExportConfig{})
+			export := &models.DataExport{ID: uuid.New(), UserID: uuid.New(), Status: models.DataExportRunning}
+
+			mock.ExpectQuery(`UPDATE data_exports SET status = 'running'`).
+				WillReturnRows(exportRow(export))
+			tt.expectUser(mock, export.UserID)
+			mock.ExpectExec(`UPDATE data_exports SET status = 'failed'`).
+				WithArgs(export.ID, tt.wantReason).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			processed, err := service.ProcessNext(context.Background())
+			if !processed || err != nil {
+				t.Errorf("ProcessNext = %v, %v, want true, nil", processed, err)
+			}
+
+			entries, _ := os.ReadDir(service.cfg.Dir)
+			if len(entries) != 0 {
+				t.Errorf("failed export left %d files behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	dir := t.TempDir()
+	service, mock := newDataExportService(t, configs.ExportConfig{Dir: dir})
+	mock.MatchExpectationsInOrder(false)
+
+	archive := func(id uuid.UUID) string {
+		path := filepath.Join(dir, id.String()+".zip")
+		if err := os.WriteFile(path, []byte("zip"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	lookup := func(id uuid.UUID, status string) {
+		rows := sqlmock.NewRows(dataExportColumns)
+		if status != "" {
+			rows = exportRow(&models.DataExport{ID: id, UserID: uuid.New(), Status: status})
+		}
+		mock.ExpectQuery(`FROM data_exports WHERE id`).WithArgs(id).WillReturnRows(rows)
+	}
+
+	// Past its download window
+	expired := &models.DataExport{ID: uuid.New(), UserID: uuid.New(), Status: models.DataExportCompleted}
+	expiredPath := archive(expired.ID)
+	expired.FilePath = &expiredPath
+	// Listed as expired although its archive is already gone
+	removed := &models.DataExport{ID: uuid.New(), UserID: uuid.New(), Status: models.DataExportCompleted}
+	removedPath := filepath.Join(dir, removed.ID.String()+".zip")
+	removed.FilePath = &removedPath
+
+	mock.ExpectQuery(`FROM data_exports\s+WHERE status = 'completed' AND expires_at`).
+		WillReturnRows(exportRow(expired).AddRow(removed.ID, removed.UserID, removed.Status, removed.FilePath, nil, time.Now(), nil, nil, nil))
+	for _, export := range []*models.DataExport{expired, removed} {
+		mock.ExpectExec(`UPDATE data_exports SET status = 'expired'`).
+			WithArgs(export.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// Orphans: the export was deleted with its account, or it failed
+	// after writing the archive
+	orphanID, failedID, readyID := uuid.New(), uuid.New(), uuid.New()
+	orphanPath, failedPath, readyPath := archive(orphanID), archive(failedID), archive(readyID)
+	lookup(orphanID, "")
+	lookup(failedID, models.DataExportFailed)
+	lookup(readyID, models.DataExportCompleted)
+
+	// Files that aren't archives are left alone
+	otherPath := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(otherPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.PurgeExpired(); err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+
+	for path, wantKept := range map[string]bool{
+		expiredPath: false,
+		orphanPath:  false,
+		failedPath:  false,
+		readyPath:   true,
+		otherPath:   true,
+	} {
+		_, err := os.Stat(path)
+		if kept := err == nil; kept != wantKept {
+			t.Errorf("%s kept = %v, want %v", filepath.Base(path), kept, wantKept)
+		}
+	}
+}
+
+func TestPurgeExpiredWithoutDirectory(t *testing.T) {
+	service, mock := newDataExportService(t, configs.ExportConfig{Dir: filepath.Join(t.TempDir(), "missing")})
+	mock.ExpectQuery(`FROM data_exports\s+WHERE status = 'completed' AND expires_at`).
+		WillReturnRows(sqlmock.NewRows(dataExportColumns))
+
+	if err := service.PurgeExpired(); err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+}
+
+func TestFetchSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantData string
+		wantErr  string
+	}{
+		{name: "data", status: http.StatusOK, body: `{"threads":[]}`, wantData: `{"threads":[]}`},
+		{name: "nothing about the user", status: http.StatusNotFound, body: `{"error":"not found"}`},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "unexpected status 500"},
+		{name: "oversize", status: http.StatusOK, body: `{"threads":["` + strings.Repeat("a", 64) + `"]}`, wantErr: "response larger than 32 bytes"},
+		{name: "invalid JSON", status: http.StatusOK, body: `{"threads":`, wantErr: "response is not valid JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/internal/users/"+userID.String()+"/export" {
+					http.NotFound(w, r)
+					return
+				}
+				if r.Header.Get("Authorization") != "Bearer internal-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(server.Close)
+
+			service, _ := newDataExportService(t, configs.ExportConfig{InternalToken: "internal-token"})
+			service.maxSourceSize = 32
+			source := configs.ExportSource{Name: "threads", URL: server.URL + "/internal/users/{user_id}/export"}
+
+			data, err := service.fetchSource(context.Background(), source, userID)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("fetchSource = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchSource: %v", err)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("data = %q, want %q", data, tt.wantData)
+			}
+		})
+	}
+}
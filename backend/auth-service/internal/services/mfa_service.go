@@ -0,0 +1,306 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+const recoveryCodeCount = 10
+
+type MFAService struct {
+	mfaRepo     *database.MFARepository
+	userRepo    *database.UserRepository
+	authService *AuthService
+	jwtManager  *utils.JWTManager
+	secretBox   *utils.SecretBox
+	guard       *lockout.Guard
+	issuer      string
+}
+
+func NewMFAService(
+	mfaRepo *database.MFARepository,
+	userRepo *database.UserRepository,
+	authService *AuthService,
+	jwtManager *utils.JWTManager,
+	secretBox *utils.SecretBox,
+	guard *lockout.Guard,
+	issuer string,
+) *MFAService {
+	return &MFAService{
+		mfaRepo:     mfaRepo,
+		userRepo:    userRepo,
+		authService: authService,
+		jwtManager:  jwtManager,
+		secretBox:   secretBox,
+		guard:       guard,
+		issuer:      issuer,
+	}
+}
+
+func (s *MFAService) GetStatus(userID uuid.UUID) (*models.MFAStatusResponse, error) {
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA status: %w", err)
+	}
+
+	status := &models.MFAStatusResponse{
+		TOTPEnabled: mfa != nil && mfa.TOTPEnabledAt != nil,
+	}
+
+	if status.TOTPEnabled {
+		status.RecoveryCodesRemaining, err = s.mfaRepo.CountRecoveryCodes(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+		}
+	}
+
+	return status, nil
+}
+
+// SetupTOTP starts enrollment with a fresh secret. It takes effect once
+// confirmed with EnableTOTP.
+func (s *MFAService) SetupTOTP(userID uuid.UUID) (*models.TOTPSetupResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA status: %w", err)
+	}
+	if mfa != nil && mfa.TOTPEnabledAt != nil {
+		return nil, fmt.Errorf("mfa already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := s.secretBox.Seal(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+
+	if err := s.mfaRepo.SavePendingTOTP(userID, encryptedSecret); err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// EnableTOTP confirms a pending enrollment and returns the first set of recovery codes
+func (s *MFAService) EnableTOTP(userID uuid.UUID, req *models.MFACodeRequest) (*models.RecoveryCodesResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA status: %w", err)
+	}
+	if mfa == nil {
+		return nil, fmt.Errorf("mfa setup not started")
+	}
+	if mfa.TOTPEnabledAt != nil {
+		return nil, fmt.Errorf("mfa already enabled")
+	}
+
+	secret, err := s.secretBox.Open(mfa.TOTPSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	step, ok := utils.ValidateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("invalid mfa code")
+	}
+
+	enabled, err := s.mfaRepo.EnableTOTP(userID, step)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, fmt.Errorf("mfa already enabled")
+	}
+	s.authService.RecordSecurityEvent(userID, models.SecurityEventTOTPEnable, nil)
+
+	return s.replaceRecoveryCodes(userID)
+}
+
+// DisableTOTP removes two-factor authentication after checking a current code
+func (s *MFAService) DisableTOTP(userID uuid.UUID, req *models.DisableMFARequest) error {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if err := s.verifySecondFactor(userID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := s.mfaRepo.Delete(userID); err != nil {
+		return err
+	}
+
+	s.authService.RecordSecurityEvent(userID, models.SecurityEventTOTPDisable, nil)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a current TOTP code
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID, req *models.MFACodeRequest) (*models.RecoveryCodesResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	if err := s.verifySecondFactor(userID, req.Code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.replaceRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.authService.RecordSecurityEvent(userID, models.SecurityEventRecoveryCodesRenew, nil)
+	return codes, nil
+}
+
+// VerifyChallenge completes a login that was paused for two-factor authentication
+func (s *MFAService) VerifyChallenge(req *models.MFAVerifyRequest, client *models.ClientInfo) (*models.AuthResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	claims, err := s.jwtManager.ValidateActionToken(req.MFAToken, models.TokenPurposeMFAChallenge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+	if err := s.authService.CheckMFAChallenge(req.MFAToken, claims.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(claims.UserID, req.Code, req.RecoveryCode); err != nil {
+		return nil, err
+	}
+
+	return s.authService.CompleteMFAChallenge(req.MFAToken, claims.UserID, client)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// Failed codes count towards a lock on the user's second factor, since six
+// digit codes are otherwise easy to guess.
+func (s *MFAService) verifySecondFactor(userID uuid.UUID, code, recoveryCode string) error {
+	attemptKey := lockout.MFA(userID)
+	if err := s.guard.Check(attemptKey); err != nil {
+		return err
+	}
+
+	err := s.checkSecondFactor(userID, code, recoveryCode)
+	switch {
+	case err == nil:
+		s.guard.Succeed(attemptKey)
+	case err.Error() == "invalid mfa code":
+		s.guard.Fail(attemptKey)
+	}
+	return err
+}
+
+func (s *MFAService) checkSecondFactor(userID uuid.UUID, code, recoveryCode string) error {
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get MFA status: %w", err)
+	}
+	if mfa == nil || mfa.TOTPEnabledAt == nil {
+		return fmt.Errorf("mfa not enabled")
+	}
+
+	if recoveryCode != "" {
+		used, err := s.mfaRepo.UseRecoveryCode(userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return fmt.Errorf("invalid mfa code")
+		}
+		return nil
+	}
+
+	secret, err := s.secretBox.Open(mfa.TOTPSecret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return fmt.Errorf("invalid mfa code")
+	}
+
+	// Reject a code that was already used, even within its validity window
+	fresh, err := s.mfaRepo.UseTOTPStep(userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return fmt.Errorf("invalid mfa code")
+	}
+
+	return nil
+}
+
+func (s *MFAService) replaceRecoveryCodes(userID uuid.UUID) (*models.RecoveryCodesResponse, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// generateRecoveryCode returns a code like "k3fq7-x2mzd"
+func generateRecoveryCode() (string, error) {
+	bytes := make([]byte, 7)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode normalizes user input before hashing so that case and
+// separators don't matter
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashToken(normalized)
+}
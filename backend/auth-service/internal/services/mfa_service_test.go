@@ -0,0 +1,211 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+// totpCode computes the RFC 6238 code of the secret for a time step
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+type mfaTest struct {
+	service *MFAService
+	mock    sqlmock.Sqlmock
+	userID  uuid.UUID
+	secret  string
+	sealed  string
+}
+
+func newMFATest(t *testing.T) *mfaTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	secretBox, err := utils.NewSecretBox("test-mfa-key")
+	if err != nil {
+		t.Fatalf("NewSecretBox: %v", err)
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	sealed, err := secretBox.Seal(secret)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	guard := lockout.NewGuard(lockout.NewMemoryStore(), map[string]lockout.Policy{
+		lockout.ScopeMFA: {Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	})
+
+	return &mfaTest{
+		service: &MFAService{
+			mfaRepo:     database.NewMFARepository(db),
+			authService: &AuthService{oneTimeTokenRepo: database.NewOneTimeTokenRepository(db)},
+			secretBox:   secretBox,
+			guard:       guard,
+		},
+		mock:   mock,
+		userID: uuid.New(),
+		secret: secret,
+		sealed: sealed,
+	}
+}
+
+func (m *mfaTest) expectEnrollment() {
+	enabledAt := time.Now().Add(-time.Hour)
+	m.mock.ExpectQuery(`FROM user_mfa WHERE user_id`).
+		WithArgs(m.userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "totp_secret", "totp_enabled_at", "totp_last_used_step", "created_at"}).
+			AddRow(m.userID, m.sealed, enabledAt, nil, enabledAt))
+}
+
+func TestVerifySecondFactorTOTP(t *testing.T) {
+	current := time.Now().Unix() / 30
+
+	tests := []struct {
+		name string
+		step int64
+		// fresh is whether the step is later than the last used one, nil
+		// when the code is rejected before the step is recorded
+		fresh   *bool
+		code    func(m *mfaTest) string
+		wantErr string
+	}{
+		{name: "current step", step: current, fresh: boolPtr(true)},
+		{name: "previous step within skew", step: current - 1, fresh: boolPtr(true)},
+		{name: "replayed step", step: current, fresh: boolPtr(false), wantErr: "invalid mfa code"},
+		{name: "step outside skew", step: current - 3, wantErr: "invalid mfa code"},
+		{
+			name:    "malformed code",
+			code:    func(*mfaTest) string { return "12345" },
+			wantErr: "invalid mfa code",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMFATest(t)
+			code := totpCode(t, m.secret, tt.step)
+			if tt.code != nil {
+				code = tt.code(m)
+			}
+
+			m.expectEnrollment()
+			if tt.fresh != nil {
+				affected := int64(0)
+				if *tt.fresh {
+					affected = 1
+				}
+				m.mock.ExpectExec(`UPDATE user_mfa SET totp_last_used_step`).
+					WithArgs(m.userID, tt.step).
+					WillReturnResult(sqlmock.NewResult(0, affected))
+			}
+
+			err := m.service.verifySecondFactor(m.userID, code, "")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("verifySecondFactor = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("verifySecondFactor = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A code is accepted once, using it again within its validity window fails
+// and counts towards the lock of the second factor
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	m := newMFATest(t)
+	step := time.Now().Unix() / 30
+	code := totpCode(t, m.secret, step)
+
+	m.expectEnrollment()
+	m.mock.ExpectExec(`UPDATE user_mfa SET totp_last_used_step`).
+		WithArgs(m.userID, step).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := m.service.verifySecondFactor(m.userID, code, ""); err != nil {
+		t.Fatalf("first use = %v, want nil", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		m.expectEnrollment()
+		m.mock.ExpectExec(`UPDATE user_mfa SET totp_last_used_step`).
+			WithArgs(m.userID, step).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		if err := m.service.verifySecondFactor(m.userID, code, ""); err == nil || err.Error() != "invalid mfa code" {
+			t.Fatalf("replay %d = %v, want invalid mfa code", i+1, err)
+		}
+	}
+
+	// The lock applies before the code is even checked
+	var locked *lockout.LockedError
+	if err := m.service.verifySecondFactor(m.userID, code, ""); !errors.As(err, &locked) {
+		t.Fatalf("after replays = %v, want *lockout.LockedError", err)
+	}
+}
+
+// A challenge that was already completed is turned away before the recovery
+// code that comes with it is spent
+func TestVerifyChallengeReplayKeepsRecoveryCode(t *testing.T) {
+	m := newMFATest(t)
+	m.service.jwtManager = newTestJWTManager(t)
+	recoveryCode := "k3fq7-x2mzd"
+
+	mfaToken, err := m.service.jwtManager.GenerateActionToken(m.userID, models.TokenPurposeMFAChallenge, "", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateActionToken: %v", err)
+	}
+
+	// The challenge was used by the first login
+	m.mock.ExpectQuery(`FROM one_time_tokens`).
+		WithArgs(utils.HashToken(mfaToken), models.TokenPurposeMFAChallenge).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose", "token_hash", "payload", "expires_at", "used_at", "created_at"}))
+
+	_, err = m.service.VerifyChallenge(&models.MFAVerifyRequest{MFAToken: mfaToken, RecoveryCode: recoveryCode}, nil)
+	if err == nil || err.Error() != "invalid mfa token" {
+		t.Fatalf("replayed challenge = %v, want invalid mfa token", err)
+	}
+
+	// The recovery code is still there for the user's next login
+	m.expectEnrollment()
+	m.mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at`).
+		WithArgs(m.userID, hashRecoveryCode(recoveryCode)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := m.service.verifySecondFactor(m.userID, "", recoveryCode); err != nil {
+		t.Fatalf("recovery code after replay = %v, want nil", err)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,499 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+// authorizationCodeTTL bounds the trip of an authorization code from the
+// consent page to the client's token request
+const authorizationCodeTTL = 5 * time.Minute
+
+// OAuth grant types accepted by the token endpoint
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+)
+
+// OAuthServerService lets third-party apps sign users in with the
+// authorization code grant and PKCE, and call APIs within the scopes the
+// user granted them
+type OAuthServerService struct {
+	clientRepo       *database.OAuthClientRepository
+	userRepo         *database.UserRepository
+	oneTimeTokenRepo *database.OneTimeTokenRepository
+	authService      *AuthService
+	jwtManager       *utils.JWTManager
+	authorizeURL     string
+}
+
+func NewOAuthServerService(
+	clientRepo *database.OAuthClientRepository,
+	userRepo *database.UserRepository,
+	oneTimeTokenRepo *database.OneTimeTokenRepository,
+	authService *AuthService,
+	jwtManager *utils.JWTManager,
+	authorizeURL string,
+) *OAuthServerService {
+	return &OAuthServerService{
+		clientRepo:       clientRepo,
+		userRepo:         userRepo,
+		oneTimeTokenRepo: oneTimeTokenRepo,
+		authService:      authService,
+		jwtManager:       jwtManager,
+		authorizeURL:     authorizeURL,
+	}
+}
+
+// RegisterClient creates a client owned by the user. The secret of a
+// confidential client is returned once and only its hash is stored.
+func (s *OAuthServerService) RegisterClient(ownerID uuid.UUID, req *models.OAuthClientRequest) (*models.OAuthClientCreatedResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	for _, redirectURI := range req.RedirectURIs {
+		if !validClientRedirectURI(redirectURI) {
+			return nil, fmt.Errorf("invalid redirect uri")
+		}
+	}
+
+	scopes := normalizeScopes(req.Scopes)
+	if len(scopes) == 0 {
+		for _, scope := range models.SupportedScopes {
+			scopes = append(scopes, scope.Name)
+		}
+	}
+
+	clientID, err := utils.GenerateOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       scopes,
+		Public:       req.Public,
+		OwnerID:      ownerID,
+	}
+
+	var clientSecret string
+	if !req.Public {
+		clientSecret, err = utils.GenerateOpaqueToken(32)
+		if err != nil {
+			return nil, err
+		}
+		secretHash := utils.HashToken(clientSecret)
+		client.ClientSecretHash = &secretHash
+	}
+
+	createdClient, err := s.clientRepo.Create(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthClientCreatedResponse{
+		Client:       createdClient,
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+func (s *OAuthServerService) ListClients(ownerID uuid.UUID) ([]models.OAuthClient, error) {
+	return s.clientRepo.ListByOwner(ownerID)
+}
+
+// DeleteClient removes a client of the user. Every token issued to it stops
+// working.
+func (s *OAuthServerService) DeleteClient(ownerID, id uuid.UUID) error {
+	client, err := s.clientRepo.Delete(ownerID, id)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("oauth client not found")
+	}
+	return nil
+}
+
+// GetConsent checks an authorization request and describes it for the
+// consent page
+func (s *OAuthServerService) GetConsent(userID uuid.UUID, req *models.OAuthAuthorizeRequest) (*models.OAuthConsentResponse, error) {
+	client, scopes, err := s.checkAuthorizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	consent, err := s.clientRepo.GetConsent(userID, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.OAuthConsentResponse{
+		ClientID:     client.ClientID,
+		ClientName:   client.Name,
+		RedirectURI:  req.RedirectURI,
+		ConsentGiven: consent != nil && containsScopes(consent.Scopes, scopes),
+	}
+	for _, scope := range models.SupportedScopes {
+		if slices.Contains(scopes, scope.Name) {
+			response.Scopes = append(response.Scopes, scope)
+		}
+	}
+
+	return response, nil
+}
+
+// Authorize records the user's answer to the consent page and returns where
+// to send the browser: the client's redirect URI with an authorization code,
+// or with access_denied when the user declined.
+func (s *OAuthServerService) Authorize(userID uuid.UUID, authTime time.Time, req *models.OAuthAuthorizeRequest) (*models.OAuthAuthorizeResponse, error) {
+	client, scopes, err := s.checkAuthorizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.Approve {
+		return &models.OAuthAuthorizeResponse{
+			RedirectTo: clientRedirect(req.RedirectURI, url.Values{
+				"error": {"access_denied"},
+				"state": {req.State},
+			}),
+		}, nil
+	}
+
+	if err := s.clientRepo.SaveConsent(userID, client.ClientID, scopes); err != nil {
+		return nil, err
+	}
+
+	code, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    userID,
+		Purpose:   models.TokenPurposeAuthorizationCode,
+		TokenHash: utils.HashToken(code),
+		Payload: models.TokenPayload{
+			"client_id":      client.ClientID,
+			"redirect_uri":   req.RedirectURI,
+			"scope":          strings.Join(scopes, " "),
+			"code_challenge": req.CodeChallenge,
+			"nonce":          req.Nonce,
+			"auth_time":      strconv.FormatInt(authTime.Unix(), 10),
+		},
+		ExpiresAt: time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store authorization code: %w", err)
+	}
+
+	return &models.OAuthAuthorizeResponse{
+		RedirectTo: clientRedirect(req.RedirectURI, url.Values{
+			"code":  {code},
+			"state": {req.State},
+		}),
+	}, nil
+}
+
+// checkAuthorizeRequest validates the client, its redirect URI and the
+// requested scopes. An omitted scope requests every scope of the client.
+func (s *OAuthServerService) checkAuthorizeRequest(req *models.OAuthAuthorizeRequest) (*models.OAuthClient, []string, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	client, err := s.clientRepo.GetByClientID(req.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client == nil {
+		return nil, nil, fmt.Errorf("invalid client")
+	}
+
+	// Redirect URIs are compared exactly, a prefix match would let an
+	// attacker pick a path on the client's domain
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, fmt.Errorf("invalid redirect uri")
+	}
+
+	scopes := normalizeScopes(strings.Fields(req.Scope))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !containsScopes(client.Scopes, scopes) {
+		return nil, nil, fmt.Errorf("invalid scope")
+	}
+
+	return client, scopes, nil
+}
+
+// Token serves the token endpoint. The client authenticates with HTTP Basic
+// or in the form, public clients send only their client_id.
+func (s *OAuthServerService) Token(req *models.OAuthTokenRequest, clientInfo *models.ClientInfo) (*models.OAuthTokenResponse, error) {
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case GrantTypeAuthorizationCode:
+		return s.exchangeAuthorizationCode(client, req, clientInfo)
+	case GrantTypeRefreshToken:
+		if req.RefreshToken == "" {
+			return nil, fmt.Errorf("invalid request")
+		}
+		response, err := s.authService.RefreshClientToken(client.ClientID, req.RefreshToken)
+		if err != nil {
+			switch err.Error() {
+			case "invalid refresh token", "refresh token expired", "refresh token reuse detected", "account suspended":
+				return nil, fmt.Errorf("invalid grant")
+			}
+			return nil, err
+		}
+		return tokenResponse(response, ""), nil
+	case "":
+		return nil, fmt.Errorf("invalid request")
+	}
+
+	return nil, fmt.Errorf("unsupported grant type")
+}
+
+func (s *OAuthServerService) authenticateClient(clientID, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, fmt.Errorf("invalid client")
+	}
+
+	client, err := s.clientRepo.GetByClientID(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, fmt.Errorf("invalid client")
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	if client.ClientSecretHash == nil || clientSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(utils.HashToken(clientSecret)), []byte(*client.ClientSecretHash)) != 1 {
+		return nil, fmt.Errorf("invalid client")
+	}
+
+	return client, nil
+}
+
+func (s *OAuthServerService) exchangeAuthorizationCode(client *models.OAuthClient, req *models.OAuthTokenRequest, clientInfo *models.ClientInfo) (*models.OAuthTokenResponse, error) {
+	if req.Code == "" || req.RedirectURI == "" || req.CodeVerifier == "" {
+		return nil, fmt.Errorf("invalid request")
+	}
+
+	// The code is consumed before any check so it can't be tried twice
+	storedCode, err := s.oneTimeTokenRepo.Consume(models.TokenPurposeAuthorizationCode, utils.HashToken(req.Code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	if storedCode == nil {
+		return nil, fmt.Errorf("invalid grant")
+	}
+
+	payload := storedCode.Payload
+	if payload["client_id"] != client.ClientID || payload["redirect_uri"] != req.RedirectURI {
+		return nil, fmt.Errorf("invalid grant")
+	}
+
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(payload["code_challenge"])) != 1 {
+		return nil, fmt.Errorf("invalid grant")
+	}
+
+	user, err := s.userRepo.GetByID(storedCode.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.DeletedAt != nil {
+		return nil, fmt.Errorf("invalid grant")
+	}
+
+	authTimeUnix, err := strconv.ParseInt(payload["auth_time"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grant")
+	}
+	authTime := time.Unix(authTimeUnix, 0)
+
+	response, err := s.authService.IssueClientTokens(user, authTime, client.ClientID, payload["scope"], clientInfo)
+	if err != nil {
+		if err.Error() == "account suspended" {
+			return nil, fmt.Errorf("invalid grant")
+		}
+		return nil, err
+	}
+
+	var idToken string
+	scopes := strings.Fields(payload["scope"])
+	if slices.Contains(scopes, models.ScopeOpenID) {
+		idToken, err = s.jwtManager.GenerateIDToken(utils.IDTokenParams{
+			UserID:   user.ID,
+			ClientID: client.ClientID,
+			Nonce:    payload["nonce"],
+			AuthTime: authTime,
+			Claims:   userClaims(user, scopes),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ID token: %w", err)
+		}
+	}
+
+	return tokenResponse(response, idToken), nil
+}
+
+// UserInfo returns the claims of the user that the token's scopes allow
+func (s *OAuthServerService) UserInfo(user *models.User, claims *utils.JWTClaims) map[string]interface{} {
+	scopes := strings.Fields(claims.Scope)
+	if claims.ClientID == "" {
+		// First-party tokens see everything
+		scopes = []string{models.ScopeProfile, models.ScopeEmail}
+	}
+
+	userInfo := userClaims(user, scopes)
+	userInfo["sub"] = user.ID.String()
+	return userInfo
+}
+
+// Discovery returns the OpenID Connect discovery document
+func (s *OAuthServerService) Discovery() *models.OpenIDConfiguration {
+	issuer := strings.TrimRight(s.jwtManager.Issuer(), "/")
+
+	scopes := make([]string, 0, len(models.SupportedScopes))
+	for _, scope := range models.SupportedScopes {
+		scopes = append(scopes, scope.Name)
+	}
+
+	return &models.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             s.authorizeURL,
+		TokenEndpoint:                     issuer + "/api/v1/oauth/token",
+		UserInfoEndpoint:                  issuer + "/api/v1/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  s.jwtManager.SigningAlgorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"preferred_username", "name", "picture", "email", "email_verified",
+		},
+	}
+}
+
+// userClaims returns the OIDC claims of the user covered by the scopes
+func userClaims(user *models.User, scopes []string) map[string]interface{} {
+	claims := make(map[string]interface{})
+
+	if slices.Contains(scopes, models.ScopeProfile) {
+		claims["preferred_username"] = user.Username
+		claims["name"] = user.DisplayName
+		if user.ProfileImageURL != nil {
+			claims["picture"] = *user.ProfileImageURL
+		}
+	}
+	if slices.Contains(scopes, models.ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerifiedAt != nil
+	}
+
+	return claims
+}
+
+func tokenResponse(response *models.AuthResponse, idToken string) *models.OAuthTokenResponse {
+	return &models.OAuthTokenResponse{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		ExpiresIn:    response.ExpiresIn,
+		RefreshToken: response.RefreshToken,
+		Scope:        response.Scope,
+		IDToken:      idToken,
+	}
+}
+
+// normalizeScopes drops duplicates and keeps the scopes in the order of
+// SupportedScopes. Unknown scopes are kept so they fail the client check.
+func normalizeScopes(requested []string) []string {
+	var scopes []string
+	for _, scope := range models.SupportedScopes {
+		if slices.Contains(requested, scope.Name) {
+			scopes = append(scopes, scope.Name)
+		}
+	}
+	for _, scope := range requested {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func containsScopes(granted, requested []string) bool {
+	for _, scope := range requested {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// validClientRedirectURI accepts absolute https URIs without a fragment, and
+// plain http only for loopback addresses used by native and local apps
+func validClientRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" || parsed.User != nil {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// clientRedirect adds the parameters to the client's redirect URI, keeping
+// its own query
+func clientRedirect(redirectURI string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := parsed.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	oauthClientColumns = []string{"id", "client_id", "client_secret_hash", "name", "redirect_uris", "scopes", "public", "owner_id", "created_at"}
+)
+
+const (
+	testClientID     = "third-party-app"
+	testClientSecret = "client-secret"
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type oauthServerTest struct {
+	service *OAuthServerService
+	mock    sqlmock.Sqlmock
+	userID  uuid.UUID
+}
+
+func newOAuthServerTest(t *testing.T) *oauthServerTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	jwtManager := newTestJWTManager(t)
+	authService := &AuthService{
+		refreshTokenRepo: database.NewRefreshTokenRepository(db),
+		sessionRepo:      database.NewSessionRepository(db),
+		jwtManager:       jwtManager,
+	}
+
+	return &oauthServerTest{
+		service: NewOAuthServerService(
+			database.NewOAuthClientRepository(db),
+			database.NewUserRepository(db),
+			database.NewOneTimeTokenRepository(db),
+			authService,
+			jwtManager,
+			"https://threads.example.com/oauth/authorize",
+		),
+		mock:   mock,
+		userID: uuid.New(),
+	}
+}
+
+// expectClient answers the lookup of the confidential test client
+func (o *oauthServerTest) expectClient() {
+	secretHash := utils.HashToken(testClientSecret)
+	o.mock.ExpectQuery(`FROM oauth_clients WHERE client_id`).
+		WithArgs(testClientID).
+		WillReturnRows(sqlmock.NewRows(oauthClientColumns).
+			AddRow(uuid.New(), testClientID, secretHash, "Test App", "{"+testRedirectURI+"}", "{openid,profile,email}", false, uuid.New(), time.Now()))
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize approves an authorization request and returns the code handed
+// to the client together with the payload stored with it
+func (o *oauthServerTest) authorize(t *testing.T, scope, nonce string) (string, models.TokenPayload) {
+	t.Helper()
+
+	o.expectClient()
+	o.mock.ExpectExec(`INSERT INTO oauth_consents`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	storedHash, storedPayload := &capture{}, &capture{}
+	o.mock.ExpectQuery(`INSERT INTO one_time_tokens`).
+		WithArgs(o.userID, models.TokenPurposeAuthorizationCode, storedHash, storedPayload, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(oneTimeTokenColumns).
+			AddRow(uuid.New(), o.userID, models.TokenPurposeAuthorizationCode, "", nil, time.Now().Add(time.Minute), nil, time.Now()))
+
+	response, err := o.service.Authorize(o.userID, time.Now().Add(-time.Minute), &models.OAuthAuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            testClientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		State:               "client-state",
+		CodeChallenge:       codeChallenge(testCodeVerifier),
+		CodeChallengeMethod: "S256",
+		Nonce:               nonce,
+		Approve:             true,
+	})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	redirect, err := url.Parse(response.RedirectTo)
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	code := redirect.Query().Get("code")
+	if redirect.Query().Get("state") != "client-state" {
+		t.Errorf("state = %q, want client-state", redirect.Query().Get("state"))
+	}
+	if storedHash.value != utils.HashToken(code) {
+		t.Fatal("stored hash doesn't belong to the returned code")
+	}
+
+	var payload models.TokenPayload
+	if err := json.Unmarshal(storedPayload.value.([]byte), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	return code, payload
+}
+
+// expectConsume answers the redemption of the code with the stored payload,
+// or with nothing when the code was already used
+func (o *oauthServerTest) expectConsume(code string, payload models.TokenPayload) {
+	rows := sqlmock.NewRows(oneTimeTokenColumns)
+	if payload != nil {
+		value, _ := payload.Value()
+		rows.AddRow(uuid.New(), o.userID, models.TokenPurposeAuthorizationCode, utils.HashToken(code), value, time.Now().Add(time.Minute), time.Now(), time.Now())
+	}
+	o.mock.ExpectQuery(`UPDATE one_time_tokens SET used_at`).
+		WithArgs(utils.HashToken(code), models.TokenPurposeAuthorizationCode).
+		WillReturnRows(rows)
+}
+
+func (o *oauthServerTest) expectIssue() {
+	o.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(o.userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "credential_version", "created_at"}).
+			AddRow(o.userID, "john_doe", "john@example.com", 1, time.Now().Add(-24*time.Hour)))
+	sessionID := uuid.New()
+	o.mock.ExpectQuery(`INSERT INTO sessions`).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).
+			AddRow(sessionID, o.userID, "", "", testClientID, "openid profile", time.Now().Add(time.Hour), nil, time.Now(), time.Now()))
+	o.mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+			AddRow(uuid.New(), o.userID, sessionID, "", time.Now(), time.Now().Add(time.Hour), nil, nil, time.Now()))
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	o := newOAuthServerTest(t)
+	code, payload := o.authorize(t, "openid profile", "client-nonce")
+
+	o.expectClient()
+	o.expectConsume(code, payload)
+	o.expectIssue()
+
+	response, err := o.service.Token(&models.OAuthTokenRequest{
+		GrantType:    GrantTypeAuthorizationCode,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testCodeVerifier,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+	}, &models.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	claims, err := o.service.jwtManager.ValidateToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.ClientID != testClientID || claims.Scope != "openid profile" {
+		t.Errorf("access token client %q scope %q, want %q and openid profile", claims.ClientID, claims.Scope, testClientID)
+	}
+
+	idToken := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(response.IDToken, idToken); err != nil {
+		t.Fatalf("parse ID token: %v", err)
+	}
+	if idToken["aud"] != testClientID {
+		t.Errorf("aud = %v, want %s", idToken["aud"], testClientID)
+	}
+	if idToken["nonce"] != "client-nonce" {
+		t.Errorf("nonce = %v, want client-nonce", idToken["nonce"])
+	}
+	if idToken["sub"] != o.userID.String() {
+		t.Errorf("sub = %v, want %s", idToken["sub"], o.userID)
+	}
+	if _, exists := idToken["email"]; exists {
+		t.Error("ID token carries the email without the email scope")
+	}
+}
+
+func TestAuthorizationCodeExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		req  models.OAuthTokenRequest
+		// used makes the code already redeemed
+		used bool
+		// consumed is whether the code is redeemed before the failure
+		consumed bool
+		wantErr  string
+	}{
+		{
+			name:     "wrong code verifier",
+			req:      models.OAuthTokenRequest{RedirectURI: testRedirectURI, CodeVerifier: "another-verifier-of-the-right-length-0000000"},
+			consumed: true,
+			wantErr:  "invalid grant",
+		},
+		{
+			name:     "reused code",
+			req:      models.OAuthTokenRequest{RedirectURI: testRedirectURI, CodeVerifier: testCodeVerifier},
+			used:     true,
+			consumed: true,
+			wantErr:  "invalid grant",
+		},
+		{
+			name:     "redirect URI of another path",
+			req:      models.OAuthTokenRequest{RedirectURI: testRedirectURI + "/other", CodeVerifier: testCodeVerifier},
+			consumed: true,
+			wantErr:  "invalid grant",
+		},
+		{
+			name:    "wrong client secret",
+			req:     models.OAuthTokenRequest{RedirectURI: testRedirectURI, CodeVerifier: testCodeVerifier, ClientSecret: "guessed"},
+			wantErr: "invalid client",
+		},
+		{
+			name:    "missing code verifier",
+			req:     models.OAuthTokenRequest{RedirectURI: testRedirectURI},
+			wantErr: "invalid request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOAuthServerTest(t)
+			code, payload := o.authorize(t, "openid", "")
+
+			req := tt.req
+			req.GrantType = GrantTypeAuthorizationCode
+			req.Code = code
+			req.ClientID = testClientID
+			if req.ClientSecret == "" {
+				req.ClientSecret = testClientSecret
+			}
+
+			o.expectClient()
+			if tt.consumed {
+				if tt.used {
+					payload = nil
+				}
+				o.expectConsume(code, payload)
+			}
+
+			if _, err := o.service.Token(&req, &models.ClientInfo{}); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Token = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A code can't be redeemed by another client, even with the right verifier
+func TestAuthorizationCodeBoundToClient(t *testing.T) {
+	o := newOAuthServerTest(t)
+	code, payload := o.authorize(t, "openid", "")
+	payload["client_id"] = "another-app"
+
+	o.expectClient()
+	o.expectConsume(code, payload)
+
+	_, err := o.service.Token(&models.OAuthTokenRequest{
+		GrantType:    GrantTypeAuthorizationCode,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testCodeVerifier,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+	}, &models.ClientInfo{})
+	if err == nil || err.Error() != "invalid grant" {
+		t.Fatalf("Token = %v, want invalid grant", err)
+	}
+}
+
+func TestAuthorizeRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		redirectURI string
+		scope       string
+		wantErr     string
+	}{
+		{name: "redirect URI with a longer path", redirectURI: testRedirectURI + "/../evil", wantErr: "invalid redirect uri"},
+		{name: "redirect URI with an added query", redirectURI: testRedirectURI + "?next=evil", wantErr: "invalid redirect uri"},
+		{name: "scope the client may not request", redirectURI: testRedirectURI, scope: "openid admin", wantErr: "invalid scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOAuthServerTest(t)
+			o.expectClient()
+
+			_, err := o.service.Authorize(o.userID, time.Now(), &models.OAuthAuthorizeRequest{
+				ResponseType:        "code",
+				ClientID:            testClientID,
+				RedirectURI:         tt.redirectURI,
+				Scope:               tt.scope,
+				CodeChallenge:       codeChallenge(testCodeVerifier),
+				CodeChallengeMethod: "S256",
+				Approve:             true,
+			})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Authorize = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
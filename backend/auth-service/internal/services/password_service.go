@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/passwords"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	passwordResetTTL      = time.Hour
+	passwordResetCooldown = time.Minute
+
+	// OAuth-only users have no current password to confirm, so setting one
+	// requires having signed in recently instead
+	recentAuthWindow = 10 * time.Minute
+)
+
+type PasswordService struct {
+	userRepo         *database.UserRepository
+	oneTimeTokenRepo *database.OneTimeTokenRepository
+	authService      *AuthService
+	passwordPolicy   *passwords.Policy
+	mailer           mailer.Mailer
+	frontendURL      string
+}
+
+func NewPasswordService(
+	userRepo *database.UserRepository,
+	oneTimeTokenRepo *database.OneTimeTokenRepository,
+	authService *AuthService,
+	passwordPolicy *passwords.Policy,
+	mailer mailer.Mailer,
+	frontendURL string,
+) *PasswordService {
+	return &PasswordService{
+		userRepo:         userRepo,
+		oneTimeTokenRepo: oneTimeTokenRepo,
+		authService:      authService,
+		passwordPolicy:   passwordPolicy,
+		mailer:           mailer,
+		frontendURL:      frontendURL,
+	}
+}
+
+// personalInfo is what the user's password must not contain
+func personalInfo(user *models.User) passwords.PersonalInfo {
+	return passwords.PersonalInfo{Username: user.Username, Email: user.Email}
+}
+
+// checkPassword applies the password policy to a new password and reports a
+// violation as a validation error of the field
+func checkPassword(policy *passwords.Policy, field, password string, info passwords.PersonalInfo) error {
+	if err := policy.Check(password, info); err != nil {
+		var violation *passwords.Violation
+		if errors.As(err, &violation) {
+			return fmt.Errorf("validation failed: %w", &utils.FieldError{Field: field, Message: violation.Message, Err: violation})
+		}
+		return err
+	}
+	return nil
+}
+
+// ForgotPassword emails a reset link if the address belongs to an account.
+// The work happens in the background and the caller always gets the same
+// result, so the endpoint can't be used to discover registered emails.
+func (s *PasswordService) ForgotPassword(req *models.ForgotPasswordRequest) error {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	go func() {
+		if err := s.sendPasswordReset(context.Background(), req.Email); err != nil {
+			log.Println("Failed to send password reset email:", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *PasswordService) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	lastSent, err := s.oneTimeTokenRepo.GetLatestCreatedAt(user.ID, models.TokenPurposePasswordReset)
+	if err != nil {
+		return fmt.Errorf("failed to check last password reset: %w", err)
+	}
+	if lastSent != nil && time.Since(*lastSent) < passwordResetCooldown {
+		return nil
+	}
+
+	return s.sendResetLink(ctx, user,
+		"We received a request to reset your password.",
+		"If you did not request a reset, you can ignore this email.",
+	)
+}
+
+// ForcePasswordReset clears the user's password, signs them out everywhere
+// and emails a reset link. Staff use it when an account may be compromised.
+func (s *PasswordService) ForcePasswordReset(ctx context.Context, user *models.User) error {
+	if _, err := s.userRepo.UpdatePassword(user.ID, ""); err != nil {
+		return fmt.Errorf("failed to clear password: %w", err)
+	}
+
+	if err := s.authService.LogoutAll(user.ID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return s.sendResetLink(ctx, user,
+		"For your security, our support team has reset your password and signed you out of every device.",
+		"Until you choose a new password you can still sign in with any linked account or passkey.",
+	)
+}
+
+// sendResetLink replaces any outstanding reset token of the user with a new
+// one and emails it, framed by the intro and closing lines
+func (s *PasswordService) sendResetLink(ctx context.Context, user *models.User, intro, closing string) error {
+	// Opaque token, only its hash is stored
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	if err := s.oneTimeTokenRepo.InvalidateForUser(user.ID, models.TokenPurposePasswordReset); err != nil {
+		return fmt.Errorf("failed to invalidate old reset tokens: %w", err)
+	}
+
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposePasswordReset,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.frontendURL, url.QueryEscape(token))
+	return s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s Open the link below to choose a new password:\n\n%s\n\nThe link expires in 1 hour and can be used once. %s\n",
+			user.DisplayName,
+			intro,
+			link,
+			closing,
+		),
+	})
+}
+
+// ResetPassword redeems a reset token, sets the new password and signs the
+// user out everywhere
+func (s *PasswordService) ResetPassword(req *models.ResetPasswordRequest) error {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	// Check the new password before redeeming the token, so a rejected
+	// password doesn't cost the user their reset link
+	tokenHash := utils.HashToken(req.Token)
+	pendingToken, err := s.oneTimeTokenRepo.Get(models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+	if pendingToken == nil {
+		return fmt.Errorf("invalid reset token")
+	}
+
+	user, err := s.userRepo.GetByID(pendingToken.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("invalid reset token")
+	}
+	if err := checkPassword(s.passwordPolicy, "password", req.Password, personalInfo(user)); err != nil {
+		return err
+	}
+
+	storedToken, err := s.oneTimeTokenRepo.Consume(models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to consume reset token: %w", err)
+	}
+	if storedToken == nil {
+		return fmt.Errorf("invalid reset token")
+	}
+
+	// Hash password
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if _, err := s.userRepo.UpdatePassword(storedToken.UserID, hashedPassword); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	// The reset link proves control of the mailbox
+	if err := s.userRepo.MarkEmailVerified(storedToken.UserID); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	// Revoke existing sessions, whoever had access before the reset loses it
+	if err := s.authService.LogoutAll(storedToken.UserID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	s.authService.RecordSecurityEvent(storedToken.UserID, models.SecurityEventPasswordReset, nil)
+	return nil
+}
+
+// ChangePassword changes the password of a password user, or sets the first
+// password of an OAuth-only user. Every other session is signed out and a new
+// token pair is returned for the current one.
+func (s *PasswordService) ChangePassword(userID uuid.UUID, claims *utils.JWTClaims, req *models.ChangePasswordRequest, client *models.ClientInfo) (*models.AuthResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	if user.PasswordHash != "" {
+		if req.CurrentPassword == "" {
+			return nil, fmt.Errorf("current password is required")
+		}
+		ok, err := s.authService.confirmPassword(user, req.CurrentPassword)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("current password is incorrect")
+		}
+	} else if !claims.AuthenticatedWithin(recentAuthWindow) {
+		return nil, fmt.Errorf("recent authentication required")
+	}
+
+	if err := checkPassword(s.passwordPolicy, "new_password", req.NewPassword, personalInfo(user)); err != nil {
+		return nil, err
+	}
+
+	// Hash password
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// Updating the password bumps the credential version, which rejects every
+	// access token issued before the change. The new tokens carry the version
+	// as stored, in case another change bumped it concurrently.
+	credentialVersion, err := s.userRepo.UpdatePassword(user.ID, hashedPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update password: %w", err)
+	}
+	user.CredentialVersion = credentialVersion
+
+	if err := s.authService.RevokeRefreshTokens(user.ID); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	s.authService.RecordSecurityEvent(user.ID, models.SecurityEventPasswordChange, client)
+
+	return s.authService.IssueTokens(user, time.Now(), client)
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/lockout"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/passwords"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/revocation"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type passwordTest struct {
+	service    *PasswordService
+	db         *sqlx.DB
+	mock       sqlmock.Sqlmock
+	mailer     *recordingMailer
+	revocation revocation.Store
+	user       *models.User
+}
+
+func newPasswordTest(t *testing.T) *passwordTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	recorder := newRecordingMailer()
+	store := revocation.NewMemoryStore()
+	authService := &AuthService{
+		userRepo:          database.NewUserRepository(db),
+		refreshTokenRepo:  database.NewRefreshTokenRepository(db),
+		sessionRepo:       database.NewSessionRepository(db),
+		patRepo:           database.NewPersonalAccessTokenRepository(db),
+		roleRepo:          database.NewRoleRepository(db),
+		securityEventRepo: database.NewSecurityEventRepository(db),
+		revocationStore:   store,
+		jwtManager:        newTestJWTManager(t),
+		loginGuard: lockout.NewGuard(lockout.NewMemoryStore(), map[string]lockout.Policy{
+			lockout.ScopeAccount: {Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		}),
+	}
+
+	return &passwordTest{
+		service: NewPasswordService(
+			database.NewUserRepository(db),
+			database.NewOneTimeTokenRepository(db),
+			authService,
+			passwords.NewPolicy(8, 35, nil),
+			recorder,
+			"https://threads.example",
+		),
+		db:         db,
+		mock:       mock,
+		mailer:     recorder,
+		revocation: store,
+		user: &models.User{
+			ID:          uuid.New(),
+			Username:    "alice",
+			DisplayName: "Alice",
+			Email:       "alice@example.com",
+		},
+	}
+}
+
+// expectUserByEmail answers the lookup of the address, as unregistered when
+// user is nil
+func (p *passwordTest) expectUserByEmail(email string, user *models.User) {
+	rows := sqlmock.NewRows(userColumns)
+	if user != nil {
+		rows.AddRow(user.ID, user.Username, user.DisplayName, user.Email, "password-hash", nil, time.Now())
+	}
+	p.mock.ExpectQuery(`FROM users WHERE email`).WithArgs(email).WillReturnRows(rows)
+}
+
+func (p *passwordTest) expectUserByID() {
+	p.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(p.user.ID).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(p.user.ID, p.user.Username, p.user.DisplayName, p.user.Email, "password-hash", nil, time.Now()))
+}
+
+// expectPendingReset answers the lookup of a reset token, as unknown or used
+// when found is false
+func (p *passwordTest) expectPendingReset(token string, found bool) {
+	rows := sqlmock.NewRows(oneTimeTokenColumns)
+	if found {
+		rows.AddRow(uuid.New(), p.user.ID, models.TokenPurposePasswordReset, utils.HashToken(token), nil, time.Now().Add(time.Hour), nil, time.Now())
+	}
+	p.mock.ExpectQuery(`FROM one_time_tokens\s+WHERE token_hash`).
+		WithArgs(utils.HashToken(token), models.TokenPurposePasswordReset).
+		WillReturnRows(rows)
+}
+
+func (p *passwordTest) expectLastReset(ago time.Duration) {
+	rows := sqlmock.NewRows([]string{"created_at"})
+	if ago != 0 {
+		rows.AddRow(time.Now().Add(-ago))
+	}
+	p.mock.ExpectQuery(`SELECT created_at FROM one_time_tokens`).
+		WithArgs(p.user.ID, models.TokenPurposePasswordReset).
+		WillReturnRows(rows)
+}
+
+// expectPasswordUpdate expects the password hash to be replaced, bumping the
+// credential version to version
+func (p *passwordTest) expectPasswordUpdate(passwordHash interface{}, version int) {
+	p.mock.ExpectQuery(`UPDATE users SET password_hash`).
+		WithArgs(p.user.ID, passwordHash).
+		WillReturnRows(sqlmock.NewRows([]string{"credential_version"}).AddRow(version))
+}
+
+// expectLogoutAll expects every session of the user to be revoked
+func (p *passwordTest) expectLogoutAll() {
+	p.mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP\s+WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	p.mock.ExpectExec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP\s+WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	p.mock.ExpectExec(`DELETE FROM personal_access_tokens WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	p.expectSecurityEvent(models.SecurityEventAllSessionsRevoke)
+}
+
+func (p *passwordTest) expectSecurityEvent(eventType string) {
+	p.mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(p.user.ID, eventType, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSendPasswordReset(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered bool
+		// lastSent is how long ago the previous link was sent, zero if never
+		lastSent time.Duration
+		wantMail bool
+	}{
+		{name: "unregistered address"},
+		{name: "first link", registered: true, wantMail: true},
+		{name: "after the cooldown", registered: true, lastSent: 2 * passwordResetCooldown, wantMail: true},
+		{name: "within the cooldown", registered: true, lastSent: passwordResetCooldown / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPasswordTest(t)
+
+			if !tt.registered {
+				p.expectUserByEmail(p.user.Email, nil)
+			} else {
+				p.expectUserByEmail(p.user.Email, p.user)
+				p.expectLastReset(tt.lastSent)
+			}
+			var tokenHash *capture
+			if tt.wantMail {
+				tokenHash = expectIssue(p.mock, p.user.ID, models.TokenPurposePasswordReset)
+			}
+
+			if err := p.service.sendPasswordReset(context.Background(), p.user.Email); err != nil {
+				t.Fatalf("sendPasswordReset: %v", err)
+			}
+
+			if !tt.wantMail {
+				if p.mailer.count() != 0 {
+					t.Errorf("sent %d messages, want none", p.mailer.count())
+				}
+				return
+			}
+			token := p.mailer.linkToken(t, "https://threads.example/reset-password")
+			if tokenHash.value != utils.HashToken(token) {
+				t.Error("stored hash does not match the mailed token")
+			}
+		})
+	}
+}
+
+// ForgotPassword must answer the same whether or not the address is
+// registered, the lookup happens after the response
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	t.Run("registered", func(t *testing.T) {
+		p := newPasswordTest(t)
+		p.expectUserByEmail(p.user.Email, p.user)
+		p.expectLastReset(0)
+		expectIssue(p.mock, p.user.ID, models.TokenPurposePasswordReset)
+
+		if err := p.service.ForgotPassword(&models.ForgotPasswordRequest{Email: p.user.Email}); err != nil {
+			t.Fatalf("ForgotPassword: %v", err)
+		}
+		select {
+		case <-p.mailer.sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reset link never sent")
+		}
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		p := newPasswordTest(t)
+		p.expectUserByEmail("nobody@example.com", nil)
+
+		if err := p.service.ForgotPassword(&models.ForgotPasswordRequest{Email: "nobody@example.com"}); err != nil {
+			t.Fatalf("ForgotPassword: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for p.mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p.mailer.count() != 0 {
+			t.Errorf("sent %d messages, want none", p.mailer.count())
+		}
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		p := newPasswordTest(t)
+		if err := p.service.ForgotPassword(&models.ForgotPasswordRequest{Email: "not-an-email"}); err == nil {
+			t.Error("ForgotPassword accepted an invalid address")
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	p := newPasswordTest(t)
+	token := "reset-token"
+	newPassword := "correct horse battery staple"
+	issuedBefore := time.Now().Add(-time.Minute)
+
+	// A rejected password doesn't use up the link
+	p.expectPendingReset(token, true)
+	p.expectUserByID()
+	err := p.service.ResetPassword(&models.ResetPasswordRequest{Token: token, Password: "alice-2024!"})
+	var fieldErr *utils.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "password" {
+		t.Fatalf("ResetPassword with the username in the password = %v, want a password field error", err)
+	}
+
+	passwordHash := &capture{}
+	p.expectPendingReset(token, true)
+	p.expectUserByID()
+	expectConsume(p.mock, models.TokenPurposePasswordReset, token, &p.user.ID)
+	p.expectPasswordUpdate(passwordHash, 1)
+	p.mock.ExpectExec(`UPDATE users SET email_verified_at`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	p.expectLogoutAll()
+	p.expectSecurityEvent(models.SecurityEventPasswordReset)
+
+	if err := p.service.ResetPassword(&models.ResetPasswordRequest{Token: token, Password: newPassword}); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	hash, _ := passwordHash.value.(string)
+	if err := utils.VerifyPassword(hash, newPassword); err != nil {
+		t.Errorf("stored hash does not match the new password: %v", err)
+	}
+
+	// Access tokens issued before the reset are rejected
+	revoked, err := p.revocation.IsRevoked(uuid.New().String(), p.user.ID, issuedBefore)
+	if err != nil || !revoked {
+		t.Errorf("token issued before the reset revoked = %v, %v, want true", revoked, err)
+	}
+
+	// The link only works once
+	p.expectPendingReset(token, false)
+	err = p.service.ResetPassword(&models.ResetPasswordRequest{Token: token, Password: newPassword})
+	if err == nil || err.Error() != "invalid reset token" {
+		t.Errorf("second ResetPassword = %v, want invalid reset token", err)
+	}
+}
+
+// expectUserWithPassword answers the lookup of the user by ID with the hash of
+// password and credential version 5
+func (p *passwordTest) expectUserWithPassword(t *testing.T, password string) {
+	t.Helper()
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	p.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(p.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name", "email", "password_hash", "credential_version", "created_at"}).
+			AddRow(p.user.ID, p.user.Username, p.user.DisplayName, p.user.Email, hash, 5, time.Now()))
+}
+
+func TestChangePassword(t *testing.T) {
+	const currentPassword = "correct horse battery staple"
+	p := newPasswordTest(t)
+	client := &models.ClientInfo{IP: "203.0.113.7"}
+
+	p.expectUserWithPassword(t, currentPassword)
+	_, err := p.service.ChangePassword(p.user.ID, &utils.JWTClaims{}, &models.ChangePasswordRequest{
+		CurrentPassword: "guess",
+		NewPassword:     "purple monkey dishwasher",
+	}, client)
+	if err == nil || err.Error() != "current password is incorrect" {
+		t.Fatalf("ChangePassword with a wrong password = %v, want current password is incorrect", err)
+	}
+
+	// The database bumped the version past the one read with the user, the
+	// new access token must carry the stored one
+	p.expectUserWithPassword(t, currentPassword)
+	p.expectPasswordUpdate(sqlmock.AnyArg(), 7)
+	p.mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP\s+WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	p.mock.ExpectExec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP\s+WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	p.expectSecurityEvent(models.SecurityEventPasswordChange)
+	sessionID := uuid.New()
+	p.mock.ExpectQuery(`INSERT INTO sessions`).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).
+			AddRow(sessionID, p.user.ID, "", client.IP, nil, "", time.Now().Add(time.Hour), nil, time.Now(), time.Now()))
+	p.mock.ExpectQuery(`FROM user_roles WHERE user_id`).
+		WithArgs(p.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "role", "granted_by", "granted_at"}))
+	p.mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+			AddRow(uuid.New(), p.user.ID, sessionID, "", time.Now(), time.Now().Add(time.Hour), nil, nil, time.Now()))
+	p.expectSecurityEvent(models.SecurityEventLogin)
+
+	response, err := p.service.ChangePassword(p.user.ID, &utils.JWTClaims{}, &models.ChangePasswordRequest{
+		CurrentPassword: currentPassword,
+		NewPassword:     "purple monkey dishwasher",
+	}, client)
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	claims, err := p.service.authService.jwtManager.ValidateToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.CredentialVersion != 7 {
+		t.Errorf("credential version = %d, want 7", claims.CredentialVersion)
+	}
+}
+
+// Wrong current passwords count against the account's login lockout
+func TestChangePasswordLockout(t *testing.T) {
+	const currentPassword = "correct horse battery staple"
+	p := newPasswordTest(t)
+	client := &models.ClientInfo{IP: "203.0.113.7"}
+
+	for i := 0; i < 3; i++ {
+		p.expectUserWithPassword(t, currentPassword)
+		_, err := p.service.ChangePassword(p.user.ID, &utils.JWTClaims{}, &models.ChangePasswordRequest{
+			CurrentPassword: "guess",
+			NewPassword:     "purple monkey dishwasher",
+		}, client)
+		if err == nil || err.Error() != "current password is incorrect" {
+			t.Fatalf("attempt %d: ChangePassword = %v, want current password is incorrect", i+1, err)
+		}
+	}
+
+	p.expectUserWithPassword(t, currentPassword)
+	_, err := p.service.ChangePassword(p.user.ID, &utils.JWTClaims{}, &models.ChangePasswordRequest{
+		CurrentPassword: currentPassword,
+		NewPassword:     "purple monkey dishwasher",
+	}, client)
+	var lockedErr *lockout.LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("ChangePassword while locked = %v, want a lockout", err)
+	}
+}
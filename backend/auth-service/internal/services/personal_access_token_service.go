@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+// personalAccessTokenPrefixLength is how much of a token is kept in clear to
+// identify it, the fixed prefix and four random characters
+const personalAccessTokenPrefixLength = len(models.PersonalAccessTokenPrefix) + 4
+
+// maxPersonalAccessTokens limits how many tokens one user can hold
+const maxPersonalAccessTokens = 50
+
+type PersonalAccessTokenService struct {
+	patRepo *database.PersonalAccessTokenRepository
+}
+
+func NewPersonalAccessTokenService(patRepo *database.PersonalAccessTokenRepository) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{
+		patRepo: patRepo,
+	}
+}
+
+// Create issues a personal access token. The token is returned once and
+// only its hash is stored.
+func (s *PersonalAccessTokenService) Create(userID uuid.UUID, req *models.PersonalAccessTokenRequest) (*models.PersonalAccessTokenCreatedResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	tokens, err := s.patRepo.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) >= maxPersonalAccessTokens {
+		return nil, fmt.Errorf("too many personal access tokens")
+	}
+
+	secret, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	token := models.PersonalAccessTokenPrefix + secret
+
+	pat := &models.PersonalAccessToken{
+		UserID:      userID,
+		Name:        req.Name,
+		TokenPrefix: token[:personalAccessTokenPrefixLength],
+		TokenHash:   utils.HashToken(token),
+		Scopes:      normalizeTokenScopes(req.Scopes),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		pat.ExpiresAt = &expiresAt
+	}
+
+	createdToken, err := s.patRepo.Create(pat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PersonalAccessTokenCreatedResponse{
+		Token:               token,
+		PersonalAccessToken: createdToken,
+	}, nil
+}
+
+func (s *PersonalAccessTokenService) List(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	return s.patRepo.ListByUserID(userID)
+}
+
+// Delete revokes a token of the user. It returns false if the user has no
+// such token.
+func (s *PersonalAccessTokenService) Delete(userID, id uuid.UUID) (bool, error) {
+	return s.patRepo.Delete(userID, id)
+}
+
+// normalizeTokenScopes drops duplicate scopes
+func normalizeTokenScopes(requested []string) []string {
+	seen := make(map[string]bool)
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
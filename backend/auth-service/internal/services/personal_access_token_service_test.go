@@ -0,0 +1,180 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/revocation"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+var personalAccessTokenColumns = []string{"id", "user_id", "name", "token_prefix", "token_hash", "scopes", "expires_at", "last_used_at", "created_at"}
+
+// Only the hash of a new token is stored, next to a prefix short enough to
+// be shown in the token list
+func TestCreatePersonalAccessToken(t *testing.T) {
+	db, mock := newMockDB(t)
+	service := NewPersonalAccessTokenService(database.NewPersonalAccessTokenRepository(db))
+	userID := uuid.New()
+
+	mock.ExpectQuery(`FROM personal_access_tokens WHERE user_id`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows(personalAccessTokenColumns))
+	prefix, hash, scopes := &capture{}, &capture{}, &capture{}
+	mock.ExpectQuery(`INSERT INTO personal_access_tokens`).
+		WithArgs(userID, "deploy bot", prefix, hash, scopes, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(personalAccessTokenColumns).
+			AddRow(uuid.New(), userID, "deploy bot", "thr_pat_abcd", "", "{threads:read}", nil, nil, time.Now()))
+
+	response, err := service.Create(userID, &models.PersonalAccessTokenRequest{
+		Name:          "deploy bot",
+		Scopes:        []string{models.ScopeThreadsRead, models.ScopeThreadsRead},
+		ExpiresInDays: 30,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if !strings.HasPrefix(response.Token, models.PersonalAccessTokenPrefix) {
+		t.Errorf("token = %q, want the %s prefix", response.Token, models.PersonalAccessTokenPrefix)
+	}
+	if hash.value != utils.HashToken(response.Token) {
+		t.Error("stored hash doesn't belong to the returned token")
+	}
+	if prefix.value != response.Token[:personalAccessTokenPrefixLength] {
+		t.Errorf("stored prefix = %v, want %q", prefix.value, response.Token[:personalAccessTokenPrefixLength])
+	}
+	if scopes.value != `{"threads:read"}` {
+		t.Errorf("stored scopes = %v, want the scope once", scopes.value)
+	}
+}
+
+func TestCreatePersonalAccessTokenRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     models.PersonalAccessTokenRequest
+		wantErr string
+	}{
+		{name: "no scopes", req: models.PersonalAccessTokenRequest{Name: "bot"}, wantErr: "validation failed"},
+		{name: "unknown scope", req: models.PersonalAccessTokenRequest{Name: "bot", Scopes: []string{"admin"}}, wantErr: "validation failed"},
+		{name: "expiry beyond a year", req: models.PersonalAccessTokenRequest{Name: "bot", Scopes: []string{models.ScopeProfileRead}, ExpiresInDays: 366}, wantErr: "validation failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewPersonalAccessTokenService(nil)
+			if _, err := service.Create(uuid.New(), &tt.req); err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("Create = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticatePersonalAccessToken(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		known     bool
+		expiresAt *time.Time
+		// validateScopes are the scopes ValidateToken is asked for
+		validateScopes []string
+		wantErr        string
+	}{
+		{name: "token with the scope", known: true, validateScopes: []string{models.ScopeProfileRead}},
+		{name: "unknown token", wantErr: "invalid token: unknown or expired personal access token"},
+		{name: "expired token", known: true, expiresAt: &expired, wantErr: "invalid token: unknown or expired personal access token"},
+		{name: "no scope asked for", known: true, wantErr: "insufficient scope"},
+		{name: "scope the token lacks", known: true, validateScopes: []string{models.ScopeThreadsWrite}, wantErr: "insufficient scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			service := &AuthService{
+				userRepo: database.NewUserRepository(db),
+				patRepo:  database.NewPersonalAccessTokenRepository(db),
+			}
+			token := models.PersonalAccessTokenPrefix + "secret"
+			userID, tokenID := uuid.New(), uuid.New()
+
+			rows := sqlmock.NewRows(personalAccessTokenColumns)
+			if tt.known {
+				rows.AddRow(tokenID, userID, "bot", "thr_pat_secr", utils.HashToken(token), "{profile:read,threads:read}", tt.expiresAt, nil, now.Add(-time.Hour))
+			}
+			mock.ExpectQuery(`FROM personal_access_tokens WHERE token_hash`).
+				WithArgs(utils.HashToken(token)).
+				WillReturnRows(rows)
+
+			if tt.known && tt.expiresAt == nil {
+				mock.ExpectQuery(`FROM users WHERE id`).
+					WithArgs(userID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "credential_version", "created_at"}).
+						AddRow(userID, "john_doe", "john@example.com", 1, now.Add(-24*time.Hour)))
+				mock.ExpectExec(`UPDATE personal_access_tokens SET last_used_at`).
+					WithArgs(tokenID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			user, err := service.ValidateToken(token, tt.validateScopes...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ValidateToken = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if user.ID != userID {
+				t.Errorf("user = %s, want %s", user.ID, userID)
+			}
+		})
+	}
+}
+
+// Logging out everywhere also ends the user's scripts and bots
+func TestLogoutAllRevokesPersonalAccessTokens(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := revocation.NewMemoryStore()
+	service := &AuthService{
+		refreshTokenRepo:  database.NewRefreshTokenRepository(db),
+		sessionRepo:       database.NewSessionRepository(db),
+		patRepo:           database.NewPersonalAccessTokenRepository(db),
+		securityEventRepo: database.NewSecurityEventRepository(db),
+		revocationStore:   store,
+	}
+	userID := uuid.New()
+
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE sessions SET revoked_at`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM personal_access_tokens WHERE user_id = \$1$`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO security_events`).
+		WithArgs(userID, models.SecurityEventAllSessionsRevoke, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := service.LogoutAll(userID); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+
+	// The tokens are gone, so presenting one finds nothing
+	token := models.PersonalAccessTokenPrefix + "secret"
+	mock.ExpectQuery(`FROM personal_access_tokens WHERE token_hash`).
+		WithArgs(utils.HashToken(token)).
+		WillReturnRows(sqlmock.NewRows(personalAccessTokenColumns))
+	if _, err := service.ValidateToken(token, models.ScopeProfileRead); err == nil {
+		t.Error("personal access token accepted after LogoutAll")
+	}
+}
@@ -2,23 +2,50 @@ package services
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
 	"github.com/google/uuid"
 )
 
 type UserService struct {
-	userRepo *database.UserRepository
+	userRepo       *database.UserRepository
+	usernamePolicy *usernames.Policy
+	cfg            configs.AccountConfig
 }
 
-func NewUserService(userRepo *database.UserRepository) *UserService {
+func NewUserService(userRepo *database.UserRepository, usernamePolicy *usernames.Policy, cfg configs.AccountConfig) *UserService {
 	return &UserService{
-		userRepo: userRepo,
+		userRepo:       userRepo,
+		usernamePolicy: usernamePolicy,
+		cfg:            cfg,
 	}
 }
 
+// UsernameCooldownError is returned when the username was changed too
+// recently to change it again
+type UsernameCooldownError struct {
+	NextChangeAt time.Time
+}
+
+func (e *UsernameCooldownError) Error() string {
+	return "username change too soon"
+}
+
+// UsernameMovedError is returned when a username was given up recently and
+// its previous owner now goes by another one
+type UsernameMovedError struct {
+	Username string
+}
+
+func (e *UsernameMovedError) Error() string {
+	return "username changed"
+}
+
 func (s *UserService) GetProfile(userID uuid.UUID) (*models.User, error) {
 	user, err := s.userRepo.GetByID(userID)
 	if err != nil {
@@ -55,12 +82,102 @@ func (s *UserService) UpdateProfile(userID uuid.UUID, req *models.UpdateProfileR
 	return updatedUser, nil
 }
 
+// ChangeUsername renames the current user. The old username stays reserved
+// for them and redirects to the new one for the reservation period.
+func (s *UserService) ChangeUsername(userID uuid.UUID, req *models.ChangeUsernameRequest) (*models.ChangeUsernameResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.DeletedAt != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if user.Username == req.Username {
+		return nil, fmt.Errorf("username unchanged")
+	}
+	if err := s.usernamePolicy.Check(req.Username); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkUsernameChange(user, req.Username); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updatedUser, change, err := s.userRepo.ChangeUsername(
+		userID,
+		user.Username,
+		req.Username,
+		now.Add(-s.cfg.UsernameChangeCooldown),
+		now.Add(s.cfg.UsernameReservationPeriod),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if updatedUser == nil {
+		// Lost a race with another change, tell which check fails now
+		if err := s.checkUsernameChange(user, req.Username); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("username already taken")
+	}
+
+	// Remove password hash from response
+	updatedUser.PasswordHash = ""
+
+	return &models.ChangeUsernameResponse{
+		User:         updatedUser,
+		NextChangeAt: change.ChangedAt.Add(s.cfg.UsernameChangeCooldown),
+	}, nil
+}
+
+// checkUsernameChange returns why the user can't take the username now, if
+// anything
+func (s *UserService) checkUsernameChange(user *models.User, username string) error {
+	lastChange, err := s.userRepo.GetLastUsernameChange(user.ID)
+	if err != nil {
+		return err
+	}
+	if lastChange != nil {
+		if nextChangeAt := lastChange.ChangedAt.Add(s.cfg.UsernameChangeCooldown); time.Now().Before(nextChangeAt) {
+			return &UsernameCooldownError{NextChangeAt: nextChangeAt}
+		}
+	}
+
+	available, err := s.userRepo.UsernameAvailable(username, user.ID)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return fmt.Errorf("username already taken")
+	}
+
+	return nil
+}
+
+// GetUserByUsername returns the user with the username. A username given up
+// recently returns a UsernameMovedError naming the current one.
 func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 	user, err := s.userRepo.GetByUsername(username)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by username: %w", err)
 	}
 	if user == nil {
+		redirect, err := s.userRepo.GetUsernameRedirect(username)
+		if err != nil {
+			return nil, err
+		}
+		if redirect != "" {
+			return nil, &UsernameMovedError{Username: redirect}
+		}
+	}
+	// Accounts pending deletion are hidden like deleted ones
+	if user == nil || user.DeletedAt != nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
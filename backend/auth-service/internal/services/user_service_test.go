@@ -0,0 +1,265 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var usernameChangeColumns = []string{"id", "user_id", "old_username", "new_username", "changed_at", "reserved_until"}
+
+const (
+	usernameCooldown    = 30 * 24 * time.Hour
+	usernameReservation = 14 * 24 * time.Hour
+)
+
+type usernameTest struct {
+	service *UserService
+	mock    sqlmock.Sqlmock
+	user    *models.User
+}
+
+func newUsernameTest(t *testing.T) *usernameTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	return &usernameTest{
+		service: NewUserService(database.NewUserRepository(db), usernames.NewPolicy([]string{"threads"}), configs.AccountConfig{
+			UsernameChangeCooldown:    usernameCooldown,
+			UsernameReservationPeriod: usernameReservation,
+		}),
+		mock: mock,
+		user: &models.User{ID: uuid.New(), Username: "alice", DisplayName: "Alice", Email: "alice@example.com"},
+	}
+}
+
+func (u *usernameTest) expectUser() {
+	u.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(u.user.ID).
+		WillReturnRows(sqlmock.NewRows(deletedUserColumns).
+			AddRow(u.user.ID, u.user.Username, u.user.DisplayName, u.user.Email, "password-hash", nil, time.Now()))
+}
+
+// expectLastChange answers the lookup of the user's last username change,
+// as never changed when ago is zero
+func (u *usernameTest) expectLastChange(ago time.Duration) {
+	rows := sqlmock.NewRows(usernameChangeColumns)
+	if ago != 0 {
+		changedAt := time.Now().Add(-ago)
+		rows.AddRow(uuid.New(), u.user.ID, "alice_old", u.user.Username, changedAt, changedAt.Add(usernameReservation))
+	}
+	u.mock.ExpectQuery(`FROM username_history WHERE user_id`).
+		WithArgs(u.user.ID).
+		WillReturnRows(rows)
+}
+
+func (u *usernameTest) expectAvailable(username string, available bool) {
+	u.mock.ExpectQuery(`SELECT NOT EXISTS\(SELECT 1 FROM users WHERE username_skeleton`).
+		WithArgs(usernames.Skeleton(username), u.user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(available))
+}
+
+// timeNear matches a time within a minute of want
+type timeNear struct {
+	want time.Time
+}
+
+func (n timeNear) Match(value driver.Value) bool {
+	at, ok := value.(time.Time)
+	return ok && at.Sub(n.want).Abs() < time.Minute
+}
+
+func TestChangeUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		// lastChange is how long ago the username was last changed, zero if
+		// never
+		lastChange time.Duration
+		available  bool
+		// lostRace makes the rename find the username taken or changed by
+		// a concurrent request, recheckChange is how long ago the last
+		// change is then
+		lostRace      error
+		recheckChange time.Duration
+		wantErr       string
+		wantCooldown  bool
+	}{
+		{name: "first change", username: "alice_new", available: true},
+		{name: "after the cooldown", username: "alice_new", lastChange: 2 * usernameCooldown, available: true},
+		// The user's own reservation doesn't count, and the rename releases it
+		{name: "taking back the old username", username: "alice_old", lastChange: 2 * usernameCooldown, available: true},
+		{name: "within the cooldown", username: "alice_new", lastChange: usernameCooldown / 2, wantCooldown: true},
+		{name: "taken or reserved for someone else", username: "bob", wantErr: "username already taken"},
+		{name: "unchanged", username: "alice", wantErr: "username unchanged"},
+		{name: "reserved name", username: "Threads", wantErr: "username reserved"},
+		{name: "route name", username: "tokens", wantErr: "username reserved"},
+		{
+			name: "look-alike registered concurrently", username: "alice_new", available: true,
+			lostRace: &pq.Error{Code: "23505", Constraint: "idx_users_username_skeleton"},
+			wantErr:  "username already taken",
+		},
+		{
+			name: "changed concurrently", username: "alice_new", available: true,
+			lostRace: errNoRename, recheckChange: time.Second,
+			wantCooldown: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUsernameTest(t)
+
+			u.expectUser()
+			checked := tt.username != u.user.Username && tt.wantErr != "username reserved"
+			if checked {
+				u.expectLastChange(tt.lastChange)
+				if !tt.wantCooldown || tt.lostRace != nil {
+					u.expectAvailable(tt.username, tt.available)
+				}
+			}
+
+			var changedAt time.Time
+			if checked && tt.available {
+				u.mock.ExpectBegin()
+				rename := u.mock.ExpectQuery(`UPDATE users SET username = \$3`).
+					WithArgs(u.user.ID, u.user.Username, tt.username, timeNear{time.Now().Add(-usernameCooldown)}, usernames.Skeleton(tt.username))
+				switch tt.lostRace {
+				case nil:
+					rename.WillReturnRows(sqlmock.NewRows(deletedUserColumns).
+						AddRow(u.user.ID, tt.username, u.user.DisplayName, u.user.Email, "password-hash", nil, time.Now()))
+
+					u.mock.ExpectExec(`UPDATE username_history SET reserved_until = CURRENT_TIMESTAMP`).
+						WithArgs(u.user.ID, usernames.Skeleton(tt.username)).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+					changedAt = time.Now().Truncate(time.Second)
+					u.mock.ExpectQuery(`INSERT INTO username_history`).
+						WithArgs(u.user.ID, u.user.Username, tt.username, timeNear{time.Now().Add(usernameReservation)}, usernames.Skeleton(u.user.Username)).
+						WillReturnRows(sqlmock.NewRows(usernameChangeColumns).
+							AddRow(uuid.New(), u.user.ID, u.user.Username, tt.username, changedAt, changedAt.Add(usernameReservation)))
+					u.mock.ExpectExec(`INSERT INTO event_outbox`).
+						WithArgs(sqlmock.AnyArg(), models.EventUserUsernameChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					u.mock.ExpectCommit()
+				case errNoRename:
+					rename.WillReturnRows(sqlmock.NewRows(deletedUserColumns))
+					u.mock.ExpectRollback()
+				default:
+					rename.WillReturnError(tt.lostRace)
+					u.mock.ExpectRollback()
+				}
+
+				if tt.lostRace != nil {
+					u.expectLastChange(tt.recheckChange)
+					if tt.recheckChange == 0 {
+						u.expectAvailable(tt.username, false)
+					}
+				}
+			}
+
+			response, err := u.service.ChangeUsername(u.user.ID, &models.ChangeUsernameRequest{Username: tt.username})
+			if tt.wantCooldown {
+				var cooldownErr *UsernameCooldownError
+				if !errors.As(err, &cooldownErr) {
+					t.Fatalf("ChangeUsername = %v, want a cooldown", err)
+				}
+				if time.Until(cooldownErr.NextChangeAt) <= 0 || time.Until(cooldownErr.NextChangeAt) > usernameCooldown {
+					t.Errorf("next change at %s, want within the cooldown", cooldownErr.NextChangeAt)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ChangeUsername = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChangeUsername: %v", err)
+			}
+			if response.User.Username != tt.username || response.User.PasswordHash != "" {
+				t.Errorf("user = %s with hash %q, want %s without", response.User.Username, response.User.PasswordHash, tt.username)
+			}
+			if !response.NextChangeAt.Equal(changedAt.Add(usernameCooldown)) {
+				t.Errorf("next change at %s, want %s", response.NextChangeAt, changedAt.Add(usernameCooldown))
+			}
+		})
+	}
+}
+
+// errNoRename marks a rename that matched no row, the user changed their
+// username concurrently
+var errNoRename = errors.New("no rename")
+
+func TestGetUserByUsername(t *testing.T) {
+	tests := []struct {
+		name      string
+		found     bool
+		deleted   bool
+		redirect  string
+		wantErr   string
+		wantMoved string
+	}{
+		{name: "current username", found: true},
+		{name: "given up recently", redirect: "alice_new", wantMoved: "alice_new"},
+		{name: "unknown", wantErr: "user not found"},
+		{name: "pending deletion", found: true, deleted: true, wantErr: "user not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUsernameTest(t)
+
+			rows := sqlmock.NewRows(deletedUserColumns)
+			if tt.found {
+				var deletedAt interface{}
+				if tt.deleted {
+					deletedAt = time.Now()
+				}
+				rows.AddRow(u.user.ID, u.user.Username, u.user.DisplayName, u.user.Email, "password-hash", deletedAt, time.Now())
+			}
+			u.mock.ExpectQuery(`FROM users WHERE LOWER\(username\) = LOWER\(\$1\)`).
+				WithArgs("Alice").
+				WillReturnRows(rows)
+			if !tt.found {
+				redirect := sqlmock.NewRows([]string{"username"})
+				if tt.redirect != "" {
+					redirect.AddRow(tt.redirect)
+				}
+				u.mock.ExpectQuery(`FROM username_history h`).
+					WithArgs("Alice").
+					WillReturnRows(redirect)
+			}
+
+			user, err := u.service.GetUserByUsername("Alice")
+			if tt.wantMoved != "" {
+				var movedErr *UsernameMovedError
+				if !errors.As(err, &movedErr) || movedErr.Username != tt.wantMoved {
+					t.Fatalf("GetUserByUsername = %v, want moved to %s", err, tt.wantMoved)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("GetUserByUsername = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUserByUsername: %v", err)
+			}
+			if user.ID != u.user.ID || user.PasswordHash != "" {
+				t.Errorf("user = %s with hash %q, want %s without", user.ID, user.PasswordHash, u.user.ID)
+			}
+		})
+	}
+}
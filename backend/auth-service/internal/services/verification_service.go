@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	emailVerificationTTL      = utils.MaxActionTokenTTL
+	emailVerificationCooldown = time.Minute
+)
+
+type VerificationService struct {
+	userRepo         *database.UserRepository
+	oneTimeTokenRepo *database.OneTimeTokenRepository
+	jwtManager       *utils.JWTManager
+	mailer           mailer.Mailer
+	frontendURL      string
+}
+
+func NewVerificationService(
+	userRepo *database.UserRepository,
+	oneTimeTokenRepo *database.OneTimeTokenRepository,
+	jwtManager *utils.JWTManager,
+	mailer mailer.Mailer,
+	frontendURL string,
+) *VerificationService {
+	return &VerificationService{
+		userRepo:         userRepo,
+		oneTimeTokenRepo: oneTimeTokenRepo,
+		jwtManager:       jwtManager,
+		mailer:           mailer,
+		frontendURL:      frontendURL,
+	}
+}
+
+// SendVerificationEmail issues a new verification token and mails the link.
+// Previously issued tokens stop working.
+func (s *VerificationService) SendVerificationEmail(ctx context.Context, user *models.User) error {
+	// Signed token, bound to the address it was sent to
+	token, err := s.jwtManager.GenerateActionToken(user.ID, models.TokenPurposeEmailVerification, user.Email, emailVerificationTTL)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	if err := s.oneTimeTokenRepo.InvalidateForUser(user.ID, models.TokenPurposeEmailVerification); err != nil {
+		return fmt.Errorf("failed to invalidate old verification tokens: %w", err)
+	}
+
+	// Store the token hash so each link can only be used once
+	_, err = s.oneTimeTokenRepo.Create(&models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeEmailVerification,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", s.frontendURL, url.QueryEscape(token))
+	err = s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours. If you did not create an account, you can ignore this email.\n",
+			user.DisplayName,
+			link,
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail redeems a verification token and marks the email as verified
+func (s *VerificationService) VerifyEmail(req *models.VerifyEmailRequest) (*models.User, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	claims, err := s.jwtManager.ValidateActionToken(req.Token, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification token")
+	}
+
+	storedToken, err := s.oneTimeTokenRepo.Consume(models.TokenPurposeEmailVerification, utils.HashToken(req.Token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume verification token: %w", err)
+	}
+	if storedToken == nil || storedToken.UserID != claims.UserID {
+		return nil, fmt.Errorf("invalid verification token")
+	}
+
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// The token is only good for the address it was sent to
+	if user == nil || user.Email != claims.Email {
+		return nil, fmt.Errorf("invalid verification token")
+	}
+
+	if user.EmailVerifiedAt == nil {
+		if err := s.userRepo.MarkEmailVerified(user.ID); err != nil {
+			return nil, fmt.Errorf("failed to verify email: %w", err)
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	// Remove password hash from response
+	user.PasswordHash = ""
+
+	return user, nil
+}
+
+// ResendVerificationEmail sends a fresh verification link to the user
+func (s *VerificationService) ResendVerificationEmail(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	if user.EmailVerifiedAt != nil {
+		return fmt.Errorf("email already verified")
+	}
+
+	lastSent, err := s.oneTimeTokenRepo.GetLatestCreatedAt(user.ID, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return fmt.Errorf("failed to check last verification email: %w", err)
+	}
+	if lastSent != nil && time.Since(*lastSent) < emailVerificationCooldown {
+		return fmt.Errorf("verification email recently sent")
+	}
+
+	return s.SendVerificationEmail(ctx, user)
+}
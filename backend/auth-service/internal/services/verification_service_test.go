@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/mailer"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	userColumns         = []string{"id", "username", "display_name", "email", "password_hash", "email_verified_at", "created_at"}
+	oneTimeTokenColumns = []string{"id", "user_id", "purpose", "token_hash", "payload", "expires_at", "used_at", "created_at"}
+)
+
+// recordingMailer keeps the messages it is asked to send
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+	sent     chan struct{}
+}
+
+func newRecordingMailer() *recordingMailer {
+	return &recordingMailer{sent: make(chan struct{}, 10)}
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	m.mu.Unlock()
+	m.sent <- struct{}{}
+	return nil
+}
+
+func (m *recordingMailer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages)
+}
+
+// linkToken returns the token of the link in the only message sent
+func (m *recordingMailer) linkToken(t *testing.T, path string) string {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(m.messages))
+	}
+
+	body := m.messages[0].Body
+	start := strings.Index(body, path+"?token=")
+	if start < 0 {
+		t.Fatalf("no %s link in %q", path, body)
+	}
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return link.Query().Get("token")
+}
+
+type verificationTest struct {
+	service *VerificationService
+	mock    sqlmock.Sqlmock
+	mailer  *recordingMailer
+	user    *models.User
+}
+
+func newVerificationTest(t *testing.T) *verificationTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	recorder := newRecordingMailer()
+
+	return &verificationTest{
+		service: NewVerificationService(
+			database.NewUserRepository(db),
+			database.NewOneTimeTokenRepository(db),
+			newTestJWTManager(t),
+			recorder,
+			"https://threads.example",
+		),
+		mock:   mock,
+		mailer: recorder,
+		user: &models.User{
+			ID:          uuid.New(),
+			Username:    "alice",
+			DisplayName: "Alice",
+			Email:       "alice@example.com",
+		},
+	}
+}
+
+// expectIssue expects a new token of the purpose to replace older ones
+func expectIssue(mock sqlmock.Sqlmock, userID uuid.UUID, purpose string) *capture {
+	tokenHash := &capture{}
+	mock.ExpectExec(`UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP\s+WHERE user_id`).
+		WithArgs(userID, purpose).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO one_time_tokens`).
+		WithArgs(userID, purpose, tokenHash, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(oneTimeTokenColumns).
+			AddRow(uuid.New(), userID, purpose, "stored", nil, time.Now().Add(time.Hour), nil, time.Now()))
+	return tokenHash
+}
+
+// expectConsume expects the token to be redeemed, returning it as stored for
+// ownerID, or as already used when ownerID is nil
+func expectConsume(mock sqlmock.Sqlmock, purpose, token string, ownerID *uuid.UUID) {
+	query := mock.ExpectQuery(`UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP\s+WHERE token_hash`).
+		WithArgs(utils.HashToken(token), purpose)
+	rows := sqlmock.NewRows(oneTimeTokenColumns)
+	if ownerID != nil {
+		rows.AddRow(uuid.New(), *ownerID, purpose, utils.HashToken(token), nil, time.Now().Add(time.Hour), time.Now(), time.Now())
+	}
+	query.WillReturnRows(rows)
+}
+
+func (v *verificationTest) expectUser(email string, verified bool) {
+	var verifiedAt interface{}
+	if verified {
+		verifiedAt = time.Now().Add(-time.Hour)
+	}
+	v.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(v.user.ID).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(v.user.ID, v.user.Username, v.user.DisplayName, email, "password-hash", verifiedAt, time.Now()))
+}
+
+func TestVerifyEmail(t *testing.T) {
+	v := newVerificationTest(t)
+
+	tokenHash := expectIssue(v.mock, v.user.ID, models.TokenPurposeEmailVerification)
+	if err := v.service.SendVerificationEmail(context.Background(), v.user); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+
+	token := v.mailer.linkToken(t, "https://threads.example/verify-email")
+	if tokenHash.value != utils.HashToken(token) {
+		t.Fatal("stored hash does not match the mailed token")
+	}
+
+	expectConsume(v.mock, models.TokenPurposeEmailVerification, token, &v.user.ID)
+	v.expectUser(v.user.Email, false)
+	v.mock.ExpectExec(`UPDATE users SET email_verified_at`).
+		WithArgs(v.user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user, err := v.service.VerifyEmail(&models.VerifyEmailRequest{Token: token})
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if user.EmailVerifiedAt == nil || user.PasswordHash != "" {
+		t.Errorf("verified user %+v", user)
+	}
+
+	// The link only works once
+	expectConsume(v.mock, models.TokenPurposeEmailVerification, token, nil)
+	if _, err := v.service.VerifyEmail(&models.VerifyEmailRequest{Token: token}); err == nil || err.Error() != "invalid verification token" {
+		t.Errorf("second VerifyEmail = %v, want invalid verification token", err)
+	}
+}
+
+func TestVerifyEmailRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// token returns the token to present, setting up the database
+		token func(t *testing.T, v *verificationTest) string
+	}{
+		{
+			name: "garbage",
+			token: func(t *testing.T, v *verificationTest) string {
+				return "not-a-token"
+			},
+		},
+		{
+			name: "token of another purpose",
+			token: func(t *testing.T, v *verificationTest) string {
+				return v.actionToken(t, "password_reset", v.user.Email)
+			},
+		},
+		{
+			name: "token stored for another user",
+			token: func(t *testing.T, v *verificationTest) string {
+				token := v.actionToken(t, models.TokenPurposeEmailVerification, v.user.Email)
+				other := uuid.New()
+				expectConsume(v.mock, models.TokenPurposeEmailVerification, token, &other)
+				return token
+			},
+		},
+		{
+			name: "email changed since the link was sent",
+			token: func(t *testing.T, v *verificationTest) string {
+				token := v.actionToken(t, models.TokenPurposeEmailVerification, "old@example.com")
+				expectConsume(v.mock, models.TokenPurposeEmailVerification, token, &v.user.ID)
+				v.expectUser(v.user.Email, false)
+				return token
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVerificationTest(t)
+
+			_, err := v.service.VerifyEmail(&models.VerifyEmailRequest{Token: tt.token(t, v)})
+			if err == nil || err.Error() != "invalid verification token" {
+				t.Errorf("VerifyEmail = %v, want invalid verification token", err)
+			}
+		})
+	}
+}
+
+func (v *verificationTest) actionToken(t *testing.T, purpose, email string) string {
+	t.Helper()
+
+	token, err := v.service.jwtManager.GenerateActionToken(v.user.ID, purpose, email, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateActionToken: %v", err)
+	}
+	return token
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		verified bool
+		// lastSent is how long ago the previous link was sent, zero if never
+		lastSent time.Duration
+		wantErr  string
+	}{
+		{name: "first link"},
+		{name: "after the cooldown", lastSent: 2 * emailVerificationCooldown},
+		{name: "within the cooldown", lastSent: emailVerificationCooldown / 2, wantErr: "verification email recently sent"},
+		{name: "already verified", verified: true, wantErr: "email already verified"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVerificationTest(t)
+
+			v.expectUser(v.user.Email, tt.verified)
+			if !tt.verified {
+				rows := sqlmock.NewRows([]string{"created_at"})
+				if tt.lastSent != 0 {
+					rows.AddRow(time.Now().Add(-tt.lastSent))
+				}
+				v.mock.ExpectQuery(`SELECT created_at FROM one_time_tokens`).
+					WithArgs(v.user.ID, models.TokenPurposeEmailVerification).
+					WillReturnRows(rows)
+			}
+			if tt.wantErr == "" {
+				expectIssue(v.mock, v.user.ID, models.TokenPurposeEmailVerification)
+			}
+
+			err := v.service.ResendVerificationEmail(context.Background(), v.user.ID)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ResendVerificationEmail: %v", err)
+				}
+				if v.mailer.count() != 1 {
+					t.Errorf("sent %d messages, want 1", v.mailer.count())
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ResendVerificationEmail = %v, want %s", err, tt.wantErr)
+			}
+			if v.mailer.count() != 0 {
+				t.Errorf("sent %d messages, want none", v.mailer.count())
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/utils"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const webAuthnCeremonyTTL = 5 * time.Minute
+
+type WebAuthnService struct {
+	webAuthn     *webauthn.WebAuthn
+	webAuthnRepo *database.WebAuthnRepository
+	userRepo     *database.UserRepository
+	authService  *AuthService
+	jwtManager   *utils.JWTManager
+}
+
+func NewWebAuthnService(
+	cfg *configs.Config,
+	webAuthnRepo *database.WebAuthnRepository,
+	userRepo *database.UserRepository,
+	authService *AuthService,
+	jwtManager *utils.JWTManager,
+) (*WebAuthnService, error) {
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    webAuthnCeremonyTTL,
+		TimeoutUVD: webAuthnCeremonyTTL,
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid webauthn configuration: %w", err)
+	}
+
+	return &WebAuthnService{
+		webAuthn:     webAuthn,
+		webAuthnRepo: webAuthnRepo,
+		userRepo:     userRepo,
+		authService:  authService,
+		jwtManager:   jwtManager,
+	}, nil
+}
+
+// BeginRegistration creates the options for navigator.credentials.create.
+// Passkeys are registered as discoverable credentials so they can be used
+// without entering an email.
+func (s *WebAuthnService) BeginRegistration(userID uuid.UUID) (*models.WebAuthnBeginResponse, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	return s.createSession(models.WebAuthnCeremonyRegistration, &userID, session, options)
+}
+
+// FinishRegistration verifies the attestation and stores the new passkey
+func (s *WebAuthnService) FinishRegistration(userID uuid.UUID, req *models.WebAuthnFinishRequest) (*models.WebAuthnCredential, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	session, err := s.consumeSession(models.WebAuthnCeremonyRegistration, req.SessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != userID {
+		return nil, fmt.Errorf("invalid webauthn session")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	credential, err := s.webAuthn.CreateCredential(user, *session.data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	existing, err := s.webAuthnRepo.GetByCredentialID(credential.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("passkey already registered")
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	passkey, err := s.webAuthnRepo.Create(&models.WebAuthnCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            req.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.authService.RecordSecurityEvent(userID, models.SecurityEventPasskeyAdd, nil)
+	return passkey, nil
+}
+
+func (s *WebAuthnService) ListCredentials(userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	return s.webAuthnRepo.ListByUserID(userID)
+}
+
+func (s *WebAuthnService) DeleteCredential(userID, credentialID uuid.UUID) error {
+	deleted, err := s.webAuthnRepo.Delete(userID, credentialID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("passkey not found")
+	}
+
+	s.authService.RecordSecurityEvent(userID, models.SecurityEventPasskeyRemove, nil)
+	return nil
+}
+
+// BeginLogin creates the options for a usernameless passkey sign-in
+func (s *WebAuthnService) BeginLogin() (*models.WebAuthnBeginResponse, error) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	return s.createSession(models.WebAuthnCeremonyLogin, nil, session, options)
+}
+
+// FinishLogin signs the user in with a passkey. The passkey proves possession
+// and user verification, so no second factor is asked for.
+func (s *WebAuthnService) FinishLogin(req *models.WebAuthnFinishRequest, client *models.ClientInfo) (*models.AuthResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	session, err := s.consumeSession(models.WebAuthnCeremonyLogin, req.SessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	var user *webAuthnUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user handle")
+		}
+		user, err = s.loadUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("user not found")
+		}
+		return user, nil
+	}
+
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(findUser, *session.data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	if err := s.recordAssertion(user, credential); err != nil {
+		return nil, err
+	}
+
+	return s.authService.IssueTokens(user.User, time.Now(), client)
+}
+
+// BeginMFA creates the options for answering a login challenge with one of
+// the user's passkeys
+func (s *WebAuthnService) BeginMFA(req *models.WebAuthnMFABeginRequest) (*models.WebAuthnBeginResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	claims, err := s.jwtManager.ValidateActionToken(req.MFAToken, models.TokenPurposeMFAChallenge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+
+	user, err := s.loadUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+	if len(user.credentials) == 0 {
+		return nil, fmt.Errorf("no passkeys registered")
+	}
+
+	options, session, err := s.webAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey verification: %w", err)
+	}
+
+	return s.createSession(models.WebAuthnCeremonyMFA, &claims.UserID, session, options)
+}
+
+// FinishMFA completes a login challenge with a passkey assertion
+func (s *WebAuthnService) FinishMFA(req *models.WebAuthnMFAFinishRequest, client *models.ClientInfo) (*models.AuthResponse, error) {
+	// Validate request
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	claims, err := s.jwtManager.ValidateActionToken(req.MFAToken, models.TokenPurposeMFAChallenge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+	if err := s.authService.CheckMFAChallenge(req.MFAToken, claims.UserID); err != nil {
+		return nil, err
+	}
+
+	session, err := s.consumeSession(models.WebAuthnCeremonyMFA, req.SessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != claims.UserID {
+		return nil, fmt.Errorf("invalid webauthn session")
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	user, err := s.loadUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+
+	credential, err := s.webAuthn.ValidateLogin(user, *session.data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passkey")
+	}
+
+	if err := s.recordAssertion(user, credential); err != nil {
+		return nil, err
+	}
+
+	return s.authService.CompleteMFAChallenge(req.MFAToken, claims.UserID, client)
+}
+
+// recordAssertion stores the new signature counter of a verified assertion.
+// A counter that did not increase means the private key may exist on more
+// than one authenticator, so the credential is disabled.
+func (s *WebAuthnService) recordAssertion(user *webAuthnUser, credential *webauthn.Credential) error {
+	stored := user.findCredential(credential.ID)
+	if stored == nil {
+		return fmt.Errorf("invalid passkey")
+	}
+	if stored.CloneWarning {
+		return fmt.Errorf("passkey disabled")
+	}
+
+	if !credential.Authenticator.CloneWarning {
+		updated, err := s.webAuthnRepo.UpdateSignCount(stored.ID, int64(credential.Authenticator.SignCount), credential.Flags.BackupState)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+	}
+
+	if err := s.webAuthnRepo.MarkCloneWarning(stored.ID); err != nil {
+		return err
+	}
+	return fmt.Errorf("passkey disabled")
+}
+
+type webAuthnSession struct {
+	*models.WebAuthnSession
+	data *webauthn.SessionData
+}
+
+func (s *WebAuthnService) createSession(ceremony string, userID *uuid.UUID, session *webauthn.SessionData, options interface{}) (*models.WebAuthnBeginResponse, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.webAuthnRepo.CreateSession(&models.WebAuthnSession{
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionHash: utils.HashToken(token),
+		Data:        data,
+		ExpiresAt:   time.Now().Add(webAuthnCeremonyTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnBeginResponse{
+		SessionToken: token,
+		ExpiresIn:    int64(webAuthnCeremonyTTL.Seconds()),
+		Options:      options,
+	}, nil
+}
+
+func (s *WebAuthnService) consumeSession(ceremony, token string) (*webAuthnSession, error) {
+	session, err := s.webAuthnRepo.ConsumeSession(ceremony, utils.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("invalid webauthn session")
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn session: %w", err)
+	}
+
+	return &webAuthnSession{WebAuthnSession: session, data: &data}, nil
+}
+
+func (s *WebAuthnService) loadUser(userID uuid.UUID) (*webAuthnUser, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	credentials, err := s.webAuthnRepo.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{User: user, credentials: credentials}, nil
+}
+
+// webAuthnUser adapts a user and their passkeys to webauthn.User. The user
+// handle is the raw user ID.
+type webAuthnUser struct {
+	*models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.ID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.DisplayName
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, stored := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(stored.Transports))
+		for j, transport := range stored.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              stored.CredentialID,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       stored.AAGUID,
+				SignCount:    uint32(stored.SignCount),
+				CloneWarning: stored.CloneWarning,
+			},
+		}
+	}
+	return credentials
+}
+
+func (u *webAuthnUser) findCredential(credentialID []byte) *models.WebAuthnCredential {
+	for i := range u.credentials {
+		if string(u.credentials[i].CredentialID) == string(credentialID) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/database"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+)
+
+const (
+	testRPID   = "threads.example"
+	testOrigin = "https://threads.example"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagAttestedCreds = 0x40
+)
+
+// softAuthenticator is a passkey held in memory. It answers ceremonies the
+// way a platform authenticator would, with an ES256 key and none attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	// origin and rpID are what the browser reports, tests change them to
+	// play a phishing site
+	origin string
+	rpID   string
+	// flags of the next response, without the attested credential flag
+	flags byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("generate credential id: %v", err)
+	}
+
+	return &softAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		origin:       testOrigin,
+		rpID:         testRPID,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+// publicKey is the COSE encoding of the public key, as stored with the
+// credential
+func (a *softAuthenticator) publicKey(t *testing.T) []byte {
+	t.Helper()
+
+	encoded, err := coseEncoding.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	return encoded
+}
+
+// coseEncoding sorts map keys, so a key encodes to the same bytes every time
+var coseEncoding, _ = cbor.CoreDetEncOptions().EncMode()
+
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	clientData, err := json.Marshal(map[string]interface{}{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatalf("encode client data: %v", err)
+	}
+	return clientData
+}
+
+// create answers navigator.credentials.create
+func (a *softAuthenticator) create(t *testing.T, challenge protocol.URLEncodedBase64) json.RawMessage {
+	t.Helper()
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.publicKey(t)...)
+
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(a.flags|flagAttestedCreds, attested),
+	})
+	if err != nil {
+		t.Fatalf("encode attestation object: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeBase64URL(a.clientData(t, "webauthn.create", challenge)),
+		"attestationObject": encodeBase64URL(attestationObject),
+	})
+}
+
+// get answers navigator.credentials.get, signing with the given key
+func (a *softAuthenticator) get(t *testing.T, challenge protocol.URLEncodedBase64, userHandle []byte, key *ecdsa.PrivateKey) json.RawMessage {
+	t.Helper()
+
+	authData := a.authenticatorData(a.flags, nil)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeBase64URL(clientData),
+		"authenticatorData": encodeBase64URL(authData),
+		"signature":         encodeBase64URL(signature),
+		"userHandle":        encodeBase64URL(userHandle),
+	})
+}
+
+// credential wraps a response into the PublicKeyCredential the browser sends
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) json.RawMessage {
+	t.Helper()
+
+	credential, err := json.Marshal(map[string]interface{}{
+		"id":       encodeBase64URL(a.credentialID),
+		"rawId":    encodeBase64URL(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("encode credential: %v", err)
+	}
+	return credential
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+var webAuthnCredentialColumns = []string{"id", "user_id", "credential_id", "public_key", "attestation_type", "aaguid",
+	"sign_count", "transports", "backup_eligible", "backup_state", "clone_warning", "name", "last_used_at", "created_at"}
+
+type webAuthnTest struct {
+	service       *WebAuthnService
+	mock          sqlmock.Sqlmock
+	userID        uuid.UUID
+	authenticator *softAuthenticator
+	// storedID is the row ID of the registered passkey
+	storedID uuid.UUID
+}
+
+func newWebAuthnTest(t *testing.T) *webAuthnTest {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	userRepo := database.NewUserRepository(db)
+	webAuthnRepo := database.NewWebAuthnRepository(db)
+	jwtManager := newTestJWTManager(t)
+
+	authService := &AuthService{
+		userRepo:          userRepo,
+		refreshTokenRepo:  database.NewRefreshTokenRepository(db),
+		sessionRepo:       database.NewSessionRepository(db),
+		roleRepo:          database.NewRoleRepository(db),
+		securityEventRepo: database.NewSecurityEventRepository(db),
+		jwtManager:        jwtManager,
+	}
+
+	cfg := &configs.Config{WebAuthn: configs.WebAuthnConfig{
+		RPID:          testRPID,
+		RPDisplayName: "Threads",
+		RPOrigins:     []string{testOrigin},
+	}}
+	service, err := NewWebAuthnService(cfg, webAuthnRepo, userRepo, authService, jwtManager)
+	if err != nil {
+		t.Fatalf("NewWebAuthnService: %v", err)
+	}
+
+	return &webAuthnTest{
+		service:       service,
+		mock:          mock,
+		userID:        uuid.New(),
+		authenticator: newSoftAuthenticator(t),
+		storedID:      uuid.New(),
+	}
+}
+
+// expectUser answers loadUser, with the registered passkey if registered
+func (w *webAuthnTest) expectUser(t *testing.T, registered, cloneWarning bool, signCount int64) {
+	t.Helper()
+
+	w.mock.ExpectQuery(`FROM users WHERE id`).
+		WithArgs(w.userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "display_name", "credential_version", "created_at"}).
+			AddRow(w.userID, "john_doe", "john@example.com", "John Doe", 1, time.Now().Add(-24*time.Hour)))
+
+	rows := sqlmock.NewRows(webAuthnCredentialColumns)
+	if registered {
+		rows.AddRow(w.storedID, w.userID, w.authenticator.credentialID, w.authenticator.publicKey(t), "none", make([]byte, 16),
+			signCount, "{internal}", false, false, cloneWarning, "Laptop", nil, time.Now().Add(-time.Hour))
+	}
+	w.mock.ExpectQuery(`FROM webauthn_credentials WHERE user_id`).
+		WithArgs(w.userID).
+		WillReturnRows(rows)
+}
+
+// expectSession stores the ceremony begun next and returns a func that
+// answers its consumption, as the given user
+func (w *webAuthnTest) expectSession(ceremony string) func(userID *uuid.UUID) {
+	data := &capture{}
+	w.mock.ExpectExec(`INSERT INTO webauthn_sessions`).
+		WithArgs(sqlmock.AnyArg(), ceremony, sqlmock.AnyArg(), data, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	return func(userID *uuid.UUID) {
+		w.mock.ExpectQuery(`DELETE FROM webauthn_sessions`).
+			WithArgs(sqlmock.AnyArg(), ceremony).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "ceremony", "session_hash", "data", "expires_at", "created_at"}).
+				AddRow(uuid.New(), userID, ceremony, "", data.value, time.Now().Add(webAuthnCeremonyTTL), time.Now()))
+	}
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the authenticator or challenge before it answers
+		tamper func(a *softAuthenticator, challenge protocol.URLEncodedBase64) protocol.URLEncodedBase64
+		// otherUser finishes the ceremony begun by the user
+		otherUser         bool
+		alreadyRegistered bool
+		wantErr           string
+	}{
+		{name: "registers a passkey"},
+		{
+			name: "origin of another site",
+			tamper: func(a *softAuthenticator, challenge protocol.URLEncodedBase64) protocol.URLEncodedBase64 {
+				a.origin = "https://threads.example.evil"
+				return challenge
+			},
+			wantErr: "invalid passkey",
+		},
+		{
+			name: "relying party of another site",
+			tamper: func(a *softAuthenticator, challenge protocol.URLEncodedBase64) protocol.URLEncodedBase64 {
+				a.rpID = "evil.example"
+				return challenge
+			},
+			wantErr: "invalid passkey",
+		},
+		{
+			name: "answer to another challenge",
+			tamper: func(a *softAuthenticator, challenge protocol.URLEncodedBase64) protocol.URLEncodedBase64 {
+				return protocol.URLEncodedBase64("another challenge")
+			},
+			wantErr: "invalid passkey",
+		},
+		{name: "ceremony of another user", otherUser: true, wantErr: "invalid webauthn session"},
+		{name: "passkey registered before", alreadyRegistered: true, wantErr: "passkey already registered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWebAuthnTest(t)
+
+			w.expectUser(t, false, false, 0)
+			consume := w.expectSession(models.WebAuthnCeremonyRegistration)
+			begin, err := w.service.BeginRegistration(w.userID)
+			if err != nil {
+				t.Fatalf("BeginRegistration: %v", err)
+			}
+
+			options := begin.Options.(*protocol.CredentialCreation)
+			if options.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+				t.Errorf("resident key = %q, want required", options.Response.AuthenticatorSelection.ResidentKey)
+			}
+
+			challenge := options.Response.Challenge
+			if tt.tamper != nil {
+				challenge = tt.tamper(w.authenticator, challenge)
+			}
+			credential := w.authenticator.create(t, challenge)
+
+			finishingUser := w.userID
+			if tt.otherUser {
+				finishingUser = uuid.New()
+			}
+			consume(&w.userID)
+
+			storedKey := &capture{}
+			if !tt.otherUser {
+				w.expectUser(t, false, false, 0)
+			}
+			if tt.wantErr == "" || tt.alreadyRegistered {
+				rows := sqlmock.NewRows(webAuthnCredentialColumns)
+				if tt.alreadyRegistered {
+					rows.AddRow(uuid.New(), uuid.New(), w.authenticator.credentialID, []byte{}, "none", make([]byte, 16),
+						0, "{}", false, false, false, "", nil, time.Now())
+				}
+				w.mock.ExpectQuery(`FROM webauthn_credentials WHERE credential_id`).
+					WithArgs(w.authenticator.credentialID).
+					WillReturnRows(rows)
+			}
+			if tt.wantErr == "" {
+				w.mock.ExpectQuery(`INSERT INTO webauthn_credentials`).
+					WithArgs(w.userID, w.authenticator.credentialID, storedKey, "none", sqlmock.AnyArg(), 0, sqlmock.AnyArg(), false, false, "Laptop").
+					WillReturnRows(sqlmock.NewRows(webAuthnCredentialColumns).
+						AddRow(w.storedID, w.userID, w.authenticator.credentialID, w.authenticator.publicKey(t), "none", make([]byte, 16),
+							0, "{}", false, false, false, "Laptop", nil, time.Now()))
+				w.mock.ExpectExec(`INSERT INTO security_events`).
+					WithArgs(w.userID, models.SecurityEventPasskeyAdd, "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			passkey, err := w.service.FinishRegistration(finishingUser, &models.WebAuthnFinishRequest{
+				SessionToken: begin.SessionToken,
+				Credential:   credential,
+				Name:         "Laptop",
+			})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("FinishRegistration = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FinishRegistration: %v", err)
+			}
+			if passkey.ID != w.storedID {
+				t.Errorf("passkey = %s, want %s", passkey.ID, w.storedID)
+			}
+			if string(storedKey.value.([]byte)) != string(w.authenticator.publicKey(t)) {
+				t.Error("stored public key isn't the authenticator's")
+			}
+		})
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// storedCount is the counter saved by the previous assertion and
+		// signCount the one the authenticator reports
+		storedCount  int64
+		signCount    uint32
+		cloneWarning bool
+		// counterRaced makes a concurrent assertion store the counter first
+		counterRaced bool
+		// withoutUV answers without user verification
+		withoutUV bool
+		// wrongKey signs with a key other than the registered one
+		wrongKey bool
+		wantErr  string
+	}{
+		{name: "signs in with a passkey", storedCount: 4, signCount: 5},
+		{name: "authenticator without a counter", storedCount: 0, signCount: 0},
+		{name: "counter going backwards disables the passkey", storedCount: 9, signCount: 5, wantErr: "passkey disabled"},
+		{name: "counter stored by a concurrent assertion disables the passkey", storedCount: 4, signCount: 5, counterRaced: true, wantErr: "passkey disabled"},
+		{name: "passkey disabled before", storedCount: 4, signCount: 5, cloneWarning: true, wantErr: "passkey disabled"},
+		{name: "user not verified", storedCount: 4, signCount: 5, withoutUV: true, wantErr: "invalid passkey"},
+		{name: "signature of another key", storedCount: 4, signCount: 5, wrongKey: true, wantErr: "invalid passkey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWebAuthnTest(t)
+
+			consume := w.expectSession(models.WebAuthnCeremonyLogin)
+			begin, err := w.service.BeginLogin()
+			if err != nil {
+				t.Fatalf("BeginLogin: %v", err)
+			}
+			options := begin.Options.(*protocol.CredentialAssertion)
+
+			w.authenticator.signCount = tt.signCount
+			if tt.withoutUV {
+				w.authenticator.flags = flagUserPresent
+			}
+			key := w.authenticator.key
+			if tt.wrongKey {
+				key = newSoftAuthenticator(t).key
+			}
+			credential := w.authenticator.get(t, options.Response.Challenge, w.userID[:], key)
+
+			consume(nil)
+			w.expectUser(t, true, tt.cloneWarning, tt.storedCount)
+
+			// The library flags a counter that didn't increase, such
+			// assertions never reach the database
+			counterIncreased := int64(tt.signCount) > tt.storedCount || (tt.signCount == 0 && tt.storedCount == 0)
+			verified := tt.wantErr != "invalid passkey"
+			if verified && !tt.cloneWarning {
+				if counterIncreased {
+					affected := int64(1)
+					if tt.counterRaced {
+						affected = 0
+					}
+					w.mock.ExpectExec(`UPDATE webauthn_credentials SET sign_count`).
+						WithArgs(w.storedID, int64(tt.signCount), false).
+						WillReturnResult(sqlmock.NewResult(0, affected))
+				}
+				if tt.wantErr == "passkey disabled" {
+					w.mock.ExpectExec(`UPDATE webauthn_credentials SET clone_warning = TRUE`).
+						WithArgs(w.storedID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			sessionID := uuid.New()
+			if tt.wantErr == "" {
+				w.mock.ExpectQuery(`INSERT INTO sessions`).
+					WillReturnRows(sqlmock.NewRows(sessionColumns).
+						AddRow(sessionID, w.userID, "test", "127.0.0.1", nil, "", time.Now().Add(time.Hour), nil, time.Now(), time.Now()))
+				w.mock.ExpectQuery(`FROM user_roles WHERE user_id`).
+					WithArgs(w.userID).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "role", "granted_by", "granted_at"}))
+				w.mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+						AddRow(uuid.New(), w.userID, sessionID, "", time.Now(), time.Now().Add(time.Hour), nil, nil, time.Now()))
+				w.mock.ExpectExec(`INSERT INTO security_events`).
+					WithArgs(w.userID, models.SecurityEventLogin, "127.0.0.1", "test").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			response, err := w.service.FinishLogin(&models.WebAuthnFinishRequest{
+				SessionToken: begin.SessionToken,
+				Credential:   credential,
+			}, &models.ClientInfo{IP: "127.0.0.1", UserAgent: "test"})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("FinishLogin = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FinishLogin: %v", err)
+			}
+
+			claims, err := w.service.jwtManager.ValidateToken(response.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.UserID != w.userID || claims.SessionID != sessionID {
+				t.Errorf("claims = user %s session %s, want user %s session %s", claims.UserID, claims.SessionID, w.userID, sessionID)
+			}
+		})
+	}
+}
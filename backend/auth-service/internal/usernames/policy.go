@@ -0,0 +1,93 @@
+package usernames
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Length bounds of a username
+const (
+	MinLength = 3
+	MaxLength = 30
+)
+
+// routeNames collide with routes under /users and are reserved whatever the
+// configured list says
+var routeNames = []string{
+	"me", "profile", "password", "username", "tokens", "sessions", "identities",
+	"mfa", "webauthn", "exports",
+}
+
+// Policy decides which usernames can be taken. Reserved names are compared by
+// skeleton, so look-alikes of a reserved name are reserved too.
+type Policy struct {
+	reserved map[string]struct{}
+}
+
+func NewPolicy(reserved []string) *Policy {
+	p := &Policy{reserved: make(map[string]struct{})}
+	for _, name := range append(reserved, routeNames...) {
+		if skeleton := Skeleton(name); skeleton != "" {
+			p.reserved[skeleton] = struct{}{}
+		}
+	}
+	return p
+}
+
+// Check returns why the username can't be taken, if anything. Whether
+// someone else uses it, or a look-alike, is up to the caller.
+func (p *Policy) Check(username string) error {
+	if len(username) < MinLength || len(username) > MaxLength || !ValidFormat(username) {
+		return fmt.Errorf("invalid username")
+	}
+	if p.Reserved(username) {
+		return fmt.Errorf("username reserved")
+	}
+	return nil
+}
+
+// Reserved reports whether the username or a look-alike of it is reserved
+func (p *Policy) Reserved(username string) bool {
+	_, ok := p.reserved[Skeleton(username)]
+	return ok
+}
+
+// ValidFormat reports whether the username uses only letters, digits,
+// underscores and periods, without a period at either end or two in a row.
+// Length is checked separately.
+func ValidFormat(username string) bool {
+	if username == "" || username[0] == '.' || username[len(username)-1] == '.' || strings.Contains(username, "..") {
+		return false
+	}
+
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sanitize turns a display name or email prefix into a username candidate:
+// lowercase, disallowed characters dropped and cut to leave room for a
+// numeric suffix. It returns "user" when nothing usable is left.
+func Sanitize(base string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(Fold(base)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+
+	candidate := b.String()
+	if len(candidate) > MaxLength-8 {
+		candidate = candidate[:MaxLength-8]
+	}
+	if len(candidate) < MinLength {
+		return "user" + candidate
+	}
+	return candidate
+}
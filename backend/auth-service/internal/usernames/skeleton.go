@@ -0,0 +1,93 @@
+package usernames
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The ASCII rules of the skeleton: each of asciiLookAlikes becomes the
+// letter at the same position of asciiTargets, separators are dropped, then
+// each sequence becomes its replacement. SkeletonSQL builds the SQL the
+// migrations compute skeletons with from the same rules.
+const (
+	asciiLookAlikes = "01i"
+	asciiTargets    = "oll"
+	separators      = "._-"
+)
+
+// sequenceReplacements are pairs of a sequence looking like a single letter
+// and that letter
+var sequenceReplacements = []string{"rn", "m", "vv", "w"}
+
+var sequences = strings.NewReplacer(sequenceReplacements...)
+
+// confusables maps characters to the ASCII letter they are mistaken for.
+// Digits and letters that look alike collapse to one of them, and Cyrillic
+// and Greek homoglyphs to their Latin twin.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'ɡ': 'g', 'һ': 'h',
+	'і': 'l', 'ї': 'l', 'ӏ': 'l', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x',
+
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+
+	// Latin look-alikes
+	'ı': 'l', 'ℓ': 'l', 'ł': 'l', 'ø': 'o',
+}
+
+func init() {
+	for i, r := range asciiLookAlikes {
+		confusables[r] = rune(asciiTargets[i])
+	}
+}
+
+// Skeleton reduces a username to the form look-alikes share, e.g. j0hn_doe,
+// John.Doe and јohn_doe (Cyrillic ј) all become johndoe. Two usernames with
+// the same skeleton can't coexist.
+//
+// For ASCII usernames this is lowercasing, translating 0, 1 and i to o, l
+// and l, dropping _ . and -, then replacing rn and vv with m and w, which is
+// what SkeletonSQL computes.
+func Skeleton(username string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(Fold(username)) {
+		// Separators are ignored, so john_doe, john.doe and johndoe collide
+		if strings.ContainsRune(separators, r) {
+			continue
+		}
+		if mapped, ok := confusables[r]; ok {
+			r = mapped
+		}
+		b.WriteRune(r)
+	}
+	return sequences.Replace(b.String())
+}
+
+// SkeletonSQL returns the Postgres expression computing the skeleton of the
+// column for ASCII usernames. Non-ASCII usernames are only lowercased, their
+// skeleton needs Skeleton.
+func SkeletonSQL(column string) string {
+	expr := fmt.Sprintf("translate(lower(%s), '%s%s', '%s')", column, asciiLookAlikes, separators, asciiTargets)
+	for i := 0; i < len(sequenceReplacements); i += 2 {
+		expr = fmt.Sprintf("replace(%s, '%s', '%s')", expr, sequenceReplacements[i], sequenceReplacements[i+1])
+	}
+	return expr
+}
+
+// Fold decomposes compatibility characters, such as fullwidth letters, and
+// strips accents
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package usernames
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"john_doe", "johndoe"},
+		{"John.Doe", "johndoe"},
+		{"j0hn-doe", "johndoe"},
+		{"јohn_doe", "johndoe"}, // Cyrillic ј
+		{"ｊｏｈｎ", "john"},        // fullwidth
+		{"jóhn", "john"},
+		{"bill1", "bllll"},
+		{"modern", "modem"},
+		{"rnodern", "modem"},
+		{"vvolf", "wolf"},
+		{"vvv", "wv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := Skeleton(tt.username); got != tt.want {
+				t.Errorf("Skeleton(%q) = %q, want %q", tt.username, got, tt.want)
+			}
+		})
+	}
+}
+
+// The migrations backfill skeletons in SQL, they must follow the rules of
+// Skeleton
+func TestSkeletonSQLMatchesMigrations(t *testing.T) {
+	migration, err := os.ReadFile("../../migrations/019_add_username_policy.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+
+	for _, column := range []string{"username", "old_username"} {
+		if expr := SkeletonSQL(column); !strings.Contains(string(migration), expr) {
+			t.Errorf("migration doesn't compute the skeleton of %s as %s", column, expr)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"log"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
@@ -10,49 +13,234 @@ import (
 )
 
 type JWTClaims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
-	Email    string    `json:"email"`
+	UserID            uuid.UUID        `json:"user_id"`
+	Username          string           `json:"username"`
+	Email             string           `json:"email"`
+	AuthTime          *jwt.NumericDate `json:"auth_time,omitempty"`
+	CredentialVersion int              `json:"cv"`
+	SessionID         uuid.UUID        `json:"sid"`
+	Roles             []string         `json:"roles,omitempty"`
+	Permissions       []string         `json:"permissions,omitempty"`
+	// Scope and ClientID are only set on tokens issued to third-party OAuth apps
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// PersonalAccessTokenID is set when the request was made with a personal
+	// access token instead of a JWT, Scope then holds the token's scopes
+	PersonalAccessTokenID uuid.UUID `json:"-"`
+	// IssuedAtMicros is when the token was issued in Unix microseconds. iat
+	// only has second precision, too coarse to tell a token issued right
+	// after a logout from everywhere from one issued before it.
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IssueTime returns when the token was issued, falling back to iat for
+// tokens issued without iat_us
+func (c *JWTClaims) IssueTime() time.Time {
+	if c.IssuedAtMicros != 0 {
+		return time.UnixMicro(c.IssuedAtMicros)
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// TokenParams describes the subject of an access token
+type TokenParams struct {
+	UserID            uuid.UUID
+	Username          string
+	Email             string
+	AuthTime          time.Time // when the user last presented credentials
+	CredentialVersion int
+	SessionID         uuid.UUID
+	Roles             []string
+	Permissions       []string
+	Scope             string
+	ClientID          string
+}
+
+// IDTokenParams describes an OpenID Connect ID token issued to a third-party
+// app. Claims holds the user claims its scopes allow, such as email.
+type IDTokenParams struct {
+	UserID   uuid.UUID
+	ClientID string
+	Nonce    string
+	AuthTime time.Time
+	Claims   map[string]interface{}
+}
+
+// ActionClaims are carried by short-lived, purpose bound tokens such as email
+// verification links. They are never accepted as access tokens.
+type ActionClaims struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Purpose string    `json:"purpose"`
+	Email   string    `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// accessTokenType is the typ header of access tokens (RFC 9068)
+const accessTokenType = "at+jwt"
+
 type JWTManager struct {
-	secretKey []byte
-	expiresIn time.Duration
+	keyring          *Keyring
+	issuer           string
+	expiresIn        time.Duration
+	refreshExpiresIn time.Duration
 }
 
-func NewJWTManager(cfg *configs.Config) *JWTManager {
+func NewJWTManager(cfg *configs.Config) (*JWTManager, error) {
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &JWTManager{
-		secretKey: []byte(cfg.JWT.Secret),
-		expiresIn: cfg.JWT.ExpiresIn,
+		keyring:          keyring,
+		issuer:           strings.TrimRight(cfg.OAuthServer.Issuer, "/"),
+		expiresIn:        cfg.JWT.ExpiresIn,
+		refreshExpiresIn: cfg.JWT.RefreshExpiresIn,
+	}, nil
+}
+
+// loadKeyring prefers a keyring directory and falls back to a single key file
+func loadKeyring(cfg *configs.Config) (*Keyring, error) {
+	if cfg.JWT.KeyringDir != "" {
+		keyring, err := LoadKeyring(cfg.JWT.KeyringDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := keyring.SigningKey(time.Now()); err != nil {
+			return nil, fmt.Errorf("keyring %s: %w", cfg.JWT.KeyringDir, err)
+		}
+		return keyring, nil
+	}
+
+	var signingKey *SigningKey
+	var err error
+
+	if cfg.JWT.PrivateKeyPath != "" {
+		signingKey, err = LoadSigningKey(cfg.JWT.PrivateKeyPath, cfg.JWT.KeyID)
+	} else if cfg.Server.Env != "production" {
+		// Ephemeral key for local development, tokens won't survive a restart
+		log.Println("JWT_PRIVATE_KEY_PATH not set, generating an ephemeral signing key")
+		signingKey, err = GenerateSigningKey(cfg.JWT.Algorithm)
+	} else {
+		err = fmt.Errorf("JWT_KEYRING_DIR or JWT_PRIVATE_KEY_PATH is required in production")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if signingKey.Algorithm != cfg.JWT.Algorithm {
+		return nil, fmt.Errorf("signing key is %s but JWT_SIGNING_ALG is %s", signingKey.Algorithm, cfg.JWT.Algorithm)
 	}
+
+	return NewStaticKeyring(signingKey), nil
 }
 
-func (j *JWTManager) GenerateToken(userID uuid.UUID, username, email string) (string, error) {
+// WatchKeyring reloads the keyring periodically so scheduled rotations and
+// keys promoted with keyctl take effect without a restart
+func (j *JWTManager) WatchKeyring(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := j.keyring.Reload(); err != nil {
+			log.Println("Failed to reload JWT keyring:", err)
+		}
+	}
+}
+
+func (j *JWTManager) GenerateToken(params TokenParams) (string, error) {
+	now := time.Now()
 	claims := JWTClaims{
-		UserID:   userID,
-		Username: username,
-		Email:    email,
+		UserID:            params.UserID,
+		Username:          params.Username,
+		Email:             params.Email,
+		AuthTime:          jwt.NewNumericDate(params.AuthTime),
+		CredentialVersion: params.CredentialVersion,
+		SessionID:         params.SessionID,
+		Roles:             params.Roles,
+		Permissions:       params.Permissions,
+		Scope:             params.Scope,
+		ClientID:          params.ClientID,
+		IssuedAtMicros:    now.UnixMicro(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.expiresIn)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "threads-auth-service",
-			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    j.issuer,
+			Subject:   params.UserID.String(),
+			ID:        uuid.New().String(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(j.secretKey)
+	signingKey, err := j.keyring.SigningKey(now)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingKey.SigningMethod(), claims)
+	token.Header["kid"] = signingKey.KeyID
+	token.Header["typ"] = accessTokenType
+	return token.SignedString(signingKey.PrivateKey)
+}
+
+// GenerateIDToken signs an OpenID Connect ID token. It has no at+jwt typ so
+// it is never accepted as an access token.
+func (j *JWTManager) GenerateIDToken(params IDTokenParams) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for name, value := range params.Claims {
+		claims[name] = value
+	}
+	claims["iss"] = j.issuer
+	claims["sub"] = params.UserID.String()
+	claims["aud"] = params.ClientID
+	claims["exp"] = now.Add(j.expiresIn).Unix()
+	claims["iat"] = now.Unix()
+	claims["auth_time"] = params.AuthTime.Unix()
+	if params.Nonce != "" {
+		claims["nonce"] = params.Nonce
+	}
+
+	signingKey, err := j.keyring.SigningKey(now)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingKey.SigningMethod(), claims)
+	token.Header["kid"] = signingKey.KeyID
+	token.Header["typ"] = "JWT"
+	return token.SignedString(signingKey.PrivateKey)
+}
+
+// Issuer returns the public base URL of the service
+func (j *JWTManager) Issuer() string {
+	return j.issuer
+}
+
+// SigningAlgorithms lists the algorithms of the current verification keys
+func (j *JWTManager) SigningAlgorithms() []string {
+	seen := make(map[string]bool)
+	var algorithms []string
+	for _, key := range j.keyring.VerificationKeys(time.Now()) {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	return algorithms
 }
 
 func (j *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		if typ, _ := token.Header["typ"].(string); typ != accessTokenType {
+			return nil, fmt.Errorf("not an access token")
 		}
-		return j.secretKey, nil
-	})
+		return j.verificationKey(token)
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}), jwt.WithIssuer(j.issuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -70,6 +258,126 @@ func (j *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 	return claims, nil
 }
 
+// GenerateActionToken signs a token that is only valid for the given purpose.
+// The ttl can't exceed MaxActionTokenTTL, retired keys only verify for so long.
+func (j *JWTManager) GenerateActionToken(userID uuid.UUID, purpose, email string, ttl time.Duration) (string, error) {
+	if ttl > MaxActionTokenTTL {
+		return "", fmt.Errorf("action token lifetime %s exceeds %s", ttl, MaxActionTokenTTL)
+	}
+
+	claims := ActionClaims{
+		UserID:  userID,
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    j.issuer,
+			Subject:   userID.String(),
+			ID:        uuid.New().String(),
+		},
+	}
+
+	signingKey, err := j.keyring.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingKey.SigningMethod(), claims)
+	token.Header["kid"] = signingKey.KeyID
+	return token.SignedString(signingKey.PrivateKey)
+}
+
+// ValidateActionToken verifies a purpose bound token
+func (j *JWTManager) ValidateActionToken(tokenString, purpose string) (*ActionClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ActionClaims{}, j.verificationKey,
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}), jwt.WithIssuer(j.issuer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*ActionClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("token purpose mismatch")
+	}
+
+	return claims, nil
+}
+
+// verificationKey selects the public key for a token by its kid header
+func (j *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := j.keyring.VerificationKey(kid, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %v", token.Header["kid"])
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey(), nil
+}
+
+// AuthenticatedWithin reports whether the user presented credentials within the given window
+func (c *JWTClaims) AuthenticatedWithin(window time.Duration) bool {
+	return c.AuthTime != nil && time.Since(c.AuthTime.Time) <= window
+}
+
+// Scoped reports whether the token is limited to its scopes, as tokens of
+// third-party apps and personal access tokens are
+func (c *JWTClaims) Scoped() bool {
+	return c.ClientID != "" || c.PersonalAccessTokenID != uuid.Nil
+}
+
+// HasScopes reports whether a scoped token was granted every given scope.
+// First-party tokens carry no scope and are not limited by one.
+func (c *JWTClaims) HasScopes(scopes ...string) bool {
+	if !c.Scoped() {
+		return true
+	}
+
+	granted := strings.Fields(c.Scope)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasPermissions reports whether the token grants every given permission
+func (c *JWTClaims) HasPermissions(permissions ...string) bool {
+	for _, permission := range permissions {
+		if !slices.Contains(c.Permissions, permission) {
+			return false
+		}
+	}
+	return true
+}
+
 func (j *JWTManager) GetExpiresIn() int64 {
 	return int64(j.expiresIn.Seconds())
 }
+
+func (j *JWTManager) GetRefreshExpiresIn() int64 {
+	return int64(j.refreshExpiresIn.Seconds())
+}
+
+// GetRefreshExpiry returns the expiry time for a refresh token issued now
+func (j *JWTManager) GetRefreshExpiry() time.Time {
+	return time.Now().Add(j.refreshExpiresIn)
+}
+
+// JWKS returns the public keys that verify tokens issued by this manager
+func (j *JWTManager) JWKS() JWKS {
+	keys := j.keyring.VerificationKeys(time.Now())
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestJWTManager(t *testing.T) *JWTManager {
+	t.Helper()
+
+	signingKey, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	return &JWTManager{
+		keyring:          NewStaticKeyring(signingKey),
+		issuer:           "http://localhost:8080",
+		expiresIn:        15 * time.Minute,
+		refreshExpiresIn: 24 * time.Hour,
+	}
+}
+
+func TestGenerateTokenIssueTime(t *testing.T) {
+	manager := newTestJWTManager(t)
+
+	before := time.Now().Truncate(time.Microsecond)
+	token, err := manager.GenerateToken(TokenParams{UserID: uuid.New(), AuthTime: time.Now()})
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	after := time.Now()
+
+	claims, err := manager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	issued := claims.IssueTime()
+	if issued.Before(before) || issued.After(after) {
+		t.Errorf("IssueTime = %v, want between %v and %v", issued, before, after)
+	}
+	if issued.Truncate(time.Second) != claims.IssuedAt.Time {
+		t.Errorf("IssueTime %v disagrees with iat %v", issued, claims.IssuedAt.Time)
+	}
+}
+
+func TestJWTClaimsIssueTime(t *testing.T) {
+	precise := time.Date(2026, 1, 2, 3, 4, 5, 678901000, time.UTC)
+
+	tests := []struct {
+		name   string
+		claims JWTClaims
+		want   time.Time
+	}{
+		{
+			name: "prefers iat_us",
+			claims: JWTClaims{
+				IssuedAtMicros:   precise.UnixMicro(),
+				RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(precise)},
+			},
+			want: precise,
+		},
+		{
+			name:   "falls back to iat",
+			claims: JWTClaims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(precise)}},
+			want:   precise.Truncate(time.Second),
+		},
+		{
+			name: "zero without either",
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.IssueTime(); !got.Equal(tt.want) {
+				t.Errorf("IssueTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokensCarryIssuer(t *testing.T) {
+	manager := newTestJWTManager(t)
+	other := *manager
+	other.issuer = "https://other.example"
+
+	userID := uuid.New()
+	generate := map[string]func(m *JWTManager) (string, error){
+		"access": func(m *JWTManager) (string, error) {
+			return m.GenerateToken(TokenParams{UserID: userID, AuthTime: time.Now()})
+		},
+		"action": func(m *JWTManager) (string, error) {
+			return m.GenerateActionToken(userID, "test", "", time.Minute)
+		},
+	}
+	validate := map[string]func(m *JWTManager, token string) (jwt.Claims, error){
+		"access": func(m *JWTManager, token string) (jwt.Claims, error) {
+			return m.ValidateToken(token)
+		},
+		"action": func(m *JWTManager, token string) (jwt.Claims, error) {
+			return m.ValidateActionToken(token, "test")
+		},
+	}
+
+	for kind := range generate {
+		t.Run(kind, func(t *testing.T) {
+			token, err := generate[kind](manager)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			claims, err := validate[kind](manager, token)
+			if err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if issuer, _ := claims.GetIssuer(); issuer != manager.Issuer() {
+				t.Errorf("iss = %q, want %q", issuer, manager.Issuer())
+			}
+
+			// Same keys, another issuer
+			if _, err := validate[kind](&other, token); err == nil {
+				t.Error("token accepted by a manager with another issuer")
+			}
+		})
+	}
+}
+
+// Retired keys verify for RetiredKeyGrace, so no token they sign may outlive it
+func TestTokenLifetimesWithinRetiredKeyGrace(t *testing.T) {
+	manager := newTestJWTManager(t)
+	grace := RetiredKeyGrace(manager.expiresIn)
+
+	if grace <= manager.expiresIn || grace <= MaxActionTokenTTL {
+		t.Errorf("grace %s doesn't cover access tokens (%s) and action tokens (%s)", grace, manager.expiresIn, MaxActionTokenTTL)
+	}
+
+	if _, err := manager.GenerateActionToken(uuid.New(), "test", "", MaxActionTokenTTL); err != nil {
+		t.Errorf("GenerateActionToken at the limit: %v", err)
+	}
+	if _, err := manager.GenerateActionToken(uuid.New(), "test", "", MaxActionTokenTTL+time.Second); err == nil {
+		t.Error("GenerateActionToken signed a token outliving the grace period")
+	}
+}
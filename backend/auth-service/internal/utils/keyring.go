@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const keyringManifestFile = "keyring.json"
+
+const (
+	// KeyringReloadInterval is how often running instances reload the keyring
+	KeyringReloadInterval = time.Minute
+	// JWKSMaxAge is how long consumers may cache the published keys
+	JWKSMaxAge = 5 * time.Minute
+	// MaxActionTokenTTL bounds the lifetime of action tokens
+	MaxActionTokenTTL = 24 * time.Hour
+)
+
+// RetiredKeyGrace returns how long a retired key has to keep verifying so
+// every token it signed, access or action, expires first
+func RetiredKeyGrace(accessTokenTTL time.Duration) time.Duration {
+	return max(accessTokenTTL, MaxActionTokenTTL) + time.Hour
+}
+
+// PublishDelay is how long a new key takes to reach every verifier: running
+// instances pick it up on their next reload and JWKS consumers on their next
+// fetch. A key that signs earlier issues tokens they reject.
+const PublishDelay = KeyringReloadInterval + JWKSMaxAge
+
+const (
+	KeyStatusPending = "pending"
+	KeyStatusActive  = "active"
+	KeyStatusRetired = "retired"
+	KeyStatusExpired = "expired"
+)
+
+// KeyringEntry describes the lifecycle of one signing key. A key signs new
+// tokens between ActivateAt and RetireAt, and verifies tokens from the moment
+// it is published until ExpiresAt.
+type KeyringEntry struct {
+	KeyID      string     `json:"kid"`
+	Algorithm  string     `json:"alg"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ActivateAt time.Time  `json:"activate_at"`
+	RetireAt   *time.Time `json:"retire_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Status returns the lifecycle state of the key at the given time
+func (e *KeyringEntry) Status(now time.Time) string {
+	switch {
+	case e.ExpiresAt != nil && !now.Before(*e.ExpiresAt):
+		return KeyStatusExpired
+	case e.RetireAt != nil && !now.Before(*e.RetireAt):
+		return KeyStatusRetired
+	case now.Before(e.ActivateAt):
+		return KeyStatusPending
+	default:
+		return KeyStatusActive
+	}
+}
+
+// KeyringManifest is the on-disk description of a keyring directory. Private
+// keys are stored next to it as <kid>.pem.
+type KeyringManifest struct {
+	Keys []KeyringEntry `json:"keys"`
+}
+
+type keyringKey struct {
+	entry KeyringEntry
+	key   *SigningKey
+}
+
+// Keyring holds every signing key known to the service. The newest active key
+// signs, any key that is not expired verifies.
+type Keyring struct {
+	mu   sync.RWMutex
+	dir  string
+	keys []keyringKey
+}
+
+// NewStaticKeyring wraps a single key that is always active
+func NewStaticKeyring(key *SigningKey) *Keyring {
+	return &Keyring{
+		keys: []keyringKey{{
+			entry: KeyringEntry{KeyID: key.KeyID, Algorithm: key.Algorithm},
+			key:   key,
+		}},
+	}
+}
+
+// LoadKeyring reads a keyring directory
+func LoadKeyring(dir string) (*Keyring, error) {
+	keyring := &Keyring{dir: dir}
+	if err := keyring.Reload(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Reload re-reads the keyring directory so keys promoted by the admin command
+// are picked up without a restart. Static keyrings are left untouched.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	manifest, err := ReadKeyringManifest(k.dir)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]keyringKey, 0, len(manifest.Keys))
+	for _, entry := range manifest.Keys {
+		key, err := LoadSigningKey(filepath.Join(k.dir, entry.KeyID+".pem"), entry.KeyID)
+		if err != nil {
+			return fmt.Errorf("failed to load key %s: %w", entry.KeyID, err)
+		}
+		if key.Algorithm != entry.Algorithm {
+			return fmt.Errorf("key %s is %s but manifest says %s", entry.KeyID, key.Algorithm, entry.Algorithm)
+		}
+		keys = append(keys, keyringKey{entry: entry, key: key})
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+// SigningKey returns the most recently activated key that is not retired
+func (k *Keyring) SigningKey(now time.Time) (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var current *keyringKey
+	for i := range k.keys {
+		candidate := &k.keys[i]
+		if candidate.entry.Status(now) != KeyStatusActive {
+			continue
+		}
+		if current == nil || candidate.entry.ActivateAt.After(current.entry.ActivateAt) {
+			current = candidate
+		}
+	}
+
+	if current == nil {
+		return nil, fmt.Errorf("no active signing key")
+	}
+	return current.key, nil
+}
+
+// VerificationKey returns the key with the given kid unless it has expired
+func (k *Keyring) VerificationKey(keyID string, now time.Time) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, candidate := range k.keys {
+		if candidate.entry.KeyID == keyID && candidate.entry.Status(now) != KeyStatusExpired {
+			return candidate.key, true
+		}
+	}
+	return nil, false
+}
+
+// VerificationKeys returns every key that is not expired, including pending
+// keys so that verifiers learn about them before they start signing
+func (k *Keyring) VerificationKeys(now time.Time) []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, candidate := range k.keys {
+		if candidate.entry.Status(now) != KeyStatusExpired {
+			keys = append(keys, candidate.key)
+		}
+	}
+	return keys
+}
+
+// ReadKeyringManifest reads keyring.json from a keyring directory
+func ReadKeyringManifest(dir string) (*KeyringManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, keyringManifestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &KeyringManifest{}, nil
+		}
+		return nil, fmt.Errorf("failed to read keyring manifest: %w", err)
+	}
+
+	var manifest KeyringManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// WriteKeyringManifest atomically replaces keyring.json
+func WriteKeyringManifest(dir string, manifest *KeyringManifest) error {
+	sort.Slice(manifest.Keys, func(i, j int) bool {
+		return manifest.Keys[i].ActivateAt.Before(manifest.Keys[j].ActivateAt)
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keyring manifest: %w", err)
+	}
+
+	tmpPath := filepath.Join(dir, keyringManifestFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write keyring manifest: %w", err)
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, keyringManifestFile))
+}
+
+// Find returns the entry with the given kid
+func (m *KeyringManifest) Find(keyID string) *KeyringEntry {
+	for i := range m.Keys {
+		if m.Keys[i].KeyID == keyID {
+			return &m.Keys[i]
+		}
+	}
+	return nil
+}
+
+// Promote schedules a key to become the signing key at the given time. Keys
+// that are active or pending at that time are retired then and stay
+// verifiable for the grace period, so tokens they signed remain valid.
+func (m *KeyringManifest) Promote(keyID string, at time.Time, grace time.Duration) error {
+	promoted := m.Find(keyID)
+	if promoted == nil {
+		return fmt.Errorf("key %s not found", keyID)
+	}
+	if status := promoted.Status(at); status == KeyStatusRetired || status == KeyStatusExpired {
+		return fmt.Errorf("key %s is %s", keyID, status)
+	}
+
+	promoted.ActivateAt = at
+	promoted.RetireAt = nil
+	promoted.ExpiresAt = nil
+
+	for i := range m.Keys {
+		entry := &m.Keys[i]
+		if entry.KeyID == keyID {
+			continue
+		}
+		if status := entry.Status(at); status == KeyStatusActive || status == KeyStatusPending {
+			m.retire(entry, at, grace)
+		}
+	}
+
+	return nil
+}
+
+// Retire stops a key from signing at the given time and removes it from
+// verification after the grace period
+func (m *KeyringManifest) Retire(keyID string, at time.Time, grace time.Duration) error {
+	entry := m.Find(keyID)
+	if entry == nil {
+		return fmt.Errorf("key %s not found", keyID)
+	}
+	m.retire(entry, at, grace)
+	return nil
+}
+
+func (m *KeyringManifest) retire(entry *KeyringEntry, at time.Time, grace time.Duration) {
+	expiresAt := at.Add(grace)
+	entry.RetireAt = &at
+	entry.ExpiresAt = &expiresAt
+}
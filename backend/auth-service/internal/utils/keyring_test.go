@@ -0,0 +1,411 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var keyringEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// keyringAt returns the keyring epoch shifted by the given number of hours
+func keyringAt(hours int) time.Time {
+	return keyringEpoch.Add(time.Duration(hours) * time.Hour)
+}
+
+func keyringAtPtr(hours int) *time.Time {
+	t := keyringAt(hours)
+	return &t
+}
+
+func TestKeyringEntryStatus(t *testing.T) {
+	entry := KeyringEntry{ActivateAt: keyringAt(10), RetireAt: keyringAtPtr(20), ExpiresAt: keyringAtPtr(30)}
+
+	tests := []struct {
+		name  string
+		entry KeyringEntry
+		now   time.Time
+		want  string
+	}{
+		{name: "before activation", entry: entry, now: keyringAt(9), want: KeyStatusPending},
+		{name: "at activation", entry: entry, now: keyringAt(10), want: KeyStatusActive},
+		{name: "at retirement", entry: entry, now: keyringAt(20), want: KeyStatusRetired},
+		{name: "at expiry", entry: entry, now: keyringAt(30), want: KeyStatusExpired},
+		{name: "never retired", entry: KeyringEntry{ActivateAt: keyringAt(10)}, now: keyringAt(1000), want: KeyStatusActive},
+		{name: "expired while pending", entry: KeyringEntry{ActivateAt: keyringAt(10), ExpiresAt: keyringAtPtr(5)}, now: keyringAt(6), want: KeyStatusExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Status(tt.now); got != tt.want {
+				t.Errorf("Status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestKeyring builds a keyring from entries, generating a key for each
+func newTestKeyring(t *testing.T, entries ...KeyringEntry) (*Keyring, map[string]*SigningKey) {
+	t.Helper()
+
+	keyring := &Keyring{}
+	keys := make(map[string]*SigningKey)
+	for _, entry := range entries {
+		key, err := GenerateSigningKey(AlgorithmEdDSA)
+		if err != nil {
+			t.Fatalf("GenerateSigningKey: %v", err)
+		}
+		key.KeyID = entry.KeyID
+		entry.Algorithm = key.Algorithm
+		keyring.keys = append(keyring.keys, keyringKey{entry: entry, key: key})
+		keys[entry.KeyID] = key
+	}
+	return keyring, keys
+}
+
+func TestKeyringSigningKey(t *testing.T) {
+	keyring, _ := newTestKeyring(t,
+		KeyringEntry{KeyID: "old", ActivateAt: keyringAt(0), RetireAt: keyringAtPtr(20), ExpiresAt: keyringAtPtr(30)},
+		KeyringEntry{KeyID: "current", ActivateAt: keyringAt(10)},
+		KeyringEntry{KeyID: "next", ActivateAt: keyringAt(20)},
+	)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{name: "only key active", now: keyringAt(5), want: "old"},
+		{name: "newest active key wins", now: keyringAt(15), want: "current"},
+		{name: "scheduled key takes over", now: keyringAt(25), want: "next"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := keyring.SigningKey(tt.now)
+			if err != nil {
+				t.Fatalf("SigningKey: %v", err)
+			}
+			if key.KeyID != tt.want {
+				t.Errorf("signing key = %s, want %s", key.KeyID, tt.want)
+			}
+		})
+	}
+
+	t.Run("nothing active", func(t *testing.T) {
+		if _, err := keyring.SigningKey(keyringAt(-1)); err == nil {
+			t.Error("SigningKey returned a key before any was active")
+		}
+	})
+}
+
+func TestKeyringVerificationKeys(t *testing.T) {
+	keyring, _ := newTestKeyring(t,
+		KeyringEntry{KeyID: "expired", ActivateAt: keyringAt(0), RetireAt: keyringAtPtr(5), ExpiresAt: keyringAtPtr(10)},
+		KeyringEntry{KeyID: "retired", ActivateAt: keyringAt(5), RetireAt: keyringAtPtr(10), ExpiresAt: keyringAtPtr(30)},
+		KeyringEntry{KeyID: "active", ActivateAt: keyringAt(10)},
+		KeyringEntry{KeyID: "pending", ActivateAt: keyringAt(100)},
+	)
+	now := keyringAt(15)
+
+	var published []string
+	for _, key := range keyring.VerificationKeys(now) {
+		published = append(published, key.KeyID)
+	}
+	if want := []string{"retired", "active", "pending"}; !slices.Equal(published, want) {
+		t.Errorf("VerificationKeys = %v, want %v", published, want)
+	}
+
+	tests := []struct {
+		keyID string
+		want  bool
+	}{
+		{keyID: "expired", want: false},
+		{keyID: "retired", want: true},
+		{keyID: "active", want: true},
+		{keyID: "pending", want: true},
+		{keyID: "unknown", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.keyID, func(t *testing.T) {
+			key, ok := keyring.VerificationKey(tt.keyID, now)
+			if ok != tt.want {
+				t.Fatalf("VerificationKey ok = %v, want %v", ok, tt.want)
+			}
+			if ok && key.KeyID != tt.keyID {
+				t.Errorf("VerificationKey = %s, want %s", key.KeyID, tt.keyID)
+			}
+		})
+	}
+}
+
+func TestKeyringManifestPromote(t *testing.T) {
+	grace := 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		keys    []KeyringEntry
+		promote string
+		at      time.Time
+		wantErr bool
+		// want maps a kid to its expected status an hour before the
+		// promotion, at the promotion and one grace period later
+		want map[string][3]string
+	}{
+		{
+			name: "retires the active key",
+			keys: []KeyringEntry{
+				{KeyID: "a", ActivateAt: keyringAt(0)},
+				{KeyID: "b", ActivateAt: keyringAt(1000)},
+			},
+			promote: "b",
+			at:      keyringAt(10),
+			want: map[string][3]string{
+				"a": {KeyStatusActive, KeyStatusRetired, KeyStatusExpired},
+				"b": {KeyStatusPending, KeyStatusActive, KeyStatusActive},
+			},
+		},
+		{
+			name: "retires every other active key",
+			keys: []KeyringEntry{
+				{KeyID: "a", ActivateAt: keyringAt(0)},
+				{KeyID: "b", ActivateAt: keyringAt(5)},
+				{KeyID: "c", ActivateAt: keyringAt(1000)},
+			},
+			promote: "c",
+			at:      keyringAt(10),
+			want: map[string][3]string{
+				"a": {KeyStatusActive, KeyStatusRetired, KeyStatusExpired},
+				"b": {KeyStatusActive, KeyStatusRetired, KeyStatusExpired},
+				"c": {KeyStatusPending, KeyStatusActive, KeyStatusActive},
+			},
+		},
+		{
+			name: "leaves expired keys alone",
+			keys: []KeyringEntry{
+				{KeyID: "a", ActivateAt: keyringAt(0), RetireAt: keyringAtPtr(1), ExpiresAt: keyringAtPtr(2)},
+				{KeyID: "b", ActivateAt: keyringAt(1000)},
+			},
+			promote: "b",
+			at:      keyringAt(10),
+			want: map[string][3]string{
+				"a": {KeyStatusExpired, KeyStatusExpired, KeyStatusExpired},
+				"b": {KeyStatusPending, KeyStatusActive, KeyStatusActive},
+			},
+		},
+		{
+			name: "refuses a retired key",
+			keys: []KeyringEntry{
+				{KeyID: "a", ActivateAt: keyringAt(0), RetireAt: keyringAtPtr(5), ExpiresAt: keyringAtPtr(50)},
+				{KeyID: "b", ActivateAt: keyringAt(5)},
+			},
+			promote: "a",
+			at:      keyringAt(10),
+			wantErr: true,
+		},
+		{
+			name:    "refuses an unknown key",
+			keys:    []KeyringEntry{{KeyID: "a", ActivateAt: keyringAt(0)}},
+			promote: "missing",
+			at:      keyringAt(10),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := &KeyringManifest{Keys: tt.keys}
+
+			err := manifest.Promote(tt.promote, tt.at, grace)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Promote succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Promote: %v", err)
+			}
+
+			for keyID, want := range tt.want {
+				entry := manifest.Find(keyID)
+				got := [3]string{entry.Status(tt.at.Add(-time.Hour)), entry.Status(tt.at), entry.Status(tt.at.Add(grace))}
+				if got != want {
+					t.Errorf("key %s status = %v, want %v", keyID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringManifestRetire(t *testing.T) {
+	manifest := &KeyringManifest{Keys: []KeyringEntry{{KeyID: "a", ActivateAt: keyringAt(0)}}}
+
+	if err := manifest.Retire("a", keyringAt(10), time.Hour); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+
+	entry := manifest.Find("a")
+	for now, want := range map[time.Time]string{
+		keyringAt(9):  KeyStatusActive,
+		keyringAt(10): KeyStatusRetired,
+		keyringAt(11): KeyStatusExpired,
+	} {
+		if got := entry.Status(now); got != want {
+			t.Errorf("status at %s = %s, want %s", now, got, want)
+		}
+	}
+
+	if err := manifest.Retire("missing", keyringAt(10), time.Hour); err == nil {
+		t.Error("Retire succeeded for an unknown key")
+	}
+}
+
+// writeTestKey stores a new key in a keyring directory the way keyctl does
+func writeTestKey(t *testing.T, dir, algorithm string) *SigningKey {
+	t.Helper()
+
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	data, err := key.EncodePEM()
+	if err != nil {
+		t.Fatalf("EncodePEM: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key.KeyID+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestWriteKeyringManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	empty, err := ReadKeyringManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadKeyringManifest: %v", err)
+	}
+	if len(empty.Keys) != 0 {
+		t.Errorf("missing manifest read as %d keys", len(empty.Keys))
+	}
+
+	manifest := &KeyringManifest{Keys: []KeyringEntry{
+		{KeyID: "b", Algorithm: AlgorithmRS256, ActivateAt: keyringAt(10)},
+		{KeyID: "a", Algorithm: AlgorithmEdDSA, ActivateAt: keyringAt(0), RetireAt: keyringAtPtr(10), ExpiresAt: keyringAtPtr(20)},
+	}}
+	if err := WriteKeyringManifest(dir, manifest); err != nil {
+		t.Fatalf("WriteKeyringManifest: %v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != keyringManifestFile {
+		t.Errorf("keyring directory holds %v, want only %s", files, keyringManifestFile)
+	}
+
+	read, err := ReadKeyringManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadKeyringManifest: %v", err)
+	}
+	if len(read.Keys) != 2 || read.Keys[0].KeyID != "a" || read.Keys[1].KeyID != "b" {
+		t.Fatalf("read back %+v, want keys ordered by activation", read.Keys)
+	}
+	if !read.Keys[0].ExpiresAt.Equal(keyringAt(20)) || read.Keys[1].RetireAt != nil {
+		t.Errorf("read back %+v", read.Keys)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, keyringManifestFile), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadKeyringManifest(dir); err == nil {
+		t.Error("ReadKeyringManifest accepted a corrupt manifest")
+	}
+}
+
+func TestLoadKeyringReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	first := writeTestKey(t, dir, AlgorithmRS256)
+	manifest := &KeyringManifest{Keys: []KeyringEntry{
+		{KeyID: first.KeyID, Algorithm: first.Algorithm, ActivateAt: now.Add(-time.Hour)},
+	}}
+	if err := WriteKeyringManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	if key, err := keyring.SigningKey(now); err != nil || key.KeyID != first.KeyID {
+		t.Fatalf("SigningKey = %v, %v, want %s", key, err, first.KeyID)
+	}
+
+	// Promote a second key behind the keyring's back
+	second := writeTestKey(t, dir, AlgorithmEdDSA)
+	manifest.Keys = append(manifest.Keys, KeyringEntry{KeyID: second.KeyID, Algorithm: second.Algorithm, ActivateAt: now.Add(time.Hour)})
+	if err := manifest.Promote(second.KeyID, now.Add(-time.Minute), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyringManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if key, err := keyring.SigningKey(now); err != nil || key.KeyID != second.KeyID {
+		t.Errorf("SigningKey after reload = %v, %v, want %s", key, err, second.KeyID)
+	}
+	if _, ok := keyring.VerificationKey(first.KeyID, now); !ok {
+		t.Error("retired key no longer verifies within its grace period")
+	}
+
+	// A broken manifest leaves the loaded keys in place
+	manifest.Keys[0].Algorithm = AlgorithmEdDSA
+	if err := WriteKeyringManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Reload(); err == nil {
+		t.Error("Reload accepted a key whose algorithm disagrees with the manifest")
+	}
+	if key, err := keyring.SigningKey(now); err != nil || key.KeyID != second.KeyID {
+		t.Errorf("SigningKey after failed reload = %v, %v, want %s", key, err, second.KeyID)
+	}
+
+	manifest.Keys[0].Algorithm = AlgorithmRS256
+	manifest.Keys = append(manifest.Keys, KeyringEntry{KeyID: "missing", Algorithm: AlgorithmRS256, ActivateAt: now})
+	if err := WriteKeyringManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyring(dir); err == nil {
+		t.Error("LoadKeyring accepted a manifest entry without a key file")
+	}
+}
+
+func TestStaticKeyring(t *testing.T) {
+	key, err := GenerateSigningKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewStaticKeyring(key)
+
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	for _, now := range []time.Time{time.Time{}, time.Now(), keyringAt(1 << 20)} {
+		if signing, err := keyring.SigningKey(now); err != nil || signing != key {
+			t.Errorf("SigningKey(%s) = %v, %v", now, signing, err)
+		}
+	}
+	if keys := keyring.VerificationKeys(time.Now()); len(keys) != 1 || keys[0] != key {
+		t.Errorf("VerificationKeys = %v", keys)
+	}
+}
@@ -2,134 +2,469 @@ package utils
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
 	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/facebook"
-	"golang.org/x/oauth2/google"
 )
 
+// OAuthManager is the registry of configured identity providers
 type OAuthManager struct {
-	googleConfig   *oauth2.Config
-	facebookConfig *oauth2.Config
-}
-
-func NewOAuthManager(cfg *configs.Config) *OAuthManager {
-	return &OAuthManager{
-		googleConfig: &oauth2.Config{
-			ClientID:     cfg.OAuth.Google.ClientID,
-			ClientSecret: cfg.OAuth.Google.ClientSecret,
-			RedirectURL:  cfg.OAuth.Google.RedirectURL,
-			Scopes:       []string{"openid", "profile", "email"},
-			Endpoint:     google.Endpoint,
-		},
-		facebookConfig: &oauth2.Config{
-			ClientID:     cfg.OAuth.Facebook.ClientID,
-			ClientSecret: cfg.OAuth.Facebook.ClientSecret,
-			RedirectURL:  cfg.OAuth.Facebook.RedirectURL,
-			Scopes:       []string{"email", "public_profile"},
-			Endpoint:     facebook.Endpoint,
-		},
+	providers           map[string]*OAuthProvider
+	order               []string
+	redirectAllowlist   []string
+	callbackMode        string
+	frontendCallbackURL string
+}
+
+func NewOAuthManager(cfg *configs.Config) (*OAuthManager, error) {
+	providerConfigs, err := configs.LoadOAuthProviders(cfg.OAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &OAuthManager{
+		providers:           make(map[string]*OAuthProvider),
+		redirectAllowlist:   cfg.OAuth.RedirectAllowlist,
+		callbackMode:        cfg.OAuth.CallbackMode,
+		frontendCallbackURL: cfg.OAuth.FrontendCallbackURL,
+	}
+	for _, providerConfig := range providerConfigs {
+		provider, err := NewOAuthProvider(providerConfig, nil)
+		if err != nil {
+			return nil, err
+		}
+		manager.providers[providerConfig.Name] = provider
+		manager.order = append(manager.order, providerConfig.Name)
 	}
+
+	return manager, nil
 }
 
-func (o *OAuthManager) GetGoogleAuthURL(state string) string {
-	return o.googleConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
+// Provider returns the provider registered under the name
+func (o *OAuthManager) Provider(name string) (*OAuthProvider, bool) {
+	provider, exists := o.providers[name]
+	return provider, exists
 }
 
-func (o *OAuthManager) GetFacebookAuthURL(state string) string {
-	return o.facebookConfig.AuthCodeURL(state)
+// Providers lists the configured providers in configuration order
+func (o *OAuthManager) Providers() []models.OAuthProviderInfo {
+	providers := make([]models.OAuthProviderInfo, 0, len(o.order))
+	for _, name := range o.order {
+		providers = append(providers, models.OAuthProviderInfo{
+			Name:        name,
+			DisplayName: o.providers[name].config.DisplayName,
+		})
+	}
+	return providers
 }
 
-func (o *OAuthManager) ExchangeGoogleCode(ctx context.Context, code string) (*models.OAuthUserInfo, error) {
-	token, err := o.googleConfig.Exchange(ctx, code)
+// RedirectMode reports whether callbacks hand off to the frontend with a redirect
+func (o *OAuthManager) RedirectMode() bool {
+	return o.callbackMode == "redirect"
+}
+
+// FrontendCallbackURL returns the frontend callback page with the given
+// query parameters added
+func (o *OAuthManager) FrontendCallbackURL(params url.Values) string {
+	separator := "?"
+	if strings.Contains(o.frontendCallbackURL, "?") {
+		separator = "&"
+	}
+	return o.frontendCallbackURL + separator + params.Encode()
+}
+
+// ValidateRedirect checks where a user may be sent after an OAuth login.
+// Absolute URLs must belong to an allowlisted origin, relative ones must be a
+// plain path so "//evil.example" can't pass as one.
+func (o *OAuthManager) ValidateRedirect(target string) (string, error) {
+	if target == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange Google code: %w", err)
+		return "", fmt.Errorf("invalid redirect url")
+	}
+
+	if !parsed.IsAbs() {
+		if parsed.Host != "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+			return "", fmt.Errorf("invalid redirect url")
+		}
+		return target, nil
+	}
+
+	origin := parsed.Scheme + "://" + parsed.Host
+	for _, allowed := range o.redirectAllowlist {
+		if strings.EqualFold(origin, strings.TrimRight(allowed, "/")) && parsed.User == nil {
+			return target, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid redirect url")
+}
+
+// OAuthProvider signs users in with one external identity provider. OIDC
+// providers are discovered on first use so an unreachable issuer doesn't
+// keep the service from starting.
+type OAuthProvider struct {
+	config configs.OAuthProviderConfig
+
+	mu          sync.Mutex
+	oauthConfig *oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	userInfoURL string
+
+	// appleKey signs Apple client secrets
+	appleKey *ecdsa.PrivateKey
+}
+
+// NewOAuthProvider creates a provider from its configuration. ID tokens of
+// providers with a jwks_url, such as Apple, are checked against keySet, or
+// against the keys published at jwks_url when keySet is nil.
+func NewOAuthProvider(config configs.OAuthProviderConfig, keySet oidc.KeySet) (*OAuthProvider, error) {
+	provider := &OAuthProvider{
+		config: config,
+		oauthConfig: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       config.Scopes,
+		},
+		userInfoURL: config.UserInfoURL,
+	}
+
+	if config.Type == configs.OAuthProviderTypeOAuth2 || config.JWKSURL != "" {
+		provider.oauthConfig.Endpoint = oauth2.Endpoint{
+			AuthURL:  config.AuthURL,
+			TokenURL: config.TokenURL,
+		}
+	}
+
+	if config.Type != configs.OAuthProviderTypeOAuth2 && config.JWKSURL != "" {
+		if keySet == nil {
+			keySet = oidc.NewRemoteKeySet(context.Background(), config.JWKSURL)
+		}
+		provider.verifier = oidc.NewVerifier(config.Issuer, keySet, &oidc.Config{ClientID: config.ClientID})
+	}
+
+	if config.Type == configs.OAuthProviderTypeApple {
+		keyPEM, err := os.ReadFile(config.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s private key: %w", config.Name, err)
+		}
+		provider.appleKey, err = jwt.ParseECPrivateKeyFromPEM(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s private key: %w", config.Name, err)
+		}
+		// Apple expects the client credentials in the request body
+		provider.oauthConfig.Endpoint.AuthStyle = oauth2.AuthStyleInParams
 	}
 
-	userInfo, err := o.getGoogleUserInfo(ctx, token)
+	return provider, nil
+}
+
+func (p *OAuthProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the provider's consent page URL for the given state,
+// carrying the PKCE challenge of the verifier and, for OIDC, the nonce
+func (p *OAuthProvider) AuthCodeURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	oauthConfig, _, err := p.setup(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Google user info: %w", err)
+		return "", err
 	}
 
-	userInfo.Provider = "google"
-	return userInfo, nil
+	options := make([]oauth2.AuthCodeOption, 0, len(p.config.AuthParams)+2)
+	for key, value := range p.config.AuthParams {
+		options = append(options, oauth2.SetAuthURLParam(key, value))
+	}
+	if !p.config.DisablePKCE {
+		options = append(options, oauth2.S256ChallengeOption(verifier))
+	}
+	if p.config.Type != configs.OAuthProviderTypeOAuth2 {
+		options = append(options, oidc.Nonce(nonce))
+	}
+
+	return oauthConfig.AuthCodeURL(state, options...), nil
 }
 
-func (o *OAuthManager) ExchangeFacebookCode(ctx context.Context, code string) (*models.OAuthUserInfo, error) {
-	token, err := o.facebookConfig.Exchange(ctx, code)
+// Exchange redeems the authorization code of a callback and returns the
+// user's identity. For OIDC providers the ID token signature, issuer,
+// audience, expiry and nonce are verified before any claim is trusted.
+func (p *OAuthProvider) Exchange(ctx context.Context, callback *models.OAuthCallbackRequest, codeVerifier, nonce string) (*models.OAuthUserInfo, error) {
+	oauthConfig, verifier, err := p.setup(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange Facebook code: %w", err)
+		return nil, err
 	}
 
-	userInfo, err := o.getFacebookUserInfo(ctx, token)
+	if p.appleKey != nil {
+		clientSecret, err := p.appleClientSecret()
+		if err != nil {
+			return nil, err
+		}
+		configCopy := *oauthConfig
+		configCopy.ClientSecret = clientSecret
+		oauthConfig = &configCopy
+	}
+
+	var options []oauth2.AuthCodeOption
+	if !p.config.DisablePKCE {
+		options = append(options, oauth2.VerifierOption(codeVerifier))
+	}
+
+	token, err := oauthConfig.Exchange(ctx, callback.Code, options...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Facebook user info: %w", err)
+		return nil, fmt.Errorf("failed to exchange %s code: %w", p.config.Name, err)
 	}
 
-	userInfo.Provider = "facebook"
-	return userInfo, nil
+	claims := make(map[string]interface{})
+
+	if verifier != nil {
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			return nil, fmt.Errorf("%s did not return an ID token", p.config.Name)
+		}
+
+		idToken, err := verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s ID token: %w", p.config.Name, err)
+		}
+		if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+			return nil, fmt.Errorf("invalid %s ID token: nonce mismatch", p.config.Name)
+		}
+		if err := idToken.Claims(&claims); err != nil {
+			return nil, fmt.Errorf("failed to decode %s ID token: %w", p.config.Name, err)
+		}
+	}
+
+	// Plain OAuth2 providers only have the user info endpoint. OIDC providers
+	// may leave the email out of the ID token.
+	if p.userInfoURL != "" && (verifier == nil || lookupClaim(claims, p.config.Claims.Email) == nil) {
+		var userInfo map[string]interface{}
+		if err := getJSON(ctx, oauthConfig.Client(ctx, token), p.userInfoURL, &userInfo); err != nil {
+			return nil, fmt.Errorf("failed to get %s user info: %w", p.config.Name, err)
+		}
+
+		if verifier != nil && userInfo["sub"] != claims["sub"] {
+			return nil, fmt.Errorf("%s user info does not match the ID token", p.config.Name)
+		}
+		for key, value := range userInfo {
+			if _, exists := claims[key]; !exists {
+				claims[key] = value
+			}
+		}
+	}
+
+	// Apple sends the user's name once, with the first authorization, and
+	// never puts it in the ID token
+	if p.config.Type == configs.OAuthProviderTypeApple && callback.User != "" {
+		if name := appleUserName(callback.User); name != "" && lookupClaim(claims, p.config.Claims.Name) == nil {
+			claims[p.config.Claims.Name] = name
+		}
+	}
+
+	// Providers such as GitHub leave private addresses out of the user info
+	// and only say which addresses are verified at a separate endpoint
+	var emails []providerEmail
+	if p.config.EmailsURL != "" {
+		if err := getJSON(ctx, oauthConfig.Client(ctx, token), p.config.EmailsURL, &emails); err != nil {
+			return nil, fmt.Errorf("failed to get %s email addresses: %w", p.config.Name, err)
+		}
+	}
+
+	return p.mapClaims(claims, emails)
 }
 
-func (o *OAuthManager) getGoogleUserInfo(ctx context.Context, token *oauth2.Token) (*models.OAuthUserInfo, error) {
-	client := o.googleConfig.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+// appleClientSecret signs the short-lived ES256 client secret Apple requires
+// instead of a static one
+func (p *OAuthProvider) appleClientSecret() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    p.config.TeamID,
+		Subject:   p.config.ClientID,
+		Audience:  jwt.ClaimStrings{p.config.Issuer},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	})
+	token.Header["kid"] = p.config.KeyID
+
+	clientSecret, err := token.SignedString(p.appleKey)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to sign %s client secret: %w", p.config.Name, err)
 	}
-	defer resp.Body.Close()
+	return clientSecret, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// appleUserName reads the name from the user form field of an Apple callback
+func appleUserName(raw string) string {
+	var user struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(raw), &user); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+}
+
+// setup discovers the OIDC endpoints on first use
+func (p *OAuthProvider) setup(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.Type == configs.OAuthProviderTypeOAuth2 || p.verifier != nil {
+		return p.oauthConfig, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to discover %s: %w", p.config.Name, err)
 	}
 
-	var userInfo models.OAuthUserInfo
-	if err := json.Unmarshal(body, &userInfo); err != nil {
-		return nil, err
+	p.oauthConfig.Endpoint = provider.Endpoint()
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	if p.userInfoURL == "" {
+		p.userInfoURL = provider.UserInfoEndpoint()
 	}
 
-	return &userInfo, nil
+	return p.oauthConfig, p.verifier, nil
+}
+
+// providerEmail is an entry of a provider's emails endpoint
+type providerEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
 }
 
-func (o *OAuthManager) getFacebookUserInfo(ctx context.Context, token *oauth2.Token) (*models.OAuthUserInfo, error) {
-	client := o.facebookConfig.Client(ctx, token)
-	resp, err := client.Get("https://graph.facebook.com/me?fields=id,name,email,picture")
+func getJSON(ctx context.Context, client *http.Client, url string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
 
-	var fbUser struct {
-		ID      string `json:"id"`
-		Name    string `json:"name"`
-		Email   string `json:"email"`
-		Picture struct {
-			Data struct {
-				URL string `json:"url"`
-			} `json:"data"`
-		} `json:"picture"`
+	return json.Unmarshal(body, value)
+}
+
+// mapClaims reads the user's identity from the claims and the addresses of
+// the emails endpoint, if the provider has one
+func (p *OAuthProvider) mapClaims(claims map[string]interface{}, emails []providerEmail) (*models.OAuthUserInfo, error) {
+	mapping := p.config.Claims
+
+	userInfo := &models.OAuthUserInfo{
+		ID:       claimString(claims, mapping.Subject),
+		Email:    claimString(claims, mapping.Email),
+		Name:     claimString(claims, mapping.Name),
+		Picture:  claimString(claims, mapping.Picture),
+		Provider: p.config.Name,
 	}
 
-	if err := json.Unmarshal(body, &fbUser); err != nil {
-		return nil, err
+	if mapping.PrivateEmail != "" {
+		userInfo.PrivateEmail = claimBool(claims, mapping.PrivateEmail)
 	}
 
-	userInfo := &models.OAuthUserInfo{
-		ID:      fbUser.ID,
-		Name:    fbUser.Name,
-		Email:   fbUser.Email,
-		Picture: fbUser.Picture.Data.URL,
+	if p.config.TrustEmail {
+		userInfo.EmailVerified = userInfo.Email != ""
+	} else if mapping.EmailVerified != "" {
+		userInfo.EmailVerified = claimBool(claims, mapping.EmailVerified)
+	}
+	// The emails endpoint has the primary address when the claims leave it
+	// out, and says whether it is verified
+	for _, email := range emails {
+		if email.Email != "" && (email.Email == userInfo.Email || userInfo.Email == "" && email.Primary) {
+			userInfo.Email = email.Email
+			userInfo.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	if userInfo.ID == "" {
+		return nil, fmt.Errorf("%s did not return a user ID", p.config.Name)
+	}
+	if userInfo.Email == "" {
+		return nil, fmt.Errorf("%s did not return an email address", p.config.Name)
+	}
+	if userInfo.Name == "" {
+		userInfo.Name = strings.Split(userInfo.Email, "@")[0]
+		// Relay addresses have a random local part
+		if userInfo.PrivateEmail {
+			userInfo.Name = p.config.DisplayName + " User"
+		}
 	}
 
 	return userInfo, nil
 }
+
+// lookupClaim follows a dot separated path such as "picture.data.url"
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	var current interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	switch value := lookupClaim(claims, path).(type) {
+	case string:
+		return value
+	case float64:
+		// Numeric IDs such as GitHub's decode as float64
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func claimBool(claims map[string]interface{}, path string) bool {
+	switch value := lookupClaim(claims, path).(type) {
+	case bool:
+		return value
+	case string:
+		// Some providers send "true" as a string
+		return value == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,545 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	appleIssuer   = "https://appleid.apple.com"
+	appleClientID = "com.threads.web"
+	appleTeamID   = "TEAM123456"
+	appleKeyID    = "KEY1234567"
+	appleJWKSKey  = "stub-signing-key"
+)
+
+// appleStub plays Apple's token and keys endpoints. The token endpoint checks
+// the client secret and answers with the ID token the test sets.
+type appleStub struct {
+	server *httptest.Server
+	// jwksKey is the key published at the keys endpoint
+	jwksKey *rsa.PrivateKey
+	// clientKey is the .p8 key the service signs client secrets with
+	clientKey *ecdsa.PrivateKey
+	idToken   string
+}
+
+func newAppleStub(t *testing.T) *appleStub {
+	t.Helper()
+
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate jwks key: %v", err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	stub := &appleStub{jwksKey: jwksKey, clientKey: clientKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": appleJWKSKey,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(jwksKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(jwksKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := stub.checkClientSecret(r); err != nil {
+			t.Errorf("client secret: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		response := map[string]interface{}{
+			"access_token": "apple-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		}
+		if stub.idToken != "" {
+			response["id_token"] = stub.idToken
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+
+	return stub
+}
+
+// checkClientSecret verifies the client secret is signed with the .p8 key
+// and names the team, Services ID and key
+func (s *appleStub) checkClientSecret(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if r.PostForm.Get("client_id") != appleClientID {
+		return jwt.ErrTokenInvalidClaims
+	}
+
+	token, err := jwt.ParseWithClaims(r.PostForm.Get("client_secret"), &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != appleKeyID {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return &s.clientKey.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{"ES256"}),
+		jwt.WithIssuer(appleTeamID),
+		jwt.WithSubject(appleClientID),
+		jwt.WithAudience(appleIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return jwt.ErrTokenInvalidClaims
+	}
+	return nil
+}
+
+// signIDToken signs the claims the way Apple does, or with another key
+func (s *appleStub) signIDToken(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = appleJWKSKey
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign id token: %v", err)
+	}
+	return signed
+}
+
+// provider configures Apple through a providers file, the way a deployment
+// would point it at Apple's endpoints
+func (s *appleStub) provider(t *testing.T) *OAuthProvider {
+	t.Helper()
+
+	dir := t.TempDir()
+	der, err := x509.MarshalPKCS8PrivateKey(s.clientKey)
+	if err != nil {
+		t.Fatalf("encode client key: %v", err)
+	}
+	keyPath := filepath.Join(dir, "apple.p8")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write client key: %v", err)
+	}
+
+	providers, err := json.Marshal(map[string]interface{}{
+		"providers": []configs.OAuthProviderConfig{{
+			Name:           "apple",
+			DisplayName:    "Apple",
+			Type:           configs.OAuthProviderTypeApple,
+			ClientID:       appleClientID,
+			TeamID:         appleTeamID,
+			KeyID:          appleKeyID,
+			PrivateKeyPath: keyPath,
+			TokenURL:       s.server.URL + "/auth/token",
+			JWKSURL:        s.server.URL + "/auth/keys",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("encode providers: %v", err)
+	}
+	providersPath := filepath.Join(dir, "providers.json")
+	if err := os.WriteFile(providersPath, providers, 0o600); err != nil {
+		t.Fatalf("write providers: %v", err)
+	}
+
+	configured, err := configs.LoadOAuthProviders(configs.OAuthConfig{
+		ProvidersFile:   providersPath,
+		CallbackBaseURL: "http://localhost:3001",
+	})
+	if err != nil {
+		t.Fatalf("LoadOAuthProviders: %v", err)
+	}
+
+	provider, err := NewOAuthProvider(configured[0], nil)
+	if err != nil {
+		t.Fatalf("NewOAuthProvider: %v", err)
+	}
+	return provider
+}
+
+func TestAppleExchange(t *testing.T) {
+	const nonce = "callback-nonce"
+
+	validClaims := func() jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{
+			"iss":   appleIssuer,
+			"aud":   appleClientID,
+			"sub":   "001234.abcdef.1234",
+			"iat":   now.Unix(),
+			"exp":   now.Add(10 * time.Minute).Unix(),
+			"nonce": nonce,
+			"email": "x7fk2@privaterelay.appleid.com",
+			// Apple sends both flags as strings
+			"email_verified":   "true",
+			"is_private_email": "true",
+		}
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// claims changes the ID token claims
+		claims func(claims jwt.MapClaims)
+		// signedByOtherKey signs with a key that isn't published
+		signedByOtherKey bool
+		noIDToken        bool
+		user             string
+		want             *models.OAuthUserInfo
+		wantErr          string
+	}{
+		{
+			name: "first authorization with the user's name",
+			user: `{"name":{"firstName":"John","lastName":"Doe"},"email":"x7fk2@privaterelay.appleid.com"}`,
+			want: &models.OAuthUserInfo{
+				ID: "001234.abcdef.1234", Email: "x7fk2@privaterelay.appleid.com", Name: "John Doe",
+				Provider: "apple", EmailVerified: true, PrivateEmail: true,
+			},
+		},
+		{
+			name: "later authorization of a private relay address",
+			want: &models.OAuthUserInfo{
+				ID: "001234.abcdef.1234", Email: "x7fk2@privaterelay.appleid.com", Name: "Apple User",
+				Provider: "apple", EmailVerified: true, PrivateEmail: true,
+			},
+		},
+		{
+			name: "shared address",
+			claims: func(claims jwt.MapClaims) {
+				claims["email"] = "john@example.com"
+				claims["is_private_email"] = "false"
+			},
+			want: &models.OAuthUserInfo{
+				ID: "001234.abcdef.1234", Email: "john@example.com", Name: "john",
+				Provider: "apple", EmailVerified: true,
+			},
+		},
+		{name: "signed by an unpublished key", signedByOtherKey: true, wantErr: "invalid apple ID token"},
+		{name: "issued for another client", claims: func(claims jwt.MapClaims) { claims["aud"] = "com.other.app" }, wantErr: "invalid apple ID token"},
+		{name: "issued by another issuer", claims: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" }, wantErr: "invalid apple ID token"},
+		{name: "expired", claims: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }, wantErr: "invalid apple ID token"},
+		{name: "nonce of another login", claims: func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" }, wantErr: "nonce mismatch"},
+		{name: "no nonce", claims: func(claims jwt.MapClaims) { delete(claims, "nonce") }, wantErr: "nonce mismatch"},
+		{name: "no ID token", noIDToken: true, wantErr: "apple did not return an ID token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAppleStub(t)
+			provider := stub.provider(t)
+
+			claims := validClaims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			key := stub.jwksKey
+			if tt.signedByOtherKey {
+				key = otherKey
+			}
+			if !tt.noIDToken {
+				stub.idToken = stub.signIDToken(t, claims, key)
+			}
+
+			userInfo, err := provider.Exchange(context.Background(), &models.OAuthCallbackRequest{
+				Code: "authorization-code",
+				User: tt.user,
+			}, "", nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if *userInfo != *tt.want {
+				t.Errorf("Exchange = %+v, want %+v", *userInfo, *tt.want)
+			}
+		})
+	}
+}
+
+func TestAppleAuthCodeURL(t *testing.T) {
+	provider := newAppleStub(t).provider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	for _, want := range []string{"response_mode=form_post", "nonce=nonce", "scope=name+email", "client_id=" + appleClientID} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("AuthCodeURL = %s, missing %s", authURL, want)
+		}
+	}
+	// Apple's token endpoint rejects PKCE
+	if strings.Contains(authURL, "code_challenge") {
+		t.Errorf("AuthCodeURL = %s, want no PKCE challenge", authURL)
+	}
+}
+
+func TestValidateRedirect(t *testing.T) {
+	manager := &OAuthManager{redirectAllowlist: []string{"https://threads.example.com/"}}
+
+	tests := []struct {
+		target string
+		ok     bool
+	}{
+		{target: "", ok: true},
+		{target: "/feed?tab=following", ok: true},
+		{target: "https://threads.example.com/feed", ok: true},
+		{target: "HTTPS://THREADS.EXAMPLE.COM/feed", ok: true},
+		{target: "https://threads.example.com.evil.example/feed"},
+		{target: "https://evil@threads.example.com/feed"},
+		{target: "http://threads.example.com/feed"},
+		{target: "//evil.example/feed"},
+		{target: "/\\evil.example"},
+		{target: "feed"},
+		{target: "javascript:alert(1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			redirect, err := manager.ValidateRedirect(tt.target)
+			if tt.ok && (err != nil || redirect != tt.target) {
+				t.Fatalf("ValidateRedirect = %q, %v, want %q", redirect, err, tt.target)
+			}
+			if !tt.ok && err == nil {
+				t.Fatalf("ValidateRedirect = %q, want invalid redirect url", redirect)
+			}
+		})
+	}
+}
+
+// A plain OAuth2 provider gets the S256 challenge of the verifier with the
+// authorization request and the verifier itself with the code
+func TestOAuth2PKCE(t *testing.T) {
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	var sentVerifier string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			sentVerifier = r.PostForm.Get("code_verifier")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"provider-token","token_type":"Bearer"}`))
+		case "/userinfo":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"1234","email":"john@example.com","name":"John"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := NewOAuthProvider(configs.OAuthProviderConfig{
+		Name:        "example",
+		Type:        configs.OAuthProviderTypeOAuth2,
+		ClientID:    "client",
+		AuthURL:     server.URL + "/authorize",
+		TokenURL:    server.URL + "/token",
+		UserInfoURL: server.URL + "/userinfo",
+		Claims:      configs.OAuthClaimMapping{Subject: "id", Email: "email", Name: "name"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewOAuthProvider: %v", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", verifier, "nonce")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	// RFC 7636 appendix B
+	for _, want := range []string{"code_challenge=E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "code_challenge_method=S256"} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("AuthCodeURL = %s, missing %s", authURL, want)
+		}
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Errorf("AuthCodeURL = %s, leaks the verifier", authURL)
+	}
+
+	userInfo, err := provider.Exchange(context.Background(), &models.OAuthCallbackRequest{Code: "code"}, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if sentVerifier != verifier {
+		t.Errorf("code_verifier = %q, want %q", sentVerifier, verifier)
+	}
+	if userInfo.ID != "1234" {
+		t.Errorf("ID = %q, want 1234", userInfo.ID)
+	}
+}
+
+func TestClaimString(t *testing.T) {
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"id": 583231,
+		"big_id": 12345678901234,
+		"sub": "abc",
+		"email": null,
+		"picture": {"data": {"url": "https://example.com/a.png"}},
+		"verified": "true"
+	}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "id", want: "583231"},
+		{path: "big_id", want: "12345678901234"},
+		{path: "sub", want: "abc"},
+		{path: "email"},
+		{path: "picture.data.url", want: "https://example.com/a.png"},
+		{path: "picture.data"},
+		{path: "picture.data.url.more"},
+		{path: "missing.path"},
+		{path: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := claimString(claims, tt.path); got != tt.want {
+				t.Errorf("claimString(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if !claimBool(claims, "verified") || claimBool(claims, "sub") {
+		t.Error("claimBool misread a string claim")
+	}
+}
+
+// GitHub returns "email": null for private addresses and only says which
+// addresses are verified at /user/emails
+func TestOAuth2EmailsEndpoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         string
+		emails       string
+		wantEmail    string
+		wantVerified bool
+		wantErr      bool
+	}{
+		{
+			name:         "private address",
+			user:         `{"id":583231,"login":"octocat","email":null}`,
+			emails:       `[{"email":"old@example.com","primary":false,"verified":true},{"email":"octocat@example.com","primary":true,"verified":true}]`,
+			wantEmail:    "octocat@example.com",
+			wantVerified: true,
+		},
+		{
+			name:      "unverified primary address",
+			user:      `{"id":583231,"login":"octocat","email":null}`,
+			emails:    `[{"email":"octocat@example.com","primary":true,"verified":false}]`,
+			wantEmail: "octocat@example.com",
+		},
+		{
+			name:         "public address",
+			user:         `{"id":583231,"login":"octocat","email":"public@example.com"}`,
+			emails:       `[{"email":"octocat@example.com","primary":true,"verified":true},{"email":"public@example.com","primary":false,"verified":true}]`,
+			wantEmail:    "public@example.com",
+			wantVerified: true,
+		},
+		{
+			name:    "no address",
+			user:    `{"id":583231,"login":"octocat","email":null}`,
+			emails:  `[]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/token":
+					w.Write([]byte(`{"access_token":"provider-token","token_type":"Bearer"}`))
+				case "/user":
+					w.Write([]byte(tt.user))
+				case "/user/emails":
+					if r.Header.Get("Authorization") != "Bearer provider-token" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					w.Write([]byte(tt.emails))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			config := configs.OAuthProviderConfig{
+				Name:        "github",
+				DisplayName: "GitHub",
+				Type:        configs.OAuthProviderTypeOAuth2,
+				ClientID:    "client",
+				AuthURL:     server.URL + "/authorize",
+				TokenURL:    server.URL + "/token",
+				UserInfoURL: server.URL + "/user",
+				EmailsURL:   server.URL + "/user/emails",
+				Claims:      configs.OAuthClaimMapping{Subject: "id", Email: "email", Name: "name", Picture: "avatar_url"},
+			}
+			provider, err := NewOAuthProvider(config, nil)
+			if err != nil {
+				t.Fatalf("NewOAuthProvider: %v", err)
+			}
+
+			userInfo, err := provider.Exchange(context.Background(), &models.OAuthCallbackRequest{Code: "code"}, "verifier", "nonce")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange = %+v, want an error", userInfo)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if userInfo.ID != "583231" || userInfo.Email != tt.wantEmail || userInfo.EmailVerified != tt.wantVerified {
+				t.Errorf("user info = id %s email %s verified %v, want 583231 %s %v",
+					userInfo.ID, userInfo.Email, userInfo.EmailVerified, tt.wantEmail, tt.wantVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("secret")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	signature := signer.Sign("data-export:1", expiresAt)
+
+	tests := []struct {
+		name      string
+		signer    *URLSigner
+		resource  string
+		expiresAt time.Time
+		signature string
+		want      bool
+	}{
+		{name: "valid link", signer: signer, resource: "data-export:1", expiresAt: expiresAt, signature: signature, want: true},
+		{name: "other resource", signer: signer, resource: "data-export:2", expiresAt: expiresAt, signature: signature},
+		{name: "extended expiry", signer: signer, resource: "data-export:1", expiresAt: expiresAt.Add(time.Hour), signature: signature},
+		{name: "other key", signer: NewURLSigner("other"), resource: "data-export:1", expiresAt: expiresAt, signature: signature},
+		{name: "truncated signature", signer: signer, resource: "data-export:1", expiresAt: expiresAt, signature: signature[:32]},
+		{name: "no signature", signer: signer, resource: "data-export:1", expiresAt: expiresAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.signer.Verify(tt.resource, tt.expiresAt, tt.signature); got != tt.want {
+				t.Errorf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An expired link is rejected even with a valid signature
+func TestURLSignerExpiry(t *testing.T) {
+	signer := NewURLSigner("secret")
+	expiresAt := time.Now().Add(-time.Second)
+
+	if signer.Verify("data-export:1", expiresAt, signer.Sign("data-export:1", expiresAt)) {
+		t.Error("expired link accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IndraSty/threads-clone/backend/auth-service/configs"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var signingAlgorithms = []string{AlgorithmRS256, AlgorithmEdDSA}
+
+// publicKeyFromJWK rebuilds a verification key the way a consumer of the JWKS
+// endpoint would
+func publicKeyFromJWK(t *testing.T, jwk JWK) crypto.PublicKey {
+	t.Helper()
+
+	decode := func(value string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatalf("decode %q: %v", value, err)
+		}
+		return data
+	}
+
+	switch jwk.KeyType {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode(jwk.N)),
+			E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64()),
+		}
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			t.Fatalf("crv = %q, want Ed25519", jwk.Curve)
+		}
+		return ed25519.PublicKey(decode(jwk.X))
+	}
+	t.Fatalf("unexpected kty %q", jwk.KeyType)
+	return nil
+}
+
+func signTestToken(t *testing.T, key *SigningKey) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.SigningMethod(), jwt.MapClaims{"sub": "test"})
+	token.Header["kid"] = key.KeyID
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func verifyTestToken(signed string, publicKey crypto.PublicKey) error {
+	_, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods(signingAlgorithms))
+	return err
+}
+
+func TestSigningKeySignsAndVerifies(t *testing.T) {
+	for _, algorithm := range signingAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatalf("GenerateSigningKey: %v", err)
+			}
+			if key.Algorithm != algorithm || key.SigningMethod().Alg() != algorithm {
+				t.Fatalf("key is %s signing with %s, want %s", key.Algorithm, key.SigningMethod().Alg(), algorithm)
+			}
+
+			signed := signTestToken(t, key)
+			if err := verifyTestToken(signed, key.PublicKey()); err != nil {
+				t.Errorf("own signature rejected: %v", err)
+			}
+
+			other, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatalf("GenerateSigningKey: %v", err)
+			}
+			if err := verifyTestToken(signed, other.PublicKey()); err == nil {
+				t.Error("signature accepted by another key")
+			}
+		})
+	}
+}
+
+func TestGenerateSigningKeyRejectsUnknownAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{"HS256", "ES256", ""} {
+		if _, err := GenerateSigningKey(algorithm); err == nil {
+			t.Errorf("GenerateSigningKey(%q) succeeded", algorithm)
+		}
+	}
+}
+
+func TestJWTManagerTokensCarryKeyID(t *testing.T) {
+	for _, algorithm := range signingAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			cfg := &configs.Config{
+				Server: configs.ServerConfig{Env: "test"},
+				JWT:    configs.JWTConfig{Algorithm: algorithm, ExpiresIn: time.Minute},
+			}
+			manager, err := NewJWTManager(cfg)
+			if err != nil {
+				t.Fatalf("NewJWTManager: %v", err)
+			}
+
+			signed, err := manager.GenerateToken(TokenParams{UserID: uuid.New(), Username: "alice", Email: "alice@example.com"})
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(signed, &JWTClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			jwks := manager.JWKS()
+			if len(jwks.Keys) != 1 {
+				t.Fatalf("JWKS has %d keys, want 1", len(jwks.Keys))
+			}
+			if token.Header["alg"] != algorithm || token.Header["kid"] != jwks.Keys[0].KeyID {
+				t.Errorf("header alg=%v kid=%v, want %s %s", token.Header["alg"], token.Header["kid"], algorithm, jwks.Keys[0].KeyID)
+			}
+
+			if _, err := manager.ValidateToken(signed); err != nil {
+				t.Errorf("ValidateToken: %v", err)
+			}
+
+			other, err := NewJWTManager(cfg)
+			if err != nil {
+				t.Fatalf("NewJWTManager: %v", err)
+			}
+			if _, err := other.ValidateToken(signed); err == nil {
+				t.Error("token accepted by a manager with another key")
+			}
+		})
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, algorithm := range signingAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatalf("GenerateSigningKey: %v", err)
+			}
+
+			data, err := json.Marshal(JWKS{Keys: []JWK{key.JWK()}})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var jwks JWKS
+			if err := json.Unmarshal(data, &jwks); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+
+			jwk := jwks.Keys[0]
+			if jwk.Use != "sig" || jwk.Algorithm != algorithm || jwk.KeyID != key.KeyID {
+				t.Errorf("use=%q alg=%q kid=%q, want sig %s %s", jwk.Use, jwk.Algorithm, jwk.KeyID, algorithm, key.KeyID)
+			}
+
+			if err := verifyTestToken(signTestToken(t, key), publicKeyFromJWK(t, jwk)); err != nil {
+				t.Errorf("signature rejected by the published key: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	for _, algorithm := range signingAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatalf("GenerateSigningKey: %v", err)
+			}
+			data, err := key.EncodePEM()
+			if err != nil {
+				t.Fatalf("EncodePEM: %v", err)
+			}
+			path := filepath.Join(t.TempDir(), "key.pem")
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			// Without a configured kid the thumbprint is used, so it
+			// survives the round trip
+			loaded, err := LoadSigningKey(path, "")
+			if err != nil {
+				t.Fatalf("LoadSigningKey: %v", err)
+			}
+			if loaded.Algorithm != algorithm || loaded.KeyID != key.KeyID || loaded.JWK() != key.JWK() {
+				t.Errorf("loaded %+v, want %+v", loaded.JWK(), key.JWK())
+			}
+
+			named, err := LoadSigningKey(path, "configured")
+			if err != nil {
+				t.Fatalf("LoadSigningKey: %v", err)
+			}
+			if named.KeyID != "configured" {
+				t.Errorf("kid = %q, want configured", named.KeyID)
+			}
+		})
+	}
+}
+
+func TestLoadSigningKeyRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSigningKey(path, ""); err == nil {
+		t.Error("LoadSigningKey accepted a file without a PEM block")
+	}
+	if _, err := LoadSigningKey(filepath.Join(t.TempDir(), "missing.pem"), ""); err == nil {
+		t.Error("LoadSigningKey accepted a missing file")
+	}
+}
+
+// The Ed25519 example of RFC 8037, appendix A.3
+func TestThumbprintRFC8037(t *testing.T) {
+	seed, err := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := newSigningKey(ed25519.NewKeyFromSeed(seed), "")
+	if err != nil {
+		t.Fatalf("newSigningKey: %v", err)
+	}
+
+	if x := key.JWK().X; x != "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo" {
+		t.Errorf("x = %s", x)
+	}
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; key.KeyID != want {
+		t.Errorf("kid = %s, want %s", key.KeyID, want)
+	}
+}
@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 
+	"github.com/IndraSty/threads-clone/backend/auth-service/internal/usernames"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -11,23 +14,58 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	// Report fields by the name clients send them with
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return strings.ToLower(field.Name)
+		}
+		return name
+	})
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernames.ValidFormat(fl.Field().String())
+	})
 }
 
 func ValidateStruct(s interface{}) error {
 	return validate.Struct(s)
 }
 
+// FieldError rejects a request field for a reason the validation tags can't
+// express, such as a password policy violation
+type FieldError struct {
+	Field   string
+	Message string
+	Err     error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FormatValidationErrors maps the fields of a validation error to messages
+// for the client. It returns an empty map for other errors.
 func FormatValidationErrors(err error) map[string]string {
-	errors := make(map[string]string)
+	fieldErrors := make(map[string]string)
 
-	if validationErrors, ok := err.(validator.ValidationErrors); ok {
+	// Services wrap validation errors with context
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
 		for _, fieldError := range validationErrors {
-			fieldName := strings.ToLower(fieldError.Field())
-			errors[fieldName] = getErrorMessage(fieldError)
+			fieldErrors[fieldError.Field()] = getErrorMessage(fieldError)
 		}
 	}
 
-	return errors
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		fieldErrors[fieldErr.Field] = fieldErr.Message
+	}
+
+	return fieldErrors
 }
 
 func getErrorMessage(fe validator.FieldError) string {
@@ -40,6 +78,8 @@ func getErrorMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("%s must be at least %s characters", fe.Field(), fe.Param())
 	case "max":
 		return fmt.Sprintf("%s must not exceed %s characters", fe.Field(), fe.Param())
+	case "username":
+		return "Username may only contain letters, numbers, underscores and periods, and can't start or end with a period or have two in a row"
 	default:
 		return fmt.Sprintf("%s is invalid", fe.Field())
 	}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+)
+
+// Fields are reported by their JSON name, in the key and in the message
+func TestFormatValidationErrors(t *testing.T) {
+	type request struct {
+		DisplayName     string `json:"display_name" validate:"required"`
+		ProfileImageURL string `json:"profile_image_url,omitempty" validate:"max=5"`
+		Email           string `json:"email" validate:"omitempty,email"`
+		Untagged        string `validate:"required"`
+	}
+
+	err := ValidateStruct(&request{ProfileImageURL: "https://example.com", Email: "not-an-email"})
+	got := FormatValidationErrors(fmt.Errorf("validation failed: %w", err))
+	want := map[string]string{
+		"display_name":      "display_name is required",
+		"profile_image_url": "profile_image_url must not exceed 5 characters",
+		"email":             "Invalid email format",
+		"untagged":          "untagged is required",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("FormatValidationErrors = %v, want %v", got, want)
+	}
+}
+
+func TestFormatValidationErrorsFieldError(t *testing.T) {
+	err := fmt.Errorf("validation failed: %w", &FieldError{
+		Field:   "new_password",
+		Message: "Password is too easy to guess",
+		Err:     errors.New("password rejected: weak"),
+	})
+
+	got := FormatValidationErrors(err)
+	if want := map[string]string{"new_password": "Password is too easy to guess"}; !maps.Equal(got, want) {
+		t.Errorf("FormatValidationErrors = %v, want %v", got, want)
+	}
+
+	if got := FormatValidationErrors(errors.New("user not found")); len(got) != 0 {
+		t.Errorf("FormatValidationErrors of another error = %v, want none", got)
+	}
+}
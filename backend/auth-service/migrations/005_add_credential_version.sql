@@ -0,0 +1,8 @@
+-- migrations/005_add_credential_version.sql
+-- Migration to invalidate tokens on credential changes
+
+-- Bumped on every password change; access tokens carry the version they were issued with
+ALTER TABLE users ADD COLUMN IF NOT EXISTS credential_version INTEGER NOT NULL DEFAULT 0;
+
+-- When the user last presented credentials, carried across refresh token rotation
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
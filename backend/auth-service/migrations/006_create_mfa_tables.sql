@@ -0,0 +1,24 @@
+-- migrations/006_create_mfa_tables.sql
+-- Migration to create TOTP two-factor authentication tables
+
+-- One TOTP enrollment per user. The secret is AES-GCM encrypted; the row exists
+-- with totp_enabled_at NULL while enrollment is pending confirmation.
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    totp_secret TEXT NOT NULL,
+    totp_enabled_at TIMESTAMP WITH TIME ZONE,
+    totp_last_used_step BIGINT, -- Last accepted time step, prevents code replay
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
@@ -0,0 +1,26 @@
+-- migrations/008_create_sessions_table.sql
+-- Migration to create sessions table for device management
+
+-- One session per login. The session ID doubles as the family ID of the
+-- refresh tokens issued for it and as the sid claim of its access tokens.
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL, -- Follows the latest refresh token
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Keep refresh token families issued before sessions existed usable
+INSERT INTO sessions (id, user_id, expires_at, last_seen_at, created_at)
+SELECT family_id, user_id, MAX(expires_at), MAX(created_at), MIN(created_at)
+FROM refresh_tokens
+WHERE revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
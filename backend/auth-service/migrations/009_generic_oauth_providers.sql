@@ -0,0 +1,8 @@
+-- migrations/009_generic_oauth_providers.sql
+-- Migration to index linked identities of any configured OAuth provider
+
+-- oauth_providers is keyed by provider name, so one containment index serves every provider
+CREATE INDEX IF NOT EXISTS idx_users_oauth_providers ON users USING GIN (oauth_providers jsonb_path_ops);
+
+DROP INDEX IF EXISTS idx_users_oauth_google;
+DROP INDEX IF EXISTS idx_users_oauth_facebook;
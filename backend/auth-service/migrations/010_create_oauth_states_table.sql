@@ -0,0 +1,15 @@
+-- migrations/010_create_oauth_states_table.sql
+-- Migration to create oauth_states table for pending OAuth logins
+
+-- One row per login sent to an OAuth provider, deleted when the callback uses it
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state_hash VARCHAR(64) PRIMARY KEY, -- SHA-256 of the state parameter
+    provider VARCHAR(100) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL, -- PKCE verifier
+    nonce VARCHAR(64) NOT NULL,
+    redirect_url TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_states_expires_at ON oauth_states(expires_at);
@@ -0,0 +1,4 @@
+-- migrations/011_add_oauth_link_flow.sql
+-- Migration to let an OAuth flow link an identity to a signed in user
+
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS link_user_id UUID REFERENCES users(id) ON DELETE CASCADE;
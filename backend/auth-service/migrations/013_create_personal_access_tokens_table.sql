@@ -0,0 +1,16 @@
+-- migrations/013_create_personal_access_tokens_table.sql
+-- Migration to let scripts and bots authenticate without a user's JWT
+
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL, -- Start of the token, shown so users can tell tokens apart
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 of the token
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL never expires
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
@@ -0,0 +1,16 @@
+-- migrations/018_create_username_history_table.sql
+-- Migration for username changes
+
+-- Every username change is recorded. The old username stays reserved for its
+-- previous owner until reserved_until and redirects to the new one meanwhile.
+CREATE TABLE IF NOT EXISTS username_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    old_username VARCHAR(50) NOT NULL,
+    new_username VARCHAR(50) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reserved_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_username_history_user_id ON username_history(user_id, changed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_username_history_old_username ON username_history(old_username, reserved_until);
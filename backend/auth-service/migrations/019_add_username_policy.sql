@@ -0,0 +1,40 @@
+-- migrations/019_add_username_policy.sql
+-- Migration for case-insensitive usernames and look-alike detection
+
+-- Usernames that only differ by case can't coexist any more. The oldest
+-- account keeps its username, the others get theirs suffixed with the start
+-- of their ID and cut to leave room for it.
+UPDATE users u
+SET username = left(u.username, 21) || '_' || left(replace(u.id::text, '-', ''), 8)
+FROM users older
+WHERE LOWER(older.username) = LOWER(u.username)
+    AND (older.created_at, older.id) < (u.created_at, u.id);
+
+-- Skeletons are computed by usernames.Skeleton. For ASCII usernames the
+-- expression below is the same: lowercase, 0 1 i become o l l, separators are
+-- dropped, then rn and vv become m and w. Older non-ASCII usernames only get
+-- lowercased here.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_skeleton TEXT;
+
+UPDATE users
+SET username_skeleton = replace(replace(translate(lower(username), '01i._-', 'oll'), 'rn', 'm'), 'vv', 'w')
+WHERE username_skeleton IS NULL;
+
+ALTER TABLE users ALTER COLUMN username_skeleton SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_username_skeleton ON users(username_skeleton);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users(LOWER(username));
+
+-- Reserved old usernames block their look-alikes too
+ALTER TABLE username_history ADD COLUMN IF NOT EXISTS old_username_skeleton TEXT;
+
+UPDATE username_history
+SET old_username_skeleton = replace(replace(translate(lower(old_username), '01i._-', 'oll'), 'rn', 'm'), 'vv', 'w')
+WHERE old_username_skeleton IS NULL;
+
+ALTER TABLE username_history ALTER COLUMN old_username_skeleton SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_username_history_old_username;
+CREATE INDEX IF NOT EXISTS idx_username_history_old_username ON username_history(LOWER(old_username), reserved_until);
+CREATE INDEX IF NOT EXISTS idx_username_history_old_username_skeleton ON username_history(old_username_skeleton, reserved_until);
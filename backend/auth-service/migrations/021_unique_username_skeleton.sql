@@ -0,0 +1,15 @@
+-- migrations/021_unique_username_skeleton.sql
+-- Migration making look-alike usernames unique
+
+-- Look-alikes that already exist keep their usernames: the oldest account
+-- keeps the skeleton, the others get theirs suffixed with their ID, which no
+-- username can produce. Their skeleton is recomputed when they change their
+-- username.
+UPDATE users u
+SET username_skeleton = u.username_skeleton || '#' || u.id
+FROM users older
+WHERE older.username_skeleton = u.username_skeleton
+    AND (older.created_at, older.id) < (u.created_at, u.id);
+
+DROP INDEX IF EXISTS idx_users_username_skeleton;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_skeleton ON users(username_skeleton);
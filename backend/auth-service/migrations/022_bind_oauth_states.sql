@@ -0,0 +1,6 @@
+-- migrations/022_bind_oauth_states.sql
+-- Migration to bind pending OAuth flows to the browser that started them
+
+-- SHA-256 of the state-binding cookie. Flows saved before this migration have
+-- none and can no longer complete, they expire within minutes anyway.
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS binding_hash VARCHAR(64) NOT NULL DEFAULT '';